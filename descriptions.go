@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"path/filepath"
+	"sort"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/irmago/internal/common"
@@ -48,8 +49,8 @@ type Issuer struct {
 	SchemeManagerID string           `xml:"SchemeManager"`
 	ContactAddress  string
 	ContactEMail    string
-	DeprecatedSince Timestamp
-	XMLVersion      int `xml:"version,attr"`
+	DeprecatedSince Timestamp `xml:"DeprecatedSince,omitempty"`
+	XMLVersion      int       `xml:"version,attr"`
 }
 
 // CredentialType is a description of a credential type, specifying (a.o.) its name, issuer, and attributes.
@@ -59,48 +60,48 @@ type CredentialType struct {
 	ShortName             TranslatedString `xml:"ShortName"`
 	IssuerID              string           `xml:"IssuerID"`
 	SchemeManagerID       string           `xml:"SchemeManager"`
-	IsSingleton           bool             `xml:"ShouldBeSingleton"`
-	DisallowDelete        bool             `xml:"DisallowDelete"`
+	IsSingleton           bool             `xml:"ShouldBeSingleton,omitempty"`
+	DisallowDelete        bool             `xml:"DisallowDelete,omitempty"`
 	Description           TranslatedString
 	AttributeTypes        []*AttributeType `xml:"Attributes>Attribute" json:"-"`
 	RevocationServers     []string         `xml:"RevocationServers>RevocationServer"`
-	RevocationUpdateCount uint64
-	RevocationUpdateSpeed uint64
-	RevocationIndex       int      `xml:"-"`
-	XMLVersion            int      `xml:"version,attr"`
-	XMLName               xml.Name `xml:"IssueSpecification"`
-
-	IssueURL     TranslatedString `xml:"IssueURL"`
-	IsULIssueURL bool             `xml:"IsULIssueURL"`
-
-	DeprecatedSince Timestamp
-
-	ForegroundColor         string
-	BackgroundGradientStart string
-	BackgroundGradientEnd   string
-
-	IsInCredentialStore bool
-	Category            TranslatedString
-	FAQIntro            TranslatedString
-	FAQPurpose          TranslatedString
-	FAQContent          TranslatedString
-	FAQHowto            TranslatedString
+	RevocationUpdateCount uint64           `xml:"RevocationUpdateCount,omitempty"`
+	RevocationUpdateSpeed uint64           `xml:"RevocationUpdateSpeed,omitempty"`
+	RevocationIndex       int              `xml:"-"`
+	XMLVersion            int              `xml:"version,attr"`
+	XMLName               xml.Name         `xml:"IssueSpecification"`
+
+	IssueURL     TranslatedString `xml:"IssueURL,omitempty"`
+	IsULIssueURL bool             `xml:"IsULIssueURL,omitempty"`
+
+	DeprecatedSince Timestamp `xml:"DeprecatedSince,omitempty"`
+
+	ForegroundColor         string `xml:"ForegroundColor,omitempty"`
+	BackgroundGradientStart string `xml:"BackgroundGradientStart,omitempty"`
+	BackgroundGradientEnd   string `xml:"BackgroundGradientEnd,omitempty"`
+
+	IsInCredentialStore bool             `xml:"IsInCredentialStore,omitempty"`
+	Category            TranslatedString `xml:"Category,omitempty"`
+	FAQIntro            TranslatedString `xml:"FAQIntro,omitempty"`
+	FAQPurpose          TranslatedString `xml:"FAQPurpose,omitempty"`
+	FAQContent          TranslatedString `xml:"FAQContent,omitempty"`
+	FAQHowto            TranslatedString `xml:"FAQHowto,omitempty"`
 }
 
 // AttributeType is a description of an attribute within a credential type.
 type AttributeType struct {
-	ID          string `xml:"id,attr"`
-	Optional    string `xml:"optional,attr"  json:",omitempty"`
-	Name        TranslatedString
-	Description TranslatedString
+	ID          string           `xml:"id,attr,omitempty"`
+	Optional    string           `xml:"optional,attr,omitempty"  json:",omitempty"`
+	Name        TranslatedString `xml:"Name,omitempty"`
+	Description TranslatedString `xml:"Description,omitempty"`
 
-	RandomBlind bool `xml:"randomblind,attr,optional" json:",omitempty"`
+	RandomBlind bool `xml:"randomblind,attr,omitempty" json:",omitempty"`
 
 	Index        int    `xml:"-"`
-	DisplayIndex *int   `xml:"displayIndex,attr" json:",omitempty"`
-	DisplayHint  string `xml:"displayHint,attr"  json:",omitempty"`
+	DisplayIndex *int   `xml:"displayIndex,attr,omitempty" json:",omitempty"`
+	DisplayHint  string `xml:"displayHint,attr,omitempty"  json:",omitempty"`
 
-	RevocationAttribute bool `xml:"revocation,attr" json:",omitempty"`
+	RevocationAttribute bool `xml:"revocation,attr,omitempty" json:",omitempty"`
 
 	// Taken from containing CredentialType
 	CredentialTypeID string `xml:"-"`
@@ -128,8 +129,36 @@ type RequestorInfo struct {
 	Hostnames  []string                  `json:"hostnames"`
 	Logo       *string                   `json:"logo"`
 	ValidUntil *Timestamp                `json:"valid_until"`
+	// Purpose describes to the user why the requestor asks for attributes.
+	Purpose *TranslatedString `json:"purpose,omitempty"`
+	// AllowedAttributes are the attribute types (or credential types, if they consist of 3 parts)
+	// that the requestor may ask for; if absent, the requestor scheme does not restrict them.
+	AllowedAttributes []AttributeTypeIdentifier `json:"allowed_attributes,omitempty"`
 }
 
+// SessionRequestorInfo is the requestor of a session as determined by the IRMA app: its
+// RequestorInfo, which is shared with the requestor scheme and must not be modified, along with
+// the properties of the requestor that depend on the session.
+type SessionRequestorInfo struct {
+	*RequestorInfo
+	Verification RequestorVerification `json:"verification,omitempty"`
+	// UndeclaredAttributes are the requested attribute types not in AllowedAttributes.
+	UndeclaredAttributes []AttributeTypeIdentifier `json:"undeclared_attributes,omitempty"`
+}
+
+// RequestorVerification describes whether the requestor of a session was found in a requestor scheme.
+type RequestorVerification string
+
+const (
+	// RequestorVerified means that the requestor occurs in a requestor scheme.
+	RequestorVerified = RequestorVerification("verified")
+	// RequestorUnverified means that the requestor does not occur in any requestor scheme, or
+	// that its identity could not be established, e.g. in manual sessions or without https.
+	RequestorUnverified = RequestorVerification("unverified")
+	// RequestorExpired means that the requestor occurs in a requestor scheme, but its ValidUntil has passed.
+	RequestorExpired = RequestorVerification("expired")
+)
+
 // RequestorChunk is a number of verified requestors stored together. The RequestorScheme can consist of multiple such chunks
 type RequestorChunk []*RequestorInfo
 
@@ -141,6 +170,35 @@ func NewRequestorInfo(hostname string) *RequestorInfo {
 	}
 }
 
+// Undeclared returns the attribute types requested in the condiscon that the requestor is not
+// allowed to ask for according to its AllowedAttributes, or nil if these are not restricted.
+func (ri *RequestorInfo) Undeclared(condiscon AttributeConDisCon) []AttributeTypeIdentifier {
+	if ri.AllowedAttributes == nil {
+		return nil
+	}
+	allowed := map[AttributeTypeIdentifier]struct{}{}
+	for _, id := range ri.AllowedAttributes {
+		allowed[id] = struct{}{}
+	}
+	var undeclared []AttributeTypeIdentifier
+	seen := map[AttributeTypeIdentifier]struct{}{}
+	_ = condiscon.Iterate(func(attr *AttributeRequest) error {
+		if _, ok := seen[attr.Type]; ok {
+			return nil
+		}
+		seen[attr.Type] = struct{}{}
+		if _, ok := allowed[attr.Type]; ok {
+			return nil
+		}
+		if _, ok := allowed[NewAttributeTypeIdentifier(attr.Type.CredentialTypeIdentifier().String())]; ok {
+			return nil
+		}
+		undeclared = append(undeclared, attr.Type)
+		return nil
+	})
+	return undeclared
+}
+
 func (ad AttributeType) GetAttributeTypeIdentifier() AttributeTypeIdentifier {
 	return NewAttributeTypeIdentifier(fmt.Sprintf("%s.%s.%s.%s", ad.SchemeManagerID, ad.IssuerID, ad.CredentialTypeID, ad.ID))
 }
@@ -228,12 +286,18 @@ type xmlTranslatedString struct {
 	Translations []xmlTranslation `xml:",any"`
 }
 
-// MarshalXML implements xml.Marshaler.
+// MarshalXML implements xml.Marshaler. The translations are sorted by language,
+// so that marshaling the same TranslatedString always yields the same XML.
 func (ts *TranslatedString) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	temp := &xmlTranslatedString{}
-	for lang, text := range *ts {
+	langs := make([]string, 0, len(*ts))
+	for lang := range *ts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
 		temp.Translations = append(temp.Translations,
-			xmlTranslation{XMLName: xml.Name{Local: lang}, Text: text},
+			xmlTranslation{XMLName: xml.Name{Local: lang}, Text: (*ts)[lang]},
 		)
 	}
 	return e.EncodeElement(temp, start)
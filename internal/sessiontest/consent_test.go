@@ -0,0 +1,40 @@
+package sessiontest
+
+import (
+	"encoding/json"
+	"testing"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsentRuleAutoApproval(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	if TestType == "irmaserver" || TestType == "irmaserver-jwt" || TestType == "irmaserver-hmac-jwt" {
+		StartRequestorServer(JwtServerConfiguration)
+		defer StopRequestorServer()
+	}
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	for i := 0; i < 2; i++ {
+		qr := startSession(t, getDisclosureRequest(id), "verification")
+		c := make(chan *SessionResult)
+		h := &ConsentTestHandler{TestHandler{t: t, c: c, client: client, expectedServerName: expectedRequestorInfo(t, client.Configuration)}}
+		qrjson, err := json.Marshal(qr)
+		require.NoError(t, err)
+		client.NewSession(string(qrjson), h)
+		if result := <-c; result != nil {
+			require.NoError(t, result.Err)
+		}
+	}
+
+	rules, err := client.ConsentRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	logs, err := client.LoadNewestLogs(2)
+	require.NoError(t, err)
+	require.Equal(t, rules[0].ID, logs[0].ConsentRule)
+	require.Empty(t, logs[1].ConsentRule)
+}
@@ -25,6 +25,7 @@ func (i *TestClientHandler) UpdateAttributes()                               {}
 func (i *TestClientHandler) Revoked(cred *irma.CredentialIdentifier) {
 	i.revoked = cred
 }
+func (i *TestClientHandler) CredentialsExpiring(creds []*irmaclient.ExpiringCredential) {}
 func (i *TestClientHandler) EnrollmentSuccess(manager irma.SchemeManagerIdentifier) {
 	select {
 	case i.c <- nil: // nop
@@ -79,7 +80,7 @@ type TestHandler struct {
 	t                  *testing.T
 	c                  chan *SessionResult
 	client             *irmaclient.Client
-	expectedServerName *irma.RequestorInfo
+	expectedServerName *irma.SessionRequestorInfo
 	wait               time.Duration
 	result             string
 }
@@ -112,7 +113,7 @@ func (th TestHandler) Failure(err *irma.SessionError) {
 	}
 }
 func (th TestHandler) ClientReturnURLSet(clientReturnUrl string) {}
-func (th TestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.RequestorInfo, callback irmaclient.PermissionHandler) {
+func (th TestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.SessionRequestorInfo, callback irmaclient.PermissionHandler) {
 	if !satisfiable {
 		th.Failure(&irma.SessionError{ErrorType: irma.ErrorType("UnsatisfiableRequest")})
 		return
@@ -138,15 +139,18 @@ func (th TestHandler) RequestVerificationPermission(request *irma.DisclosureRequ
 	}
 	callback(true, &choice)
 }
-func (th TestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.RequestorInfo, callback irmaclient.PermissionHandler) {
+func (th TestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.SessionRequestorInfo, callback irmaclient.PermissionHandler) {
 	th.RequestVerificationPermission(&request.DisclosureRequest, satisfiable, candidates, ServerName, callback)
 }
-func (th TestHandler) RequestSignaturePermission(request *irma.SignatureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.RequestorInfo, callback irmaclient.PermissionHandler) {
+func (th TestHandler) RequestSignaturePermission(request *irma.SignatureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.SessionRequestorInfo, callback irmaclient.PermissionHandler) {
 	th.RequestVerificationPermission(&request.DisclosureRequest, satisfiable, candidates, ServerName, callback)
 }
 func (th TestHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
 	callback(true)
 }
+func (th TestHandler) RequestReplacementPermission(credentials []*irma.CredentialInfo, callback func(replace bool)) {
+	callback(true)
+}
 func (th TestHandler) RequestPin(remainingAttempts int, callback irmaclient.PinHandler) {
 	callback(true, "12345")
 }
@@ -174,7 +178,7 @@ func (th *UnsatisfiableTestHandler) Success(result string) {
 	}
 }
 
-func (th *UnsatisfiableTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.RequestorInfo, callback irmaclient.PermissionHandler) {
+func (th *UnsatisfiableTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.SessionRequestorInfo, callback irmaclient.PermissionHandler) {
 	if !th.called {
 		if satisfiable {
 			th.Failure(&irma.SessionError{ErrorType: irma.ErrorType("Unsatisfiable request succeeded")})
@@ -227,10 +231,10 @@ func (th *ManualTestHandler) Success(result string) {
 
 	th.c <- retval
 }
-func (th *ManualTestHandler) RequestSignaturePermission(request *irma.SignatureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, requesterName *irma.RequestorInfo, ph irmaclient.PermissionHandler) {
+func (th *ManualTestHandler) RequestSignaturePermission(request *irma.SignatureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, requesterName *irma.SessionRequestorInfo, ph irmaclient.PermissionHandler) {
 	th.RequestVerificationPermission(&request.DisclosureRequest, satisfiable, candidates, requesterName, ph)
 }
-func (th *ManualTestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, issuerName *irma.RequestorInfo, ph irmaclient.PermissionHandler) {
+func (th *ManualTestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, issuerName *irma.SessionRequestorInfo, ph irmaclient.PermissionHandler) {
 	ph(true, nil)
 }
 
@@ -238,7 +242,7 @@ func (th *ManualTestHandler) RequestIssuancePermission(request *irma.IssuanceReq
 func (th *ManualTestHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
 	th.Failure(&irma.SessionError{Err: errors.New("Unexpected session type")})
 }
-func (th *ManualTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, verifierName *irma.RequestorInfo, ph irmaclient.PermissionHandler) {
+func (th *ManualTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, verifierName *irma.SessionRequestorInfo, ph irmaclient.PermissionHandler) {
 	if !satisfiable {
 		th.Failure(&irma.SessionError{ErrorType: irma.ErrorType("UnsatisfiableRequest")})
 		return
@@ -262,3 +266,45 @@ func (th *ManualTestHandler) RequestVerificationPermission(request *irma.Disclos
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
+
+// ConsentTestHandler approves disclosure sessions like TestHandler, remembering the consent of the
+// user using a consent rule, and fails if it is asked for permission while such a rule exists.
+type ConsentTestHandler struct {
+	TestHandler
+}
+
+func (th *ConsentTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.SessionRequestorInfo, callback irmaclient.PermissionHandler) {
+	if th.client.RememberedChoice(request, ServerName, candidates) != nil {
+		th.Failure(&irma.SessionError{Err: errors.New("Asked for permission despite consent rule")})
+		return
+	}
+	th.TestHandler.RequestVerificationPermission(request, satisfiable, candidates, ServerName, func(proceed bool, choice *irma.DisclosureChoice) {
+		_, err := th.client.AddConsentRule(request, ServerName, choice, true, time.Hour)
+		require.NoError(th.t, err)
+		callback(proceed, choice)
+	})
+}
+
+// InterruptedTestHandler never answers when asked for permission, as if the app was killed while
+// asking the user for permission.
+type InterruptedTestHandler struct {
+	TestHandler
+	asked chan struct{}
+}
+
+func (th *InterruptedTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, ServerName *irma.SessionRequestorInfo, callback irmaclient.PermissionHandler) {
+	th.asked <- struct{}{}
+}
+
+// ReplacementTestHandler answers RequestReplacementPermission() with replace, remembering the
+// credentials it was asked about.
+type ReplacementTestHandler struct {
+	TestHandler
+	replace bool
+	asked   []*irma.CredentialInfo
+}
+
+func (th *ReplacementTestHandler) RequestReplacementPermission(credentials []*irma.CredentialInfo, callback func(replace bool)) {
+	th.asked = credentials
+	callback(th.replace)
+}
@@ -43,6 +43,7 @@ func parseExistingStorage(t *testing.T, storage string) (*irmaclient.Client, *Te
 		filepath.Join(path, "irma_configuration"),
 		handler,
 		"",
+		nil,
 	)
 	require.NoError(t, err)
 	client.SetPreferences(irmaclient.Preferences{DeveloperMode: true})
@@ -223,10 +224,13 @@ func sessionHelper(t *testing.T, request irma.SessionRequest, sessiontype string
 	}
 }
 
-func expectedRequestorInfo(t *testing.T, conf *irma.Configuration) *irma.RequestorInfo {
+func expectedRequestorInfo(t *testing.T, conf *irma.Configuration) *irma.SessionRequestorInfo {
 	if common.ForceHTTPS {
-		return irma.NewRequestorInfo("localhost")
+		return &irma.SessionRequestorInfo{
+			RequestorInfo: irma.NewRequestorInfo("localhost"),
+			Verification:  irma.RequestorUnverified,
+		}
 	}
 	require.Contains(t, conf.Requestors, "localhost")
-	return conf.Requestors["localhost"]
+	return &irma.SessionRequestorInfo{RequestorInfo: conf.Requestors["localhost"], Verification: irma.RequestorVerified}
 }
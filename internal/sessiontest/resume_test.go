@@ -0,0 +1,49 @@
+package sessiontest
+
+import (
+	"encoding/json"
+	"testing"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeSession(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	if TestType == "irmaserver" || TestType == "irmaserver-jwt" || TestType == "irmaserver-hmac-jwt" {
+		StartRequestorServer(JwtServerConfiguration)
+		defer StopRequestorServer()
+	}
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	qr := startSession(t, getDisclosureRequest(id), "verification")
+	qrjson, err := json.Marshal(qr)
+	require.NoError(t, err)
+	c := make(chan *SessionResult)
+	asked := make(chan struct{})
+	client.NewSession(string(qrjson), &InterruptedTestHandler{TestHandler{t: t, c: c, client: client}, asked})
+	<-asked
+
+	// Restart the client while the user is asked for permission
+	require.NoError(t, client.Close())
+	client, handler = parseExistingStorage(t, handler.storage)
+	pending, err := client.PendingSessions()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.NotNil(t, pending[0].Request)
+	require.Nil(t, pending[0].Choice)
+
+	// The session request is not requested again, as the server would refuse that
+	h := &TestHandler{t: t, c: c, client: client, expectedServerName: expectedRequestorInfo(t, client.Configuration)}
+	_, err = client.ResumeSession(pending[0].ID, h)
+	require.NoError(t, err)
+	if result := <-c; result != nil {
+		require.NoError(t, result.Err)
+	}
+
+	pending, err = client.PendingSessions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
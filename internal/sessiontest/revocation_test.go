@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -27,8 +29,13 @@ var (
 	revocationServer        *irmaserver.Server
 	revocationConfiguration *server.Configuration
 
-	//revocationDbType, revocationDbStr = "postgres", "host=127.0.0.1 port=5432 user=testuser dbname=test password='testpassword' sslmode=disable"
-	revocationDbType, revocationDbStr = "mysql", "testuser:testpassword@tcp(127.0.0.1)/test"
+	// Databases against which TestRevocationAll runs; the first is used by the other tests
+	revocationDbs = []struct{ typ, str string }{
+		//{"postgres", "host=127.0.0.1 port=5432 user=testuser dbname=test password='testpassword' sslmode=disable"},
+		{"mysql", "testuser:testpassword@tcp(127.0.0.1)/test"},
+		{"bolt", filepath.Join(os.TempDir(), "irma-revocation-test.db")},
+	}
+	revocationDbType, revocationDbStr = revocationDbs[0].typ, revocationDbs[0].str
 
 	revocationPkCounter uint = 2
 )
@@ -92,6 +99,16 @@ func testRevocation(t *testing.T, attr irma.AttributeTypeIdentifier, client *irm
 }
 
 func TestRevocationAll(t *testing.T) {
+	defer func() {
+		revocationDbType, revocationDbStr = revocationDbs[0].typ, revocationDbs[0].str
+	}()
+	for _, db := range revocationDbs {
+		revocationDbType, revocationDbStr = db.typ, db.str
+		t.Run(db.typ, testRevocationAll)
+	}
+}
+
+func testRevocationAll(t *testing.T) {
 	t.Run("Revocation", func(t *testing.T) {
 		startRevocationServer(t, true)
 		defer stopRevocationServer()
@@ -519,6 +536,29 @@ func TestRevocationAll(t *testing.T) {
 		}
 	})
 
+	t.Run("Audit", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		conf := revocationConfiguration.IrmaConfiguration
+		rev := conf.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+
+		// revoke enough credentials for the audit to have to fetch events in multiple intervals
+		for i := 0; i < 20; i++ {
+			key := strconv.Itoa(i)
+			insertIssuanceRecord(t, key, rev, sacc.Accumulator)
+			require.NoError(t, rev.Revoke(revocationTestCred, key, time.Time{}))
+		}
+
+		audit, err := irma.AuditRevocationServer(conf, revocationTestCred, "http://localhost:48683")
+		require.NoError(t, err)
+		require.Len(t, audit.Keys, 1)
+		require.True(t, audit.OK(), "%v", audit.Keys[0].Problems)
+		require.Equal(t, uint64(20), audit.Keys[0].Index)
+		require.Equal(t, 21, audit.Keys[0].Events)
+	})
+
 	t.Run("RevocationTolerance", func(t *testing.T) {
 		client, handler := revocationSetup(t)
 		defer test.ClearTestStorage(t, handler.storage)
@@ -748,15 +788,16 @@ func startRevocationServer(t *testing.T, droptables bool) {
 	var err error
 
 	// Connect to database and clear records from previous test runs
-	if droptables {
+	if droptables && revocationDbType == "bolt" {
+		require.NoError(t, os.RemoveAll(revocationDbStr))
+	} else if droptables {
 		g, err := gorm.Open(revocationDbType, revocationDbStr)
 		require.NoError(t, err)
 		require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
 		require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
 		require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
+		require.NoError(t, g.DropTableIfExists((*irma.ReplicationRecord)(nil)).Error)
+		require.NoError(t, g.DropTableIfExists((*irma.RevocationMigrationRecord)(nil)).Error)
 		require.NoError(t, g.Close())
 	}
 
@@ -119,6 +119,72 @@ func TestIssuanceSameAttributesNotSingleton(t *testing.T) {
 	require.Equal(t, prevLen+1, len(client.CredentialInfoList()))
 }
 
+func TestIssuanceReplacementPolicy(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	if TestType == "irmaserver" || TestType == "irmaserver-jwt" || TestType == "irmaserver-hmac-jwt" {
+		StartRequestorServer(JwtServerConfiguration)
+		defer StopRequestorServer()
+	}
+
+	credtype := irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	// Credentials issued on the same day with the same expiry are identical, so vary the expiry
+	issue := func(years int, replace bool) *ReplacementTestHandler {
+		request := getIssuanceRequest(true)
+		expiry := irma.Timestamp(irma.FloorToEpochBoundary(time.Now().AddDate(years, 0, 0)))
+		request.Credentials[0].Validity = &expiry
+		qr := startSession(t, request, "issue")
+		c := make(chan *SessionResult)
+		h := &ReplacementTestHandler{TestHandler: TestHandler{t: t, c: c, client: client}, replace: replace}
+		qrjson, err := json.Marshal(qr)
+		require.NoError(t, err)
+		client.NewSession(string(qrjson), h)
+		if result := <-c; result != nil {
+			require.NoError(t, result.Err)
+		}
+		return h
+	}
+	count := func() int {
+		n := 0
+		for _, cred := range client.CredentialInfoList() {
+			if cred.SchemeManagerID+"."+cred.IssuerID+"."+cred.ID == credtype.String() {
+				n++
+			}
+		}
+		return n
+	}
+
+	issue(1, true)
+	prevLen := count()
+
+	// Keep older credentials with the same attribute values
+	client.SetPreferences(irmaclient.Preferences{DeveloperMode: true, ReplacementPolicies: map[irma.CredentialTypeIdentifier]irmaclient.ReplacementPolicy{
+		credtype: irmaclient.ReplacementKeep,
+	}})
+	h := issue(2, true)
+	require.Empty(t, h.asked)
+	require.Equal(t, prevLen+1, count())
+
+	// Ask the user, who declines replacement
+	client.SetPreferences(irmaclient.Preferences{DeveloperMode: true, ReplacementPolicies: map[irma.CredentialTypeIdentifier]irmaclient.ReplacementPolicy{
+		credtype: irmaclient.ReplacementAsk,
+	}})
+	h = issue(3, false)
+	require.Len(t, h.asked, 2)
+	require.Equal(t, prevLen+2, count())
+	logs, err := client.LoadNewestLogs(1)
+	require.NoError(t, err)
+	require.Empty(t, logs[0].Replaced)
+
+	// Ask the user, who accepts replacement, which is recorded in the log
+	h = issue(4, true)
+	require.Len(t, h.asked, 3)
+	require.Equal(t, prevLen, count())
+	logs, err = client.LoadNewestLogs(1)
+	require.NoError(t, err)
+	require.Len(t, logs[0].Replaced[credtype], 3)
+}
+
 func TestLargeAttribute(t *testing.T) {
 	client, handler := parseStorage(t)
 	defer test.ClearTestStorage(t, handler.storage)
@@ -538,6 +604,28 @@ func TestBlindIssuanceSession(t *testing.T) {
 	require.NoError(t, client.Close())
 }
 
+func TestBlindIssuanceReplacementPolicy(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	// Random blind attributes differ for each issuance, so the user is not asked to replace credentials.
+	// Older instances of singletons are always replaced, so make the credential type a non-singleton.
+	credID := irma.NewCredentialTypeIdentifier("irma-demo.stemmen.stempas")
+	client.Configuration.CredentialTypes[credID].IsSingleton = false
+	client.SetPreferences(irmaclient.Preferences{DeveloperMode: true, ReplacementPolicies: map[irma.CredentialTypeIdentifier]irmaclient.ReplacementPolicy{
+		credID: irmaclient.ReplacementAsk,
+	}})
+	request := irma.NewIssuanceRequest([]*irma.CredentialRequest{
+		{
+			CredentialTypeID: credID,
+			Attributes:       map[string]string{"election": "plantsoen"},
+		},
+	})
+	sessionHelper(t, request, "issue", client)
+	sessionHelper(t, request, "issue", client)
+	require.NotNil(t, client.Attributes(credID, 1))
+}
+
 // Tests whether the client correctly detects a mismatch in the randomblind attributes between client and server.
 // In this test we simulate a scenario where the client has an out-of-date configuration compared to the server.
 // The server has updated configuration in which two randomblind attributes are present.
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/irmaclient"
+	"github.com/sietseringers/cobra"
+)
+
+var clientListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the credentials of the client",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		asJSON, _ := flags.GetBool("json")
+		lang, _ := flags.GetString("lang")
+
+		client, _, err := openClient(flags)
+		if err != nil {
+			return err
+		}
+		defer closeClient(client)
+
+		creds := client.CredentialInfoList()
+		if asJSON {
+			return printJSON(creds)
+		}
+		for _, cred := range creds {
+			status := ""
+			if cred.Revoked {
+				status = " (revoked)"
+			} else if time.Now().After(time.Time(cred.Expires)) {
+				status = " (expired)"
+			}
+			fmt.Printf("%s.%s.%s%s\n  hash:    %s\n  issued:  %s\n  expires: %s\n",
+				cred.SchemeManagerID, cred.IssuerID, cred.ID, status, cred.Hash,
+				time.Time(cred.SignedOn).Format("2006-01-02"), time.Time(cred.Expires).Format("2006-01-02"))
+
+			attrs := make([]irma.AttributeTypeIdentifier, 0, len(cred.Attributes))
+			for attr := range cred.Attributes {
+				attrs = append(attrs, attr)
+			}
+			sort.Slice(attrs, func(i, j int) bool { return attrs[i].String() < attrs[j].String() })
+			for _, attr := range attrs {
+				fmt.Printf("  %s: %s\n", attr.Name(), translatedValue(cred.Attributes[attr], lang))
+			}
+		}
+		return nil
+	},
+}
+
+var clientRemoveCmd = &cobra.Command{
+	Use:   "remove <hash>...",
+	Short: "Remove credentials from the client",
+	Long: `Remove credentials from the client
+
+The remove command removes the credentials with the specified hashes, as shown by "irma client list".`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, _, err := openClient(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer closeClient(client)
+
+		for _, hash := range args {
+			if err = client.RemoveCredentialByHash(hash); err != nil {
+				return errors.WrapPrefix(err, "failed to remove credential "+hash, 0)
+			}
+		}
+		return nil
+	},
+}
+
+var clientLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show the session logs of the client",
+	Long: `Show the session logs of the client
+
+The logs command prints the newest log entries of the client, as JSON or CSV.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		max, _ := flags.GetInt("max")
+		format, _ := flags.GetString("format")
+		lang, _ := flags.GetString("lang")
+		if format != string(irmaclient.LogExportJSON) && format != string(irmaclient.LogExportCSV) {
+			return errors.Errorf("unknown format %s", format)
+		}
+
+		client, _, err := openClient(flags)
+		if err != nil {
+			return err
+		}
+		defer closeClient(client)
+
+		entries, err := client.QueryLogs(&irmaclient.LogQuery{Max: max})
+		if err != nil {
+			return errors.WrapPrefix(err, "failed to load logs", 0)
+		}
+		return client.ExportLogs(os.Stdout, entries, irmaclient.LogExportFormat(format), lang)
+	},
+}
+
+// translatedValue returns the translation of s in the specified language, or any translation if
+// it does not exist.
+func translatedValue(s irma.TranslatedString, lang string) string {
+	if v, ok := s[lang]; ok {
+		return v
+	}
+	for _, v := range s {
+		return v
+	}
+	return ""
+}
+
+func init() {
+	clientCmd.AddCommand(clientListCmd)
+	clientCmd.AddCommand(clientRemoveCmd)
+	clientCmd.AddCommand(clientLogsCmd)
+
+	clientListCmd.Flags().Bool("json", false, "print credentials as JSON")
+	clientListCmd.Flags().String("lang", "en", "language of attribute values")
+
+	flags := clientLogsCmd.Flags()
+	flags.IntP("max", "n", 20, "maximum amount of log entries to show")
+	flags.String("format", "json", "output format: json or csv")
+	flags.String("lang", "en", "language of names and attribute values")
+}
@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/irmaclient"
+	"github.com/sietseringers/cobra"
+)
+
+var clientSessionCmd = &cobra.Command{
+	Use:   "session <qr-json|url>",
+	Short: "Perform an IRMA session as the client",
+	Long: `Perform an IRMA session as the client
+
+The session command performs the session specified by its argument, which is either the JSON
+session pointer that IRMA servers show in the QR (e.g. as printed by "irma session --noqr"), a
+link containing such a session pointer after the # (as in https://irma.app/-/session#...), or the
+URL of a backend that starts a session when POSTed to, returning a session pointer.
+
+Instead of asking the user, the client chooses the attributes to disclose using the policy
+specified by --policy:
+  first    the first option of each disjunction that the client has all attributes for, in the
+           order in which the client ranks them
+  newest   the option whose credentials were issued most recently
+  reject   decline all sessions
+Options containing the attributes specified with --choose take precedence, so that a particular
+credential can be selected. If the session involves a keyshare server, the client supplies the
+PIN specified by --pin or the IRMA_CLIENT_PIN environment variable.
+
+The command exits with a nonzero exit code if the session fails, or if it is cancelled while
+--policy is not reject. The result of disclosure and signature sessions (the disclosure proofs or
+the signature) is printed.`,
+	Example: `irma client session '{"u":"http://localhost:48680/irma/session/6m2yrqJ6jD9KdZ3TtCnD","irmaqr":"disclosing"}'
+irma client session --choose irma-demo.MijnOverheid.root.BSN=999999990 https://example.com/start-session
+irma client session --policy reject "$(cat qr.json)"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		policy, _ := flags.GetString("policy")
+		if policy != "first" && policy != "newest" && policy != "reject" {
+			return errors.Errorf("unknown policy %s", policy)
+		}
+		choose, _ := flags.GetStringArray("choose")
+		developer, _ := flags.GetBool("developer")
+		timeout, _ := flags.GetDuration("timeout")
+
+		sessionrequest, err := parseSessionArgument(args[0])
+		if err != nil {
+			return err
+		}
+
+		client, _, err := openClient(flags)
+		if err != nil {
+			return err
+		}
+		client.Preferences.DeveloperMode = developer
+
+		handler := &clientSessionHandler{
+			client: client,
+			policy: policy,
+			pin:    clientPin(flags),
+			done:   make(chan error, 1),
+		}
+		for _, c := range choose {
+			parts := strings.SplitN(c, "=", 2)
+			handler.choose = append(handler.choose, parts)
+		}
+
+		dismisser := client.NewSession(sessionrequest, handler)
+		select {
+		case err = <-handler.done:
+		case <-time.After(timeout):
+			if dismisser != nil {
+				dismisser.Dismiss()
+			}
+			err = errors.New("session timed out")
+		}
+		// Closing the client waits until the server has been informed of cancellation, if needed
+		closeClient(client)
+		if err != nil {
+			return errors.WrapPrefix(err, "session failed", 0)
+		}
+		if handler.result != "" {
+			fmt.Println(handler.result)
+		}
+		return nil
+	},
+}
+
+// parseSessionArgument converts the argument of the session command to a session pointer or
+// request in JSON, as accepted by irmaclient.Client.NewSession().
+func parseSessionArgument(arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(arg, "{") {
+		return arg, nil
+	}
+	u, err := url.Parse(arg)
+	if err != nil || u.Scheme == "" {
+		return "", errors.New("argument is neither a session pointer nor a URL")
+	}
+	if u.Fragment != "" {
+		return u.Fragment, nil
+	}
+	bts, err := json.Marshal(&irma.Qr{URL: arg, Type: irma.ActionRedirect})
+	return string(bts), err
+}
+
+// clientSessionHandler is a non-interactive irmaclient.Handler, that approves or declines sessions
+// and chooses attributes to disclose according to the flags of the session command.
+type clientSessionHandler struct {
+	client    *irmaclient.Client
+	policy    string
+	choose    [][]string // attribute type and optionally value
+	pin       string
+	pinPassed bool
+	result    string
+	done      chan error
+}
+
+func (h *clientSessionHandler) StatusUpdate(action irma.Action, status irma.Status) {
+	logger.Debugf("%s session: %s", action, status)
+}
+
+func (h *clientSessionHandler) ClientReturnURLSet(clientReturnURL string) {
+	logger.Info("Client return URL: ", clientReturnURL)
+}
+
+func (h *clientSessionHandler) Success(result string) {
+	h.result = result
+	h.finish(nil)
+}
+
+func (h *clientSessionHandler) Cancelled() {
+	if h.policy == "reject" {
+		h.finish(nil)
+		return
+	}
+	h.finish(errors.New("session cancelled"))
+}
+
+func (h *clientSessionHandler) Failure(err *irma.SessionError) {
+	h.finish(err)
+}
+
+func (h *clientSessionHandler) KeyshareBlocked(manager irma.SchemeManagerIdentifier, duration int) {
+	h.finish(errors.Errorf("keyshare account at %s blocked for %d seconds", manager, duration))
+}
+
+func (h *clientSessionHandler) KeyshareEnrollmentIncomplete(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("keyshare enrollment at %s incomplete", manager))
+}
+
+func (h *clientSessionHandler) KeyshareEnrollmentMissing(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("not enrolled at keyshare server of %s, see irma client enroll", manager))
+}
+
+func (h *clientSessionHandler) KeyshareEnrollmentDeleted(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("keyshare enrollment at %s deleted", manager))
+}
+
+func (h *clientSessionHandler) RequestIssuancePermission(request *irma.IssuanceRequest,
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	requestorInfo *irma.SessionRequestorInfo,
+	callback irmaclient.PermissionHandler,
+) {
+	h.requestPermission(satisfiable, candidates, requestorInfo, callback)
+}
+
+func (h *clientSessionHandler) RequestVerificationPermission(request *irma.DisclosureRequest,
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	requestorInfo *irma.SessionRequestorInfo,
+	callback irmaclient.PermissionHandler,
+) {
+	h.requestPermission(satisfiable, candidates, requestorInfo, callback)
+}
+
+func (h *clientSessionHandler) RequestSignaturePermission(request *irma.SignatureRequest,
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	requestorInfo *irma.SessionRequestorInfo,
+	callback irmaclient.PermissionHandler,
+) {
+	logger.Info("Message to be signed: ", request.Message)
+	h.requestPermission(satisfiable, candidates, requestorInfo, callback)
+}
+
+func (h *clientSessionHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
+	logger.Warnf("Declining to install scheme %s", manager.ID)
+	callback(false)
+}
+
+func (h *clientSessionHandler) RequestReplacementPermission(credentials []*irma.CredentialInfo, callback func(replace bool)) {
+	for _, cred := range credentials {
+		logger.Infof("Replacing credential %s.%s.%s", cred.SchemeManagerID, cred.IssuerID, cred.ID)
+	}
+	callback(true)
+}
+
+func (h *clientSessionHandler) RequestPin(remainingAttempts int, callback irmaclient.PinHandler) {
+	// Supply the PIN only once, as asking again means it was incorrect
+	if h.pin == "" || h.pinPassed {
+		if h.pinPassed {
+			logger.Errorf("Incorrect PIN, %d attempts remaining", remainingAttempts)
+		}
+		callback(false, "")
+		return
+	}
+	h.pinPassed = true
+	callback(true, h.pin)
+}
+
+// finish reports the outcome of the session, unless it has already been reported.
+func (h *clientSessionHandler) finish(err error) {
+	select {
+	case h.done <- err:
+	default:
+	}
+}
+
+func (h *clientSessionHandler) requestPermission(
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	requestorInfo *irma.SessionRequestorInfo,
+	callback irmaclient.PermissionHandler,
+) {
+	if requestorInfo != nil {
+		logger.Infof("Session with %s (%s)", requestorInfo.Name["en"], requestorInfo.Verification)
+	}
+	if h.policy == "reject" {
+		logger.Info("Declining session")
+		callback(false, nil)
+		return
+	}
+	if !satisfiable {
+		logger.Error("Client does not have the requested attributes")
+		callback(false, nil)
+		return
+	}
+	choice, err := h.chooseAttributes(candidates)
+	if err != nil {
+		logger.Error(err)
+		callback(false, nil)
+		return
+	}
+	callback(true, choice)
+}
+
+// chooseAttributes chooses, for each disjunction, the option with the most attributes specified
+// by --choose, and among those the first or newest option depending on the policy.
+func (h *clientSessionHandler) chooseAttributes(candidates [][]irmaclient.DisclosureCandidates) (*irma.DisclosureChoice, error) {
+	signedOn := map[string]time.Time{}
+	for _, cred := range h.client.CredentialInfoList() {
+		signedOn[cred.Hash] = time.Time(cred.SignedOn)
+	}
+
+	choice := &irma.DisclosureChoice{}
+	for i, discon := range candidates {
+		var (
+			chosen      []*irma.AttributeIdentifier
+			chosenScore = -1
+			chosenTime  time.Time
+		)
+		for _, option := range discon {
+			ids, err := option.Choose()
+			if err != nil {
+				continue
+			}
+			score, issued := 0, time.Time{}
+			for _, attr := range option {
+				if h.chosen(attr) {
+					score++
+				}
+				if t := signedOn[attr.CredentialHash]; t.After(issued) {
+					issued = t
+				}
+			}
+			if score > chosenScore || (score == chosenScore && h.policy == "newest" && issued.After(chosenTime)) {
+				chosen, chosenScore, chosenTime = ids, score, issued
+			}
+		}
+		if chosenScore < 0 {
+			return nil, errors.Errorf("no attributes available for disjunction %d", i)
+		}
+		choice.Attributes = append(choice.Attributes, chosen)
+	}
+	return choice, nil
+}
+
+// chosen returns whether the candidate is specified by --choose.
+func (h *clientSessionHandler) chosen(attr *irmaclient.DisclosureCandidate) bool {
+	for _, c := range h.choose {
+		if attr.Type.String() != c[0] {
+			continue
+		}
+		if len(c) == 1 {
+			return true
+		}
+		for _, value := range attr.Value {
+			if value == c[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() {
+	clientCmd.AddCommand(clientSessionCmd)
+
+	flags := clientSessionCmd.Flags()
+	flags.String("policy", "first", "how to choose attributes to disclose: first, newest or reject")
+	flags.StringArray("choose", nil, "prefer disclosing this attribute, as attributetype[=value] (repeatable)")
+	flags.String("pin", "", "keyshare PIN (default $IRMA_CLIENT_PIN)")
+	flags.Bool("developer", false, "developer mode: allow sessions with servers not using HTTPS")
+	flags.Duration("timeout", 5*time.Minute, "maximum duration of the session")
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/irmaclient"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
+)
+
+var clientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "Headless IRMA client for testing and automation",
+	Long: `Headless IRMA client for testing and automation
+
+The client commands act as an IRMA app, keeping credentials, keyshare enrollments and logs in the
+directory specified with --storage. They allow testing IRMA servers and verifiers end-to-end,
+e.g. in CI, without a mobile device.`,
+}
+
+var clientEnrollCmd = &cobra.Command{
+	Use:   "enroll <scheme>",
+	Short: "Enroll the client at the keyshare server of a scheme",
+	Long: `Enroll the client at the keyshare server of a scheme
+
+The enroll command registers the client at the keyshare server of the specified scheme, using the
+PIN specified by --pin or the IRMA_CLIENT_PIN environment variable. Afterwards the client can
+perform sessions involving credentials of the scheme. The command fails if the enrollment does
+not complete within --timeout.`,
+	Example: `irma client enroll pbdf --pin 12345 --email user@example.com`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		pin := clientPin(flags)
+		if pin == "" {
+			return errors.New("no PIN specified")
+		}
+		var email *string
+		if e, _ := flags.GetString("email"); e != "" {
+			email = &e
+		}
+		lang, _ := flags.GetString("lang")
+		timeout, _ := flags.GetDuration("timeout")
+
+		client, handler, err := openClient(flags)
+		if err != nil {
+			return err
+		}
+		defer closeClient(client)
+
+		id := irma.NewSchemeManagerIdentifier(args[0])
+		if _, ok := client.Configuration.SchemeManagers[id]; !ok {
+			return errors.Errorf("unknown scheme %s", id)
+		}
+		client.KeyshareEnroll(id, email, pin, lang)
+		select {
+		case err = <-handler.enrollment:
+		case <-time.After(timeout):
+			err = errors.New("timed out")
+		}
+		if err != nil {
+			return errors.WrapPrefix(err, "enrollment failed", 0)
+		}
+		logger.Infof("Enrolled at keyshare server of %s", id)
+		return nil
+	},
+}
+
+// clientHandler is the irmaclient.ClientHandler of the client commands.
+type clientHandler struct {
+	enrollment chan error
+}
+
+func (h *clientHandler) EnrollmentSuccess(manager irma.SchemeManagerIdentifier) {
+	h.enrollment <- nil
+}
+
+func (h *clientHandler) EnrollmentFailure(manager irma.SchemeManagerIdentifier, err error) {
+	h.enrollment <- err
+}
+
+func (h *clientHandler) ChangePinSuccess(manager irma.SchemeManagerIdentifier) {}
+
+func (h *clientHandler) ChangePinFailure(manager irma.SchemeManagerIdentifier, err error) {}
+
+func (h *clientHandler) ChangePinIncorrect(manager irma.SchemeManagerIdentifier, attempts int) {}
+
+func (h *clientHandler) ChangePinBlocked(manager irma.SchemeManagerIdentifier, timeout int) {}
+
+func (h *clientHandler) UpdateConfiguration(new *irma.IrmaIdentifierSet) {}
+
+func (h *clientHandler) UpdateAttributes() {}
+
+func (h *clientHandler) Revoked(cred *irma.CredentialIdentifier) {
+	logger.Warnf("Credential %s has been revoked", cred.Type)
+}
+
+func (h *clientHandler) CredentialsExpiring(creds []*irmaclient.ExpiringCredential) {
+	for _, cred := range creds {
+		logger.Warnf("Credential %s.%s.%s expires on %s", cred.SchemeManagerID, cred.IssuerID, cred.ID,
+			time.Time(cred.Expires).Format("2006-01-02"))
+	}
+}
+
+func (h *clientHandler) ReportError(err error) {
+	logger.Error(err)
+}
+
+// openClient opens the client whose storage is specified in the flags, creating it if necessary.
+func openClient(flags *pflag.FlagSet) (*irmaclient.Client, *clientHandler, error) {
+	storagePath, _ := flags.GetString("storage")
+	schemesPath, _ := flags.GetString("schemes-path")
+	verbosity, _ := flags.GetCount("verbose")
+	logger.Level = server.Verbosity(verbosity)
+	irma.SetLogger(logger)
+
+	if storagePath == "" {
+		return nil, nil, errors.New("no storage directory specified")
+	}
+	if err := os.MkdirAll(storagePath, 0700); err != nil {
+		return nil, nil, errors.WrapPrefix(err, "failed to create storage directory", 0)
+	}
+
+	handler := &clientHandler{enrollment: make(chan error, 1)}
+	client, err := irmaclient.New(storagePath, schemesPath, handler, "", nil)
+	if _, ok := err.(*irma.SchemeManagerError); ok && client != nil {
+		logger.Warn("Failed to parse scheme: ", err)
+	} else if err != nil {
+		return nil, nil, errors.WrapPrefix(err, "failed to open client", 0)
+	}
+	return client, handler, nil
+}
+
+func closeClient(client *irmaclient.Client) {
+	if err := client.Close(); err != nil {
+		logger.Error("failed to close client: ", err)
+	}
+}
+
+// clientPin returns the PIN specified by --pin, or else by the IRMA_CLIENT_PIN environment variable.
+func clientPin(flags *pflag.FlagSet) string {
+	if pin, _ := flags.GetString("pin"); pin != "" {
+		return pin
+	}
+	return os.Getenv("IRMA_CLIENT_PIN")
+}
+
+func init() {
+	RootCmd.AddCommand(clientCmd)
+	clientCmd.AddCommand(clientEnrollCmd)
+
+	flags := clientCmd.PersistentFlags()
+	flags.StringP("storage", "d", filepath.Join(irma.DefaultDataPath(), "client"), "directory in which the client stores its data")
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration with which the client storage is initialized")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+
+	flags = clientEnrollCmd.Flags()
+	flags.String("pin", "", "keyshare PIN (default $IRMA_CLIENT_PIN)")
+	flags.String("email", "", "email address to register at the keyshare server")
+	flags.String("lang", "en", "language of the emails of the keyshare server")
+	flags.Duration("timeout", time.Minute, "maximum duration of the enrollment")
+}
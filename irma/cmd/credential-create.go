@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
+)
+
+var credentialCreateCmd = &cobra.Command{
+	Use:   "create [<path>]",
+	Short: "Create a new IRMA credential type within an IRMA issuer",
+	Long: `Create a new IRMA credential type within an IRMA issuer
+
+The create command creates a new credential type directory containing a description.xml within the
+Issues folder of the IRMA issuer specified by the "path" parameter (if "path" is not provided the
+current directory is taken).
+
+Attributes are specified in order as --attribute id[:option,...], where the options may be:
+  optional         the attribute need not be present when issuing
+  randomblind      the attribute is a random blind attribute
+  displayhint=X    sets the displayHint of the attribute
+  displayindex=N   sets the displayIndex of the attribute
+Names and descriptions of attributes are specified as --attribute-name id:lang=text and
+--attribute-description id:lang=text. If one or more revocation servers are specified, a revocation
+attribute is added to the credential type. For example:
+
+  irma scheme credential create --id email --name "en=Email address" --name "nl=E-mailadres" \
+    --attribute email --attribute-name "email:en=Email address" --attribute-name "email:nl=E-mailadres" \
+    --attribute domain:optional --attribute-name "domain:en=Domain" --attribute-name "domain:nl=Domein"
+
+After creating the credential type the scheme must be resigned (using "irma scheme sign", or by passing
+--sign to this command) before it can be used in IRMA applications.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		id, _ := flags.GetString("id")
+		logo, _ := flags.GetString("logo")
+		overwrite, _ := flags.GetBool("force-overwrite")
+
+		path, err := pathArgument(args)
+		if err != nil {
+			return err
+		}
+		schemepath := filepath.Dir(path)
+		conf, scheme, err := parseSchemeForAuthoring(schemepath)
+		if err != nil {
+			return err
+		}
+		issuer, err := authoringIssuer(conf, scheme, path)
+		if err != nil {
+			return err
+		}
+
+		if err = validateIdentifierPart("credential type", id); err != nil {
+			return err
+		}
+		cred, err := credentialTypeFromFlags(flags)
+		if err != nil {
+			return err
+		}
+		cred.ID = id
+		cred.IssuerID = issuer.ID
+		cred.SchemeManagerID = scheme.ID
+		if err = conf.ValidateCredentialType(issuer, cred); err != nil {
+			return err
+		}
+
+		if err = writeDescription(filepath.Join(path, "Issues", id), cred, logo, overwrite); err != nil {
+			return err
+		}
+		printWarnings(conf)
+
+		return signAfterAuthoring(flags, schemepath)
+	},
+}
+
+// authoringIssuer returns the issuer at the specified path, which may not yet be included
+// in the index of its scheme, in which case its description is read directly from disk.
+func authoringIssuer(conf *irma.Configuration, scheme *irma.SchemeManager, path string) (*irma.Issuer, error) {
+	id := irma.NewIssuerIdentifier(scheme.ID + "." + filepath.Base(path))
+	if issuer, ok := conf.Issuers[id]; ok {
+		return issuer, nil
+	}
+	bts, err := ioutil.ReadFile(filepath.Join(path, "description.xml"))
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to read issuer description", 0)
+	}
+	issuer := &irma.Issuer{}
+	if err = xml.Unmarshal(bts, issuer); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to parse issuer description", 0)
+	}
+	if err = conf.ValidateIssuer(issuer); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+func credentialTypeFromFlags(flags *pflag.FlagSet) (*irma.CredentialType, error) {
+	var err error
+	cred := &irma.CredentialType{XMLVersion: 4}
+	cred.IsSingleton, _ = flags.GetBool("singleton")
+	cred.DisallowDelete, _ = flags.GetBool("disallow-delete")
+	cred.IsULIssueURL, _ = flags.GetBool("ul-issue-url")
+	cred.RevocationServers, _ = flags.GetStringArray("revocation-server")
+	cred.RevocationUpdateCount, _ = flags.GetUint64("revocation-update-count")
+	cred.RevocationUpdateSpeed, _ = flags.GetUint64("revocation-update-speed")
+
+	if cred.Name, err = translatedStringFlag(flags, "name"); err != nil {
+		return nil, err
+	}
+	if cred.ShortName, err = translatedStringFlag(flags, "shortname"); err != nil {
+		return nil, err
+	}
+	if len(cred.ShortName) == 0 {
+		cred.ShortName = cred.Name
+	}
+	if cred.Description, err = translatedStringFlag(flags, "description"); err != nil {
+		return nil, err
+	}
+	if cred.IssueURL, err = translatedStringFlag(flags, "issue-url"); err != nil {
+		return nil, err
+	}
+
+	if cred.AttributeTypes, err = attributeTypesFromFlags(flags); err != nil {
+		return nil, err
+	}
+	if len(cred.RevocationServers) > 0 {
+		cred.AttributeTypes = append(cred.AttributeTypes, &irma.AttributeType{RevocationAttribute: true})
+	}
+	return cred, nil
+}
+
+func attributeTypesFromFlags(flags *pflag.FlagSet) ([]*irma.AttributeType, error) {
+	specs, _ := flags.GetStringArray("attribute")
+	attrs := make(map[string]*irma.AttributeType, len(specs))
+	list := make([]*irma.AttributeType, 0, len(specs))
+
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		attr := &irma.AttributeType{
+			ID:          parts[0],
+			Name:        irma.TranslatedString{},
+			Description: irma.TranslatedString{},
+		}
+		if err := validateIdentifierPart("attribute", attr.ID); err != nil {
+			return nil, err
+		}
+		if _, exists := attrs[attr.ID]; exists {
+			return nil, errors.Errorf("attribute %s specified twice", attr.ID)
+		}
+		if len(parts) == 2 {
+			if err := parseAttributeOptions(attr, parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		attrs[attr.ID] = attr
+		list = append(list, attr)
+	}
+
+	for flag, field := range map[string]func(*irma.AttributeType) irma.TranslatedString{
+		"attribute-name":        func(attr *irma.AttributeType) irma.TranslatedString { return attr.Name },
+		"attribute-description": func(attr *irma.AttributeType) irma.TranslatedString { return attr.Description },
+	} {
+		values, _ := flags.GetStringArray(flag)
+		for _, val := range values {
+			parts := strings.SplitN(val, ":", 2)
+			attr, ok := attrs[parts[0]]
+			if !ok {
+				return nil, errors.Errorf("--%s specified for unknown attribute %s", flag, parts[0])
+			}
+			translation := strings.SplitN(parts[len(parts)-1], "=", 2)
+			if len(parts) != 2 || len(translation) != 2 || translation[0] == "" {
+				return nil, errors.Errorf("invalid value for --%s: %q (must be of the form id:lang=text)", flag, val)
+			}
+			field(attr)[translation[0]] = translation[1]
+		}
+	}
+
+	return list, nil
+}
+
+func parseAttributeOptions(attr *irma.AttributeType, options string) error {
+	for _, option := range strings.Split(options, ",") {
+		parts := strings.SplitN(option, "=", 2)
+		switch parts[0] {
+		case "optional":
+			attr.Optional = "true"
+		case "randomblind":
+			attr.RandomBlind = true
+		case "displayhint":
+			if len(parts) != 2 {
+				return errors.Errorf("attribute %s: displayhint requires a value", attr.ID)
+			}
+			attr.DisplayHint = parts[1]
+		case "displayindex":
+			if len(parts) != 2 {
+				return errors.Errorf("attribute %s: displayindex requires a value", attr.ID)
+			}
+			i, err := strconv.Atoi(parts[1])
+			if err != nil || i < 0 {
+				return errors.Errorf("attribute %s: invalid displayindex %s", attr.ID, parts[1])
+			}
+			attr.DisplayIndex = &i
+		default:
+			return errors.Errorf("attribute %s: unknown option %s", attr.ID, parts[0])
+		}
+	}
+	return nil
+}
+
+func init() {
+	credentialCmd.AddCommand(credentialCreateCmd)
+
+	flags := credentialCreateCmd.Flags()
+	flags.String("id", "", "Credential type ID (required)")
+	flags.StringArray("description", nil, "Description, as lang=text (repeatable)")
+	flags.StringArray("issue-url", nil, "URL where the credential can be obtained, as lang=url (repeatable)")
+	flags.Bool("ul-issue-url", false, "Issue URL is a universal link")
+	flags.Bool("singleton", false, "Credential type is a singleton")
+	flags.Bool("disallow-delete", false, "Disallow deletion of instances of the credential type by the user")
+	flags.StringArray("attribute", nil, "Attribute, as id[:option,...] (repeatable, in order)")
+	flags.StringArray("attribute-name", nil, "Attribute name, as id:lang=text (repeatable)")
+	flags.StringArray("attribute-description", nil, "Attribute description, as id:lang=text (repeatable)")
+	flags.StringArray("revocation-server", nil, "Revocation server URL (repeatable); enables revocation")
+	flags.Uint64("revocation-update-count", 0, "Amount of revocation updates to include in sessions (0 for default)")
+	flags.Uint64("revocation-update-speed", 0, "Speed of revocation updates (0 for default)")
+	addAuthoringFlags(flags)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Show differences between two versions of a scheme",
+	Long: `The diff command compares two versions of an IRMA scheme, or of an irma_configuration folder
+containing multiple schemes, and lists the added, removed and deprecated issuers, credential types,
+attributes and public keys. Changes that would invalidate existing credentials are marked as breaking.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		asJSON, _ := flags.GetBool("json")
+		failOnBreaking, _ := flags.GetBool("fail-on-breaking")
+
+		oldconf, err := parseForDiff(args[0])
+		if err != nil {
+			die("Failed to parse "+args[0], err)
+		}
+		newconf, err := parseForDiff(args[1])
+		if err != nil {
+			die("Failed to parse "+args[1], err)
+		}
+		diff, err := irma.DiffConfigurations(oldconf, newconf)
+		if err != nil {
+			die("Failed to compare schemes", err)
+		}
+
+		if asJSON {
+			bts, err := json.MarshalIndent(diff, "", "  ")
+			if err != nil {
+				die("Failed to serialize differences", err)
+			}
+			fmt.Println(string(bts))
+		} else if diff.Empty() {
+			fmt.Println("No differences found.")
+		} else {
+			fmt.Print(diff.String())
+		}
+
+		if breaking := diff.Breaking(); failOnBreaking && len(breaking) > 0 {
+			die(fmt.Sprintf("Found %d breaking changes", len(breaking)), nil)
+		}
+		return nil
+	},
+}
+
+// parseForDiff parses the scheme or irma_configuration folder at the specified path.
+func parseForDiff(path string) (*irma.Configuration, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := common.IsIrmaconfDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		conf, err := irma.NewConfiguration(path, irma.ConfigurationOptions{ReadOnly: true, IgnorePrivateKeys: true})
+		if err != nil {
+			return nil, err
+		}
+		return conf, conf.ParseFolder()
+	}
+
+	ok, err = common.IsScheme(path, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("path must contain a scheme, or multiple schemes in subdirectories")
+	}
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	_, err = conf.ParseSchemeFolder(path)
+	return conf, err
+}
+
+func init() {
+	schemeCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().Bool("json", false, "Output differences as JSON")
+	diffCmd.Flags().Bool("fail-on-breaking", false, "Exit with nonzero exit code if breaking changes are found")
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
+)
+
+var issuerCreateCmd = &cobra.Command{
+	Use:   "create [<path>]",
+	Short: "Create a new IRMA issuer within an IRMA scheme",
+	Long: `Create a new IRMA issuer within an IRMA scheme
+
+The create command creates a new issuer directory containing a description.xml within the IRMA scheme
+specified by the "path" parameter (if "path" is not provided the current directory is taken).
+Translated texts are specified as lang=text, for example:
+
+  irma scheme issuer create --id myissuer --name "en=My issuer" --name "nl=Mijn uitgever"
+
+After creating the issuer, public keys should be generated for it (using "irma scheme issuer keygen"),
+and the scheme must be resigned (using "irma scheme sign", or by passing --sign to this command)
+before it can be used in IRMA applications.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		id, _ := flags.GetString("id")
+		email, _ := flags.GetString("email")
+		address, _ := flags.GetString("address")
+		logo, _ := flags.GetString("logo")
+		overwrite, _ := flags.GetBool("force-overwrite")
+
+		path, err := pathArgument(args)
+		if err != nil {
+			return err
+		}
+		conf, scheme, err := parseSchemeForAuthoring(path)
+		if err != nil {
+			return err
+		}
+
+		if err = validateIdentifierPart("issuer", id); err != nil {
+			return err
+		}
+		issuer := &irma.Issuer{
+			ID:              id,
+			SchemeManagerID: scheme.ID,
+			ContactAddress:  address,
+			ContactEMail:    email,
+			XMLVersion:      4,
+		}
+		if issuer.Name, err = translatedStringFlag(flags, "name"); err != nil {
+			return err
+		}
+		if issuer.ShortName, err = translatedStringFlag(flags, "shortname"); err != nil {
+			return err
+		}
+		if len(issuer.ShortName) == 0 {
+			issuer.ShortName = issuer.Name
+		}
+		if err = conf.ValidateIssuer(issuer); err != nil {
+			return err
+		}
+
+		dir := filepath.Join(path, id)
+		if err = writeDescription(dir, issuer, logo, overwrite); err != nil {
+			return err
+		}
+		if err = common.EnsureDirectoryExists(filepath.Join(dir, "Issues")); err != nil {
+			return err
+		}
+		printWarnings(conf)
+
+		return signAfterAuthoring(flags, path)
+	},
+}
+
+var identifierPartRegexp = regexp.MustCompile(`^[\w-]+$`)
+
+func validateIdentifierPart(typ, id string) error {
+	if !identifierPartRegexp.MatchString(id) {
+		return errors.Errorf("invalid %s id %q: must be nonempty and may contain only letters, digits, - and _", typ, id)
+	}
+	return nil
+}
+
+func pathArgument(args []string) (string, error) {
+	var path string
+	var err error
+	if len(args) != 0 {
+		path = args[0]
+	} else {
+		if path, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", errors.WrapPrefix(err, "Invalid path", 0)
+	}
+	if err = common.AssertPathExists(path); err != nil {
+		return "", errors.WrapPrefix(err, "Nonexisting path specified", 0)
+	}
+	return path, nil
+}
+
+// parseSchemeForAuthoring parses the issuer scheme at the specified path. Content parsing errors
+// are tolerated, as these occur when the scheme contains newly created files not yet in its index.
+func parseSchemeForAuthoring(path string) (*irma.Configuration, *irma.SchemeManager, error) {
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := conf.ParseSchemeFolder(path)
+	if err != nil {
+		serr, ok := err.(*irma.SchemeManagerError)
+		if !ok || serr.Status != irma.SchemeManagerStatusContentParsingError {
+			return nil, nil, err
+		}
+	}
+	scheme, ok := s.(*irma.SchemeManager)
+	if !ok {
+		return nil, nil, errors.New("path does not contain an issuer scheme")
+	}
+	return conf, scheme, nil
+}
+
+// translatedStringFlag parses the values of a string array flag of the form lang=text.
+func translatedStringFlag(flags *pflag.FlagSet, name string) (irma.TranslatedString, error) {
+	values, _ := flags.GetStringArray(name)
+	ts := irma.TranslatedString{}
+	for _, val := range values {
+		parts := strings.SplitN(val, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid value for --%s: %q (must be of the form lang=text)", name, val)
+		}
+		ts[parts[0]] = parts[1]
+	}
+	return ts, nil
+}
+
+// writeDescription writes the XML encoding of the description to description.xml in the specified
+// directory, creating it if necessary, and copies the logo into it if specified.
+func writeDescription(dir string, description interface{}, logo string, overwrite bool) error {
+	file := filepath.Join(dir, "description.xml")
+	exists, err := common.PathExists(file)
+	if err != nil {
+		return err
+	}
+	if exists && !overwrite {
+		return errors.Errorf("%s already exists, will not overwrite (force with -f flag)", file)
+	}
+	if err = common.EnsureDirectoryExists(dir); err != nil {
+		return errors.WrapPrefix(err, "Failed to create "+dir, 0)
+	}
+
+	bts, err := xml.MarshalIndent(description, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(file, append(bts, '\n'), 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write description.xml", 0)
+	}
+	fmt.Println("Written", file)
+
+	if logo == "" {
+		return nil
+	}
+	bts, err = ioutil.ReadFile(logo)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to read logo", 0)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "logo.png"), bts, 0644)
+}
+
+func printWarnings(conf *irma.Configuration) {
+	for _, warning := range conf.Warnings {
+		// Files we just created are not yet in the index and show up as warnings; no need to report those
+		if strings.HasPrefix(warning, "Ignored ") {
+			continue
+		}
+		fmt.Println("Warning: " + warning)
+	}
+}
+
+// signAfterAuthoring resigns the scheme at the specified path if requested by the user.
+func signAfterAuthoring(flags *pflag.FlagSet, path string) error {
+	sign, _ := flags.GetBool("sign")
+	if !sign {
+		fmt.Println(`Scheme not resigned; use "irma scheme sign" to do so`)
+		return nil
+	}
+	sk, _ := flags.GetString("privatekey")
+	if sk == "" {
+		sk = filepath.Join(path, "sk.pem")
+	}
+	privatekey, err := readPrivateKey(sk)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to read private key:", 0)
+	}
+	if err = signScheme(privatekey, path, false); err != nil {
+		return errors.WrapPrefix(err, "Failed to sign scheme:", 0)
+	}
+	return nil
+}
+
+func addAuthoringFlags(flags *pflag.FlagSet) {
+	flags.StringArray("name", nil, "Name, as lang=text (repeatable)")
+	flags.StringArray("shortname", nil, "Short name, as lang=text (repeatable; default same as name)")
+	flags.String("logo", "", "Path to logo to copy into the new directory as logo.png")
+	flags.BoolP("force-overwrite", "f", false, "Force overwriting of description.xml if it already exists")
+	flags.Bool("sign", false, "Resign the scheme afterwards")
+	flags.String("privatekey", "", `Private key to resign the scheme with (default "sk.pem" in the scheme)`)
+}
+
+func init() {
+	issuerCmd.AddCommand(issuerCreateCmd)
+
+	flags := issuerCreateCmd.Flags()
+	flags.String("id", "", "Issuer ID (required)")
+	flags.String("email", "", "Contact e-mail address")
+	flags.String("address", "", "Contact address")
+	addAuthoringFlags(flags)
+}
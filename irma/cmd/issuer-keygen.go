@@ -12,6 +12,7 @@ import (
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
 )
 
 // issuerKeygenCmd represents the keygen command
@@ -35,38 +36,10 @@ IRMA applications.`,
 		privkeyfile, _ := flags.GetString("privatekey")
 		pubkeyfile, _ := flags.GetString("publickey")
 		overwrite, _ := flags.GetBool("force-overwrite")
-		expiryDateString, _ := flags.GetString("expirydate")
-		validFor, _ := flags.GetString("valid-for")
 
-		var expiryDate time.Time
-		var err error
-		if expiryDateString != "" {
-			expiryDate, err = time.Parse(time.RFC3339, expiryDateString)
-			if err != nil {
-				return errors.WrapPrefix(err, "Failed to parse expirydate", 0)
-			}
-		} else {
-			expiryDate = time.Now()
-			m := regexp.MustCompile(`^(\d+)([yMdhm])$`).FindStringSubmatch(validFor)
-			if m == nil {
-				return errors.New("unable to parse valid-for period")
-			}
-			num, err := strconv.Atoi(m[1])
-			if err != nil {
-				return errors.New("unable to parse valid-for period")
-			}
-			switch m[2] {
-			case "m":
-				expiryDate = expiryDate.Add(time.Minute * time.Duration(num))
-			case "h":
-				expiryDate = expiryDate.Add(time.Hour * time.Duration(num))
-			case "d":
-				expiryDate = expiryDate.AddDate(0, 0, num)
-			case "M":
-				expiryDate = expiryDate.AddDate(0, num, 0)
-			case "y":
-				expiryDate = expiryDate.AddDate(num, 0, 0)
-			}
+		expiryDate, err := expiryDateFromFlags(flags)
+		if err != nil {
+			return err
 		}
 
 		var path string
@@ -124,6 +97,43 @@ IRMA applications.`,
 	},
 }
 
+// expiryDateFromFlags returns the key expiry date specified by the expirydate or valid-for flags.
+func expiryDateFromFlags(flags *pflag.FlagSet) (time.Time, error) {
+	expiryDateString, _ := flags.GetString("expirydate")
+	validFor, _ := flags.GetString("valid-for")
+
+	if expiryDateString != "" {
+		expiryDate, err := time.Parse(time.RFC3339, expiryDateString)
+		if err != nil {
+			return time.Time{}, errors.WrapPrefix(err, "Failed to parse expirydate", 0)
+		}
+		return expiryDate, nil
+	}
+
+	expiryDate := time.Now()
+	m := regexp.MustCompile(`^(\d+)([yMdhm])$`).FindStringSubmatch(validFor)
+	if m == nil {
+		return time.Time{}, errors.New("unable to parse valid-for period")
+	}
+	num, err := strconv.Atoi(m[1])
+	if err != nil {
+		return time.Time{}, errors.New("unable to parse valid-for period")
+	}
+	switch m[2] {
+	case "m":
+		expiryDate = expiryDate.Add(time.Minute * time.Duration(num))
+	case "h":
+		expiryDate = expiryDate.Add(time.Hour * time.Duration(num))
+	case "d":
+		expiryDate = expiryDate.AddDate(0, 0, num)
+	case "M":
+		expiryDate = expiryDate.AddDate(0, num, 0)
+	case "y":
+		expiryDate = expiryDate.AddDate(num, 0, 0)
+	}
+	return expiryDate, nil
+}
+
 func defaultCounter(path string) (counter int) {
 	matches, _ := filepath.Glob(filepath.Join(path, "PublicKeys", "*.xml"))
 	for _, match := range matches {
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+)
+
+var issuerRotateCmd = &cobra.Command{
+	Use:   "rotate [<path>]",
+	Short: "Generate the next IRMA issuer private/public keypair",
+	Long: `Generate the next IRMA issuer private/public keypair
+
+The rotate command generates a new private/public keypair, including revocation key material, for the
+IRMA issuer specified by the "path" parameter (if "path" is not provided the current directory is
+taken). Its counter is one higher than that of the latest existing public key of the issuer, and
+unless specified otherwise, its key length and number of attributes are taken from that key.
+
+The public key is placed in the PublicKeys folder of the issuer. The private key is placed in its
+PrivateKeys folder, or if --privkeys is specified, in that folder as scheme.issuer.counter.xml, such
+that it can be used directly by an IRMA server configured with that private keys folder.
+
+After rotating, the scheme must be resigned (using "irma scheme sign", or by passing --sign to this
+command) before it can be used in IRMA applications.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		keylength, _ := flags.GetInt("keylength")
+		numAttributes, _ := flags.GetInt("numattributes")
+		privkeysdir, _ := flags.GetString("privkeys")
+
+		expiryDate, err := expiryDateFromFlags(flags)
+		if err != nil {
+			return err
+		}
+		path, err := pathArgument(args)
+		if err != nil {
+			return err
+		}
+		schemepath := filepath.Dir(path)
+		conf, scheme, err := parseSchemeForAuthoring(schemepath)
+		if err != nil {
+			return err
+		}
+		issuer, err := authoringIssuer(conf, scheme, path)
+		if err != nil {
+			return err
+		}
+
+		counter := uint(defaultCounter(path))
+		if counter > 0 {
+			latest, err := gabi.NewPublicKeyFromFile(filepath.Join(path, "PublicKeys", strconv.Itoa(int(counter-1))+".xml"))
+			if err != nil {
+				return errors.WrapPrefix(err, "Failed to read latest public key", 0)
+			}
+			if keylength == 0 {
+				keylength = latest.N.BitLen()
+			}
+			if numAttributes == 0 {
+				numAttributes = len(latest.R)
+			}
+			if time.Unix(latest.ExpiryDate, 0).After(expiryDate) {
+				return errors.Errorf("new public key would expire before the latest public key (at %s)",
+					time.Unix(latest.ExpiryDate, 0).String())
+			}
+		}
+		if keylength == 0 {
+			keylength = 2048
+		}
+		if numAttributes == 0 {
+			numAttributes = 12
+		}
+		sysParams, ok := gabi.DefaultSystemParameters[keylength]
+		if !ok {
+			return errors.Errorf("Unsupported key length, should be one of %v", gabi.DefaultKeyLengths)
+		}
+
+		pubkeyfile := filepath.Join(path, "PublicKeys", fmt.Sprintf("%d.xml", counter))
+		privkeyfile := filepath.Join(path, "PrivateKeys", fmt.Sprintf("%d.xml", counter))
+		if privkeysdir != "" {
+			privkeyfile = filepath.Join(privkeysdir, fmt.Sprintf("%s.%s.%d.xml", scheme.ID, issuer.ID, counter))
+		}
+		for _, file := range []string{pubkeyfile, privkeyfile} {
+			if err = common.EnsureDirectoryExists(filepath.Dir(file)); err != nil {
+				return errors.WrapPrefix(err, "Failed to create "+filepath.Dir(file), 0)
+			}
+			if exists, _ := common.PathExists(file); exists {
+				return errors.Errorf("%s already exists, will not overwrite", file)
+			}
+		}
+
+		fmt.Printf("Generating keypair %d for issuer %s.%s (may take several minutes)\n", counter, scheme.ID, issuer.ID)
+		privk, pubk, err := gabi.GenerateKeyPair(sysParams, numAttributes, counter, expiryDate)
+		if err != nil {
+			return err
+		}
+		if _, err = privk.WriteToFile(privkeyfile, false); err != nil {
+			return errors.WrapPrefix(err, "Failed to write private key", 0)
+		}
+		if _, err = pubk.WriteToFile(pubkeyfile, false); err != nil {
+			return errors.WrapPrefix(err, "Failed to write public key", 0)
+		}
+		fmt.Println("Private key written to", privkeyfile)
+		fmt.Println("Public key written to", pubkeyfile, "(expires at", expiryDate.String()+")")
+
+		return signAfterAuthoring(flags, schemepath)
+	},
+}
+
+func init() {
+	issuerCmd.AddCommand(issuerRotateCmd)
+
+	flags := issuerRotateCmd.Flags()
+	flags.StringP("expirydate", "e", "", "Expiry date for the key pair. Specify in RFC3339 (\"2006-01-02T15:04:05+07:00\") format. Alternatively, use the --valid-for option.")
+	flags.StringP("valid-for", "v", "1y", "The duration key pair should be valid starting from now. Specify as a number followed by either y, M, d, h, or m (for years, months, days, hours, and minutes, respectively). This flag is ignored when expirydate flag is used.")
+	flags.IntP("keylength", "l", 0, "Keylength (default same as latest public key, or 2048)")
+	flags.IntP("numattributes", "a", 0, "Number of attributes (default same as latest public key, or 12)")
+	flags.StringP("privkeys", "k", "", "Folder to write private key to (default PrivateKeys folder of the issuer)")
+	flags.Bool("sign", false, "Resign the scheme afterwards")
+	flags.String("privatekey", "", `Private key to resign the scheme with (default "sk.pem" in the scheme)`)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [<path>]",
+	Short: "Check a scheme for problems",
+	Long: `The lint command checks the scheme in the specified directory, or the current directory if not
+specified, for problems. Unlike "irma scheme verify" it does not stop at the first problem, but lists
+all problems it finds along with their position: signature and index problems, invalid descriptions,
+missing translations, unused logos, conflicting attribute display indices, unrecognized display hints,
+nondeprecated credential types of deprecated issuers, and (soon to be) expired public keys.
+
+The command exits with a nonzero exit code if any problem with severity "error" is found.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		format, _ := flags.GetString("format")
+		days, _ := flags.GetUint("expiry-days")
+		langs, _ := flags.GetStringSlice("languages")
+
+		path, err := pathArgument(args)
+		if err != nil {
+			return err
+		}
+		diagnostics, err := irma.LintScheme(path, irma.LintOptions{
+			Languages:    langs,
+			ExpiryWindow: time.Duration(days) * 24 * time.Hour,
+		})
+		if err != nil {
+			die("Failed to lint scheme", err)
+		}
+
+		switch format {
+		case "text":
+			for _, d := range diagnostics {
+				fmt.Println(d.String())
+			}
+		case "json":
+			if diagnostics == nil {
+				diagnostics = []*irma.LintDiagnostic{}
+			}
+			err = printJSON(diagnostics)
+		case "sarif":
+			err = printJSON(sarifLog(diagnostics))
+		default:
+			return errors.New("unsupported format (must be text, json or sarif)")
+		}
+		if err != nil {
+			die("Failed to serialize problems", err)
+		}
+
+		for _, d := range diagnostics {
+			if d.Severity == irma.LintSeverityError {
+				die("Scheme contains errors", nil)
+			}
+		}
+		return nil
+	},
+}
+
+func printJSON(o interface{}) error {
+	bts, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bts))
+	return nil
+}
+
+// sarifLog converts the diagnostics to a log in the Static Analysis Results Interchange Format,
+// see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+func sarifLog(diagnostics []*irma.LintDiagnostic) map[string]interface{} {
+	var rules []string
+	for rule := range irma.LintRules {
+		rules = append(rules, string(rule))
+	}
+	sort.Strings(rules)
+	sarifRules := make([]map[string]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		sarifRules = append(sarifRules, map[string]interface{}{
+			"id":               rule,
+			"shortDescription": map[string]string{"text": irma.LintRules[irma.LintRule(rule)]},
+		})
+	}
+
+	results := make([]map[string]interface{}, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		location := map[string]interface{}{
+			"artifactLocation": map[string]string{"uri": d.File},
+		}
+		if d.Line > 0 {
+			location["region"] = map[string]int{"startLine": d.Line}
+		}
+		results = append(results, map[string]interface{}{
+			"ruleId":    d.Rule,
+			"level":     d.Severity,
+			"message":   map[string]string{"text": d.Message},
+			"locations": []map[string]interface{}{{"physicalLocation": location}},
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "irma scheme lint",
+					"version":        irma.Version,
+					"informationUri": "https://irma.app/docs",
+					"rules":          sarifRules,
+				},
+			},
+			"results": results,
+		}},
+	}
+}
+
+func init() {
+	schemeCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringP("format", "f", "text", "Output format (text, json or sarif)")
+	lintCmd.Flags().Uint("expiry-days", 31, "Report public keys expiring within this amount of days")
+	lintCmd.Flags().StringSlice("languages", []string{"en", "nl"}, "Languages in which all texts must be translated")
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+)
+
+var revocationAuditCmd = &cobra.Command{
+	Use:   "audit <credentialtype> [<url>]",
+	Short: "Verify the revocation event chains of a credential type",
+	Long: `Verify the revocation event chains of a credential type
+
+The audit command retrieves the accumulator and all events of each revocation key of the credential
+type, and verifies that the events form a hash chain with consecutive indices that ends in the
+accumulator, and that the accumulator is validly signed by the issuer. All problems found are reported.
+
+The revocation state is downloaded from the revocation server at the specified URL, or if no URL is
+given, from the revocation servers listed in the credential type. Alternatively, a revocation database
+can be audited directly by specifying --db-type and --db-str; a bolt database can only be audited
+directly while no IRMA server using it is running.
+
+The command exits with a nonzero exit code if any problems are found, so that it can be used for
+periodic checks.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		asjson, _ := flags.GetBool("json")
+		dbtype, _ := flags.GetString("db-type")
+		verbosity, _ := flags.GetCount("verbose")
+		logger.Level = server.Verbosity(verbosity)
+		irma.SetLogger(logger)
+
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+
+		var audit *irma.RevocationAudit
+		if dbtype != "" {
+			if len(args) > 1 {
+				die("specify either a URL or a database", nil)
+			}
+			var connstr string
+			if _, connstr, err = revocationDBFromFlags(flags); err != nil {
+				die("", err)
+			}
+			audit, err = irma.AuditRevocationDB(conf, id, dbtype, connstr)
+		} else {
+			var url string
+			if len(args) > 1 {
+				url = args[1]
+			}
+			audit, err = irma.AuditRevocationServer(conf, id, url)
+		}
+		if err != nil {
+			die("failed to audit revocation state", err)
+		}
+
+		if asjson {
+			bts, err := json.MarshalIndent(audit, "", "  ")
+			if err != nil {
+				die("failed to serialize audit", err)
+			}
+			fmt.Println(string(bts))
+		} else {
+			printRevocationAudit(audit)
+		}
+		if !audit.OK() {
+			os.Exit(1)
+		}
+	},
+}
+
+func printRevocationAudit(audit *irma.RevocationAudit) {
+	if len(audit.Keys) == 0 {
+		fmt.Printf("No revocation state found for %s\n", audit.CredentialType)
+		return
+	}
+	for _, k := range audit.Keys {
+		status := "OK"
+		if len(k.Problems) > 0 {
+			status = fmt.Sprintf("%d problem(s)", len(k.Problems))
+		}
+		fmt.Printf("Key %d: %d events, accumulator index %d of %s: %s\n",
+			k.Counter, k.Events, k.Index, time.Unix(k.Time, 0).Format(time.RFC3339), status)
+		for _, p := range k.Problems {
+			fmt.Printf("  - %s\n", p)
+		}
+	}
+}
+
+func init() {
+	revocationCmd.AddCommand(revocationAuditCmd)
+
+	flags := revocationAuditCmd.Flags()
+	addRevocationDBFlags(flags)
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.Bool("json", false, "print the audit results as JSON")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
+)
+
+var revocationDBCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the schema of a revocation database",
+	Long: `Manage the schema of a revocation database
+
+The schema of the revocation database of an IRMA server is versioned. By default, the IRMA server
+applies pending schema migrations when it starts. When started with --revocation-db-no-migrate, it
+instead refuses to start if migrations are pending, so that they can be applied manually using the
+migrate subcommand (for example, after making a backup of the database). The IRMA server always
+refuses to start if the database schema is newer than it supports.
+
+A bolt database cannot be accessed by these commands while an IRMA server using it is running.`,
+}
+
+var revocationDBStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the schema version and pending migrations of a revocation database",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbtype, connstr, err := revocationDBFromFlags(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		status, err := irma.RevocationDBSchemaStatus(dbtype, connstr)
+		if err != nil {
+			die("failed to read revocation database schema version", err)
+		}
+
+		fmt.Printf("Schema version: %d (latest supported: %d)\n", status.Version, status.LatestVersion)
+		if status.Version > status.LatestVersion {
+			fmt.Println("The database schema is newer than supported by this version of irma")
+		}
+		if len(status.Applied) > 0 {
+			fmt.Println("Applied migrations:")
+			for _, m := range status.Applied {
+				fmt.Printf("  %3d  %s  %s\n", m.Version, time.Unix(m.AppliedAt, 0).Format(time.RFC3339), m.Description)
+			}
+		}
+		if len(status.Pending) > 0 {
+			fmt.Println("Pending migrations:")
+			for _, m := range status.Pending {
+				fmt.Printf("  %3d  %s\n", m.Version, m.Description)
+			}
+		}
+		return nil
+	},
+}
+
+var revocationDBMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations to a revocation database",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbtype, connstr, err := revocationDBFromFlags(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		verbosity, _ := cmd.Flags().GetCount("verbose")
+		logger.Level = server.Verbosity(verbosity)
+		irma.SetLogger(logger)
+
+		applied, err := irma.MigrateRevocationDB(dbtype, connstr)
+		if err != nil {
+			die("", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Revocation database schema is up to date")
+			return nil
+		}
+		fmt.Printf("Migrated revocation database to schema version %d\n", applied[len(applied)-1].Version)
+		return nil
+	},
+}
+
+func init() {
+	revocationCmd.AddCommand(revocationDBCmd)
+	revocationDBCmd.AddCommand(revocationDBStatusCmd)
+	revocationDBCmd.AddCommand(revocationDBMigrateCmd)
+
+	addRevocationDBFlags(revocationDBStatusCmd.Flags())
+	addRevocationDBFlags(revocationDBMigrateCmd.Flags())
+	revocationDBMigrateCmd.Flags().CountP("verbose", "v", "verbose (repeatable)")
+}
+
+func addRevocationDBFlags(flags *pflag.FlagSet) {
+	flags.String("db-type", "", "database type of revocation database (supported: mysql, postgres, bolt)")
+	flags.String("db-str", "", "connection string for revocation database (for bolt: path to database file)")
+}
+
+func revocationDBFromFlags(flags *pflag.FlagSet) (string, string, error) {
+	dbtype, _ := flags.GetString("db-type")
+	connstr, _ := flags.GetString("db-str")
+	if dbtype == "" || connstr == "" {
+		return "", "", errors.New("--db-type and --db-str are required")
+	}
+	return dbtype, connstr, nil
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
+)
+
+var revocationKeysCmd = &cobra.Command{
+	Use:   "keys <credentialtype>",
+	Short: "Show the accumulator state of each issuer key of a credential type",
+	Long: `Show the accumulator state of each issuer key of a credential type
+
+Revocation state is kept per issuer key: credentials issued with an older key of the issuer are
+revoked using the accumulator of that key. The keys command lists, for each issuer key for which
+revocation is enabled in the revocation database specified by --db-type and --db-str, the amount of
+revocations, when its accumulator was last signed, and when the key expires.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf, err := openRevocationAuthority(cmd.Flags(), id)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conf.Revocation.Close() }()
+		printRevocationKeyStates(conf, id)
+		return nil
+	},
+}
+
+var revocationRotateCmd = &cobra.Command{
+	Use:   "rotate <credentialtype>",
+	Short: "Enable revocation for the latest issuer key of a credential type",
+	Long: `Enable revocation for the latest issuer key of a credential type
+
+After the issuer of a credential type has rotated to a new key (see "irma issuer rotate"), the rotate
+command creates the initial accumulator for the new key in the revocation database specified by
+--db-type and --db-str, so that credentials issued with the new key can be revoked. The accumulators
+of older keys are kept. Afterwards it shows the accumulator state of each issuer key.
+
+The IRMA server also creates the initial accumulator when it starts, if it has the new private key.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf, err := openRevocationAuthority(cmd.Flags(), id)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conf.Revocation.Close() }()
+
+		counter, err := conf.Revocation.RotateRevocation(id)
+		if err != nil {
+			die("failed to enable revocation for latest issuer key", err)
+		}
+		fmt.Printf("Revocation enabled for %s-%d\n", id, counter)
+		printRevocationKeyStates(conf, id)
+		return nil
+	},
+}
+
+// openRevocationAuthority opens the revocation database specified in the flags as revocation
+// authority for the specified credential type.
+func openRevocationAuthority(flags *pflag.FlagSet, id irma.CredentialTypeIdentifier) (*irma.Configuration, error) {
+	dbtype, connstr, err := revocationDBFromFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+	schemespath, _ := flags.GetString("schemes-path")
+	privkeys, _ := flags.GetString("privkeys")
+	verbosity, _ := flags.GetCount("verbose")
+	logger.Level = server.Verbosity(verbosity)
+	irma.SetLogger(logger)
+
+	conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{
+		ReadOnly:            true,
+		RevocationDBType:    dbtype,
+		RevocationDBConnStr: connstr,
+		RevocationSettings:  irma.RevocationSettings{id: {Authority: true}},
+	})
+	if err != nil {
+		die("failed to open irma_configuration", err)
+	}
+	if err = conf.ParseFolder(); err != nil {
+		die("failed to parse irma_configuration", err)
+	}
+	if privkeys != "" {
+		ring, err := irma.NewPrivateKeyRingFolder(privkeys, conf)
+		if err != nil {
+			die("failed to read private keys", err)
+		}
+		if err = conf.AddPrivateKeyRing(ring); err != nil {
+			die("failed to read private keys", err)
+		}
+	}
+	if credtype := conf.CredentialTypes[id]; credtype == nil || !credtype.RevocationSupported() {
+		die("unknown credential type or credential type does not support revocation", nil)
+	}
+	return conf, nil
+}
+
+func printRevocationKeyStates(conf *irma.Configuration, id irma.CredentialTypeIdentifier) {
+	states, err := conf.Revocation.KeyStates(id)
+	if err != nil {
+		die("failed to read revocation key states", err)
+	}
+	fmt.Printf("%-8s %-10s %-26s %s\n", "Counter", "Revoked", "Accumulator signed", "Key expires")
+	for _, s := range states {
+		expiry := "unknown"
+		if s.ExpiryDate != 0 {
+			expiry = time.Unix(s.ExpiryDate, 0).Format(time.RFC3339)
+		}
+		latest := ""
+		if s.Latest {
+			latest = " (latest)"
+		}
+		fmt.Printf("%-8d %-10d %-26s %s%s\n", s.Counter, s.Index, time.Unix(s.Time, 0).Format(time.RFC3339), expiry, latest)
+	}
+}
+
+func addRevocationAuthorityFlags(flags *pflag.FlagSet) {
+	addRevocationDBFlags(flags)
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
+
+func init() {
+	revocationCmd.AddCommand(revocationKeysCmd)
+	revocationCmd.AddCommand(revocationRotateCmd)
+
+	addRevocationAuthorityFlags(revocationKeysCmd.Flags())
+	addRevocationAuthorityFlags(revocationRotateCmd.Flags())
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+)
+
+var revocationPromoteCmd = &cobra.Command{
+	Use:   "promote <credentialtype> <authorityurl>",
+	Short: "Promote a revocation standby to revocation authority",
+	Long: `Promote a revocation standby to revocation authority
+
+The promote command promotes the revocation standby of a credential type, whose revocation database
+is specified by --db-type and --db-str, to revocation authority. It first synchronizes the database
+with the current revocation authority at the specified URL. It then fences the current authority,
+after which that refuses to act as authority for the credential type, also after restarting.
+
+If the current authority cannot be reached, the command fails unless --force is specified. In that
+case you must ensure that the current authority is not running and will not be restarted.
+
+The IRMA server of the standby must not be running during promotion. Afterwards, configure it as
+authority for the credential type (instead of standby) and restart it. Issuers of the credential type
+must be reconfigured to send their issuance records to the new authority.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		dbtype, connstr, err := revocationDBFromFlags(flags)
+		if err != nil {
+			return err
+		}
+		schemespath, _ := flags.GetString("schemes-path")
+		privkeys, _ := flags.GetString("privkeys")
+		force, _ := flags.GetBool("force")
+		verbosity, _ := flags.GetCount("verbose")
+		logger.Level = server.Verbosity(verbosity)
+		irma.SetLogger(logger)
+
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{
+			ReadOnly:            true,
+			RevocationDBType:    dbtype,
+			RevocationDBConnStr: connstr,
+			RevocationSettings: irma.RevocationSettings{
+				id: {Standby: true, RevocationServerURL: args[1]},
+			},
+		})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+		defer func() { _ = conf.Revocation.Close() }()
+		if privkeys != "" {
+			ring, err := irma.NewPrivateKeyRingFolder(privkeys, conf)
+			if err != nil {
+				die("failed to read private keys", err)
+			}
+			if err = conf.AddPrivateKeyRing(ring); err != nil {
+				die("failed to read private keys", err)
+			}
+		}
+		if credtype := conf.CredentialTypes[id]; credtype == nil || !credtype.RevocationSupported() {
+			die("unknown credential type or credential type does not support revocation", nil)
+		}
+
+		if err = conf.Revocation.Promote(id, force); err != nil {
+			die("failed to promote revocation standby", err)
+		}
+		fmt.Printf("Promoted to revocation authority for %s; now configure the IRMA server as authority for it\n", id)
+		return nil
+	},
+}
+
+func init() {
+	revocationCmd.AddCommand(revocationPromoteCmd)
+
+	flags := revocationPromoteCmd.Flags()
+	addRevocationDBFlags(flags)
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.Bool("force", false, "promote even if the current revocation authority cannot be reached")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
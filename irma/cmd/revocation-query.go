@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
+)
+
+var revocationStatusCmd = &cobra.Command{
+	Use:   "status <credentialtype> <key> <url>",
+	Short: "Show the issuance records of a revocation key and their revocation state",
+	Long: `Show the issuance records of a revocation key and their revocation state
+
+The status command asks the IRMA server at the specified URL, which must be the revocation server of
+the credential type, for all credentials issued with the specified revocation key. For each of them
+it shows when it was issued and until when it is valid, with which issuer key it was issued, and if
+it has been revoked, when and by which event in the event log of the issuer key.`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		request := &irma.RevocationQueryRequest{
+			LDContext:      irma.LDContextRevocationQueryRequest,
+			CredentialType: irma.NewCredentialTypeIdentifier(args[0]),
+			Key:            args[1],
+		}
+		_, res := postRevocationQuery(cmd.Flags(), request, args[2])
+
+		if asjson, _ := cmd.Flags().GetBool("json"); asjson {
+			printRevocationQueryJson(res.Records)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ISSUED\tVALID UNTIL\tCOUNTER\tREVOKED AT\tEVENT")
+		for _, r := range res.Records {
+			revokedAt, event := "-", "-"
+			if r.Revoked {
+				revokedAt = formatNanos(r.RevokedAt)
+				if r.EventIndex != nil {
+					event = strconv.FormatUint(*r.EventIndex, 10)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+				formatNanos(r.Issued), formatNanos(r.ValidUntil), r.PKCounter, revokedAt, event)
+		}
+		_ = w.Flush()
+	},
+}
+
+var revocationEventsCmd = &cobra.Command{
+	Use:   "events <credentialtype> <counter> <url>",
+	Short: "Export the revocation event log of an issuer key",
+	Long: `Export the revocation event log of an issuer key
+
+The events command fetches the complete event log of the specified issuer key, along with the current
+accumulator signed by the issuer, from the IRMA server at the specified URL, which must be the revocation
+server of the credential type. It verifies the event log and writes it as JSON to stdout, or to the
+file specified with --output.`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		counter, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			die("invalid key counter", err)
+		}
+		c := uint(counter)
+		request := &irma.RevocationQueryRequest{
+			LDContext:      irma.LDContextRevocationQueryRequest,
+			CredentialType: irma.NewCredentialTypeIdentifier(args[0]),
+			Counter:        &c,
+		}
+		conf, res := postRevocationQuery(cmd.Flags(), request, args[2])
+		if res.EventLog == nil {
+			die("server did not return an event log", nil)
+		}
+		pk, err := conf.Revocation.Keys.PublicKey(request.CredentialType.IssuerIdentifier(), c)
+		if err != nil {
+			die("failed to read issuer public key", err)
+		}
+		if _, err = res.EventLog.Verify(pk); err != nil {
+			die("event log does not verify", err)
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			printRevocationQueryJson(res.EventLog)
+			return
+		}
+		bts, err := json.MarshalIndent(res.EventLog, "", "  ")
+		if err != nil {
+			die("failed to serialize event log", err)
+		}
+		if err = ioutil.WriteFile(output, bts, 0644); err != nil {
+			die("failed to write event log", err)
+		}
+	},
+}
+
+func postRevocationQuery(
+	flags *pflag.FlagSet, request *irma.RevocationQueryRequest, url string,
+) (*irma.Configuration, *irma.RevocationQueryResponse) {
+	schemespath, _ := flags.GetString("schemes-path")
+	authmethod, _ := flags.GetString("auth-method")
+	key, _ := flags.GetString("key")
+	name, _ := flags.GetString("name")
+	verbosity, _ := flags.GetCount("verbose")
+
+	conf := checkRevocationCredentialType(request.CredentialType, schemespath, verbosity)
+	res := &irma.RevocationQueryResponse{}
+	err := postRevocationRequest(url, "revocation/query", request, authmethod, key, name, res,
+		func(j irma.ServerJwt) revocationJwt {
+			return &irma.RevocationQueryJwt{ServerJwt: j, Request: request}
+		},
+	)
+	if err != nil {
+		die("failed to post revocation query request", err)
+	}
+	return conf, res
+}
+
+func printRevocationQueryJson(o interface{}) {
+	bts, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		die("failed to serialize response", err)
+	}
+	fmt.Println(string(bts))
+}
+
+func formatNanos(t int64) string {
+	return time.Unix(0, t).Format(time.RFC3339)
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{revocationStatusCmd, revocationEventsCmd} {
+		flags := cmd.Flags()
+		flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+		flags.StringP("auth-method", "a", "none", "Authentication method to server (none, token, rsa, hmac)")
+		flags.String("key", "", "Key to sign request with")
+		flags.String("name", "", "Requestor name")
+		flags.CountP("verbose", "v", "verbose (repeatable)")
+		revocationCmd.AddCommand(cmd)
+	}
+	revocationStatusCmd.Flags().Bool("json", false, "print the issuance records as JSON")
+	revocationEventsCmd.Flags().StringP("output", "o", "", "write the event log to this file instead of stdout")
+}
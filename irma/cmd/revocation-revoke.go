@@ -1,17 +1,27 @@
 package cmd
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/server"
 	"github.com/sietseringers/cobra"
+	"github.com/sietseringers/pflag"
 )
 
 var revokeCmd = &cobra.Command{
-	Use:   "revoke <credentialtype> <key> <url>",
+	Use:   "revoke <credentialtype> <key> [<url>]",
 	Short: "Revoke a previously issued credential identified by a given key",
-	Args:  cobra.ExactArgs(3),
+	Long: `Revoke a previously issued credential identified by a given key
+
+The revoke command revokes all credentials having the specified revocation key, regardless of the
+issuer key with which they were issued. By default it sends a revocation request to the IRMA server
+at the specified URL. If --db-type and --db-str are specified instead, it revokes the credentials
+directly in the revocation database of the revocation authority, in a single transaction, and shows
+the amount of revoked credentials per issuer key.`,
+	Args: cobra.RangeArgs(2, 3),
 	Run: func(cmd *cobra.Command, args []string) {
 		flags := cmd.Flags()
 		schemespath, _ := flags.GetString("schemes-path")
@@ -19,6 +29,17 @@ var revokeCmd = &cobra.Command{
 		key, _ := flags.GetString("key")
 		name, _ := flags.GetString("name")
 		verbosity, _ := cmd.Flags().GetCount("verbose")
+
+		if dbtype, _ := flags.GetString("db-type"); dbtype != "" {
+			if len(args) != 2 {
+				die("specify either a URL or --db-type and --db-str", nil)
+			}
+			revokeLocal(flags, irma.NewCredentialTypeIdentifier(args[0]), args[1])
+			return
+		}
+		if len(args) != 3 {
+			die("URL of the IRMA server required", nil)
+		}
 		url := args[2]
 
 		request := &irma.RevocationRequest{
@@ -31,7 +52,39 @@ var revokeCmd = &cobra.Command{
 	},
 }
 
+func revokeLocal(flags *pflag.FlagSet, id irma.CredentialTypeIdentifier, key string) {
+	conf, err := openRevocationAuthority(flags, id)
+	if err != nil {
+		die("", err)
+	}
+	defer func() { _ = conf.Revocation.Close() }()
+	counts, err := conf.Revocation.RevokeKey(id, key)
+	if err != nil {
+		die("failed to revoke", err)
+	}
+	for counter, count := range counts {
+		fmt.Printf("Revoked %d credential(s) issued with %s-%d\n", count, id, counter)
+	}
+}
+
 func postRevocation(request *irma.RevocationRequest, url, schemespath, authmethod, key, name string, verbosity int) {
+	checkRevocationCredentialType(request.CredentialType, schemespath, verbosity)
+	err := postRevocationRequest(url, "revocation", request, authmethod, key, name, nil,
+		func(j irma.ServerJwt) revocationJwt {
+			return &irma.RevocationJwt{ServerJwt: j, Request: request}
+		},
+	)
+	if err != nil {
+		die("failed to post revocation request", err)
+	}
+}
+
+// revocationJwt is a JWT containing a request to the revocation endpoints of the IRMA server.
+type revocationJwt interface {
+	Sign(jwt.SigningMethod, interface{}) (string, error)
+}
+
+func checkRevocationCredentialType(id irma.CredentialTypeIdentifier, schemespath string, verbosity int) *irma.Configuration {
 	logger.Level = server.Verbosity(verbosity)
 	irma.SetLogger(logger)
 
@@ -43,43 +96,49 @@ func postRevocation(request *irma.RevocationRequest, url, schemespath, authmetho
 		die("failed to parse irma_configuration", err)
 	}
 
-	credtype, known := conf.CredentialTypes[request.CredentialType]
+	credtype, known := conf.CredentialTypes[id]
 	if !known {
 		die("unknown credential type", nil)
 	}
 	if !credtype.RevocationSupported() {
 		die("credential type does not support revocation", nil)
 	}
+	return conf
+}
 
+// postRevocationRequest posts the request to the specified path of the IRMA server, authenticating
+// using the specified authentication method. For the JWT-based methods, newJwt must wrap the request
+// in a JWT.
+func postRevocationRequest(
+	url, path string, request interface{}, authmethod, key, name string, result interface{},
+	newJwt func(irma.ServerJwt) revocationJwt,
+) error {
 	transport := irma.NewHTTPTransport(url, false)
 
 	switch authmethod {
 	case "none":
-		err = transport.Post("revocation", nil, request)
+		return transport.Post(path, result, request)
 	case "token":
 		transport.SetHeader("Authorization", key)
-		err = transport.Post("revocation", nil, request)
+		return transport.Post(path, result, request)
 	case "hmac", "rsa":
 		sk, jwtalg, err := configureJWTKey(authmethod, key)
-		j := irma.RevocationJwt{
-			ServerJwt: irma.ServerJwt{
-				ServerName: name,
-				IssuedAt:   irma.Timestamp(time.Now()),
-			},
-			Request: request,
+		if err != nil {
+			die("failed to read key", err)
 		}
+		j := newJwt(irma.ServerJwt{
+			ServerName: name,
+			IssuedAt:   irma.Timestamp(time.Now()),
+		})
 		jwtstr, err := j.Sign(jwtalg, sk)
 		if err != nil {
 			die("failed to sign JWT", err)
 		}
-		err = transport.Post("revocation", nil, jwtstr)
+		return transport.Post(path, result, jwtstr)
 	default:
 		die("Invalid authentication method (must be none, token, hmac or rsa)", nil)
 	}
-
-	if err != nil {
-		die("failed to post revocation request", err)
-	}
+	return nil
 }
 
 func init() {
@@ -89,6 +148,8 @@ func init() {
 	flags.String("key", "", "Key to sign request with")
 	flags.String("name", "", "Requestor name")
 	flags.CountP("verbose", "v", "verbose (repeatable)")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys (with --db-type and --db-str)")
+	addRevocationDBFlags(flags)
 
 	revocationCmd.AddCommand(revokeCmd)
 }
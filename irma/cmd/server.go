@@ -91,11 +91,15 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.String("schemes-assets-path", "", "if specified, copy schemes from here into --schemes-path")
 	flags.Int("schemes-update", 60, "update IRMA schemes every x minutes (0 to disable)")
 	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.String("privkeys-signer", "", "path to Unix socket of signer holding IRMA private keys (see \"irma signer\")")
+	flags.Int("key-expiry-warning", 31, "warn about issuer private keys whose public key expires within x days (-1 to disable)")
 	flags.String("static-path", "", "Host files under this path as static files (leave empty to disable)")
 	flags.String("static-prefix", "/", "Host static files under this URL prefix")
 	flags.StringP("url", "u", defaulturl, "external URL to server to which the IRMA client connects, \":port\" being replaced by --port value")
-	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres)")
-	flags.String("revocation-db-str", "", "connection string for revocation database")
+	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres, bolt)")
+	flags.String("revocation-db-str", "", "connection string for revocation database (for bolt: path to database file)")
+	flags.Bool("revocation-db-no-migrate", false, "refuse to start if revocation database schema is outdated instead of migrating it")
+	flags.String("revocation-bundle-dir", "", "periodically publish revocation update bundles to this directory (leave empty to disable)")
 	flags.Bool("sse", false, "Enable server sent for status updates (experimental)")
 
 	flags.IntP("port", "p", 8088, "port at which to listen")
@@ -114,6 +118,7 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	}
 	flags.StringSlice("issue-perms", nil, issHelp)
 	flags.StringSlice("revoke-perms", nil, "list of credentials that all requestors may revoke")
+	flags.Bool("admin-perms", false, "whether all requestors may access the /admin endpoints")
 	flags.Bool("skip-private-keys-check", false, "whether or not to skip checking whether the private keys that requestors have permission for using are present in the configuration")
 	flags.String("static-sessions", "", "preconfigured static sessions (in JSON)")
 	flags.Lookup("no-auth").Header = `Requestor authentication and default requestor permissions`
@@ -205,32 +210,37 @@ func configureServer(cmd *cobra.Command) error {
 	// Read configuration from flags and/or environmental variables
 	conf = &requestorserver.Configuration{
 		Configuration: &server.Configuration{
-			SchemesPath:           viper.GetString("schemes-path"),
-			SchemesAssetsPath:     viper.GetString("schemes-assets-path"),
-			SchemesUpdateInterval: viper.GetInt("schemes-update"),
-			DisableSchemesUpdate:  viper.GetInt("schemes-update") == 0,
-			IssuerPrivateKeysPath: viper.GetString("privkeys"),
-			RevocationDBType:      viper.GetString("revocation-db-type"),
-			RevocationDBConnStr:   viper.GetString("revocation-db-str"),
-			RevocationSettings:    irma.RevocationSettings{},
-			URL:                   viper.GetString("url"),
-			DisableTLS:            viper.GetBool("no-tls"),
-			Email:                 viper.GetString("email"),
-			EnableSSE:             viper.GetBool("sse"),
-			Verbose:               viper.GetInt("verbose"),
-			Quiet:                 viper.GetBool("quiet"),
-			LogJSON:               viper.GetBool("log-json"),
-			Logger:                logger,
-			Production:            viper.GetBool("production"),
-			JwtIssuer:             viper.GetString("jwt-issuer"),
-			JwtPrivateKey:         viper.GetString("jwt-privkey"),
-			JwtPrivateKeyFile:     viper.GetString("jwt-privkey-file"),
+			SchemesPath:             viper.GetString("schemes-path"),
+			SchemesAssetsPath:       viper.GetString("schemes-assets-path"),
+			SchemesUpdateInterval:   viper.GetInt("schemes-update"),
+			DisableSchemesUpdate:    viper.GetInt("schemes-update") == 0,
+			IssuerPrivateKeysPath:   viper.GetString("privkeys"),
+			IssuerPrivateKeysSigner: viper.GetString("privkeys-signer"),
+			KeyExpiryWarning:        viper.GetInt("key-expiry-warning"),
+			RevocationDBType:        viper.GetString("revocation-db-type"),
+			RevocationDBConnStr:     viper.GetString("revocation-db-str"),
+			RevocationDBNoMigrate:   viper.GetBool("revocation-db-no-migrate"),
+			RevocationBundleDir:     viper.GetString("revocation-bundle-dir"),
+			RevocationSettings:      irma.RevocationSettings{},
+			URL:                     viper.GetString("url"),
+			DisableTLS:              viper.GetBool("no-tls"),
+			Email:                   viper.GetString("email"),
+			EnableSSE:               viper.GetBool("sse"),
+			Verbose:                 viper.GetInt("verbose"),
+			Quiet:                   viper.GetBool("quiet"),
+			LogJSON:                 viper.GetBool("log-json"),
+			Logger:                  logger,
+			Production:              viper.GetBool("production"),
+			JwtIssuer:               viper.GetString("jwt-issuer"),
+			JwtPrivateKey:           viper.GetString("jwt-privkey"),
+			JwtPrivateKeyFile:       viper.GetString("jwt-privkey-file"),
 		},
 		Permissions: requestorserver.Permissions{
 			Disclosing: handlePermission("disclose-perms"),
 			Signing:    handlePermission("sign-perms"),
 			Issuing:    handlePermission("issue-perms"),
 			Revoking:   handlePermission("revoke-perms"),
+			Admin:      viper.GetBool("admin-perms"),
 		},
 		SkipPrivateKeysCheck:           viper.GetBool("skip-private-keys-check"),
 		ListenAddress:                  viper.GetString("listen-addr"),
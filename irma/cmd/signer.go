@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+)
+
+var signerCmd = &cobra.Command{
+	Use:   "signer",
+	Short: "Compute issuance signatures on behalf of an IRMA server",
+	Long: `Compute issuance signatures on behalf of an IRMA server
+
+The signer command holds IRMA issuer private keys, and computes issuance signatures using them on
+request of an IRMA server over a Unix socket. This allows the private keys to be kept out of the IRMA
+server process, for example in a separate hardened process under a separate user. The private keys
+are read from the PrivateKeys folders in the schemes and from the folder specified with --privkeys.
+
+By default only the user running the signer can connect to the socket. If the IRMA server runs under
+another user, pass a group of which that user is a member to --socket-group, and --socket-mode 0660.
+
+Configure the IRMA server to use the signer by passing the socket path to its --privkeys-signer
+option. Note that credential types supporting revocation cannot be issued using keys held by the
+signer.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		privkeys, _ := flags.GetString("privkeys")
+		socket, _ := flags.GetString("socket")
+		socketMode, _ := flags.GetString("socket-mode")
+		socketGroup, _ := flags.GetString("socket-group")
+		verbosity, _ := cmd.Flags().GetCount("verbose")
+
+		if socket == "" {
+			return errors.New("--socket is required")
+		}
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return errors.Errorf("invalid --socket-mode %s", socketMode)
+		}
+		logger := server.NewLogger(verbosity, false, false)
+		irma.SetLogger(logger)
+
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("Failed to open schemes", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("Failed to parse schemes", err)
+		}
+		if privkeys != "" {
+			ring, err := irma.NewPrivateKeyRingFolder(privkeys, conf)
+			if err != nil {
+				die("Failed to read private keys", err)
+			}
+			if err = conf.AddPrivateKeyRing(ring); err != nil {
+				die("Failed to read private keys", err)
+			}
+		}
+
+		signer := irma.NewSignerServer(conf, conf.PrivateKeys)
+		signer.SocketMode = os.FileMode(mode)
+		signer.SocketGroup = socketGroup
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupt
+			logger.Debug("Caught interrupt")
+			if err := signer.Stop(); err != nil {
+				_ = server.LogError(err)
+			}
+		}()
+
+		logger.Info("Signer listening at ", socket)
+		if err = signer.ListenAndServe(socket); err != nil {
+			die("Failed to start signer", err)
+		}
+		logger.Info("Exiting")
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(signerCmd)
+
+	flags := signerCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.String("socket", "", "path of Unix socket to listen on (required)")
+	flags.String("socket-mode", "0600", "file mode of the socket (octal)")
+	flags.String("socket-group", "", "group owning the socket (name or ID)")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
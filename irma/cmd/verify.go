@@ -103,6 +103,9 @@ func VerifyIrmaConfiguration(path string, verbose bool) error {
 	if len(conf.SchemeManagers) == 0 {
 		return errors.New("Specified folder doesn't contain any schemes")
 	}
+	if err := conf.ValidateRequestors(); err != nil {
+		return err
+	}
 
 	for _, warning := range conf.Warnings {
 		fmt.Println("Warning: " + warning)
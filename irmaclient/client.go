@@ -47,6 +47,7 @@ type Client struct {
 	credentialsCache map[irma.CredentialTypeIdentifier]map[int]*credential
 	keyshareServers  map[irma.SchemeManagerIdentifier]*keyshareServer
 	updates          []update
+	profile          *Profile
 
 	lookup map[string]*credLookup
 
@@ -57,14 +58,20 @@ type Client struct {
 
 	// Other state
 	Preferences           Preferences
+	prefsMutex            sync.Mutex // guards Preferences against concurrent reads by background jobs
 	Configuration         *irma.Configuration
 	irmaConfigurationPath string
 	handler               ClientHandler
 	sessions              sessions
 
-	jobs       chan func()   // queue of jobs to run
-	jobsPause  chan struct{} // sending pauses background jobs
-	jobsPaused bool
+	jobs        chan func()   // queue of jobs to run
+	jobsPause   chan struct{} // sending pauses background jobs
+	jobsPaused  bool
+	jobsRunning sync.Mutex // held while a background job runs
+
+	expiryNotified map[string]bool // hashes of credentials reported to the handler as expiring
+
+	finishing sync.WaitGroup // background work of finished sessions, awaited by Close()
 
 	credMutex sync.Mutex
 }
@@ -73,6 +80,24 @@ type Client struct {
 // be part of any backup and syncing solution we implement at a later time
 type Preferences struct {
 	DeveloperMode bool
+	// ExpiryWarningDays is the amount of days before their expiry that credentials are reported
+	// to ClientHandler.CredentialsExpiring (0 for DefaultExpiryWarningDays, negative to disable).
+	ExpiryWarningDays int
+	// Log retention: log entries older than LogRetentionDays days are deleted, as are all but the
+	// newest LogRetentionEntries entries; attribute values are removed from log entries older than
+	// LogStripValuesDays days. Log entries of credential removals are always kept. 0 disables each.
+	LogRetentionDays    int
+	LogRetentionEntries int
+	LogStripValuesDays  int
+	// PreferredIssuers lists issuers whose credentials are preferred for disclosure, most preferred
+	// first; disclosure candidates are ranked accordingly (see Client.Candidates()).
+	PreferredIssuers []irma.IssuerIdentifier
+	// PinnedCredentials contains per credential type the hash of the credential instance that is
+	// disclosed by default, see Client.PinCredential().
+	PinnedCredentials map[irma.CredentialTypeIdentifier]string
+	// ReplacementPolicies contains per credential type what to do with older credentials with the
+	// same attribute values when a credential is issued (default ReplacementReplace).
+	ReplacementPolicies map[irma.CredentialTypeIdentifier]ReplacementPolicy
 }
 
 var defaultPreferences = Preferences{
@@ -102,6 +127,9 @@ type ClientHandler interface {
 	UpdateConfiguration(new *irma.IrmaIdentifierSet)
 	UpdateAttributes()
 	Revoked(cred *irma.CredentialIdentifier)
+	// CredentialsExpiring is called with the credentials that expire within the amount of days
+	// specified by Preferences.ExpiryWarningDays, each credential at most once.
+	CredentialsExpiring(creds []*ExpiringCredential)
 	ReportError(err error)
 }
 
@@ -135,6 +163,8 @@ type secretKey struct {
 // enrollment to a keyshare server needs to happen.
 // The client returned by this function has been fully deserialized
 // and is ready for use.
+// The client stores its credentials, logs and other data in s, or if s is nil,
+// in a bbolt database within storagePath. The client closes s when it is closed.
 //
 // NOTE: It is the responsibility of the caller that there exists a (properly
 // protected) directory at storagePath!
@@ -143,6 +173,7 @@ func New(
 	irmaConfigurationPath string,
 	handler ClientHandler,
 	tempPath string,
+	s Storage,
 ) (*Client, error) {
 	var err error
 	if err = common.AssertPathExists(storagePath); err != nil {
@@ -177,55 +208,43 @@ func New(
 	}
 
 	// Ensure storage path exists, and populate it with necessary files
-	client.storage = storage{storagePath: storagePath, Configuration: client.Configuration}
-	if err = client.storage.Open(); err != nil {
-		return nil, err
+	if s == nil {
+		if s, err = NewBoltStorage(storagePath); err != nil {
+			return nil, err
+		}
 	}
+	client.storage = storage{db: s, Configuration: client.Configuration}
 	// Legacy storage does not need ensuring existence
 	client.fileStorage = fileStorage{storagePath: storagePath, Configuration: client.Configuration}
 
-	if client.Preferences, err = client.storage.LoadPreferences(); err != nil {
-		return nil, err
-	}
-	client.applyPreferences()
-
-	// Perform new update functions from clientUpdates, if any
-	if err = client.update(); err != nil {
-		return nil, err
-	}
-
-	// Load our stuff
-	if client.secretkey, err = client.storage.LoadSecretKey(); err != nil {
+	registry, err := client.storage.LoadProfiles()
+	if err != nil {
 		return nil, err
 	}
-	if client.attributes, err = client.storage.LoadAttributes(); err != nil {
-		return nil, err
+	profile, ok := registry.Profiles[registry.Active]
+	if !ok {
+		return nil, errors.Errorf("active profile %s not found", registry.Active)
 	}
-	if client.keyshareServers, err = client.storage.LoadKeyshareServers(); err != nil {
+	if err = client.loadProfile(profile); err != nil {
 		return nil, err
 	}
 
-	if len(client.UnenrolledSchemeManagers()) > 1 {
-		return nil, errors.New("Too many keyshare servers")
-	}
-
-	client.lookup = map[string]*credLookup{}
-	for _, attrlistlist := range client.attributes {
-		for i, attrlist := range attrlistlist {
-			client.lookup[attrlist.Hash()] = &credLookup{id: attrlist.CredentialType().Identifier(), counter: i}
-		}
-	}
-
 	client.sessions = sessions{client: client, sessions: map[string]*session{}}
 
 	client.jobs = make(chan func(), 100)
 	client.initRevocation()
+	client.initExpiryCheck()
+	client.initLogRetention()
+	if err = client.cancelExpiredPendingSessions(); err != nil {
+		return nil, err
+	}
 	client.StartJobs()
 
 	return client, schemeMgrErr
 }
 
 func (client *Client) Close() error {
+	client.finishing.Wait()
 	return client.storage.Close()
 }
 
@@ -263,7 +282,9 @@ func (client *Client) StartJobs() {
 				return
 			case job := <-client.jobs:
 				irma.Logger.Debug("doing job")
+				client.jobsRunning.Lock()
 				job()
+				client.jobsRunning.Unlock()
 				irma.Logger.Debug("job done")
 			}
 		}
@@ -283,6 +304,9 @@ func (client *Client) PauseJobs() {
 
 // CredentialInfoList returns a list of information of all contained credentials.
 func (client *Client) CredentialInfoList() irma.CredentialInfoList {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
 	list := irma.CredentialInfoList([]*irma.CredentialInfo{})
 
 	for _, attrlistlist := range client.attributes {
@@ -299,8 +323,11 @@ func (client *Client) CredentialInfoList() irma.CredentialInfoList {
 }
 
 // addCredential adds the specified credential to the Client, saving its signature
-// imediately, and optionally cm.attributes as well.
-func (client *Client) addCredential(cred *credential) (err error) {
+// imediately, and optionally cm.attributes as well. Older credentials with the same attribute
+// values are removed according to the replacement policy of the credential type, in which
+// replaceAsked is the answer of the user in case of ReplacementAsk. It returns the removed
+// credentials.
+func (client *Client) addCredential(cred *credential, replaceAsked bool) (replaced []*irma.AttributeList, err error) {
 	id := irma.NewCredentialTypeIdentifier("")
 	if cred.CredentialType() != nil {
 		id = cred.CredentialType().Identifier()
@@ -320,25 +347,30 @@ func (client *Client) addCredential(cred *credential) (err error) {
 	}
 	if index != -1 {
 		if err = client.remove(id, index, false); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	// If this is a singleton credential type, ensure we have at most one by removing any previous instance
-	// If a credential already exists with exactly the same attribute values (except metadata), delete the previous credential
+	// If a credential already exists with the same attribute values, delete the previous credential,
+	// depending on the replacement policy
 	if !id.Empty() {
 		if cred.CredentialType().IsSingleton {
 			for len(client.attrs(id)) != 0 {
+				replaced = append(replaced, client.attrs(id)[0])
 				if err = client.remove(id, 0, false); err != nil {
-					return
+					return nil, err
 				}
 			}
 		}
 
-		for i := len(client.attrs(id)) - 1; i >= 0; i-- { // Go backwards through array because remove manipulates it
-			if client.attrs(id)[i].EqualsExceptMetadata(cred.attrs) {
-				if err = client.remove(id, i, false); err != nil {
-					return
+		policy := client.replacementPolicy(id)
+		if policy == ReplacementReplace || (policy == ReplacementAsk && replaceAsked) {
+			indices := client.duplicates(cred.attrs)
+			for i := len(indices) - 1; i >= 0; i-- { // Go backwards through array because remove manipulates it
+				replaced = append(replaced, client.attrs(id)[indices[i]])
+				if err = client.remove(id, indices[i], false); err != nil {
+					return nil, err
 				}
 			}
 		}
@@ -355,7 +387,7 @@ func (client *Client) addCredential(cred *credential) (err error) {
 		client.lookup[cred.attrs.Hash()] = &credLookup{id: id, counter: counter}
 	}
 
-	return client.storage.Transaction(func(tx *transaction) error {
+	return replaced, client.storage.Transaction(func(tx *transaction) error {
 		if err = client.storage.TxStoreSignature(tx, cred); err != nil {
 			return err
 		}
@@ -419,6 +451,12 @@ func (client *Client) remove(id irma.CredentialTypeIdentifier, index int, storeL
 
 // RemoveCredential removes the specified credential if that is allowed.
 func (client *Client) RemoveCredential(id irma.CredentialTypeIdentifier, index int) error {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+	return client.removeCredential(id, index)
+}
+
+func (client *Client) removeCredential(id irma.CredentialTypeIdentifier, index int) error {
 	if client.Configuration.CredentialTypes[id].DisallowDelete {
 		return errors.Errorf("configuration does not allow removal of credential type %s", id.String())
 	}
@@ -427,18 +465,25 @@ func (client *Client) RemoveCredential(id irma.CredentialTypeIdentifier, index i
 
 // RemoveCredentialByHash removes the specified credential.
 func (client *Client) RemoveCredentialByHash(hash string) error {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
 	cred, index, err := client.credentialByHash(hash)
 	if err != nil {
 		return err
 	}
-	return client.RemoveCredential(cred.CredentialType().Identifier(), index)
+	return client.removeCredential(cred.CredentialType().Identifier(), index)
 }
 
-// Removes all attributes, signatures, logs and userdata
+// Removes all attributes, signatures, logs and userdata of all profiles, along with the profiles
+// themselves, after which the default profile is active
 // Includes the user's secret key, keyshare servers and preferences/updates
 // A fresh secret key is installed.
 func (client *Client) RemoveStorage() error {
 	var err error
+	client.jobsRunning.Lock()
+	defer client.jobsRunning.Unlock()
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
 
 	// Remove data from memory
 	client.attributes = make(map[irma.CredentialTypeIdentifier][]*irma.AttributeList)
@@ -446,12 +491,18 @@ func (client *Client) RemoveStorage() error {
 	client.credentialsCache = make(map[irma.CredentialTypeIdentifier]map[int]*credential)
 	client.lookup = make(map[string]*credLookup)
 
-	if err = client.storage.DeleteAll(); err != nil {
+	if err = client.storage.DeleteAllProfiles(); err != nil {
 		return err
 	}
 	if err = client.fileStorage.DeleteAll(); err != nil {
 		return err
 	}
+	registry, err := client.storage.LoadProfiles()
+	if err != nil {
+		return err
+	}
+	client.profile = registry.Profiles[registry.Active]
+	client.storage = client.storage.forProfile(client.profile.ID)
 
 	// Client assumes there is always a secret key, so we have to load a new one
 	client.secretkey, err = client.storage.LoadSecretKey()
@@ -460,9 +511,13 @@ func (client *Client) RemoveStorage() error {
 	}
 
 	// TODO: do we consider this setting as user data?
-	if client.Preferences, err = client.storage.LoadPreferences(); err != nil {
+	prefs, err := client.storage.LoadPreferences()
+	if err != nil {
 		return err
 	}
+	client.prefsMutex.Lock()
+	client.Preferences = prefs
+	client.prefsMutex.Unlock()
 	client.applyPreferences()
 
 	return nil
@@ -737,6 +792,7 @@ func (client *Client) candidatesDisCon(request irma.SessionRequest, discon irma.
 	candidates []DisclosureCandidates, satisfiable bool, err error,
 ) {
 	candidates = []DisclosureCandidates{}
+	ranker := client.newCandidateRanker()
 
 	for _, con := range discon {
 		if len(con) == 0 {
@@ -760,6 +816,11 @@ func (client *Client) candidatesDisCon(request irma.SessionRequest, discon irma.
 			satisfiable = true
 		}
 
+		// Order the credential instances of each type by preference, and leave out the least
+		// preferable ones if there are too many to compute all combinations of
+		ranker.rankCreds(c)
+		c = pruneCreds(c)
+
 		// The cartesian product of the list of lists constructed above results in a list of which
 		// each item is a list of credentials containing attributes that together will satisfy the
 		// current conjunction
@@ -777,11 +838,14 @@ func (client *Client) candidatesDisCon(request irma.SessionRequest, discon irma.
 		candidates = append(candidates, expanded...)
 	}
 
+	ranker.rankOptions(candidates)
 	return
 }
 
 // Candidates returns a list of options for the user to choose from,
 // given a session request and the credentials currently in storage.
+// The options of each disjunction are sorted from most to least preferable,
+// see optionRank.better() for the criteria.
 func (client *Client) Candidates(request irma.SessionRequest) (
 	candidates [][]DisclosureCandidates, satisfiable bool, err error,
 ) {
@@ -971,10 +1035,22 @@ func (client *Client) IssueCommitments(request *irma.IssuanceRequest, choice *ir
 }
 
 // ConstructCredentials constructs and saves new credentials using the specified issuance signature messages
-// and credential builders.
+// and credential builders. Older credentials with the same attribute values are replaced according to
+// the replacement policies, except that the user is not asked: older credentials of types whose
+// replacement policy is ReplacementAsk are kept, as if the user declined their replacement.
 func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, request *irma.IssuanceRequest, builders gabi.ProofBuilderList) error {
+	_, err := client.constructCredentials(msg, request, builders, false)
+	return err
+}
+
+// constructCredentials constructs and saves new credentials like ConstructCredentials(), replacing
+// older credentials according to the replacement policies, in which replaceAsked is the answer of
+// the user in case of ReplacementAsk. It returns the attribute values of the replaced credentials.
+func (client *Client) constructCredentials(
+	msg []*gabi.IssueSignatureMessage, request *irma.IssuanceRequest, builders gabi.ProofBuilderList, replaceAsked bool,
+) (map[irma.CredentialTypeIdentifier][][]irma.TranslatedString, error) {
 	if len(msg) > len(builders) {
-		return errors.New("Received unexpected amount of signatures")
+		return nil, errors.New("Received unexpected amount of signatures")
 	}
 
 	// First collect all credentials in a slice, so that if one of them induces an error,
@@ -996,10 +1072,10 @@ func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, re
 		issuedAt := time.Now()
 		req := request.Credentials[i-offset]
 		if !req.RevocationSupported && (nonrevAttr != nil) {
-			return errors.New("credential signature unexpectedly containend nonrevocation witness")
+			return nil, errors.New("credential signature unexpectedly containend nonrevocation witness")
 		}
 		if req.RevocationSupported && (nonrevAttr == nil) {
-			return errors.New("credential signature did not contain nonrevocation witness")
+			return nil, errors.New("credential signature did not contain nonrevocation witness")
 		}
 		attrs, err := req.AttributeList(
 			client.Configuration,
@@ -1008,27 +1084,38 @@ func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, re
 			issuedAt,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		cred, err := credbuilder.ConstructCredential(sig, attrs.Ints)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		gabicreds = append(gabicreds, cred)
 	}
 
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+	replaced := map[irma.CredentialTypeIdentifier][][]irma.TranslatedString{}
 	for _, gabicred := range gabicreds {
 		attrs := irma.NewAttributeListFromInts(gabicred.Attributes[1:], client.Configuration)
 		newcred, err := newCredential(gabicred, attrs, client.Configuration)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if err = client.addCredential(newcred); err != nil {
-			return err
+		removed, err := client.addCredential(newcred, replaceAsked)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range removed {
+			if r.CredentialType() == nil {
+				continue
+			}
+			id := r.CredentialType().Identifier()
+			replaced[id] = append(replaced[id], r.Strings())
 		}
 	}
 
-	return nil
+	return replaced, nil
 }
 
 // Keyshare server handling
@@ -1208,11 +1295,21 @@ func (client *Client) SetPreferences(pref Preferences) {
 	} else {
 		irma.Logger.Info("developer mode disabled")
 	}
+	client.prefsMutex.Lock()
 	client.Preferences = pref
-	_ = client.storage.StorePreferences(client.Preferences)
+	client.prefsMutex.Unlock()
+	_ = client.storage.StorePreferences(pref)
 	client.applyPreferences()
 }
 
+// preferences returns a copy of the preferences that is safe to use outside of the goroutine
+// that modifies them, e.g. in background jobs.
+func (client *Client) preferences() Preferences {
+	client.prefsMutex.Lock()
+	defer client.prefsMutex.Unlock()
+	return client.Preferences
+}
+
 func (client *Client) applyPreferences() {}
 
 // ConfigurationUpdated should be run after Configuration.Download().
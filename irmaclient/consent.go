@@ -0,0 +1,203 @@
+package irmaclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// ConsentRule is the remembered consent of the user to disclose attributes to a requestor
+// verified by a requestor scheme, when it asks for the same attributes again. A rule applies
+// only to disclosure sessions with exactly the same disclosure request. If AutoApprove is set,
+// such sessions are performed without asking the user, disclosing the attributes in Choice;
+// otherwise the app can preselect Choice when asking the user, see Client.RememberedChoice().
+type ConsentRule struct {
+	ID          string                  `json:"id"`
+	Requestor   *irma.RequestorInfo     `json:"requestor"`
+	Disclose    irma.AttributeConDisCon `json:"disclose"`
+	Choice      *irma.DisclosureChoice  `json:"choice"`
+	AutoApprove bool                    `json:"autoApprove"`
+	Created     irma.Timestamp          `json:"created"`
+	Expires     *irma.Timestamp         `json:"expires,omitempty"` // nil if the rule does not expire
+}
+
+// AddConsentRule remembers the choice of the user to disclose the specified attributes in
+// disclosure sessions with the specified request, by the specified requestor. The requestor must
+// be verified by a requestor scheme, i.e. its Verification must be irma.RequestorVerified as in
+// the SessionRequestorInfo passed to the Handler. A validity of 0 means the rule does not expire. An
+// existing rule for the same requestor and request is replaced.
+func (client *Client) AddConsentRule(
+	request irma.SessionRequest,
+	requestor *irma.SessionRequestorInfo,
+	choice *irma.DisclosureChoice,
+	autoApprove bool,
+	validity time.Duration,
+) (*ConsentRule, error) {
+	if request.Action() != irma.ActionDisclosing {
+		return nil, errors.New("consent can only be remembered for disclosure sessions")
+	}
+	if !client.verifiedRequestor(requestor) {
+		return nil, errors.New("consent can only be remembered for requestors verified by a requestor scheme")
+	}
+	if err := choice.Validate(); err != nil {
+		return nil, err
+	}
+	disclose := request.Disclosure().Disclose
+	if len(choice.Attributes) != len(disclose) {
+		return nil, errors.New("choice does not match the disclosure request")
+	}
+	id, err := consentRuleID(requestor.RequestorInfo, disclose)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rule := &ConsentRule{
+		ID:          id,
+		Requestor:   requestor.RequestorInfo,
+		Disclose:    disclose,
+		Choice:      choice,
+		AutoApprove: autoApprove,
+		Created:     irma.Timestamp(now),
+	}
+	if validity != 0 {
+		expires := irma.Timestamp(now.Add(validity))
+		rule.Expires = &expires
+	}
+	return rule, client.storage.StoreConsentRule(rule)
+}
+
+// ConsentRules returns all unexpired consent rules, sorted by the time they were created.
+func (client *Client) ConsentRules() ([]*ConsentRule, error) {
+	rules, err := client.storage.LoadConsentRules()
+	if err != nil {
+		return nil, err
+	}
+	unexpired := make([]*ConsentRule, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.expired() {
+			unexpired = append(unexpired, rule)
+		}
+	}
+	sort.Slice(unexpired, func(i, j int) bool {
+		return time.Time(unexpired[i].Created).Before(time.Time(unexpired[j].Created))
+	})
+	return unexpired, nil
+}
+
+// RemoveConsentRule removes the consent rule with the specified ID.
+func (client *Client) RemoveConsentRule(id string) error {
+	return client.storage.DeleteConsentRule(id)
+}
+
+// RemoveAllConsentRules removes all consent rules.
+func (client *Client) RemoveAllConsentRules() error {
+	return client.storage.Transaction(func(tx *transaction) error {
+		return client.storage.TxDeleteConsentRules(tx)
+	})
+}
+
+// RememberedChoice returns the choice of attributes that the user previously made for this
+// request and requestor, if a consent rule for them exists and the choice can still be disclosed
+// with the specified candidates (as passed to Handler.RequestVerificationPermission); otherwise nil.
+func (client *Client) RememberedChoice(
+	request irma.SessionRequest,
+	requestor *irma.SessionRequestorInfo,
+	candidates [][]DisclosureCandidates,
+) *irma.DisclosureChoice {
+	rule := client.consentRule(request, requestor, candidates)
+	if rule == nil {
+		return nil
+	}
+	return rule.Choice
+}
+
+// consentRule returns the unexpired consent rule for the request and requestor, if any, and if its
+// choice can be disclosed using the candidates.
+func (client *Client) consentRule(
+	request irma.SessionRequest,
+	requestor *irma.SessionRequestorInfo,
+	candidates [][]DisclosureCandidates,
+) *ConsentRule {
+	// Don't skip the user if the requestor asks for more than its requestor scheme allows
+	if request.Action() != irma.ActionDisclosing || !client.verifiedRequestor(requestor) ||
+		len(requestor.UndeclaredAttributes) > 0 {
+		return nil
+	}
+	id, err := consentRuleID(requestor.RequestorInfo, request.Disclosure().Disclose)
+	if err != nil {
+		return nil
+	}
+	rule, err := client.storage.LoadConsentRule(id)
+	if err != nil {
+		irma.Logger.Warn("failed to load consent rule: ", err)
+		return nil
+	}
+	if rule == nil || rule.expired() || !choiceSatisfiedBy(rule.Choice, candidates) {
+		return nil
+	}
+	return rule
+}
+
+func (client *Client) verifiedRequestor(requestor *irma.SessionRequestorInfo) bool {
+	return requestor != nil && requestor.RequestorInfo != nil && requestor.Verification == irma.RequestorVerified
+}
+
+func (rule *ConsentRule) expired() bool {
+	return rule.Expires != nil && time.Now().After(time.Time(*rule.Expires))
+}
+
+// choiceSatisfiedBy returns whether for each disjunction the chosen attributes occur as one of the
+// candidate options, none of which are expired or revoked.
+func choiceSatisfiedBy(choice *irma.DisclosureChoice, candidates [][]DisclosureCandidates) bool {
+	if choice == nil || len(choice.Attributes) != len(candidates) {
+		return false
+	}
+	for i, con := range choice.Attributes {
+		found := false
+		for _, option := range candidates[i] {
+			if optionMatches(option, con) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func optionMatches(option DisclosureCandidates, con []*irma.AttributeIdentifier) bool {
+	if len(option) != len(con) {
+		return false
+	}
+	for j, candidate := range option {
+		if candidate.Expired || candidate.Revoked || candidate.CredentialHash == "" ||
+			candidate.Type != con[j].Type || candidate.CredentialHash != con[j].CredentialHash {
+			return false
+		}
+	}
+	return true
+}
+
+// consentRuleID identifies a consent rule by the requestor and the requested attributes.
+func consentRuleID(requestor *irma.RequestorInfo, disclose irma.AttributeConDisCon) (string, error) {
+	hostnames := append([]string{}, requestor.Hostnames...)
+	sort.Strings(hostnames)
+	bts, err := json.Marshal(struct {
+		Scheme    irma.RequestorSchemeIdentifier
+		Hostnames []string
+		Disclose  irma.AttributeConDisCon
+	}{requestor.Scheme, hostnames, disclose})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(bts)
+	return hex.EncodeToString(hash[:]), nil
+}
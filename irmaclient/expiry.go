@@ -0,0 +1,90 @@
+package irmaclient
+
+import (
+	"sort"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+)
+
+// DefaultExpiryWarningDays is the default amount of days before their expiry that the Client
+// reports credentials as expiring, if Preferences.ExpiryWarningDays is 0.
+const DefaultExpiryWarningDays = 14
+
+// expiryCheckInterval is the time interval in hours with which the Client checks for
+// credentials that expire soon.
+const expiryCheckInterval = 6
+
+// ExpiringCredential is a credential that expires soon, along with the URL at which the user can
+// get a new instance of it, if its credential type specifies one.
+type ExpiringCredential struct {
+	*irma.CredentialInfo
+	IssueURL irma.TranslatedString
+}
+
+// expiryWarningDays returns the amount of days before their expiry that credentials are reported,
+// or a negative value if reporting expiring credentials is disabled.
+func (client *Client) expiryWarningDays() int {
+	days := client.preferences().ExpiryWarningDays
+	if days == 0 {
+		return DefaultExpiryWarningDays
+	}
+	return days
+}
+
+// ExpiringCredentials returns the unexpired credentials that expire within the specified amount
+// of time, ordered by expiry date.
+func (client *Client) ExpiringCredentials(within time.Duration) []*ExpiringCredential {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
+	var creds []*ExpiringCredential
+	now := time.Now()
+	horizon := irma.Timestamp(now.Add(within))
+	for _, attrlistlist := range client.attributes {
+		for _, attrlist := range attrlistlist {
+			credtype := attrlist.CredentialType()
+			if credtype == nil {
+				continue
+			}
+			info := attrlist.Info()
+			if info == nil || info.IsExpired() || info.Expires.After(horizon) {
+				continue
+			}
+			creds = append(creds, &ExpiringCredential{CredentialInfo: info, IssueURL: credtype.IssueURL})
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool {
+		return creds[i].Expires.Before(creds[j].Expires)
+	})
+	return creds
+}
+
+func (client *Client) initExpiryCheck() {
+	client.expiryNotified = map[string]bool{}
+	client.jobs <- client.checkExpiry
+	client.Configuration.Scheduler.Every(expiryCheckInterval).Hours().Do(func() {
+		client.jobs <- client.checkExpiry
+	})
+}
+
+// checkExpiry informs the handler of credentials that expire within the configured amount of days.
+// Each credential is reported at most once by a Client instance.
+func (client *Client) checkExpiry() {
+	days := client.expiryWarningDays()
+	if days < 0 {
+		return
+	}
+	var creds []*ExpiringCredential
+	for _, cred := range client.ExpiringCredentials(time.Duration(days) * 24 * time.Hour) {
+		if client.expiryNotified[cred.Hash] {
+			continue
+		}
+		client.expiryNotified[cred.Hash] = true
+		creds = append(creds, cred)
+	}
+	if len(creds) > 0 {
+		irma.Logger.WithField("count", len(creds)).Debug("reporting expiring credentials")
+		client.handler.CredentialsExpiring(creds)
+	}
+}
@@ -1,14 +1,22 @@
 package irmaclient
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/gabi/big"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/privacybydesign/irmago/internal/test"
@@ -40,16 +48,19 @@ func parseStorage(t *testing.T) (*Client, *TestClientHandler) {
 }
 
 func parseExistingStorage(t *testing.T, storage string) (*Client, *TestClientHandler) {
-	handler := &TestClientHandler{t: t, c: make(chan error), storage: storage}
+	handler := &TestClientHandler{t: t, c: make(chan error), storage: storage, expiring: make(chan []*ExpiringCredential, 10)}
 	path := test.FindTestdataFolder(t)
 	client, err := New(
 		filepath.Join(storage, "client"),
 		filepath.Join(path, "irma_configuration"),
 		handler,
 		"",
+		nil,
 	)
 	require.NoError(t, err)
 	client.SetPreferences(Preferences{DeveloperMode: true})
+	// let the jobs started by New() finish before tests access the client's internals
+	waitForJobs(client)
 	return client, handler
 }
 
@@ -271,7 +282,7 @@ func TestCredentialRemoval(t *testing.T) {
 	require.Nil(t, cred)
 
 	// Also check whether credential is removed after reloading the storage
-	err = client.storage.db.Close()
+	err = client.storage.Close()
 	require.NoError(t, err)
 	client, handler = parseExistingStorage(t, handler.storage)
 	cred, err = client.credential(id2, 0)
@@ -338,7 +349,7 @@ func TestKeyshareEnrollmentRemoval(t *testing.T) {
 	err := client.KeyshareRemove(irma.NewSchemeManagerIdentifier("test"))
 	require.NoError(t, err)
 
-	err = client.storage.db.Close()
+	err = client.storage.Close()
 	require.NoError(t, err)
 	client, handler = parseExistingStorage(t, handler.storage)
 
@@ -385,17 +396,834 @@ func TestRemoveStorage(t *testing.T) {
 	require.NotEqual(t, old_sk, new_sk)
 }
 
+func TestRemoveStorageProfiles(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	profile, err := client.CreateProfile("Alice")
+	require.NoError(t, err)
+	require.NoError(t, client.SwitchProfile(profile.ID))
+	s := client.storage.forProfile(profile.ID)
+	require.True(t, s.BucketExists([]byte(userdataBucket)))
+
+	// all profiles are removed, after which the default profile is active
+	require.NoError(t, client.RemoveStorage())
+	require.Equal(t, DefaultProfileID, client.Profile().ID)
+	profiles, err := client.Profiles()
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	require.False(t, s.BucketExists([]byte(userdataBucket)))
+	require.False(t, client.storage.BucketExists([]byte("attrs")))
+	require.Empty(t, client.CredentialInfoList())
+}
+
+func TestStorageBackends(t *testing.T) {
+	dir := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, dir)
+	bolt, err := NewBoltStorage(dir)
+	require.NoError(t, err)
+
+	for name, backend := range map[string]Storage{"bolt": bolt, "memory": NewMemoryStorage()} {
+		t.Run(name, func(t *testing.T) {
+			s := storage{db: backend}
+			defer func() { require.NoError(t, s.Close()) }()
+
+			for i := 0; i < 5; i++ {
+				require.NoError(t, s.AddLogEntry(&LogEntry{Type: irma.ActionDisclosing}))
+			}
+			logs, err := s.LoadNewestLogs(2)
+			require.NoError(t, err)
+			require.Len(t, logs, 2)
+			require.Equal(t, uint64(5), logs[0].ID)
+			require.Equal(t, uint64(4), logs[1].ID)
+			logs, err = s.LoadLogsBefore(3, 10)
+			require.NoError(t, err)
+			require.Len(t, logs, 2)
+			require.Equal(t, uint64(2), logs[0].ID)
+			require.Equal(t, uint64(1), logs[1].ID)
+			logs, err = s.LoadLogsBefore(10, 1)
+			require.NoError(t, err)
+			require.Equal(t, uint64(5), logs[0].ID)
+
+			// failing transactions are rolled back
+			require.NoError(t, s.StorePreferences(Preferences{DeveloperMode: true}))
+			require.Error(t, s.Transaction(func(tx *transaction) error {
+				require.NoError(t, s.TxStorePreferences(tx, Preferences{DeveloperMode: false}))
+				require.NoError(t, s.TxDeleteLogs(tx))
+				return errors.New("fail")
+			}))
+			prefs, err := s.LoadPreferences()
+			require.NoError(t, err)
+			require.True(t, prefs.DeveloperMode)
+			require.True(t, s.BucketExists([]byte(logsBucket)))
+
+			require.NoError(t, s.DeleteAll())
+			require.False(t, s.BucketExists([]byte(logsBucket)))
+			require.False(t, s.BucketExists([]byte(userdataBucket)))
+		})
+	}
+}
+
+func TestMemoryStorageCopyOnWrite(t *testing.T) {
+	s := NewMemoryStorage()
+	bucket, other := []byte("bucket"), []byte("other")
+	require.NoError(t, s.Transaction(func(tx StorageTx) error {
+		require.NoError(t, tx.Put(bucket, []byte("a"), []byte("1")))
+		return tx.Put(other, []byte("b"), []byte("2"))
+	}))
+
+	// modifications of a failing transaction do not affect the buckets it shares with the storage
+	require.Error(t, s.Transaction(func(tx StorageTx) error {
+		require.NoError(t, tx.Put(bucket, []byte("a"), []byte("3")))
+		require.NoError(t, tx.Delete(other, []byte("b")))
+		_, err := tx.NextSequence(bucket)
+		require.NoError(t, err)
+		return errors.New("fail")
+	}))
+	require.NoError(t, s.View(func(tx StorageTx) error {
+		v, err := tx.Get(bucket, []byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("1"), v)
+		v, err = tx.Get(other, []byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("2"), v)
+		return nil
+	}))
+	require.NoError(t, s.Transaction(func(tx StorageTx) error {
+		seq, err := tx.NextSequence(bucket)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), seq)
+		return nil
+	}))
+}
+
+func TestMemoryStorageClient(t *testing.T) {
+	dir := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, dir)
+	handler := &TestClientHandler{t: t, c: make(chan error), storage: dir, expiring: make(chan []*ExpiringCredential, 10)}
+	client, err := New(
+		filepath.Join(dir, "client"),
+		filepath.Join(test.FindTestdataFolder(t), "irma_configuration"),
+		handler,
+		"",
+		NewMemoryStorage(),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, client.Close()) }()
+	require.NotNil(t, client.secretkey)
+	require.Empty(t, client.CredentialInfoList())
+
+	// nothing is written to the default bbolt database
+	_, err = os.Stat(filepath.Join(dir, "client", databaseFile))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExpiringCredentials(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	// wait until the expiry check performed at startup is done
+	waitForJobs(client)
+	require.Empty(t, handler.expiring)
+
+	var unexpired int
+	for _, cred := range client.CredentialInfoList() {
+		if !cred.IsExpired() {
+			unexpired++
+		}
+	}
+	require.NotZero(t, unexpired)
+	within := 100 * 365 * 24 * time.Hour
+	creds := client.ExpiringCredentials(within)
+	require.Len(t, creds, unexpired)
+	for i, cred := range creds {
+		require.False(t, cred.IsExpired())
+		credtype := client.Configuration.CredentialTypes[irma.NewCredentialTypeIdentifier(cred.SchemeManagerID+"."+cred.IssuerID+"."+cred.ID)]
+		require.Equal(t, credtype.IssueURL, cred.IssueURL)
+		if i > 0 {
+			require.False(t, cred.Expires.Before(creds[i-1].Expires))
+		}
+	}
+
+	// the handler is informed of each expiring credential once
+	client.SetPreferences(Preferences{ExpiryWarningDays: 100 * 365})
+	client.jobs <- client.checkExpiry
+	select {
+	case expiring := <-handler.expiring:
+		require.Len(t, expiring, unexpired)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expiring credentials not reported")
+	}
+	client.jobs <- client.checkExpiry
+	waitForJobs(client)
+	require.Empty(t, handler.expiring)
+}
+
+// waitForJobs waits until the jobs that are currently queued in the client are done.
+func waitForJobs(client *Client) {
+	done := make(chan struct{})
+	client.jobs <- func() { close(done) }
+	<-done
+}
+
+func TestQueryLogs(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	studentCard := irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	ids := func(query *LogQuery) []uint64 {
+		logs, err := client.QueryLogs(query)
+		require.NoError(t, err)
+		ids := []uint64{}
+		for _, entry := range logs {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+
+	require.Equal(t, []uint64{5, 4, 3, 2, 1}, ids(&LogQuery{}))
+	require.Equal(t, []uint64{5, 4}, ids(&LogQuery{Max: 2}))
+	require.Equal(t, []uint64{5, 4, 2}, ids(&LogQuery{Types: []irma.Action{irma.ActionIssuing}}))
+	email := irma.NewAttributeTypeIdentifier("test.test.mijnirma.email")
+	require.Equal(t, []uint64{5}, ids(&LogQuery{AttributeTypes: []irma.AttributeTypeIdentifier{email}}))
+	require.Equal(t, []uint64{3, 2, 1}, ids(&LogQuery{Types: []irma.Action{ActionRemoval, irma.ActionIssuing}, Before: 4}))
+	require.Empty(t, ids(&LogQuery{
+		Types:          []irma.Action{ActionRemoval},
+		AttributeTypes: []irma.AttributeTypeIdentifier{email},
+	}))
+	require.Equal(t, []uint64{4, 3, 2, 1}, ids(&LogQuery{CredentialTypes: []irma.CredentialTypeIdentifier{studentCard}}))
+	require.Equal(t, []uint64{3, 2}, ids(&LogQuery{
+		CredentialTypes: []irma.CredentialTypeIdentifier{studentCard},
+		Before:          4,
+		Max:             2,
+	}))
+	require.Equal(t, []uint64{4, 2}, ids(&LogQuery{
+		Requestor:       "radboud universiteit NIJMEGEN",
+		CredentialTypes: []irma.CredentialTypeIdentifier{studentCard},
+	}))
+	require.Empty(t, ids(&LogQuery{Requestor: "Radboud", Types: []irma.Action{irma.ActionIssuing}}))
+
+	logs, err := client.LoadLogsBefore(5, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5, 4, 3}, ids(&LogQuery{From: time.Time(logs[0].Time)}))
+	require.Equal(t, []uint64{4, 3}, ids(&LogQuery{
+		From:            time.Time(logs[0].Time),
+		Until:           time.Time(logs[0].Time),
+		CredentialTypes: []irma.CredentialTypeIdentifier{studentCard},
+	}))
+
+	// TxIndexLogs rebuilds the indexes, which are deleted along with the logs
+	require.NoError(t, client.storage.Transaction(func(tx *transaction) error {
+		if err := tx.DeleteBucket([]byte(logIndexBucket)); err != nil {
+			return err
+		}
+		return client.storage.TxIndexLogs(tx)
+	}))
+	require.Equal(t, []uint64{5, 4, 2}, ids(&LogQuery{Types: []irma.Action{irma.ActionIssuing}}))
+	require.NoError(t, client.storage.Transaction(func(tx *transaction) error {
+		return client.storage.TxDeleteLogs(tx)
+	}))
+	require.False(t, client.storage.BucketExists([]byte(logIndexBucket)))
+	require.Empty(t, ids(&LogQuery{Types: []irma.Action{irma.ActionIssuing}}))
+}
+
+func TestExportLogs(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	logs, err := client.QueryLogs(&LogQuery{Max: 3})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, client.ExportLogs(&buf, logs, LogExportJSON, "nl"))
+	var report []*LogReportEntry
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	require.Len(t, report, 3)
+	require.Equal(t, uint64(4), report[1].ID)
+	require.Equal(t, irma.ActionIssuing, report[1].Type)
+	require.Equal(t, "Radboud Universiteit Nijmegen", report[1].Requestor)
+	require.Len(t, report[1].Issued, 1)
+	require.Equal(t, ActionRemoval, report[2].Type)
+	require.Len(t, report[2].Removed, 1)
+
+	buf.Reset()
+	require.NoError(t, client.ExportLogs(&buf, logs, LogExportCSV, "en"))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Equal(t, "id", records[0][0])
+	require.Equal(t, "4", records[2][0])
+	require.Equal(t, "Radboud University Nijmegen", records[2][3])
+
+	require.Error(t, client.ExportLogs(&buf, logs, LogExportFormat("xml"), "en"))
+}
+
+func TestLogRetention(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	ids := func() []uint64 {
+		logs, err := client.LoadNewestLogs(100)
+		require.NoError(t, err)
+		ids := []uint64{}
+		for _, entry := range logs {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []uint64{5, 4, 3, 2, 1}, ids())
+	logs, err := client.LoadNewestLogs(5)
+	require.NoError(t, err)
+	newest := time.Time(logs[0].Time)
+
+	// strip values from all but the newest entry
+	deleted, stripped, err := client.storage.EnforceLogRetention(Preferences{LogStripValuesDays: 1}, newest.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.Equal(t, 2, stripped)
+	logs, err = client.LoadNewestLogs(5)
+	require.NoError(t, err)
+	require.False(t, logs[0].Stripped)
+	require.True(t, logs[1].Stripped)
+	require.Nil(t, logs[1].IssueCommitment)
+	issued, err := logs[1].GetIssuedCredentials(client.Configuration)
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	require.Equal(t, "studentCard", issued[0].ID)
+	require.False(t, logs[2].Stripped) // credential removal
+	disclosed, err := logs[1].GetDisclosedCredentials(client.Configuration)
+	require.NoError(t, err)
+	require.Empty(t, disclosed)
+
+	// stripped entries can still be found by credential type, and stripping happens once
+	found, err := client.QueryLogs(&LogQuery{
+		Types:           []irma.Action{irma.ActionIssuing},
+		CredentialTypes: []irma.CredentialTypeIdentifier{irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard")},
+	})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	_, stripped, err = client.storage.EnforceLogRetention(Preferences{LogStripValuesDays: 1}, newest.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Zero(t, stripped)
+
+	// keep only the newest entry, but always keep credential removals
+	deleted, _, err = client.storage.EnforceLogRetention(Preferences{LogRetentionEntries: 1}, newest)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	require.Equal(t, []uint64{5, 3, 1}, ids())
+	found, err = client.QueryLogs(&LogQuery{Types: []irma.Action{irma.ActionIssuing}})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	deleted, _, err = client.storage.EnforceLogRetention(Preferences{LogRetentionDays: 1}, newest.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Equal(t, []uint64{3, 1}, ids())
+
+	// the index entries of deleted entries, including stripped ones, are removed
+	require.NoError(t, client.storage.db.View(func(tx StorageTx) error {
+		return tx.ForEach([]byte(logIndexBucket), func(k, _ []byte) error {
+			require.Contains(t, []uint64{3, 1}, binary.BigEndian.Uint64(k[len(k)-8:]))
+			return nil
+		})
+	}))
+}
+
+func TestLogRetentionKeepsDisclosedAttributes(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	attrid := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := irma.NewDisclosureRequest(attrid)
+	candidates, _, err := client.Candidates(request)
+	require.NoError(t, err)
+	chosen, err := candidates[0][0].Choose()
+	require.NoError(t, err)
+	disclosure, _, err := client.Proofs(&irma.DisclosureChoice{Attributes: [][]*irma.AttributeIdentifier{chosen}}, request)
+	require.NoError(t, err)
+	now := time.Now()
+	entry := &LogEntry{
+		Type:       irma.ActionDisclosing,
+		Time:       irma.Timestamp(now),
+		Disclosure: disclosure,
+		request:    request,
+	}
+	require.NoError(t, entry.setSessionRequest())
+	require.NoError(t, client.storage.AddLogEntry(entry))
+
+	_, stripped, err := client.storage.EnforceLogRetention(Preferences{LogStripValuesDays: 1}, now.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	require.NotZero(t, stripped)
+	logs, err := client.LoadNewestLogs(1)
+	require.NoError(t, err)
+	require.True(t, logs[0].Stripped)
+	require.Nil(t, logs[0].Disclosure)
+
+	// the disclosed attribute types are kept, their values are not
+	disclosed, err := logs[0].GetDisclosedCredentials(client.Configuration)
+	require.NoError(t, err)
+	require.Len(t, disclosed, 1)
+	require.Len(t, disclosed[0], 1)
+	require.Equal(t, attrid, disclosed[0][0].Identifier)
+	require.Nil(t, disclosed[0][0].RawValue)
+	require.Nil(t, disclosed[0][0].Value)
+	found, err := client.QueryLogs(&LogQuery{
+		Types:          []irma.Action{irma.ActionDisclosing},
+		AttributeTypes: []irma.AttributeTypeIdentifier{attrid},
+	})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, entry.ID, found[0].ID)
+}
+
+func TestDeleteLogEntry(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	require.NoError(t, client.DeleteLogEntry(4))
+	require.Equal(t, ErrLogEntryNotFound, client.DeleteLogEntry(4))
+	require.Error(t, client.DeleteLogEntry(3)) // credential removal
+
+	logs, err := client.LoadNewestLogs(100)
+	require.NoError(t, err)
+	require.Len(t, logs, 4)
+	found, err := client.QueryLogs(&LogQuery{Types: []irma.Action{irma.ActionIssuing}})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	require.NoError(t, client.storage.db.View(func(tx StorageTx) error {
+		return tx.ForEach([]byte(logIndexBucket), func(k, _ []byte) error {
+			require.NotEqual(t, uint64(4), binary.BigEndian.Uint64(k[len(k)-8:]))
+			return nil
+		})
+	}))
+}
+
+func TestConsentRules(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	candidates, satisfiable, err := client.Candidates(request)
+	require.NoError(t, err)
+	require.True(t, satisfiable)
+	chosen, err := candidates[0][0].Choose()
+	require.NoError(t, err)
+	choice := &irma.DisclosureChoice{Attributes: [][]*irma.AttributeIdentifier{chosen}}
+	requestor := requestorInfo("http://localhost:48682/irma/session/123", client.Configuration)
+	require.Equal(t, irma.RequestorVerified, requestor.Verification)
+
+	// consent can only be remembered for verified requestors
+	unverified := requestorInfo("https://example.com/irma/session/123", client.Configuration)
+	_, err = client.AddConsentRule(request, unverified, choice, true, 0)
+	require.Error(t, err)
+	require.Nil(t, client.RememberedChoice(request, unverified, candidates))
+	_, err = client.AddConsentRule(request, &irma.SessionRequestorInfo{RequestorInfo: client.Configuration.Requestors["localhost"]}, choice, true, 0)
+	require.Error(t, err)
+
+	rule, err := client.AddConsentRule(request, requestor, choice, false, 0)
+	require.NoError(t, err)
+	require.Equal(t, choice, client.RememberedChoice(request, requestor, candidates))
+	overasking := *requestor
+	overasking.UndeclaredAttributes = []irma.AttributeTypeIdentifier{irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")}
+	require.Nil(t, client.RememberedChoice(request, &overasking, candidates))
+	other := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.level"))
+	require.Nil(t, client.RememberedChoice(other, requestor, candidates))
+
+	// adding a rule for the same requestor and request replaces it
+	_, err = client.AddConsentRule(request, requestor, choice, true, 0)
+	require.NoError(t, err)
+	rules, err := client.ConsentRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.Equal(t, rule.ID, rules[0].ID)
+	require.True(t, rules[0].AutoApprove)
+
+	// the remembered choice is not used when the chosen credential is gone
+	_, err = client.AddConsentRule(other, requestor, &irma.DisclosureChoice{Attributes: [][]*irma.AttributeIdentifier{{
+		{Type: irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.level"), CredentialHash: "nonexisting"},
+	}}}, true, 0)
+	require.NoError(t, err)
+	otherCandidates, _, err := client.Candidates(other)
+	require.NoError(t, err)
+	require.Nil(t, client.RememberedChoice(other, requestor, otherCandidates))
+
+	// expired rules are ignored
+	_, err = client.AddConsentRule(request, requestor, choice, true, -time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, client.RememberedChoice(request, requestor, candidates))
+	rules, err = client.ConsentRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	require.NoError(t, client.RemoveConsentRule(rules[0].ID))
+	rules, err = client.ConsentRules()
+	require.NoError(t, err)
+	require.Empty(t, rules)
+}
+
+func TestCandidateRanking(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	studentID := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	email := irma.NewAttributeTypeIdentifier("test.test.mijnirma.email")
+	original := client.Attributes(studentID.CredentialTypeIdentifier(), 0).Hash()
+	copied := copyCredential(t, client, original)
+
+	// Without preferences, the credentials keep their order
+	request := irma.NewDisclosureRequest(studentID)
+	candidates, _, err := client.Candidates(request)
+	require.NoError(t, err)
+	require.Equal(t, original, candidates[0][0][0].CredentialHash)
+	require.Equal(t, copied, candidates[0][1][0].CredentialHash)
+
+	// Pinned credentials come first
+	require.Error(t, client.PinCredential("nonexisting"))
+	require.NoError(t, client.PinCredential(copied))
+	candidates, _, err = client.Candidates(request)
+	require.NoError(t, err)
+	require.Equal(t, copied, candidates[0][0][0].CredentialHash)
+	choice := client.RecommendedChoice(request, nil, candidates)
+	require.NotNil(t, choice)
+	require.Equal(t, copied, choice.Attributes[0][0].CredentialHash)
+	require.NoError(t, client.UnpinCredential(studentID.CredentialTypeIdentifier()))
+	candidates, _, err = client.Candidates(request)
+	require.NoError(t, err)
+	require.Equal(t, original, candidates[0][0][0].CredentialHash)
+
+	// Of optional disjunctions disclosing nothing comes first and is recommended, followed by
+	// options with preferred issuers
+	request = &irma.DisclosureRequest{
+		BaseRequest: irma.BaseRequest{ProtocolVersion: maxVersion},
+		Disclose: irma.AttributeConDisCon{{
+			{irma.NewAttributeRequest(studentID.String())},
+			{irma.NewAttributeRequest(email.String())},
+			{},
+		}},
+	}
+	candidates, _, err = client.Candidates(request)
+	require.NoError(t, err)
+	require.Empty(t, candidates[0][0])
+	require.Equal(t, studentID, candidates[0][1][0].Type)
+	choice = client.RecommendedChoice(request, nil, candidates)
+	require.NotNil(t, choice)
+	require.Len(t, choice.Attributes, 1)
+	require.Empty(t, choice.Attributes[0])
+	client.SetPreferences(Preferences{PreferredIssuers: []irma.IssuerIdentifier{email.CredentialTypeIdentifier().IssuerIdentifier()}})
+	candidates, _, err = client.Candidates(request)
+	require.NoError(t, err)
+	require.Empty(t, candidates[0][0])
+	require.Equal(t, email, candidates[0][1][0].Type)
+
+	// Fresher credentials are preferred
+	now := time.Now()
+	require.True(t, optionRank{usable: true, signedOn: now}.better(optionRank{usable: true, signedOn: now.Add(-time.Hour)}))
+	require.False(t, optionRank{usable: true, signedOn: now}.better(optionRank{usable: true, signedOn: now}))
+
+	// When there are too many options, only the most preferable credentials are used
+	defer func(max int) { maxConCandidates = max }(maxConCandidates)
+	maxConCandidates = 1
+	require.NoError(t, client.PinCredential(copied))
+	candidates, _, err = client.Candidates(irma.NewDisclosureRequest(studentID))
+	require.NoError(t, err)
+	var present []string
+	for _, option := range candidates[0] {
+		if option[0].Present() {
+			present = append(present, option[0].CredentialHash)
+		}
+	}
+	require.Equal(t, []string{copied}, present)
+}
+
+// copyCredential adds a credential to the client with the same signature as the specified one
+// but a different attribute value, returning its hash.
+func copyCredential(t *testing.T, client *Client, hash string) string {
+	attrs, _ := client.attributesByHash(hash)
+	require.NotNil(t, attrs)
+	sig, witness, err := client.storage.LoadSignature(attrs)
+	require.NoError(t, err)
+
+	ints := make([]*big.Int, len(attrs.Ints))
+	copy(ints, attrs.Ints)
+	ints[1] = new(big.Int).Add(ints[1], big.NewInt(2))
+	copied := irma.NewAttributeListFromInts(ints, client.Configuration)
+	id := copied.CredentialType().Identifier()
+	require.NoError(t, client.storage.Transaction(func(tx *transaction) error {
+		return client.storage.TxStoreCLSignature(tx, copied.Hash(), &clSignatureWitness{CLSignature: sig, Witness: witness})
+	}))
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+	client.attributes[id] = append(client.attributes[id], copied)
+	client.lookup[copied.Hash()] = &credLookup{id: id, counter: len(client.attributes[id]) - 1}
+	return copied.Hash()
+}
+
+func TestSameAttributeValues(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	attrs := func(bsn string, revocationAttr int64) *irma.AttributeList {
+		req := &irma.CredentialRequest{
+			CredentialTypeID: irma.NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"),
+			Attributes:       map[string]string{"BSN": bsn},
+		}
+		list, err := req.AttributeList(client.Configuration, 0x03, big.NewInt(revocationAttr), time.Now())
+		require.NoError(t, err)
+		return list
+	}
+
+	// The revocation attribute differs for each issuance, so it is ignored
+	require.False(t, attrs("12345", 3).EqualsExceptMetadata(attrs("12345", 5)))
+	require.True(t, sameAttributeValues(attrs("12345", 3), attrs("12345", 5)))
+	require.False(t, sameAttributeValues(attrs("12345", 3), attrs("54321", 3)))
+}
+
+func TestRequestorInfoVerification(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	conf := client.Configuration
+
+	info := requestorInfo("http://localhost:48682/irma/session/123", conf)
+	require.Equal(t, irma.RequestorVerified, info.Verification)
+	require.Equal(t, conf.Requestors["localhost"], info.RequestorInfo)
+
+	info = requestorInfo("https://example.com/irma/session/123", conf)
+	require.Equal(t, irma.RequestorUnverified, info.Verification)
+	require.Equal(t, []string{"example.com"}, info.Hostnames)
+
+	past := irma.Timestamp(time.Now().Add(-time.Hour))
+	conf.Requestors["localhost"].ValidUntil = &past
+	defer func() { conf.Requestors["localhost"].ValidUntil = nil }()
+	info = requestorInfo("http://localhost:48682/irma/session/123", conf)
+	require.Equal(t, irma.RequestorExpired, info.Verification)
+	require.Equal(t, "localhost", info.Name["en"])
+
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	info = manualRequestorInfo(request)
+	require.Equal(t, irma.RequestorUnverified, info.Verification)
+	require.Empty(t, info.Hostnames)
+	request.ClientReturnURL = "https://example.com/return"
+	info = manualRequestorInfo(request)
+	require.Equal(t, irma.RequestorUnverified, info.Verification)
+	require.Equal(t, []string{"example.com"}, info.Hostnames)
+}
+
+func TestProfiles(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	require.Equal(t, DefaultProfileID, client.Profile().ID)
+	credcount := len(client.CredentialInfoList())
+	require.NotZero(t, credcount)
+	sk := client.secretkey.Key
+	logs, err := client.LoadNewestLogs(100)
+	require.NoError(t, err)
+	require.NotEmpty(t, logs)
+
+	_, err = client.CreateProfile(" ")
+	require.Error(t, err)
+	profile, err := client.CreateProfile("Alice")
+	require.NoError(t, err)
+	require.Equal(t, DefaultProfileID, client.Profile().ID)
+	profiles, err := client.Profiles()
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	require.Equal(t, DefaultProfileID, profiles[0].ID)
+	require.Equal(t, profile.ID, profiles[1].ID)
+
+	// The new profile is empty and has its own secret key
+	require.NoError(t, client.SwitchProfile(profile.ID))
+	require.Equal(t, "Alice", client.Profile().Name)
+	require.Empty(t, client.CredentialInfoList())
+	require.Empty(t, client.EnrolledSchemeManagers())
+	require.NotEqual(t, sk, client.secretkey.Key)
+	logs, err = client.LoadNewestLogs(100)
+	require.NoError(t, err)
+	require.Empty(t, logs)
+	require.Error(t, client.DeleteProfile(profile.ID))
+
+	// The active profile is remembered across restarts
+	require.NoError(t, client.RenameProfile(profile.ID, "Bob"))
+	require.Equal(t, "Bob", client.Profile().Name)
+	profilesk := client.secretkey.Key
+	require.NoError(t, client.Close())
+	client, handler = parseExistingStorage(t, handler.storage)
+	require.Equal(t, profile.ID, client.Profile().ID)
+	require.Equal(t, "Bob", client.Profile().Name)
+	require.Equal(t, profilesk, client.secretkey.Key)
+
+	// Switching back waits for running background jobs, and restores the data of the default profile
+	started, release, switched := make(chan struct{}), make(chan struct{}), make(chan error)
+	client.jobs <- func() {
+		close(started)
+		<-release
+	}
+	<-started
+	go func() { switched <- client.SwitchProfile(DefaultProfileID) }()
+	select {
+	case <-switched:
+		t.Fatal("profile switched while a background job was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-switched)
+	require.Len(t, client.CredentialInfoList(), credcount)
+	require.Equal(t, sk, client.secretkey.Key)
+	verifyKeyshareIsUnmarshaled(t, client)
+
+	require.Error(t, client.DeleteProfile("nonexisting"))
+	require.NoError(t, client.DeleteProfile(profile.ID))
+	profiles, err = client.Profiles()
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	s := client.storage.forProfile(profile.ID)
+	require.False(t, s.BucketExists([]byte(userdataBucket)))
+	require.Error(t, client.SwitchProfile(profile.ID))
+}
+
+func TestSendResponseRetry(t *testing.T) {
+	defer func(backoff time.Duration) { sendResponseBackoff = backoff }(sendResponseBackoff)
+	sendResponseBackoff = 10 * time.Millisecond
+
+	// Reserve an address at which no server listens yet, so that connecting to it fails
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	var requests int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/closed":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+		case "/rejected":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"UNEXPECTED_REQUEST","status":400}`))
+		default:
+			_, _ = w.Write([]byte(`"VALID"`))
+		}
+	}))
+	defer srv.Close()
+
+	// Start the server only after the first attempts of session.post() failed to connect
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		require.NoError(t, err)
+		srv.Listener = l
+		srv.Start()
+	}()
+	s := &session{transport: irma.NewHTTPTransport("http://"+addr+"/", false)}
+	var response disclosureResponse
+	require.NoError(t, s.post("proofs", &response, struct{}{}))
+	require.Equal(t, disclosureResponse("VALID"), response)
+	require.Equal(t, 1, requests)
+
+	// Errors of the server are not retried
+	err = s.post("rejected", &response, struct{}{})
+	require.Error(t, err)
+	require.Equal(t, irma.ErrorApi, err.(*irma.SessionError).ErrorType)
+	require.Equal(t, 2, requests)
+
+	// Network errors after the request reached the server are not retried by session.post(),
+	// at most by the transport itself
+	err = s.post("closed", &response, struct{}{})
+	require.Error(t, err)
+	require.Equal(t, irma.ErrorTransport, err.(*irma.SessionError).ErrorType)
+	require.LessOrEqual(t, requests, 2+3)
+
+	// Don't retry after the session is dismissed or finished
+	srv.Close()
+	s.finished = make(chan struct{})
+	close(s.finished)
+	err = s.post("proofs", &response, struct{}{})
+	require.Error(t, err)
+	require.Equal(t, irma.ErrorTransport, err.(*irma.SessionError).ErrorType)
+
+	// Don't retry after the server has expired the session
+	s.finished = nil
+	s.pending = &PendingSession{LastActive: irma.Timestamp(time.Now().Add(-PendingSessionLifetime))}
+	err = s.post("proofs", &response, struct{}{})
+	require.Error(t, err)
+	require.Equal(t, irma.ErrorTransport, err.(*irma.SessionError).ErrorType)
+}
+
+func TestPendingSessions(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	deleted := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted <- r.URL.Path
+		}
+	}))
+	defer srv.Close()
+	waitForJobs(client) // let the client finish cancelling expired sessions at startup
+
+	now := time.Now()
+	expired := &PendingSession{
+		ID:         "expired",
+		Qr:         &irma.Qr{URL: srv.URL + "/session/expired", Type: irma.ActionDisclosing},
+		Status:     irma.StatusConnected,
+		Started:    irma.Timestamp(now.Add(-time.Hour)),
+		LastActive: irma.Timestamp(now.Add(-time.Hour)),
+	}
+	fresh := &PendingSession{
+		ID:         "fresh",
+		Qr:         &irma.Qr{URL: srv.URL + "/session/fresh", Type: irma.ActionDisclosing},
+		Status:     irma.StatusCommunicating,
+		Started:    irma.Timestamp(now),
+		LastActive: irma.Timestamp(now),
+	}
+	require.NoError(t, client.storage.StorePendingSession(fresh))
+	require.NoError(t, client.storage.StorePendingSession(expired))
+
+	pending, err := client.PendingSessions()
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, "expired", pending[0].ID)
+
+	_, err = client.ResumeSession("nonexisting", nil)
+	require.Error(t, err)
+	_, err = client.ResumeSession("expired", nil)
+	require.Equal(t, ErrPendingSessionExpired, err)
+	require.Equal(t, "/session/expired/", <-deleted)
+	require.NoError(t, client.storage.StorePendingSession(expired))
+
+	// Pending sessions that the server has expired are cancelled at startup
+	require.NoError(t, client.cancelExpiredPendingSessions())
+	require.Equal(t, "/session/expired/", <-deleted)
+	pending, err = client.PendingSessions()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "fresh", pending[0].ID)
+
+	require.NoError(t, client.CancelPendingSession("fresh"))
+	require.Equal(t, "/session/fresh/", <-deleted)
+	pending, err = client.PendingSessions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
 // ------
 
 type TestClientHandler struct {
-	t       *testing.T
-	c       chan error
-	storage string
+	t        *testing.T
+	c        chan error
+	storage  string
+	expiring chan []*ExpiringCredential
 }
 
 func (i *TestClientHandler) UpdateConfiguration(new *irma.IrmaIdentifierSet) {}
 func (i *TestClientHandler) UpdateAttributes()                               {}
 func (i *TestClientHandler) Revoked(cred *irma.CredentialIdentifier)         {}
+func (i *TestClientHandler) CredentialsExpiring(creds []*ExpiringCredential) {
+	i.expiring <- creds
+}
 func (i *TestClientHandler) EnrollmentSuccess(manager irma.SchemeManagerIdentifier) {
 	select {
 	case i.c <- nil: // nop
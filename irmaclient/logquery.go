@@ -0,0 +1,402 @@
+package irmaclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// LogQuery selects log entries. A log entry is selected if it matches all fields that are set;
+// if a field contains multiple values, the entry needs to match only one of them.
+type LogQuery struct {
+	Types []irma.Action
+	// From and Until bound the time at which the session took place (inclusive); zero means unbounded.
+	From, Until time.Time
+	// Requestor is matched case insensitively against the hostnames and names of the requestor.
+	Requestor string
+	// CredentialTypes and AttributeTypes select entries in which any of these were disclosed,
+	// issued, or (for credential types) removed or replaced.
+	CredentialTypes []irma.CredentialTypeIdentifier
+	AttributeTypes  []irma.AttributeTypeIdentifier
+	// Before selects only entries older than the entry with this ID, for paging; 0 for the newest entries.
+	Before uint64
+	// Max is the maximum amount of entries to return; 0 for all matching entries.
+	Max int
+}
+
+// LogExportFormat is a format in which log entries can be exported with Client.ExportLogs.
+type LogExportFormat string
+
+const (
+	LogExportJSON = LogExportFormat("json")
+	LogExportCSV  = LogExportFormat("csv")
+)
+
+// LogReportEntry is a human-readable representation of a log entry, as exported by Client.ExportLogs.
+type LogReportEntry struct {
+	ID        uint64      `json:"id"`
+	Type      irma.Action `json:"type"`
+	Time      string      `json:"time"`
+	Requestor string      `json:"requestor,omitempty"`
+	// Disclosed contains the disclosed attributes as "<credential type>: <attribute type>: <value>".
+	Disclosed     []string `json:"disclosed,omitempty"`
+	Issued        []string `json:"issued,omitempty"`
+	Removed       []string `json:"removed,omitempty"`
+	Replaced      []string `json:"replaced,omitempty"`
+	SignedMessage string   `json:"signedMessage,omitempty"`
+}
+
+// Kinds of secondary indexes over the log entries
+const (
+	logIndexType      = "type"
+	logIndexRequestor = "requestor"
+	logIndexCredType  = "credtype"
+	logIndexAttrType  = "attrtype"
+)
+
+// QueryLogs returns the log entries selected by the query, sorted from new to old.
+func (client *Client) QueryLogs(query *LogQuery) ([]*LogEntry, error) {
+	return client.storage.QueryLogs(query)
+}
+
+// ExportLogs writes a human-readable report of the specified log entries in the specified format,
+// using the specified language for names and attribute values where available.
+func (client *Client) ExportLogs(w io.Writer, entries []*LogEntry, format LogExportFormat, lang string) error {
+	report := make([]*LogReportEntry, 0, len(entries))
+	for _, entry := range entries {
+		r, err := entry.report(client.Configuration, lang)
+		if err != nil {
+			return err
+		}
+		report = append(report, r)
+	}
+
+	switch format {
+	case LogExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case LogExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{
+			"id", "type", "time", "requestor", "disclosed", "issued", "removed", "replaced", "signed message",
+		}); err != nil {
+			return err
+		}
+		for _, r := range report {
+			if err := cw.Write([]string{
+				strconv.FormatUint(r.ID, 10), string(r.Type), r.Time, r.Requestor,
+				strings.Join(r.Disclosed, "\n"), strings.Join(r.Issued, "\n"), strings.Join(r.Removed, "\n"),
+				strings.Join(r.Replaced, "\n"), r.SignedMessage,
+			}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return errors.Errorf("unsupported log export format %s", format)
+	}
+}
+
+func (entry *LogEntry) report(conf *irma.Configuration, lang string) (*LogReportEntry, error) {
+	r := &LogReportEntry{
+		ID:            entry.ID,
+		Type:          entry.Type,
+		Time:          time.Time(entry.Time).Format(time.RFC3339),
+		SignedMessage: string(entry.SignedMessage),
+	}
+	if entry.ServerName != nil {
+		r.Requestor = translate(entry.ServerName.Name, lang)
+	}
+
+	disclosed, err := entry.disclosedAttributes(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range disclosed {
+		value := ""
+		if attr.RawValue != nil {
+			value = translate(attr.Value, lang)
+		}
+		r.Disclosed = append(r.Disclosed, credentialTypeName(conf, attr.Identifier.CredentialTypeIdentifier(), lang)+
+			": "+attributeTypeName(conf, attr.Identifier, lang)+": "+value)
+	}
+
+	issued, err := entry.GetIssuedCredentials(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range issued {
+		id := irma.NewCredentialTypeIdentifier(info.SchemeManagerID + "." + info.IssuerID + "." + info.ID)
+		r.Issued = append(r.Issued, credentialTypeName(conf, id, lang))
+	}
+
+	for id := range entry.Removed {
+		r.Removed = append(r.Removed, credentialTypeName(conf, id, lang))
+	}
+	sort.Strings(r.Removed)
+	for id := range entry.Replaced {
+		r.Replaced = append(r.Replaced, credentialTypeName(conf, id, lang))
+	}
+	sort.Strings(r.Replaced)
+	return r, nil
+}
+
+// disclosedAttributes returns the attributes disclosed in the session of the log entry.
+func (entry *LogEntry) disclosedAttributes(conf *irma.Configuration) ([]*irma.DisclosedAttribute, error) {
+	if !entry.Stripped && (entry.Type == irma.ActionIssuing && entry.IssueCommitment == nil ||
+		entry.Type != irma.ActionIssuing && entry.Disclosure == nil) {
+		return nil, nil
+	}
+	disclosed, err := entry.GetDisclosedCredentials(conf)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []*irma.DisclosedAttribute
+	for _, con := range disclosed {
+		attrs = append(attrs, con...)
+	}
+	return attrs, nil
+}
+
+// indexValues returns per kind of index the values under which the log entry is indexed.
+func (entry *LogEntry) indexValues(conf *irma.Configuration) map[string][]string {
+	values := map[string][]string{logIndexType: {string(entry.Type)}}
+	if entry.ServerName != nil {
+		for _, hostname := range entry.ServerName.Hostnames {
+			values[logIndexRequestor] = append(values[logIndexRequestor], strings.ToLower(hostname))
+		}
+		for _, name := range entry.ServerName.Name {
+			values[logIndexRequestor] = append(values[logIndexRequestor], strings.ToLower(name))
+		}
+	}
+	for id := range entry.Removed {
+		values[logIndexCredType] = append(values[logIndexCredType], id.String())
+	}
+	for id := range entry.Replaced {
+		values[logIndexCredType] = append(values[logIndexCredType], id.String())
+	}
+
+	// Entries of which the session request or disclosure cannot be parsed (anymore) are still
+	// indexed by type and requestor
+	disclosed, err := entry.disclosedAttributes(conf)
+	if err != nil {
+		irma.Logger.Warnf("failed to index attributes disclosed in log entry %d: %s", entry.ID, err.Error())
+	}
+	for _, attr := range disclosed {
+		values[logIndexCredType] = append(values[logIndexCredType], attr.Identifier.CredentialTypeIdentifier().String())
+		values[logIndexAttrType] = append(values[logIndexAttrType], attr.Identifier.String())
+	}
+	issued, err := entry.GetIssuedCredentials(conf)
+	if err != nil {
+		irma.Logger.Warnf("failed to index credentials issued in log entry %d: %s", entry.ID, err.Error())
+	}
+	for _, info := range issued {
+		values[logIndexCredType] = append(values[logIndexCredType], info.SchemeManagerID+"."+info.IssuerID+"."+info.ID)
+		for id := range info.Attributes {
+			values[logIndexAttrType] = append(values[logIndexAttrType], id.String())
+		}
+	}
+	return values
+}
+
+// indexCriteria returns the values to look up per kind of index; an entry must be present in
+// the index under at least one value of each kind.
+func (query *LogQuery) indexCriteria() map[string][]string {
+	criteria := map[string][]string{}
+	for _, t := range query.Types {
+		criteria[logIndexType] = append(criteria[logIndexType], string(t))
+	}
+	if query.Requestor != "" {
+		criteria[logIndexRequestor] = []string{strings.ToLower(query.Requestor)}
+	}
+	for _, id := range query.CredentialTypes {
+		criteria[logIndexCredType] = append(criteria[logIndexCredType], id.String())
+	}
+	for _, id := range query.AttributeTypes {
+		criteria[logIndexAttrType] = append(criteria[logIndexAttrType], id.String())
+	}
+	return criteria
+}
+
+func (query *LogQuery) matchesTime(entry *LogEntry) bool {
+	t := time.Time(entry.Time)
+	return (query.From.IsZero() || !t.Before(query.From)) && (query.Until.IsZero() || !t.After(query.Until))
+}
+
+// logIndexPrefix returns the prefix of the keys in the log index bucket of the log entries
+// indexed under the specified value of the specified kind of index.
+func logIndexPrefix(kind, value string) []byte {
+	return []byte(kind + "\x00" + value + "\x00")
+}
+
+// TxIndexLogEntry adds the log entry to the secondary indexes used by QueryLogs.
+func (s *storage) TxIndexLogEntry(tx *transaction, entry *LogEntry) error {
+	for kind, values := range entry.indexValues(s.Configuration) {
+		for _, value := range values {
+			k := append(logIndexPrefix(kind, value), s.logEntryKeyToBytes(entry.ID)...)
+			if err := tx.Put([]byte(logIndexBucket), k, []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TxIndexLogs adds all log entries to the secondary indexes used by QueryLogs.
+func (s *storage) TxIndexLogs(tx *transaction) error {
+	var entries []*LogEntry
+	err := tx.ForEach([]byte(logsBucket), func(_, v []byte) error {
+		var entry LogEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, &entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = s.TxIndexLogEntry(tx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryLogs returns the log entries selected by the query, sorted from new to old.
+func (s *storage) QueryLogs(query *LogQuery) ([]*LogEntry, error) {
+	var logs []*LogEntry
+	collect := func(v []byte) (bool, error) {
+		var entry LogEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return false, err
+		}
+		if query.matchesTime(&entry) {
+			logs = append(logs, &entry)
+		}
+		return query.Max == 0 || len(logs) < query.Max, nil
+	}
+
+	var before []byte
+	if query.Before != 0 {
+		before = s.logEntryKeyToBytes(query.Before)
+	}
+	err := s.View(func(tx *transaction) error {
+		criteria := query.indexCriteria()
+		if len(criteria) == 0 {
+			return tx.ForEachBefore([]byte(logsBucket), before, func(_, v []byte) (bool, error) {
+				return collect(v)
+			})
+		}
+
+		ids, err := s.txLookupLogIndex(tx, criteria, before)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			v, err := tx.Get([]byte(logsBucket), s.logEntryKeyToBytes(id))
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				continue
+			}
+			if cont, err := collect(v); err != nil || !cont {
+				return err
+			}
+		}
+		return nil
+	})
+	return logs, err
+}
+
+// txLookupLogIndex returns the IDs of the log entries before the specified key that are indexed
+// under at least one value of each kind of index in the criteria, sorted from new to old.
+func (s *storage) txLookupLogIndex(tx StorageTx, criteria map[string][]string, before []byte) ([]uint64, error) {
+	if before == nil {
+		before = bytes.Repeat([]byte{0xff}, 8)
+	}
+	var result map[uint64]bool
+	for kind, values := range criteria {
+		ids := map[uint64]bool{}
+		for _, value := range values {
+			prefix := logIndexPrefix(kind, value)
+			err := tx.ForEachBefore([]byte(logIndexBucket), append(prefix, before...), func(k, _ []byte) (bool, error) {
+				if !bytes.HasPrefix(k, prefix) || len(k) != len(prefix)+8 {
+					return false, nil
+				}
+				ids[binary.BigEndian.Uint64(k[len(prefix):])] = true
+				return true, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if result == nil {
+			result = ids
+			continue
+		}
+		for id := range result {
+			if !ids[id] {
+				delete(result, id)
+			}
+		}
+	}
+
+	sorted := make([]uint64, 0, len(result))
+	for id := range result {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	return sorted, nil
+}
+
+// translate returns the translation of the string in the specified language, falling back to
+// English and then to any available translation.
+func translate(ts irma.TranslatedString, lang string) string {
+	if s, ok := ts[lang]; ok {
+		return s
+	}
+	if s, ok := ts["en"]; ok {
+		return s
+	}
+	langs := make([]string, 0, len(ts))
+	for l := range ts {
+		langs = append(langs, l)
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.Strings(langs)
+	return ts[langs[0]]
+}
+
+func credentialTypeName(conf *irma.Configuration, id irma.CredentialTypeIdentifier, lang string) string {
+	if credtype := conf.CredentialTypes[id]; credtype != nil {
+		if name := translate(credtype.Name, lang); name != "" {
+			return name
+		}
+	}
+	return id.String()
+}
+
+func attributeTypeName(conf *irma.Configuration, id irma.AttributeTypeIdentifier, lang string) string {
+	if attrtype := conf.AttributeTypes[id]; attrtype != nil {
+		if name := translate(attrtype.Name, lang); name != "" {
+			return name
+		}
+	}
+	return id.Name()
+}
@@ -0,0 +1,188 @@
+package irmaclient
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// logRetentionInterval is the time interval in hours with which the Client enforces the log
+// retention preferences.
+const logRetentionInterval = 12
+
+// ErrLogEntryNotFound is returned when deleting a log entry that does not exist.
+var ErrLogEntryNotFound = errors.New("log entry not found")
+
+// DeleteLogEntry deletes the log entry with the specified ID. Log entries of credential removals
+// cannot be deleted, as they are the only record of credentials that the user once had.
+func (client *Client) DeleteLogEntry(id uint64) error {
+	return client.storage.Transaction(func(tx *transaction) error {
+		var entry LogEntry
+		found, err := client.storage.txLoad(tx, logsBucket, string(client.storage.logEntryKeyToBytes(id)), &entry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrLogEntryNotFound
+		}
+		if entry.Type == ActionRemoval {
+			return errors.Errorf("log entry %d of a credential removal cannot be deleted", id)
+		}
+		return client.storage.TxDeleteLogEntries(tx, []*LogEntry{&entry})
+	})
+}
+
+func (client *Client) initLogRetention() {
+	client.jobs <- client.enforceLogRetention
+	client.Configuration.Scheduler.Every(logRetentionInterval).Hours().Do(func() {
+		client.jobs <- client.enforceLogRetention
+	})
+}
+
+func (client *Client) enforceLogRetention() {
+	prefs := client.preferences()
+	if prefs.LogRetentionDays <= 0 && prefs.LogRetentionEntries <= 0 && prefs.LogStripValuesDays <= 0 {
+		return
+	}
+	deleted, stripped, err := client.storage.EnforceLogRetention(prefs, time.Now())
+	if err != nil {
+		client.reportError(err)
+		return
+	}
+	if deleted > 0 || stripped > 0 {
+		irma.Logger.WithField("deleted", deleted).WithField("stripped", stripped).Debug("enforced log retention")
+	}
+}
+
+// EnforceLogRetention deletes the log entries older than prefs.LogRetentionDays days and all
+// but the newest prefs.LogRetentionEntries entries, and strips attribute values from the
+// remaining entries older than prefs.LogStripValuesDays days. Log entries of credential removals
+// are kept, and not counted in prefs.LogRetentionEntries.
+func (s *storage) EnforceLogRetention(prefs Preferences, now time.Time) (deleted, stripped int, err error) {
+	before := func(days int) time.Time {
+		if days <= 0 {
+			return time.Time{}
+		}
+		return now.AddDate(0, 0, -days)
+	}
+	deleteBefore, stripBefore := before(prefs.LogRetentionDays), before(prefs.LogStripValuesDays)
+
+	err = s.Transaction(func(tx *transaction) error {
+		var (
+			count    int
+			toDelete []*LogEntry
+			toStrip  []*LogEntry
+		)
+		err := tx.ForEachBefore([]byte(logsBucket), nil, func(_, v []byte) (bool, error) {
+			var entry LogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return false, err
+			}
+			if entry.Type == ActionRemoval {
+				return true, nil
+			}
+			count++
+			t := time.Time(entry.Time)
+			switch {
+			case t.Before(deleteBefore), prefs.LogRetentionEntries > 0 && count > prefs.LogRetentionEntries:
+				toDelete = append(toDelete, &entry)
+			case t.Before(stripBefore) && !entry.Stripped:
+				toStrip = append(toStrip, &entry)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range toStrip {
+			if err = entry.stripValues(s.Configuration); err != nil {
+				return err
+			}
+			if err = s.txStore(tx, logsBucket, string(s.logEntryKeyToBytes(entry.ID)), entry); err != nil {
+				return err
+			}
+		}
+		deleted, stripped = len(toDelete), len(toStrip)
+		return s.TxDeleteLogEntries(tx, toDelete)
+	})
+	return
+}
+
+// TxDeleteLogEntries deletes the specified log entries, along with their index entries. Index
+// entries that cannot be computed from the log entry anymore, e.g. because its credential type
+// was removed from the scheme, are left behind; QueryLogs skips those.
+func (s *storage) TxDeleteLogEntries(tx *transaction, entries []*LogEntry) error {
+	for _, entry := range entries {
+		k := s.logEntryKeyToBytes(entry.ID)
+		if err := tx.Delete([]byte(logsBucket), k); err != nil {
+			return err
+		}
+		for kind, values := range entry.indexValues(s.Configuration) {
+			for _, value := range values {
+				if err := tx.Delete([]byte(logIndexBucket), append(logIndexPrefix(kind, value), k...)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// stripValues removes the disclosed and issued attribute values and the signed message from the
+// log entry, keeping its metadata: when the session took place, with which requestor, and which
+// credential types were issued. The disclosed attributes are kept without their values, so the
+// entry remains indexed under and reports the attribute types that were disclosed.
+func (entry *LogEntry) stripValues(conf *irma.Configuration) error {
+	disclosed, err := entry.disclosedCredentials(conf)
+	if err != nil {
+		return err
+	}
+
+	if entry.Type == irma.ActionIssuing {
+		request, err := entry.SessionRequest()
+		if err != nil {
+			return err
+		}
+		for _, cred := range request.(*irma.IssuanceRequest).Credentials {
+			for attr := range cred.Attributes {
+				cred.Attributes[attr] = ""
+			}
+		}
+		if err = entry.setSessionRequest(); err != nil {
+			return err
+		}
+		for id := range entry.Replaced {
+			entry.Replaced[id] = nil
+		}
+	}
+	entry.StrippedDisclosure = disclosed
+	entry.Disclosure = nil
+	entry.IssueCommitment = nil
+	entry.SignedMessage = nil
+	entry.Timestamp = nil
+	entry.Stripped = true
+	return nil
+}
+
+// disclosedCredentials returns the attributes disclosed in the session of the log entry, without
+// their values.
+func (entry *LogEntry) disclosedCredentials(conf *irma.Configuration) ([][]*irma.DisclosedAttribute, error) {
+	if entry.Type == irma.ActionIssuing && entry.IssueCommitment == nil ||
+		entry.Type != irma.ActionIssuing && entry.Disclosure == nil {
+		return nil, nil
+	}
+	disclosed, err := entry.GetDisclosedCredentials(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, con := range disclosed {
+		for _, attr := range con {
+			attr.RawValue = nil
+			attr.Value = nil
+		}
+	}
+	return disclosed, nil
+}
@@ -26,13 +26,26 @@ type LogEntry struct {
 
 	// Issuance sessions
 	IssueCommitment *irma.IssueCommitmentMessage `json:",omitempty"`
+	// Replaced contains per credential type the attribute values of each of the older credentials
+	// that were removed because of the issued credentials, see ReplacementPolicy
+	Replaced map[irma.CredentialTypeIdentifier][][]irma.TranslatedString `json:",omitempty"`
 
 	// All session types
-	ServerName *irma.RequestorInfo   `json:",omitempty"`
-	Version    *irma.ProtocolVersion `json:",omitempty"`
-	Disclosure *irma.Disclosure      `json:",omitempty"`
-	Request    json.RawMessage       `json:",omitempty"` // Message that started the session
-	request    irma.SessionRequest   // cached parsed version of Request; get with LogEntry.SessionRequest()
+	ServerName *irma.SessionRequestorInfo `json:",omitempty"`
+	Version    *irma.ProtocolVersion      `json:",omitempty"`
+	Disclosure *irma.Disclosure           `json:",omitempty"`
+	Request    json.RawMessage            `json:",omitempty"` // Message that started the session
+	request    irma.SessionRequest        // cached parsed version of Request; get with LogEntry.SessionRequest()
+
+	// Stripped is true if the attribute values and signed message have been removed from
+	// this entry because of Preferences.LogStripValuesDays
+	Stripped bool `json:",omitempty"`
+	// StrippedDisclosure contains the attributes that were disclosed in the session of a
+	// stripped entry, without their values
+	StrippedDisclosure [][]*irma.DisclosedAttribute `json:",omitempty"`
+	// ConsentRule is the ID of the consent rule by which the session was approved automatically,
+	// if it was not approved by the user
+	ConsentRule string `json:",omitempty"`
 }
 
 // We need manual unmarshalling to deal with legacy log entries that have
@@ -63,12 +76,12 @@ func (entry *LogEntry) UnmarshalJSON(b []byte) (err error) {
 	}
 
 	// If succesfull, we should have at least one translation for a name, so check that
-	if len(entry.ServerName.Name) != 0 {
+	if entry.ServerName.RequestorInfo != nil && len(entry.ServerName.Name) != 0 {
 		return nil
 	}
 
 	// No success, construct a minimal requestorinfo with the old translatedstring as name
-	entry.ServerName = &irma.RequestorInfo{}
+	entry.ServerName = &irma.SessionRequestorInfo{RequestorInfo: &irma.RequestorInfo{}}
 	return json.Unmarshal(*temp.ServerName, &(entry.ServerName.Name))
 }
 
@@ -112,6 +125,12 @@ func (entry *LogEntry) GetDisclosedCredentials(conf *irma.Configuration) ([][]*i
 	if entry.Type == ActionRemoval {
 		return [][]*irma.DisclosedAttribute{}, nil
 	}
+	if entry.Stripped {
+		if entry.StrippedDisclosure == nil {
+			return [][]*irma.DisclosedAttribute{}, nil
+		}
+		return entry.StrippedDisclosure, nil
+	}
 
 	request, err := entry.SessionRequest()
 	if err != nil {
@@ -142,7 +161,7 @@ func (entry *LogEntry) GetIssuedCredentials(conf *irma.Configuration) (list irma
 
 // GetSignedMessage gets the signed for a log entry
 func (entry *LogEntry) GetSignedMessage() (abs *irma.SignedMessage, err error) {
-	if entry.Type != irma.ActionSigning {
+	if entry.Type != irma.ActionSigning || entry.Stripped {
 		return nil, nil
 	}
 	request, err := entry.SessionRequest()
@@ -168,6 +187,9 @@ func (session *session) createLogEntry(response interface{}) (*LogEntry, error)
 		Version:    session.Version,
 		request:    session.request,
 	}
+	if session.consentRule != nil {
+		entry.ConsentRule = session.consentRule.ID
+	}
 
 	if err := entry.setSessionRequest(); err != nil {
 		return nil, err
@@ -187,6 +209,9 @@ func (session *session) createLogEntry(response interface{}) (*LogEntry, error)
 		entry.Disclosure = response.(*irma.Disclosure)
 	case irma.ActionIssuing:
 		entry.IssueCommitment = response.(*irma.IssueCommitmentMessage)
+		if len(session.replaced) > 0 {
+			entry.Replaced = session.replaced
+		}
 	default:
 		return nil, errors.New("Invalid log type")
 	}
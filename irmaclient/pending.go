@@ -0,0 +1,237 @@
+package irmaclient
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// PendingSessionLifetime is the time after the last contact with the server after which the
+// server is assumed to have expired a session, which is the session lifetime of the IRMA server.
+const PendingSessionLifetime = 5 * time.Minute
+
+// Waiting times between attempts to send the session response to the server, see session.post().
+var (
+	sendResponseBackoff    = time.Second
+	sendResponseBackoffMax = 30 * time.Second
+)
+
+// ErrPendingSessionExpired is returned when resuming a pending session that the server has
+// probably expired.
+var ErrPendingSessionExpired = errors.New("pending session expired")
+
+// PendingSession is the state of an interactive session that has not finished yet, kept in storage
+// so that the session can be resumed or cancelled when it was interrupted, e.g. because the app was
+// killed.
+type PendingSession struct {
+	ID         string                 `json:"id"`
+	Qr         *irma.Qr               `json:"qr"`
+	Request    json.RawMessage        `json:"request,omitempty"` // nil if not yet received from the server
+	Status     irma.Status            `json:"status"`
+	Choice     *irma.DisclosureChoice `json:"choice,omitempty"` // nil if the user has not yet given permission
+	Started    irma.Timestamp         `json:"started"`
+	LastActive irma.Timestamp         `json:"lastActive"` // last successful contact with the server
+}
+
+// PendingSessions returns the interactive sessions that were interrupted before they finished,
+// sorted by the time they were started. Apps should resume or cancel them at startup, using
+// ResumeSession() or CancelPendingSession(); the Client cancels those whose server has probably
+// expired them by itself.
+func (client *Client) PendingSessions() ([]*PendingSession, error) {
+	pending, err := client.storage.LoadPendingSessions()
+	if err != nil {
+		return nil, err
+	}
+	// Don't return the sessions that are currently running
+	running := map[string]bool{}
+	client.sessions.mutex.Lock()
+	for _, s := range client.sessions.sessions {
+		if s.pending != nil {
+			running[s.pending.ID] = true
+		}
+	}
+	client.sessions.mutex.Unlock()
+	list := make([]*PendingSession, 0, len(pending))
+	for _, p := range pending {
+		if !running[p.ID] {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return time.Time(list[i].Started).Before(time.Time(list[j].Started))
+	})
+	return list, nil
+}
+
+// ResumeSession continues the pending session with the specified ID. If the session request was
+// already received from the server, it is not requested again; if the user had already given
+// permission, the session continues with the attributes the user chose, if they are still
+// available, without asking for permission again.
+func (client *Client) ResumeSession(id string, handler Handler) (SessionDismisser, error) {
+	pending, err := client.storage.LoadPendingSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if pending == nil {
+		return nil, errors.Errorf("pending session %s not found", id)
+	}
+	if pending.expired(time.Now()) {
+		if err = client.CancelPendingSession(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrPendingSessionExpired
+	}
+
+	session := client.newInteractiveSession(pending.Qr, handler)
+	if session == nil {
+		return nil, nil
+	}
+	session.pending = pending
+	if pending.Request == nil {
+		go session.getSessionInfo()
+		return session, nil
+	}
+
+	if err = json.Unmarshal(pending.Request, session.request); err != nil {
+		session.fail(&irma.SessionError{ErrorType: irma.ErrorSerialization, Err: err})
+		return nil, nil
+	}
+	session.resumeChoice = pending.Choice
+	go session.processSessionInfo()
+	return session, nil
+}
+
+// CancelPendingSession informs the server that the pending session with the specified ID is
+// cancelled, and forgets it.
+func (client *Client) CancelPendingSession(id string) error {
+	pending, err := client.storage.LoadPendingSession(id)
+	if err != nil || pending == nil {
+		return err
+	}
+	irma.NewHTTPTransport(pending.Qr.URL, !client.Preferences.DeveloperMode).Delete()
+	return client.storage.DeletePendingSession(id)
+}
+
+// cancelExpiredPendingSessions forgets the pending sessions that the server has probably expired,
+// as they cannot be resumed anymore. Their servers are informed in the background.
+func (client *Client) cancelExpiredPendingSessions() error {
+	pending, err := client.PendingSessions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, p := range pending {
+		if !p.expired(now) {
+			continue
+		}
+		irma.Logger.WithField("session", p.ID).Info("cancelling expired pending session")
+		if err = client.storage.DeletePendingSession(p.ID); err != nil {
+			return err
+		}
+		transport := irma.NewHTTPTransport(p.Qr.URL, !client.preferences().DeveloperMode)
+		client.finishing.Add(1)
+		go func() {
+			defer client.finishing.Done()
+			transport.Delete()
+		}()
+	}
+	return nil
+}
+
+func (p *PendingSession) expired(now time.Time) bool {
+	return now.After(time.Time(p.LastActive).Add(PendingSessionLifetime))
+}
+
+// persist stores the state of the session in storage, if it is an interactive session.
+func (session *session) persist() {
+	if session.pending == nil {
+		return
+	}
+	if err := session.client.storage.StorePendingSession(session.pending); err != nil {
+		irma.Logger.Warn(errors.WrapPrefix(err, "failed to store pending session", 0).ErrorStack())
+	}
+}
+
+// post POSTs the message to the server. If the message did not reach the server because no
+// connection could be made, it retries with exponential backoff, for as long as the server keeps
+// the session alive and the session is not dismissed. Other errors are not retried, as the server
+// may have processed the message.
+func (session *session) post(url string, result interface{}, message interface{}) error {
+	lastActive := time.Now()
+	if session.pending != nil {
+		lastActive = time.Time(session.pending.LastActive)
+	}
+	deadline := lastActive.Add(PendingSessionLifetime)
+
+	// Keep the cause of failed attempts, to see whether the request reached the server
+	transport := session.transport.WithLastError()
+	backoff := sendResponseBackoff
+	for {
+		err := transport.Post(url, result, message)
+		if err == nil || !requestNotSent(err) || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		irma.Logger.WithField("backoff", backoff).Warn("failed to send session response, retrying: ", err)
+		select {
+		case <-session.finished:
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > sendResponseBackoffMax {
+			backoff = sendResponseBackoffMax
+		}
+	}
+}
+
+// requestNotSent returns whether the error returned by an irma.HTTPTransport shows that the
+// request did not reach the server, i.e. no connection to the server could be made.
+func requestNotSent(err error) bool {
+	serr, ok := err.(*irma.SessionError)
+	if !ok || serr.ErrorType != irma.ErrorTransport {
+		return false
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return goerrors.As(serr.Err, &dnsErr) || (goerrors.As(serr.Err, &opErr) && opErr.Op == "dial")
+}
+
+func (s *storage) StorePendingSession(pending *PendingSession) error {
+	return s.Transaction(func(tx *transaction) error {
+		return s.txStore(tx, sessionsBucket, pending.ID, pending)
+	})
+}
+
+func (s *storage) DeletePendingSession(id string) error {
+	return s.Transaction(func(tx *transaction) error {
+		return s.txDelete(tx, sessionsBucket, id)
+	})
+}
+
+// LoadPendingSession returns the pending session with the specified ID, or nil if it does not exist.
+func (s *storage) LoadPendingSession(id string) (*PendingSession, error) {
+	var pending PendingSession
+	found, err := s.load(sessionsBucket, id, &pending)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+func (s *storage) LoadPendingSessions() ([]*PendingSession, error) {
+	var pending []*PendingSession
+	return pending, s.View(func(tx *transaction) error {
+		return tx.ForEach([]byte(sessionsBucket), func(_, v []byte) error {
+			var p PendingSession
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			pending = append(pending, &p)
+			return nil
+		})
+	})
+}
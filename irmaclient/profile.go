@@ -0,0 +1,315 @@
+package irmaclient
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// DefaultProfileID identifies the profile that a Client uses when no other profile has been
+// created. Its data is stored in the same buckets as before the Client supported profiles.
+const DefaultProfileID = "default"
+
+// Profile is an independent identity within a Client, with its own secret key, credentials,
+// keyshare enrollments, logs, consent rules and preferences. All profiles share the
+// irma.Configuration of the Client.
+type Profile struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Created irma.Timestamp `json:"created"`
+}
+
+// profileRegistry keeps track of the profiles of a Client, and which one is active.
+type profileRegistry struct {
+	Active   string              `json:"active"`
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// Profile returns the active profile.
+func (client *Client) Profile() *Profile {
+	p := *client.profile
+	return &p
+}
+
+// Profiles returns all profiles, sorted by the time they were created.
+func (client *Client) Profiles() ([]*Profile, error) {
+	registry, err := client.storage.LoadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]*Profile, 0, len(registry.Profiles))
+	for _, p := range registry.Profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		ti, tj := time.Time(profiles[i].Created), time.Time(profiles[j].Created)
+		if ti.Equal(tj) {
+			return profiles[i].ID < profiles[j].ID
+		}
+		return ti.Before(tj)
+	})
+	return profiles, nil
+}
+
+// CreateProfile creates a new, empty profile with the specified name. The active profile
+// remains unchanged; use SwitchProfile() to start using the new profile.
+func (client *Client) CreateProfile(name string) (*Profile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("profile name must not be empty")
+	}
+	profile := &Profile{
+		ID:      common.NewSessionToken(),
+		Name:    name,
+		Created: irma.Timestamp(time.Now()),
+	}
+
+	// The updates from clientUpdates migrate data from before profiles existed into the
+	// default profile, so they don't need to be performed for new profiles
+	updates := make([]update, len(clientUpdates))
+	for i := range updates {
+		updates[i] = update{When: profile.Created, Number: i, Success: true}
+	}
+
+	ps := client.storage.forProfile(profile.ID)
+	err := client.storage.db.Transaction(func(tx StorageTx) error {
+		registry, err := client.storage.txLoadProfiles(&transaction{tx})
+		if err != nil {
+			return err
+		}
+		registry.Profiles[profile.ID] = profile
+		if err = ps.TxStoreUpdates(ps.transaction(tx), updates); err != nil {
+			return err
+		}
+		return client.storage.txStoreProfiles(&transaction{tx}, registry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// RenameProfile changes the name of the profile with the specified ID.
+func (client *Client) RenameProfile(id, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("profile name must not be empty")
+	}
+	err := client.storage.db.Transaction(func(tx StorageTx) error {
+		registry, err := client.storage.txLoadProfiles(&transaction{tx})
+		if err != nil {
+			return err
+		}
+		profile, ok := registry.Profiles[id]
+		if !ok {
+			return errors.Errorf("profile %s not found", id)
+		}
+		profile.Name = name
+		return client.storage.txStoreProfiles(&transaction{tx}, registry)
+	})
+	if err != nil {
+		return err
+	}
+	if id == client.profile.ID {
+		client.profile.Name = name
+	}
+	return nil
+}
+
+// DeleteProfile deletes the profile with the specified ID, along with all of its credentials,
+// logs and other data. The active profile cannot be deleted. Keyshare enrollments of the profile
+// are removed only locally; its accounts at the keyshare servers are not deleted.
+func (client *Client) DeleteProfile(id string) error {
+	if id == client.profile.ID {
+		return errors.New("cannot delete the active profile")
+	}
+	ps := client.storage.forProfile(id)
+	return client.storage.db.Transaction(func(tx StorageTx) error {
+		registry, err := client.storage.txLoadProfiles(&transaction{tx})
+		if err != nil {
+			return err
+		}
+		if _, ok := registry.Profiles[id]; !ok {
+			return errors.Errorf("profile %s not found", id)
+		}
+		delete(registry.Profiles, id)
+		if err = ps.TxDeleteAll(ps.transaction(tx)); err != nil {
+			return err
+		}
+		return client.storage.txStoreProfiles(&transaction{tx}, registry)
+	})
+}
+
+// SwitchProfile makes the profile with the specified ID the active profile, loading its secret
+// key, credentials, keyshare enrollments and preferences. It fails if a session is in progress.
+func (client *Client) SwitchProfile(id string) error {
+	switched, err := client.switchProfile(id)
+	if err != nil || !switched {
+		return err
+	}
+	return client.cancelExpiredPendingSessions()
+}
+
+// switchProfile replaces the data of the active profile with that of the specified profile.
+// No sessions can be started and no background jobs run while it does so.
+func (client *Client) switchProfile(id string) (bool, error) {
+	client.sessions.mutex.Lock()
+	defer client.sessions.mutex.Unlock()
+	if len(client.sessions.sessions) > 0 {
+		return false, errors.New("cannot switch profiles while a session is in progress")
+	}
+	if id == client.profile.ID {
+		return false, nil
+	}
+
+	client.jobsRunning.Lock()
+	defer client.jobsRunning.Unlock()
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
+	registry, err := client.storage.LoadProfiles()
+	if err != nil {
+		return false, err
+	}
+	profile, ok := registry.Profiles[id]
+	if !ok {
+		return false, errors.Errorf("profile %s not found", id)
+	}
+
+	// Persist the switch first, so that if it fails, memory and storage still agree
+	previous := client.profile
+	registry.Active = id
+	if err = client.storage.StoreProfiles(registry); err != nil {
+		return false, err
+	}
+	if err = client.loadProfile(profile); err != nil {
+		registry.Active = previous.ID
+		if storeErr := client.storage.StoreProfiles(registry); storeErr != nil {
+			client.reportError(storeErr)
+		}
+		if reloadErr := client.loadProfile(previous); reloadErr != nil {
+			client.reportError(reloadErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// loadProfile loads the data of the specified profile from storage, replacing that of the
+// previously active profile.
+func (client *Client) loadProfile(profile *Profile) error {
+	var err error
+	client.profile = profile
+	client.storage = client.storage.forProfile(profile.ID)
+
+	prefs, err := client.storage.LoadPreferences()
+	if err != nil {
+		return err
+	}
+	client.prefsMutex.Lock()
+	client.Preferences = prefs
+	client.prefsMutex.Unlock()
+	client.applyPreferences()
+
+	// Perform new update functions from clientUpdates, if any
+	if err = client.update(); err != nil {
+		return err
+	}
+
+	// Load our stuff
+	if client.secretkey, err = client.storage.LoadSecretKey(); err != nil {
+		return err
+	}
+	if client.attributes, err = client.storage.LoadAttributes(); err != nil {
+		return err
+	}
+	if client.keyshareServers, err = client.storage.LoadKeyshareServers(); err != nil {
+		return err
+	}
+
+	if len(client.UnenrolledSchemeManagers()) > 1 {
+		return errors.New("Too many keyshare servers")
+	}
+
+	client.credentialsCache = make(map[irma.CredentialTypeIdentifier]map[int]*credential)
+	client.expiryNotified = map[string]bool{}
+	client.lookup = map[string]*credLookup{}
+	for _, attrlistlist := range client.attributes {
+		for i, attrlist := range attrlistlist {
+			client.lookup[attrlist.Hash()] = &credLookup{id: attrlist.CredentialType().Identifier(), counter: i}
+		}
+	}
+	return nil
+}
+
+// forProfile returns a storage operating on the buckets of the profile with the specified ID.
+func (s storage) forProfile(id string) storage {
+	s.prefix = ""
+	if id != DefaultProfileID {
+		s.prefix = "profile/" + id + "/"
+	}
+	return s
+}
+
+// LoadProfiles returns the profile registry. If no profiles have been created, it contains
+// only the default profile.
+func (s *storage) LoadProfiles() (registry *profileRegistry, err error) {
+	err = s.db.View(func(tx StorageTx) error {
+		registry, err = s.txLoadProfiles(&transaction{tx})
+		return err
+	})
+	return
+}
+
+func (s *storage) StoreProfiles(registry *profileRegistry) error {
+	return s.db.Transaction(func(tx StorageTx) error {
+		return s.txStoreProfiles(&transaction{tx}, registry)
+	})
+}
+
+// DeleteAllProfiles deletes the data of all profiles, and the profile registry.
+func (s *storage) DeleteAllProfiles() error {
+	return s.db.Transaction(func(tx StorageTx) error {
+		registry, err := s.txLoadProfiles(&transaction{tx})
+		if err != nil {
+			return err
+		}
+		ids := map[string]bool{DefaultProfileID: true}
+		for id := range registry.Profiles {
+			ids[id] = true
+		}
+		for id := range ids {
+			ps := s.forProfile(id)
+			if err = ps.TxDeleteAll(ps.transaction(tx)); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteBucket([]byte(profilesBucket))
+	})
+}
+
+// The profile registry is not namespaced per profile, so the following functions must be
+// passed a transaction that is not wrapped in a profileTx.
+
+func (s *storage) txLoadProfiles(tx *transaction) (*profileRegistry, error) {
+	registry := &profileRegistry{}
+	found, err := s.txLoad(tx, profilesBucket, profilesKey, registry)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		registry = &profileRegistry{
+			Active:   DefaultProfileID,
+			Profiles: map[string]*Profile{DefaultProfileID: {ID: DefaultProfileID}},
+		}
+	}
+	return registry, nil
+}
+
+func (s *storage) txStoreProfiles(tx *transaction, registry *profileRegistry) error {
+	return s.txStore(tx, profilesBucket, profilesKey, registry)
+}
@@ -0,0 +1,310 @@
+package irmaclient
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// maxConCandidates is the maximum amount of options that Candidates() computes for a single
+// conjunction. As the options are all combinations of the credential instances of the credential
+// types in the conjunction, their amount grows exponentially with the amount of instances; above
+// this limit the least preferable instances are left out.
+var maxConCandidates = 256
+
+// PinCredential makes the credential with the specified hash the default credential of its type:
+// disclosure candidates containing it are ranked before those containing other instances of the
+// credential type (see Candidates()).
+func (client *Client) PinCredential(hash string) error {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
+	attrs, _ := client.attributesByHash(hash)
+	if attrs == nil {
+		return errors.Errorf("credential %s not found", hash)
+	}
+	pins := map[irma.CredentialTypeIdentifier]string{attrs.CredentialType().Identifier(): hash}
+	for credtype, h := range client.Preferences.PinnedCredentials {
+		if _, ok := pins[credtype]; !ok {
+			pins[credtype] = h
+		}
+	}
+	client.prefsMutex.Lock()
+	client.Preferences.PinnedCredentials = pins
+	client.prefsMutex.Unlock()
+	return client.storage.StorePreferences(client.preferences())
+}
+
+// UnpinCredential removes the default credential of the specified credential type, if any.
+func (client *Client) UnpinCredential(credtype irma.CredentialTypeIdentifier) error {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
+	if _, ok := client.Preferences.PinnedCredentials[credtype]; !ok {
+		return nil
+	}
+	pins := map[irma.CredentialTypeIdentifier]string{}
+	for t, h := range client.Preferences.PinnedCredentials {
+		if t != credtype {
+			pins[t] = h
+		}
+	}
+	client.prefsMutex.Lock()
+	client.Preferences.PinnedCredentials = pins
+	client.prefsMutex.Unlock()
+	return client.storage.StorePreferences(client.preferences())
+}
+
+// RecommendedChoice returns the choice of attributes that the app should preselect for the user,
+// given the candidates as passed to the Handler: the choice remembered in a consent rule if any
+// (see RememberedChoice()), and otherwise the highest ranked option that can be disclosed for each
+// disjunction. If a disjunction cannot be satisfied, it returns nil.
+func (client *Client) RecommendedChoice(
+	request irma.SessionRequest,
+	requestor *irma.SessionRequestorInfo,
+	candidates [][]DisclosureCandidates,
+) *irma.DisclosureChoice {
+	if choice := client.RememberedChoice(request, requestor, candidates); choice != nil {
+		return choice
+	}
+	choice := &irma.DisclosureChoice{Attributes: make([][]*irma.AttributeIdentifier, 0, len(candidates))}
+	for _, discon := range candidates {
+		found := false
+		for _, option := range discon {
+			if ids, err := option.Choose(); err == nil {
+				choice.Attributes = append(choice.Attributes, ids)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return choice
+}
+
+// credRank contains the properties of a credential instance that determine how preferable it is
+// to disclose attributes from it.
+type credRank struct {
+	usable     bool // not expired or revoked
+	pinned     bool // see PinCredential()
+	issuer     int  // position of the issuer in Preferences.PreferredIssuers, or its length if absent
+	revocation bool // whether it has a nonrevocation witness, so that it can prove nonrevocation
+	signedOn   time.Time
+}
+
+// optionRank contains the properties of a disclosure candidate option that determine its rank.
+type optionRank struct {
+	usable     bool // whether the option can be chosen, see DisclosureCandidates.Choose()
+	empty      bool // whether the option discloses nothing, for optional disjunctions
+	pinned     int  // amount of pinned credentials
+	issuer     int  // sum of the issuer positions of the credentials
+	revocation int  // amount of credentials without nonrevocation witness
+	disclosed  int  // amount of disclosed attributes, counting the metadata attribute of each credential
+	signedOn   time.Time
+}
+
+// candidateRanker ranks disclosure candidates, caching the properties of the credentials involved.
+// It must be used while holding client.credMutex.
+type candidateRanker struct {
+	client  *Client
+	prefs   Preferences
+	issuers map[irma.IssuerIdentifier]int
+	creds   map[string]credRank
+}
+
+func (client *Client) newCandidateRanker() *candidateRanker {
+	r := &candidateRanker{
+		client:  client,
+		prefs:   client.preferences(),
+		issuers: map[irma.IssuerIdentifier]int{},
+		creds:   map[string]credRank{},
+	}
+	for i, issuer := range r.prefs.PreferredIssuers {
+		if _, ok := r.issuers[issuer]; !ok {
+			r.issuers[issuer] = i
+		}
+	}
+	return r
+}
+
+func (r *candidateRanker) cred(hash string) credRank {
+	if rank, ok := r.creds[hash]; ok {
+		return rank
+	}
+	attrs, _ := r.client.attributesByHash(hash)
+	if attrs == nil {
+		return credRank{}
+	}
+	credtype := attrs.CredentialType().Identifier()
+	issuer, ok := r.issuers[credtype.IssuerIdentifier()]
+	if !ok {
+		issuer = len(r.prefs.PreferredIssuers)
+	}
+	rank := credRank{
+		usable:   !attrs.Revoked && attrs.IsValid(),
+		pinned:   r.prefs.PinnedCredentials[credtype] == hash,
+		issuer:   issuer,
+		signedOn: attrs.SigningDate(),
+	}
+	if cred, _, err := r.client.credentialByHash(hash); err == nil && cred != nil {
+		rank.revocation = cred.NonRevocationWitness != nil
+	}
+	r.creds[hash] = rank
+	return rank
+}
+
+// better returns whether credential instance a is preferable to b, which are of the same type.
+func (a credRank) better(b credRank) bool {
+	if a.usable != b.usable {
+		return a.usable
+	}
+	if a.pinned != b.pinned {
+		return a.pinned
+	}
+	if a.revocation != b.revocation {
+		return a.revocation
+	}
+	return a.signedOn.After(b.signedOn)
+}
+
+func (r *candidateRanker) option(option DisclosureCandidates) optionRank {
+	_, err := option.Choose()
+	rank := optionRank{usable: err == nil, empty: len(option) == 0}
+	creds := map[string]struct{}{}
+	for _, attr := range option {
+		rank.disclosed++
+		if !attr.Present() {
+			continue
+		}
+		if _, ok := creds[attr.CredentialHash]; ok {
+			continue
+		}
+		creds[attr.CredentialHash] = struct{}{}
+		rank.disclosed++ // the metadata attribute
+		cred := r.cred(attr.CredentialHash)
+		if cred.pinned {
+			rank.pinned++
+		}
+		rank.issuer += cred.issuer
+		if !cred.revocation {
+			rank.revocation++
+		}
+		if rank.signedOn.IsZero() || cred.signedOn.Before(rank.signedOn) {
+			rank.signedOn = cred.signedOn
+		}
+	}
+	return rank
+}
+
+// better returns whether option a is preferable to b. Options that cannot be chosen are never
+// preferable, so that they keep the order in which they were computed. Of the options that can be
+// chosen, the option disclosing nothing (for optional disjunctions) comes first, so that optional
+// attributes are not disclosed unless the user chooses to; the others are ordered by
+//  - the amount of pinned credentials (see PinCredential()),
+//  - the position of their issuers in Preferences.PreferredIssuers,
+//  - the amount of credentials without nonrevocation witness,
+//  - the amount of attributes disclosed,
+//  - the issuance date of their oldest credential, newest first.
+func (a optionRank) better(b optionRank) bool {
+	if !a.usable || !b.usable {
+		return a.usable && !b.usable
+	}
+	if a.empty != b.empty {
+		return a.empty
+	}
+	if a.pinned != b.pinned {
+		return a.pinned > b.pinned
+	}
+	if a.issuer != b.issuer {
+		return a.issuer < b.issuer
+	}
+	if a.revocation != b.revocation {
+		return a.revocation < b.revocation
+	}
+	if a.disclosed != b.disclosed {
+		return a.disclosed < b.disclosed
+	}
+	return a.signedOn.After(b.signedOn)
+}
+
+// rankOptions sorts the options of a disjunction from most to least preferable.
+func (r *candidateRanker) rankOptions(options []DisclosureCandidates) {
+	type rankedOption struct {
+		option DisclosureCandidates
+		rank   optionRank
+	}
+	ranked := make([]rankedOption, len(options))
+	for i, option := range options {
+		ranked[i] = rankedOption{option: option, rank: r.option(option)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].rank.better(ranked[j].rank)
+	})
+	for i := range ranked {
+		options[i] = ranked[i].option
+	}
+}
+
+// rankCreds sorts the credential instances of each credential type from most to least
+// preferable. Credentials that are not present, i.e. suggestions, remain last.
+func (r *candidateRanker) rankCreds(candidates [][]*credCandidate) {
+	for _, c := range candidates {
+		sort.SliceStable(c, func(i, j int) bool {
+			if !c[i].Present() || !c[j].Present() {
+				return c[i].Present() && !c[j].Present()
+			}
+			return r.cred(c[i].Hash).better(r.cred(c[j].Hash))
+		})
+	}
+}
+
+// pruneCreds removes the least preferable credential instances from the lists, which must have
+// been sorted using rankCreds(), until their cartesian product contains at most maxConCandidates
+// options. Of each credential type at least one instance is kept.
+func pruneCreds(candidates [][]*credCandidate) [][]*credCandidate {
+	pruned := false
+	for cartesianProductSize(candidates) > maxConCandidates {
+		longest, present := -1, 1
+		for i, c := range candidates {
+			if n := presentCount(c); n > present {
+				longest, present = i, n
+			}
+		}
+		if longest < 0 {
+			break
+		}
+		c := candidates[longest]
+		candidates[longest] = append(c[:present-1:present-1], c[present:]...)
+		pruned = true
+	}
+	if pruned {
+		irma.Logger.Warnf("too many disclosure candidates, leaving out least preferable credentials")
+	}
+	return candidates
+}
+
+// cartesianProductSize returns the amount of elements of cartesianProduct(candidates), or
+// maxConCandidates+1 if it is larger than maxConCandidates.
+func cartesianProductSize(candidates [][]*credCandidate) int {
+	size := 1
+	for _, c := range candidates {
+		if size *= len(c); size > maxConCandidates {
+			return maxConCandidates + 1
+		}
+	}
+	return size
+}
+
+func presentCount(candidates []*credCandidate) int {
+	n := 0
+	for _, c := range candidates {
+		if c.Present() {
+			n++
+		}
+	}
+	return n
+}
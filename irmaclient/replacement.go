@@ -0,0 +1,99 @@
+package irmaclient
+
+import (
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+)
+
+// ReplacementPolicy determines what happens when a credential is issued whose attribute values are
+// equal to those of credentials of the same type that the client already has, e.g. when a
+// credential is renewed. It can be configured per credential type in Preferences.ReplacementPolicies.
+type ReplacementPolicy string
+
+const (
+	// ReplacementReplace removes the older credentials (the default).
+	ReplacementReplace ReplacementPolicy = "replace"
+	// ReplacementKeep keeps the older credentials along with the new one.
+	ReplacementKeep ReplacementPolicy = "keep"
+	// ReplacementAsk asks the user using Handler.RequestReplacementPermission() whether or not to
+	// remove the older credentials, before the new credential is issued.
+	ReplacementAsk ReplacementPolicy = "ask"
+)
+
+// replacementPolicy returns the replacement policy for the specified credential type. Older
+// instances of singleton credential types are always replaced.
+func (client *Client) replacementPolicy(id irma.CredentialTypeIdentifier) ReplacementPolicy {
+	if credtype := client.Configuration.CredentialTypes[id]; credtype != nil && credtype.IsSingleton {
+		return ReplacementReplace
+	}
+	switch policy := client.preferences().ReplacementPolicies[id]; policy {
+	case ReplacementKeep, ReplacementAsk:
+		return policy
+	default:
+		return ReplacementReplace
+	}
+}
+
+// duplicates returns the indices of the credentials of the type of attrs that have the same
+// attribute values as attrs.
+func (client *Client) duplicates(attrs *irma.AttributeList) []int {
+	var indices []int
+	for i, other := range client.attrs(attrs.CredentialType().Identifier()) {
+		if sameAttributeValues(attrs, other) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// replacementCandidates returns the credentials that would be replaced by the credentials of the
+// issuance request whose type has ReplacementAsk as replacement policy.
+func (client *Client) replacementCandidates(request *irma.IssuanceRequest) ([]*irma.CredentialInfo, error) {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
+	var infos []*irma.CredentialInfo
+	for _, credreq := range request.Credentials {
+		if client.replacementPolicy(credreq.CredentialTypeID) != ReplacementAsk {
+			continue
+		}
+		attrs, err := credreq.AttributeList(
+			client.Configuration,
+			irma.GetMetadataVersion(request.Base().ProtocolVersion),
+			nil,
+			time.Now(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range client.duplicates(attrs) {
+			infos = append(infos, client.attrs(credreq.CredentialTypeID)[i].Info())
+		}
+	}
+	return infos, nil
+}
+
+// sameAttributeValues returns whether the AttributeLists, which must be of the same credential
+// type, contain the same attribute values. Unlike AttributeList.EqualsExceptMetadata(), this skips
+// the revocation attribute, as it differs for each issuance. Random blind values are chosen anew
+// for each issuance, and are absent from the AttributeLists of credential requests, so credentials
+// having those are never replaced.
+func sameAttributeValues(al, ol *irma.AttributeList) bool {
+	credtype := al.CredentialType()
+	if credtype == nil || len(al.Ints) != len(ol.Ints) || len(al.Ints) != len(credtype.AttributeTypes)+1 {
+		return al.EqualsExceptMetadata(ol)
+	}
+	for i, attrtype := range credtype.AttributeTypes {
+		if attrtype.RandomBlind {
+			return false
+		}
+		if attrtype.RevocationAttribute {
+			continue
+		}
+		if al.Ints[i+1].Cmp(ol.Ints[i+1]) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -40,45 +40,47 @@ func (client *Client) initRevocation() {
 	// We do this by every 10 seconds updating the credential with a low probability, which
 	// increases over time since the last update.
 	client.Configuration.Scheduler.Every(irma.RevocationParameters.ClientUpdateInterval).Seconds().Do(func() {
-		for id, attrsets := range client.attributes {
-			for i, attrs := range attrsets {
-				if attrs.CredentialType() == nil || !attrs.CredentialType().RevocationSupported() {
-					continue
-				}
-				cred, err := client.credential(id, i)
-				if err != nil {
-					client.reportError(err)
-					continue
-				}
-				if cred.NonRevocationWitness == nil {
-					continue
-				}
-				r, err := randomfloat()
-				if err != nil {
+		client.jobs <- client.updateNonrevWitnesses
+	})
+}
+
+// updateNonrevWitnesses updates the nonrevocation witnesses of the credentials supporting
+// revocation, each with a probability that increases over time since its last update.
+func (client *Client) updateNonrevWitnesses() {
+	for id, attrsets := range client.attributes {
+		for i, attrs := range attrsets {
+			if attrs.CredentialType() == nil || !attrs.CredentialType().RevocationSupported() {
+				continue
+			}
+			cred, err := client.credential(id, i)
+			if err != nil {
+				client.reportError(err)
+				continue
+			}
+			if cred.NonRevocationWitness == nil {
+				continue
+			}
+			r, err := randomfloat()
+			if err != nil {
+				client.reportError(err)
+				break
+			}
+			speed := attrs.CredentialType().RevocationUpdateSpeed * 60 * 60
+			p := probability(cred.NonRevocationWitness.Updated, speed)
+			if r < p {
+				irma.Logger.WithFields(logrus.Fields{
+					"random":      r,
+					"prob":        p,
+					"lastupdated": time.Now().Sub(cred.NonRevocationWitness.Updated).Seconds(),
+					"credtype":    id,
+					"hash":        attrs.Hash(),
+				}).Debug("updating nonrevocation witness from server")
+				if err = client.NonrevUpdateFromServer(id); err != nil {
 					client.reportError(err)
-					break
-				}
-				speed := attrs.CredentialType().RevocationUpdateSpeed * 60 * 60
-				p := probability(cred.NonRevocationWitness.Updated, speed)
-				if r < p {
-					irma.Logger.WithFields(logrus.Fields{
-						"random":      r,
-						"prob":        p,
-						"lastupdated": time.Now().Sub(cred.NonRevocationWitness.Updated).Seconds(),
-						"credtype":    id,
-						"hash":        attrs.Hash(),
-					}).Debug("scheduling nonrevocation witness remote update")
-					id := id // copy for closure below (https://golang.org/doc/faq#closures_and_goroutines)
-					client.jobs <- func() {
-						if err = client.NonrevUpdateFromServer(id); err != nil {
-							client.reportError(err)
-							return
-						}
-					}
 				}
 			}
 		}
-	})
+	}
 }
 
 // NonrevPrepare updates the revocation state for each credential in the request
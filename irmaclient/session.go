@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwesterb/go-atum"
@@ -43,20 +44,25 @@ type Handler interface {
 	RequestIssuancePermission(request *irma.IssuanceRequest,
 		satisfiable bool,
 		candidates [][]DisclosureCandidates,
-		requestorInfo *irma.RequestorInfo,
+		requestorInfo *irma.SessionRequestorInfo,
 		callback PermissionHandler)
 	RequestVerificationPermission(request *irma.DisclosureRequest,
 		satisfiable bool,
 		candidates [][]DisclosureCandidates,
-		requestorInfo *irma.RequestorInfo,
+		requestorInfo *irma.SessionRequestorInfo,
 		callback PermissionHandler)
 	RequestSignaturePermission(request *irma.SignatureRequest,
 		satisfiable bool,
 		candidates [][]DisclosureCandidates,
-		requestorInfo *irma.RequestorInfo,
+		requestorInfo *irma.SessionRequestorInfo,
 		callback PermissionHandler)
 	RequestSchemeManagerPermission(manager *irma.SchemeManager,
 		callback func(proceed bool))
+	// RequestReplacementPermission asks the user whether the specified credentials, which have the
+	// same attribute values as credentials that are about to be issued, should be removed after
+	// issuance. It is called only for credential types whose replacement policy is ReplacementAsk.
+	RequestReplacementPermission(credentials []*irma.CredentialInfo,
+		callback func(replace bool))
 
 	RequestPin(remainingAttempts int, callback PinHandler)
 }
@@ -70,7 +76,7 @@ type session struct {
 	Action        irma.Action
 	Handler       Handler
 	Version       *irma.ProtocolVersion
-	RequestorInfo *irma.RequestorInfo
+	RequestorInfo *irma.SessionRequestorInfo
 
 	token          string
 	choice         *irma.DisclosureChoice
@@ -78,11 +84,17 @@ type session struct {
 	client         *Client
 	request        irma.SessionRequest
 	done           <-chan struct{}
-	prepRevocation chan error // used when nonrevocation preprocessing is done
+	finished       chan struct{}          // closed when interactive sessions finish, see post()
+	prepRevocation chan error             // used when nonrevocation preprocessing is done
+	consentRule    *ConsentRule           // set if the session was approved automatically by this rule
+	pending        *PendingSession        // persisted state of interactive sessions
+	resumeChoice   *irma.DisclosureChoice // choice of the user before the session was interrupted
 
 	// State for issuance sessions
 	issuerProofNonce *big.Int
 	builders         gabi.ProofBuilderList
+	replace          *bool                                                       // answer to RequestReplacementPermission(), if asked
+	replaced         map[irma.CredentialTypeIdentifier][][]irma.TranslatedString // see LogEntry.Replaced
 
 	// State for signature sessions
 	timestamp *atum.Timestamp
@@ -96,6 +108,7 @@ type session struct {
 type sessions struct {
 	client   *Client
 	sessions map[string]*session
+	mutex    sync.Mutex
 }
 
 // We implement the handler for the keyshare protocol
@@ -162,6 +175,7 @@ func (client *Client) newManualSession(request irma.SessionRequest, handler Hand
 		Handler:        handler,
 		client:         client,
 		Version:        minVersion,
+		RequestorInfo:  manualRequestorInfo(request),
 		request:        request,
 		done:           doneChannel,
 		prepRevocation: make(chan error),
@@ -189,6 +203,27 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler) SessionDismisse
 		return client.newQrSession(newqr, handler)
 	}
 
+	session := client.newInteractiveSession(qr, handler)
+	if session == nil {
+		return nil
+	}
+	now := irma.Timestamp(time.Now())
+	session.pending = &PendingSession{
+		ID:         session.token,
+		Qr:         qr,
+		Status:     irma.StatusCommunicating,
+		Started:    now,
+		LastActive: now,
+	}
+	session.persist()
+
+	go session.getSessionInfo()
+	return session
+}
+
+// newInteractiveSession creates a session with the server specified in the QR, which is
+// not of type redirect. The caller must start it by requesting the session info.
+func (client *Client) newInteractiveSession(qr *irma.Qr, handler Handler) *session {
 	client.PauseJobs()
 
 	u, _ := url.ParseRequestURI(qr.URL) // Qr validator already checked this for errors
@@ -204,6 +239,7 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler) SessionDismisse
 		Handler:        handler,
 		client:         client,
 		done:           doneChannel,
+		finished:       make(chan struct{}),
 		prepRevocation: make(chan error),
 	}
 	client.sessions.add(session)
@@ -232,8 +268,6 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler) SessionDismisse
 	if !strings.HasSuffix(session.ServerURL, "/") {
 		session.ServerURL += "/"
 	}
-
-	go session.getSessionInfo()
 	return session
 }
 
@@ -252,10 +286,22 @@ func (session *session) getSessionInfo() {
 		return
 	}
 
+	if session.pending != nil {
+		if session.pending.Request, err = json.Marshal(session.request); err != nil {
+			session.fail(&irma.SessionError{ErrorType: irma.ErrorSerialization, Err: err})
+			return
+		}
+		session.pending.Status = irma.StatusConnected
+		session.pending.LastActive = irma.Timestamp(time.Now())
+		session.persist()
+	}
+
 	session.processSessionInfo()
 }
 
-func requestorInfo(serverURL string, conf *irma.Configuration) *irma.RequestorInfo {
+// requestorInfo returns the requestor of a session at the specified URL as found in the requestor
+// schemes, or a RequestorInfo containing just the hostname if it cannot be verified.
+func requestorInfo(serverURL string, conf *irma.Configuration) *irma.SessionRequestorInfo {
 	if serverURL == "" {
 		return nil
 	}
@@ -263,12 +309,29 @@ func requestorInfo(serverURL string, conf *irma.Configuration) *irma.RequestorIn
 	hostname := u.Hostname()
 	info, present := conf.Requestors[hostname]
 
-	if (u.Scheme == "https" || !common.ForceHTTPS) && present &&
-		(info.ValidUntil == nil || info.ValidUntil.After(irma.Timestamp(time.Now()))) {
-		return info
-	} else {
-		return irma.NewRequestorInfo(hostname)
+	if u.Scheme != "https" && common.ForceHTTPS || !present {
+		return &irma.SessionRequestorInfo{
+			RequestorInfo: irma.NewRequestorInfo(hostname),
+			Verification:  irma.RequestorUnverified,
+		}
 	}
+	if info.ValidUntil != nil && !info.ValidUntil.After(irma.Timestamp(time.Now())) {
+		return &irma.SessionRequestorInfo{
+			RequestorInfo: irma.NewRequestorInfo(hostname),
+			Verification:  irma.RequestorExpired,
+		}
+	}
+	return &irma.SessionRequestorInfo{RequestorInfo: info, Verification: irma.RequestorVerified}
+}
+
+// manualRequestorInfo returns the requestor of a manual session. As there is no server to
+// which we connect, it cannot be verified; we use the hostname of the ClientReturnURL if present.
+func manualRequestorInfo(request irma.SessionRequest) *irma.SessionRequestorInfo {
+	info := &irma.SessionRequestorInfo{RequestorInfo: &irma.RequestorInfo{}, Verification: irma.RequestorUnverified}
+	if u, err := url.ParseRequestURI(request.Base().ClientReturnURL); err == nil && u.Hostname() != "" {
+		info.RequestorInfo = irma.NewRequestorInfo(u.Hostname())
+	}
+	return info
 }
 
 func checkKey(conf *irma.Configuration, issuer irma.IssuerIdentifier, counter uint) error {
@@ -312,6 +375,15 @@ func (session *session) processSessionInfo() {
 		baserequest.ProtocolVersion = session.Version
 	}
 
+	// Check that the requestor asks only for attributes that its requestor scheme allows
+	if session.RequestorInfo != nil && session.RequestorInfo.Verification == irma.RequestorVerified {
+		session.RequestorInfo.UndeclaredAttributes = session.RequestorInfo.Undeclared(session.request.Disclosure().Disclose)
+		if len(session.RequestorInfo.UndeclaredAttributes) > 0 {
+			irma.Logger.WithField("attributes", session.RequestorInfo.UndeclaredAttributes).
+				Warn("requestor asks for attributes not allowed by its requestor scheme")
+		}
+	}
+
 	if session.Action == irma.ActionIssuing {
 		ir := session.request.(*irma.IssuanceRequest)
 		issuedAt := time.Now()
@@ -382,6 +454,26 @@ func (session *session) requestPermission() {
 
 	session.Handler.StatusUpdate(session.Action, irma.StatusConnected)
 
+	// Continue with the choice of the user if the session was interrupted after it was made
+	if choice := session.resumeChoice; choice != nil {
+		session.resumeChoice = nil
+		if satisfiable && choiceSatisfiedBy(choice, candidates) {
+			irma.Logger.Info("resuming session with previous choice")
+			session.doSession(true, choice)
+			return
+		}
+	}
+
+	// Skip asking for permission if the user has allowed it in advance
+	if satisfiable {
+		if rule := session.client.consentRule(session.request, session.RequestorInfo, candidates); rule != nil && rule.AutoApprove {
+			irma.Logger.WithField("rule", rule.ID).Info("session approved automatically by consent rule")
+			session.consentRule = rule
+			session.doSession(true, rule.Choice)
+			return
+		}
+	}
+
 	// Ask for permission to execute the session
 	switch session.Action {
 	case irma.ActionDisclosing:
@@ -413,6 +505,25 @@ func (session *session) doSession(proceed bool, choice *irma.DisclosureChoice) {
 		session.fail(&irma.SessionError{ErrorType: irma.ErrorRequiredAttributeMissing, Err: err})
 		return
 	}
+	if session.Action == irma.ActionIssuing && session.replace == nil {
+		credentials, err := session.client.replacementCandidates(session.request.(*irma.IssuanceRequest))
+		if err != nil {
+			session.fail(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: err})
+			return
+		}
+		if len(credentials) > 0 {
+			session.Handler.RequestReplacementPermission(credentials, func(replace bool) {
+				session.replace = &replace
+				session.doSession(true, choice)
+			})
+			return
+		}
+	}
+	if session.pending != nil {
+		session.pending.Choice = choice
+		session.pending.Status = irma.StatusCommunicating
+		session.persist()
+	}
 	session.Handler.StatusUpdate(session.Action, irma.StatusCommunicating)
 
 	// wait for revocation preparation to finish
@@ -475,7 +586,7 @@ func (session *session) sendResponse(message interface{}) {
 
 		if session.IsInteractive() {
 			var response disclosureResponse
-			if err = session.transport.Post("proofs", &response, irmaSignature); err != nil {
+			if err = session.post("proofs", &response, irmaSignature); err != nil {
 				session.fail(err.(*irma.SessionError))
 				return
 			}
@@ -497,7 +608,7 @@ func (session *session) sendResponse(message interface{}) {
 		}
 		if session.IsInteractive() {
 			var response disclosureResponse
-			if err = session.transport.Post("proofs", &response, message); err != nil {
+			if err = session.post("proofs", &response, message); err != nil {
 				session.fail(err.(*irma.SessionError))
 				return
 			}
@@ -513,11 +624,13 @@ func (session *session) sendResponse(message interface{}) {
 		}
 	case irma.ActionIssuing:
 		response := []*gabi.IssueSignatureMessage{}
-		if err = session.transport.Post("commitments", &response, message); err != nil {
+		if err = session.post("commitments", &response, message); err != nil {
 			session.fail(err.(*irma.SessionError))
 			return
 		}
-		if err = session.client.ConstructCredentials(response, session.request.(*irma.IssuanceRequest), session.builders); err != nil {
+		replaceAsked := session.replace != nil && *session.replace
+		session.replaced, err = session.client.constructCredentials(response, session.request.(*irma.IssuanceRequest), session.builders, replaceAsked)
+		if err != nil {
 			session.fail(&irma.SessionError{ErrorType: irma.ErrorCrypto, Err: err})
 			return
 		}
@@ -687,10 +800,20 @@ func (session *session) finish(delete bool) bool {
 	// will then read that message, whilst all further calls will see the closed channel and know
 	// that no further work is needed.
 	if _, ok := <-session.done; ok {
+		if session.finished != nil {
+			close(session.finished)
+		}
+		if session.pending != nil {
+			if err := session.client.storage.DeletePendingSession(session.pending.ID); err != nil {
+				irma.Logger.Warn(errors.WrapPrefix(err, "failed to delete pending session", 0).ErrorStack())
+			}
+		}
 		session.client.sessions.remove(session.token)
 		// Do actual delete in background, since that can take a while in some circumstances, and
 		// precise moment of completion isn't relevant for frontend.
+		session.client.finishing.Add(1)
 		go func() {
+			defer session.client.finishing.Done()
 			if delete && session.IsInteractive() {
 				session.transport.Delete()
 			}
@@ -774,22 +897,30 @@ func (session *session) KeysharePinOK() {
 	session.Handler.StatusUpdate(session.Action, irma.StatusCommunicating)
 }
 
-func (s sessions) remove(token string) {
+func (s *sessions) remove(token string) {
+	s.mutex.Lock()
 	last := s.sessions[token]
 	delete(s.sessions, token)
+	remaining := make([]*session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		remaining = append(remaining, session)
+	}
+	s.mutex.Unlock()
 
 	if last.Action == irma.ActionIssuing {
-		for _, session := range s.sessions {
+		for _, session := range remaining {
 			session.requestPermission()
 		}
 	}
 
-	if len(s.sessions) == 0 {
+	if len(remaining) == 0 {
 		s.client.StartJobs()
 	}
 }
 
-func (s sessions) add(session *session) {
+func (s *sessions) add(session *session) {
 	session.token = common.NewSessionToken()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.sessions[session.token] = session
 }
@@ -3,36 +3,76 @@ package irmaclient
 import (
 	"encoding/binary"
 	"encoding/json"
-	"path/filepath"
-	"time"
 
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/revocation"
 	irma "github.com/privacybydesign/irmago"
-	"github.com/privacybydesign/irmago/internal/common"
 
 	"github.com/go-errors/errors"
-	"go.etcd.io/bbolt"
 )
 
-// This file contains the storage struct and its methods,
-// and some general filesystem functions.
+// This file contains the storage struct and its methods, which (de)serialize the data of a
+// Client to and from a Storage backend.
+
+// Storage is a transactional key/value store, in which a Client stores its secret key,
+// credentials, logs and other data, organized in buckets. By default the Client uses a bbolt
+// database (see NewBoltStorage), but host applications can provide their own implementation
+// to irmaclient.New, e.g. to keep the data of the Client in their own database.
+type Storage interface {
+	// Transaction executes f within a read-write transaction. If f returns an error,
+	// none of the changes made in the transaction are persisted.
+	Transaction(f func(tx StorageTx) error) error
+	// View executes f within a read-only transaction.
+	View(f func(tx StorageTx) error) error
+	Close() error
+}
+
+// StorageTx is a transaction on a Storage. Byte slices returned by its methods are only valid
+// during the transaction.
+type StorageTx interface {
+	// Get returns the value of the key in the bucket, or nil if it does not exist.
+	Get(bucket, key []byte) ([]byte, error)
+	// Put stores the value under the key in the bucket, creating the bucket if necessary.
+	Put(bucket, key, value []byte) error
+	// Delete deletes the key from the bucket, if it exists.
+	Delete(bucket, key []byte) error
+	// DeleteBucket deletes the bucket and all its contents, if it exists.
+	DeleteBucket(bucket []byte) error
+	BucketExists(bucket []byte) bool
+	// NextSequence returns the next value of an auto-incrementing integer of the bucket,
+	// starting at 1, creating the bucket if necessary.
+	NextSequence(bucket []byte) (uint64, error)
+	// ForEach calls f for each key/value pair in the bucket in ascending order of the keys,
+	// stopping if f returns an error.
+	ForEach(bucket []byte, f func(key, value []byte) error) error
+	// ForEachBefore calls f for each key/value pair in the bucket whose key is smaller than
+	// before, or all key/value pairs if before is nil, in descending order of the keys,
+	// until f returns false or an error.
+	ForEachBefore(bucket, before []byte, f func(key, value []byte) (bool, error)) error
+}
 
 // Storage provider for a Client
 type storage struct {
-	storagePath   string
-	db            *bbolt.DB
+	db            Storage
 	Configuration *irma.Configuration
+	prefix        string // prepended to bucket names to namespace the data of the active profile
 }
 
 type transaction struct {
-	*bbolt.Tx
+	StorageTx
+}
+
+// profileTx is a StorageTx that prepends a prefix to all bucket names, so that the data of
+// different profiles is kept in different buckets.
+type profileTx struct {
+	tx     StorageTx
+	prefix string
 }
 
 // Filenames
 const databaseFile = "db"
 
-// Bucketnames bbolt
+// Bucketnames
 const (
 	userdataBucket = "userdata"    // Key/value: specified below
 	skKey          = "sk"          // Value: *secretKey
@@ -40,90 +80,116 @@ const (
 	updatesKey     = "updates"     // Value: []update
 	kssKey         = "kss"         // Value: map[irma.SchemeManagerIdentifier]*keyshareServer
 
-	attributesBucket = "attrs" // Key: irma.CredentialIdentifier, value: []*irma.AttributeList
-	logsBucket       = "logs"  // Key: (auto-increment index), value: *LogEntry
-	signaturesBucket = "sigs"  // Key: credential.attrs.Hash, value: *gabi.CLSignature
-)
-
-func (s *storage) path(p string) string {
-	return filepath.Join(s.storagePath, p)
-}
+	attributesBucket = "attrs"    // Key: irma.CredentialIdentifier, value: []*irma.AttributeList
+	logsBucket       = "logs"     // Key: (auto-increment index), value: *LogEntry
+	logIndexBucket   = "logidx"   // Key: index kind, indexed value and log entry index (see logIndexPrefix), value: empty
+	signaturesBucket = "sigs"     // Key: credential.attrs.Hash, value: *gabi.CLSignature
+	consentBucket    = "consent"  // Key: ConsentRule.ID, value: *ConsentRule
+	sessionsBucket   = "sessions" // Key: PendingSession.ID, value: *PendingSession
 
-// Open initializes the credential storage,
-// ensuring that it is in a usable state.
-// Setting it up in a properly protected location (e.g., with automatic
-// backups to iCloud/Google disabled) is the responsibility of the user.
-func (s *storage) Open() error {
-	var err error
-	if err = common.AssertPathExists(s.storagePath); err != nil {
-		return err
-	}
-	s.db, err = bbolt.Open(s.path(databaseFile), 0600, &bbolt.Options{Timeout: 1 * time.Second})
-	return err
-}
+	// Not namespaced per profile
+	profilesBucket = "profiles" // Key/value: specified below
+	profilesKey    = "profiles" // Value: *profileRegistry
+)
 
 func (s *storage) Close() error {
 	return s.db.Close()
 }
 
 func (s *storage) BucketExists(name []byte) bool {
-	return s.db.View(func(tx *bbolt.Tx) error {
-		if tx.Bucket(name) == nil {
-			return bbolt.ErrBucketNotFound
-		}
+	var exists bool
+	_ = s.View(func(tx *transaction) error {
+		exists = tx.BucketExists(name)
 		return nil
-	}) == nil
+	})
+	return exists
 }
 
 func (s *storage) txStore(tx *transaction, bucketName string, key string, value interface{}) error {
-	b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-	if err != nil {
-		return err
-	}
 	btsValue, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	return b.Put([]byte(key), btsValue)
+	return tx.Put([]byte(bucketName), []byte(key), btsValue)
 }
 
 func (s *storage) txDelete(tx *transaction, bucketName string, key string) error {
-	b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-	if err != nil {
-		return err
-	}
-
-	return b.Delete([]byte(key))
+	return tx.Delete([]byte(bucketName), []byte(key))
 }
 
 func (s *storage) txLoad(tx *transaction, bucketName string, key string, dest interface{}) (found bool, err error) {
-	b := tx.Bucket([]byte(bucketName))
-	if b == nil {
-		return false, nil
-	}
-	bts := b.Get([]byte(key))
-	b.Sequence()
-	if bts == nil {
-		return false, nil
+	bts, err := tx.Get([]byte(bucketName), []byte(key))
+	if err != nil || bts == nil {
+		return false, err
 	}
 	return true, json.Unmarshal(bts, dest)
 }
 
 func (s *storage) load(bucketName string, key string, dest interface{}) (found bool, err error) {
-	err = s.db.View(func(tx *bbolt.Tx) error {
-		found, err = s.txLoad(&transaction{tx}, bucketName, key, dest)
+	err = s.View(func(tx *transaction) error {
+		found, err = s.txLoad(tx, bucketName, key, dest)
 		return err
 	})
 	return
 }
 
 func (s *storage) Transaction(f func(*transaction) error) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		return f(&transaction{tx})
+	return s.db.Transaction(func(tx StorageTx) error {
+		return f(s.transaction(tx))
+	})
+}
+
+func (s *storage) View(f func(*transaction) error) error {
+	return s.db.View(func(tx StorageTx) error {
+		return f(s.transaction(tx))
 	})
 }
 
+// transaction wraps tx such that it operates on the buckets of the profile of s.
+func (s *storage) transaction(tx StorageTx) *transaction {
+	if s.prefix == "" {
+		return &transaction{tx}
+	}
+	return &transaction{profileTx{tx: tx, prefix: s.prefix}}
+}
+
+func (tx profileTx) bucket(name []byte) []byte {
+	return append([]byte(tx.prefix), name...)
+}
+
+func (tx profileTx) Get(bucket, key []byte) ([]byte, error) {
+	return tx.tx.Get(tx.bucket(bucket), key)
+}
+
+func (tx profileTx) Put(bucket, key, value []byte) error {
+	return tx.tx.Put(tx.bucket(bucket), key, value)
+}
+
+func (tx profileTx) Delete(bucket, key []byte) error {
+	return tx.tx.Delete(tx.bucket(bucket), key)
+}
+
+func (tx profileTx) DeleteBucket(bucket []byte) error {
+	return tx.tx.DeleteBucket(tx.bucket(bucket))
+}
+
+func (tx profileTx) BucketExists(bucket []byte) bool {
+	return tx.tx.BucketExists(tx.bucket(bucket))
+}
+
+func (tx profileTx) NextSequence(bucket []byte) (uint64, error) {
+	return tx.tx.NextSequence(tx.bucket(bucket))
+}
+
+func (tx profileTx) ForEach(bucket []byte, f func(key, value []byte) error) error {
+	return tx.tx.ForEach(tx.bucket(bucket), f)
+}
+
+func (tx profileTx) ForEachBefore(bucket, before []byte, f func(key, value []byte) (bool, error)) error {
+	return tx.tx.ForEachBefore(tx.bucket(bucket), before, f)
+}
+
 func (s *storage) TxDeleteSignature(tx *transaction, attrs *irma.AttributeList) error {
 	return s.txDelete(tx, signaturesBucket, attrs.Hash())
 }
@@ -200,25 +266,27 @@ func (s *storage) TxStoreKeyshareServers(tx *transaction, keyshareServers map[ir
 }
 
 func (s *storage) AddLogEntry(entry *LogEntry) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		return s.TxAddLogEntry(&transaction{tx}, entry)
+	return s.Transaction(func(tx *transaction) error {
+		return s.TxAddLogEntry(tx, entry)
 	})
 }
 
 func (s *storage) TxAddLogEntry(tx *transaction, entry *LogEntry) error {
-	b, err := tx.CreateBucketIfNotExists([]byte(logsBucket))
+	var err error
+	entry.ID, err = tx.NextSequence([]byte(logsBucket))
 	if err != nil {
 		return err
 	}
-
-	entry.ID, err = b.NextSequence()
+	k := s.logEntryKeyToBytes(entry.ID)
+	v, err := json.Marshal(entry)
 	if err != nil {
 		return err
 	}
-	k := s.logEntryKeyToBytes(entry.ID)
-	v, err := json.Marshal(entry)
 
-	return b.Put(k, v)
+	if err = tx.Put([]byte(logsBucket), k, v); err != nil {
+		return err
+	}
+	return s.TxIndexLogEntry(tx, entry)
 }
 
 func (s *storage) logEntryKeyToBytes(id uint64) []byte {
@@ -293,12 +361,8 @@ func (s *storage) LoadSecretKey() (*secretKey, error) {
 
 func (s *storage) LoadAttributes() (list map[irma.CredentialTypeIdentifier][]*irma.AttributeList, err error) {
 	list = make(map[irma.CredentialTypeIdentifier][]*irma.AttributeList)
-	return list, s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(attributesBucket))
-		if b == nil {
-			return nil
-		}
-		return b.ForEach(func(key, value []byte) error {
+	return list, s.View(func(tx *transaction) error {
+		return tx.ForEach([]byte(attributesBucket), func(key, value []byte) error {
 			credTypeID := irma.NewCredentialTypeIdentifier(string(key))
 
 			var attrlistlist []*irma.AttributeList
@@ -327,40 +391,70 @@ func (s *storage) LoadKeyshareServers() (ksses map[irma.SchemeManagerIdentifier]
 // Returns all logs stored before log with ID 'index' sorted from new to old with
 // a maximum result length of 'max'.
 func (s *storage) LoadLogsBefore(index uint64, max int) ([]*LogEntry, error) {
-	return s.loadLogs(max, func(c *bbolt.Cursor) (key, value []byte) {
-		c.Seek(s.logEntryKeyToBytes(index))
-		return c.Prev()
-	})
+	return s.loadLogs(max, s.logEntryKeyToBytes(index))
 }
 
 // Returns the latest logs stored sorted from new to old with a maximum result length of 'max'
 func (s *storage) LoadNewestLogs(max int) ([]*LogEntry, error) {
-	return s.loadLogs(max, func(c *bbolt.Cursor) (key, value []byte) {
-		return c.Last()
-	})
+	return s.loadLogs(max, nil)
 }
 
-// Returns the logs stored sorted from new to old with a maximum result length of 'max' where the starting position
-// of the bbolt cursor can be manipulated by the anonymous function 'startAt'. 'startAt' should return
-// the key and the value of the first element from the bbolt database that should be loaded.
-func (s *storage) loadLogs(max int, startAt func(*bbolt.Cursor) (key, value []byte)) ([]*LogEntry, error) {
+// Returns the logs stored before the log with key 'before' (or the newest logs if 'before' is nil)
+// sorted from new to old with a maximum result length of 'max'.
+func (s *storage) loadLogs(max int, before []byte) ([]*LogEntry, error) {
 	logs := make([]*LogEntry, 0, max)
-	return logs, s.db.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(logsBucket))
-		if bucket == nil {
-			return nil
-		}
-		c := bucket.Cursor()
-
-		for k, v := startAt(c); k != nil && len(logs) < max; k, v = c.Prev() {
+	return logs, s.View(func(tx *transaction) error {
+		return tx.ForEachBefore([]byte(logsBucket), before, func(_, v []byte) (bool, error) {
+			if len(logs) >= max {
+				return false, nil
+			}
 			var log LogEntry
 			if err := json.Unmarshal(v, &log); err != nil {
-				return err
+				return false, err
 			}
-
 			logs = append(logs, &log)
-		}
-		return nil
+			return true, nil
+		})
+	})
+}
+
+func (s *storage) StoreConsentRule(rule *ConsentRule) error {
+	return s.Transaction(func(tx *transaction) error {
+		return s.txStore(tx, consentBucket, rule.ID, rule)
+	})
+}
+
+func (s *storage) DeleteConsentRule(id string) error {
+	return s.Transaction(func(tx *transaction) error {
+		return s.txDelete(tx, consentBucket, id)
+	})
+}
+
+func (s *storage) TxDeleteConsentRules(tx *transaction) error {
+	return tx.DeleteBucket([]byte(consentBucket))
+}
+
+// LoadConsentRule returns the consent rule with the specified ID, or nil if it does not exist.
+func (s *storage) LoadConsentRule(id string) (*ConsentRule, error) {
+	var rule ConsentRule
+	found, err := s.load(consentBucket, id, &rule)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (s *storage) LoadConsentRules() ([]*ConsentRule, error) {
+	var rules []*ConsentRule
+	return rules, s.View(func(tx *transaction) error {
+		return tx.ForEach([]byte(consentBucket), func(_, v []byte) error {
+			var rule ConsentRule
+			if err := json.Unmarshal(v, &rule); err != nil {
+				return err
+			}
+			rules = append(rules, &rule)
+			return nil
+		})
 	})
 }
 
@@ -381,23 +475,29 @@ func (s *storage) TxDeleteUserdata(tx *transaction) error {
 }
 
 func (s *storage) TxDeleteLogs(tx *transaction) error {
+	if err := tx.DeleteBucket([]byte(logIndexBucket)); err != nil {
+		return err
+	}
 	return tx.DeleteBucket([]byte(logsBucket))
 }
 
 func (s *storage) TxDeleteAll(tx *transaction) error {
-	if err := s.TxDeleteAllAttributes(tx); err != nil && err != bbolt.ErrBucketNotFound {
+	if err := s.TxDeleteAllAttributes(tx); err != nil {
+		return err
+	}
+	if err := s.TxDeleteConsentRules(tx); err != nil {
 		return err
 	}
-	if err := s.TxDeleteAllSignatures(tx); err != nil && err != bbolt.ErrBucketNotFound {
+	if err := tx.DeleteBucket([]byte(sessionsBucket)); err != nil {
 		return err
 	}
-	if err := s.TxDeleteUserdata(tx); err != nil && err != bbolt.ErrBucketNotFound {
+	if err := s.TxDeleteAllSignatures(tx); err != nil {
 		return err
 	}
-	if err := s.TxDeleteLogs(tx); err != nil && err != bbolt.ErrBucketNotFound {
+	if err := s.TxDeleteUserdata(tx); err != nil {
 		return err
 	}
-	return nil
+	return s.TxDeleteLogs(tx)
 }
 
 func (s *storage) DeleteAll() error {
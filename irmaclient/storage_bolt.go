@@ -0,0 +1,124 @@
+package irmaclient
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/privacybydesign/irmago/internal/common"
+	"go.etcd.io/bbolt"
+)
+
+// boltStorage is a Storage backed by a bbolt database file.
+type boltStorage struct {
+	db *bbolt.DB
+}
+
+type boltTx struct {
+	tx *bbolt.Tx
+}
+
+// NewBoltStorage opens (creating it if necessary) the bbolt database in the file db within the
+// specified directory, for use as Storage of a Client.
+// Setting it up in a properly protected location (e.g., with automatic
+// backups to iCloud/Google disabled) is the responsibility of the user.
+func NewBoltStorage(storagePath string) (Storage, error) {
+	if err := common.AssertPathExists(storagePath); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(filepath.Join(storagePath, databaseFile), 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStorage{db: db}, nil
+}
+
+func (s *boltStorage) Transaction(f func(tx StorageTx) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return f(boltTx{tx})
+	})
+}
+
+func (s *boltStorage) View(f func(tx StorageTx) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return f(boltTx{tx})
+	})
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (b boltTx) Get(bucket, key []byte) ([]byte, error) {
+	bkt := b.tx.Bucket(bucket)
+	if bkt == nil {
+		return nil, nil
+	}
+	return bkt.Get(key), nil
+}
+
+func (b boltTx) Put(bucket, key, value []byte) error {
+	bkt, err := b.tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(key, value)
+}
+
+func (b boltTx) Delete(bucket, key []byte) error {
+	bkt := b.tx.Bucket(bucket)
+	if bkt == nil {
+		return nil
+	}
+	return bkt.Delete(key)
+}
+
+func (b boltTx) DeleteBucket(bucket []byte) error {
+	if err := b.tx.DeleteBucket(bucket); err != nil && err != bbolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
+
+func (b boltTx) BucketExists(bucket []byte) bool {
+	return b.tx.Bucket(bucket) != nil
+}
+
+func (b boltTx) NextSequence(bucket []byte) (uint64, error) {
+	bkt, err := b.tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return 0, err
+	}
+	return bkt.NextSequence()
+}
+
+func (b boltTx) ForEach(bucket []byte, f func(key, value []byte) error) error {
+	bkt := b.tx.Bucket(bucket)
+	if bkt == nil {
+		return nil
+	}
+	return bkt.ForEach(f)
+}
+
+func (b boltTx) ForEachBefore(bucket, before []byte, f func(key, value []byte) (bool, error)) error {
+	bkt := b.tx.Bucket(bucket)
+	if bkt == nil {
+		return nil
+	}
+	c := bkt.Cursor()
+	var k, v []byte
+	if before == nil {
+		k, v = c.Last()
+	} else if k, _ = c.Seek(before); k == nil {
+		// all keys are smaller than before
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	for ; k != nil; k, v = c.Prev() {
+		cont, err := f(k, v)
+		if err != nil || !cont {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package irmaclient
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/go-errors/errors"
+)
+
+// memoryStorage is a Storage that keeps all data in memory, e.g. for tests. Read-write
+// transactions operate on a copy of the data, which replaces the data only when the
+// transaction succeeds. Buckets are copied only when the transaction first modifies them.
+type memoryStorage struct {
+	sync.RWMutex
+	data   memoryData
+	closed bool
+}
+
+type memoryData map[string]*memoryBucket
+
+type memoryBucket struct {
+	values   map[string][]byte
+	sequence uint64
+}
+
+type memoryTx struct {
+	data     memoryData
+	copied   map[string]bool // buckets of data that this transaction owns and may modify
+	writable bool
+}
+
+// NewMemoryStorage returns an empty Storage that keeps all data in memory.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{data: memoryData{}}
+}
+
+func (s *memoryStorage) Transaction(f func(tx StorageTx) error) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return errors.New("storage closed")
+	}
+	tx := &memoryTx{data: s.data.copy(), copied: map[string]bool{}, writable: true}
+	if err := f(tx); err != nil {
+		return err
+	}
+	s.data = tx.data
+	return nil
+}
+
+func (s *memoryStorage) View(f func(tx StorageTx) error) error {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return errors.New("storage closed")
+	}
+	return f(&memoryTx{data: s.data})
+}
+
+func (s *memoryStorage) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	return nil
+}
+
+// copy returns a copy of d sharing its buckets, which must be copied before modifying them.
+func (d memoryData) copy() memoryData {
+	c := make(memoryData, len(d))
+	for name, b := range d {
+		c[name] = b
+	}
+	return c
+}
+
+func (b *memoryBucket) copy() *memoryBucket {
+	values := make(map[string][]byte, len(b.values))
+	for k, v := range b.values {
+		values[k] = v // values are never modified in place, so we need not copy them
+	}
+	return &memoryBucket{values: values, sequence: b.sequence}
+}
+
+// bucket returns the specified bucket for modification, creating it if it does not exist,
+// and copying it the first time this transaction modifies it.
+func (tx *memoryTx) bucket(name []byte) (*memoryBucket, error) {
+	if !tx.writable {
+		return nil, errors.New("read-only transaction")
+	}
+	b := tx.data[string(name)]
+	switch {
+	case b == nil:
+		b = &memoryBucket{values: map[string][]byte{}}
+	case !tx.copied[string(name)]:
+		b = b.copy()
+	default:
+		return b, nil
+	}
+	tx.data[string(name)] = b
+	tx.copied[string(name)] = true
+	return b, nil
+}
+
+func (tx *memoryTx) Get(bucket, key []byte) ([]byte, error) {
+	b := tx.data[string(bucket)]
+	if b == nil {
+		return nil, nil
+	}
+	return b.values[string(key)], nil
+}
+
+func (tx *memoryTx) Put(bucket, key, value []byte) error {
+	b, err := tx.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	b.values[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (tx *memoryTx) Delete(bucket, key []byte) error {
+	if !tx.writable {
+		return errors.New("read-only transaction")
+	}
+	if tx.data[string(bucket)] == nil || tx.data[string(bucket)].values[string(key)] == nil {
+		return nil
+	}
+	b, err := tx.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	delete(b.values, string(key))
+	return nil
+}
+
+func (tx *memoryTx) DeleteBucket(bucket []byte) error {
+	if !tx.writable {
+		return errors.New("read-only transaction")
+	}
+	delete(tx.data, string(bucket))
+	delete(tx.copied, string(bucket))
+	return nil
+}
+
+func (tx *memoryTx) BucketExists(bucket []byte) bool {
+	return tx.data[string(bucket)] != nil
+}
+
+func (tx *memoryTx) NextSequence(bucket []byte) (uint64, error) {
+	b, err := tx.bucket(bucket)
+	if err != nil {
+		return 0, err
+	}
+	b.sequence++
+	return b.sequence, nil
+}
+
+func (tx *memoryTx) ForEach(bucket []byte, f func(key, value []byte) error) error {
+	b := tx.data[string(bucket)]
+	if b == nil {
+		return nil
+	}
+	for _, k := range b.keys() {
+		if err := f([]byte(k), b.values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *memoryTx) ForEachBefore(bucket, before []byte, f func(key, value []byte) (bool, error)) error {
+	b := tx.data[string(bucket)]
+	if b == nil {
+		return nil
+	}
+	keys := b.keys()
+	for i := len(keys) - 1; i >= 0; i-- {
+		if before != nil && keys[i] >= string(before) {
+			continue
+		}
+		cont, err := f([]byte(keys[i]), b.values[keys[i]])
+		if err != nil || !cont {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memoryBucket) keys() []string {
+	keys := make([]string, 0, len(b.values))
+	for k := range b.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
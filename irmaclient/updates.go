@@ -146,6 +146,13 @@ var clientUpdates = []func(client *Client) error{
 		})
 	},
 
+	// 9: Build the secondary indexes over the log entries used by QueryLogs
+	func(client *Client) error {
+		return client.storage.Transaction(func(tx *transaction) error {
+			return client.storage.TxIndexLogs(tx)
+		})
+	},
+
 	// TODO: Maybe delete preferences file to start afresh
 }
 
@@ -86,6 +86,11 @@ type ConfigurationOptions struct {
 	RevocationDBConnStr string
 	RevocationDBType    string
 	RevocationSettings  RevocationSettings
+	// If set, refuse to open a revocation database having pending schema migrations
+	// instead of applying them
+	RevocationDBNoMigrate bool
+	// If set, periodically publish revocation update bundles to this directory
+	RevocationBundleDir string
 }
 
 // NewConfiguration returns a new configuration. After this
@@ -642,6 +647,31 @@ func (conf *Configuration) validateIssuer(scheme *SchemeManager, issuer *Issuer,
 	return nil
 }
 
+// ValidateIssuer performs the same consistency checks on the issuer as are done when parsing it
+// from its scheme, which must be present in this instance. The issuer itself need not (yet) be
+// present in the scheme. Non-fatal problems are added to conf.Warnings.
+func (conf *Configuration) ValidateIssuer(issuer *Issuer) error {
+	scheme := conf.SchemeManagers[issuer.SchemeManagerIdentifier()]
+	if scheme == nil {
+		return errors.Errorf("Issuer %s has unknown SchemeManager %s", issuer.ID, issuer.SchemeManagerID)
+	}
+	if issuer.XMLVersion < 4 {
+		return errors.New("Unsupported issuer description")
+	}
+	return conf.validateIssuer(scheme, issuer, filepath.Join(scheme.path(), issuer.ID))
+}
+
+// ValidateCredentialType performs the same consistency checks on the credential type as are done
+// when parsing it from its scheme, which must be present in this instance. Neither the issuer nor
+// the credential type need (yet) be present in the scheme. Non-fatal problems are added to conf.Warnings.
+func (conf *Configuration) ValidateCredentialType(issuer *Issuer, cred *CredentialType) error {
+	scheme := conf.SchemeManagers[cred.SchemeManagerIdentifier()]
+	if scheme == nil {
+		return errors.Errorf("Credential type %s has unknown SchemeManager %s", cred.ID, cred.SchemeManagerID)
+	}
+	return conf.validateCredentialType(scheme, issuer, cred, filepath.Join(scheme.path(), issuer.ID, "Issues", cred.ID))
+}
+
 func (conf *Configuration) validateCredentialType(manager *SchemeManager, issuer *Issuer, cred *CredentialType, dir string) error {
 	credid := cred.Identifier()
 	conf.validateTranslations(fmt.Sprintf("Credential type %s", credid.String()), cred)
@@ -718,6 +748,19 @@ func (conf *Configuration) validateAttributes(cred *CredentialType) error {
 // validateTranslations checks for each member of the interface o that is of type TranslatedString
 // that it contains all necessary translations.
 func (conf *Configuration) validateTranslations(file string, o interface{}) {
+	for _, missing := range missingTranslations(o, validLangs) {
+		conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, missing.lang, missing.field))
+	}
+}
+
+type missingTranslation struct {
+	field, lang string
+}
+
+// missingTranslations returns for each member of the interface o that is of type TranslatedString
+// the specified languages in which it has no translation.
+func missingTranslations(o interface{}, langs []string) []missingTranslation {
+	var missing []missingTranslation
 	v := reflect.ValueOf(o)
 
 	// Dereference in case of pointer or interface
@@ -738,12 +781,13 @@ func (conf *Configuration) validateTranslations(file string, o interface{}) {
 			continue
 		}
 		val := field.Interface().(TranslatedString)
-		for _, lang := range validLangs {
+		for _, lang := range langs {
 			if _, exists := val[lang]; !exists {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, lang, name))
+				missing = append(missing, missingTranslation{field: name, lang: lang})
 			}
 		}
 	}
+	return missing
 }
 
 func (conf *Configuration) join(other *Configuration) {
@@ -1,12 +1,18 @@
 package irma
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -17,6 +23,7 @@ import (
 	"github.com/privacybydesign/irmago/internal/test"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
 )
 
 func init() {
@@ -170,6 +177,63 @@ func TestInvalidIrmaConfigurationRestoreFromAssets(t *testing.T) {
 	require.Contains(t, conf.Requestors, "localhost")
 }
 
+func TestRequestorInfoUndeclared(t *testing.T) {
+	request := NewDisclosureRequest(
+		NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+		NewAttributeTypeIdentifier("irma-demo.MijnOverheid.fullName.firstname"),
+	)
+	request.AddSingle(NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN"), nil, nil)
+
+	info := NewRequestorInfo("example.com")
+	require.Nil(t, info.Undeclared(request.Disclose))
+
+	info.AllowedAttributes = []AttributeTypeIdentifier{
+		NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+		NewAttributeTypeIdentifier("irma-demo.MijnOverheid.fullName"),
+	}
+	require.Equal(t,
+		[]AttributeTypeIdentifier{NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN")},
+		info.Undeclared(request.Disclose),
+	)
+}
+
+func TestRequestorValidation(t *testing.T) {
+	conf := parseConfiguration(t)
+	scheme := conf.RequestorSchemes[NewRequestorSchemeIdentifier("test-requestors")]
+	require.NotNil(t, scheme)
+	require.NoError(t, conf.ValidateRequestors())
+
+	name := "Example"
+	purpose := TranslatedString{"en": "Registration", "nl": "Registratie"}
+	requestor := &RequestorInfo{
+		Scheme:    scheme.ID,
+		Name:      NewTranslatedString(&name),
+		Hostnames: []string{"example.com"},
+		Purpose:   &purpose,
+		AllowedAttributes: []AttributeTypeIdentifier{
+			NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+			NewAttributeTypeIdentifier("irma-demo.MijnOverheid.fullName"),
+			NewAttributeTypeIdentifier("pbdf.pbdf.email.email"),
+		},
+	}
+	require.NoError(t, requestor.validate())
+	scheme.requestors = append(scheme.requestors, requestor)
+	conf.Warnings = nil
+	require.NoError(t, conf.ValidateRequestors())
+	require.Len(t, conf.Warnings, 1) // pbdf scheme is not present
+
+	requestor.AllowedAttributes = append(requestor.AllowedAttributes, NewAttributeTypeIdentifier("irma-demo.RU.studentCard.nonexisting"))
+	require.Error(t, conf.ValidateRequestors())
+
+	requestor.AllowedAttributes = []AttributeTypeIdentifier{NewAttributeTypeIdentifier("irma-demo.RU")}
+	require.Error(t, requestor.validate())
+	requestor.AllowedAttributes = []AttributeTypeIdentifier{NewAttributeTypeIdentifier("irma-demo..studentCard")}
+	require.Error(t, requestor.validate())
+	requestor.AllowedAttributes = nil
+	purpose["nl"] = ""
+	require.Error(t, requestor.validate())
+}
+
 func TestParseIrmaConfiguration(t *testing.T) {
 	conf := parseConfiguration(t)
 
@@ -210,6 +274,124 @@ func TestParseIrmaConfiguration(t *testing.T) {
 	require.Equal(t, conf.Requestors["localhost"], conf.RequestorSchemes[id].requestors[0])
 }
 
+func TestMarshalDescriptions(t *testing.T) {
+	conf := parseConfiguration(t)
+
+	for _, credid := range []CredentialTypeIdentifier{
+		NewCredentialTypeIdentifier("irma-demo.RU.studentCard"),
+		NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"),
+	} {
+		// Marshal existing descriptions and check that they unmarshal to valid equal ones
+		issuer := conf.Issuers[credid.IssuerIdentifier()]
+		bts, err := xml.Marshal(issuer)
+		require.NoError(t, err)
+		newissuer := &Issuer{}
+		require.NoError(t, xml.Unmarshal(bts, newissuer))
+		require.Equal(t, issuer, newissuer)
+		require.NoError(t, conf.ValidateIssuer(newissuer))
+
+		cred := conf.CredentialTypes[credid]
+		bts, err = xml.Marshal(cred)
+		require.NoError(t, err)
+		newcred := &CredentialType{}
+		require.NoError(t, xml.Unmarshal(bts, newcred))
+		require.NoError(t, conf.ValidateCredentialType(newissuer, newcred))
+		require.Equal(t, cred.RevocationIndex, newcred.RevocationIndex)
+		require.Equal(t, cred.Name, newcred.Name)
+		require.Equal(t, len(cred.AttributeTypes), len(newcred.AttributeTypes))
+		for i, attr := range cred.AttributeTypes {
+			require.Equal(t, attr.ID, newcred.AttributeTypes[i].ID)
+			require.Equal(t, attr.Name, newcred.AttributeTypes[i].Name)
+			require.Equal(t, attr.RevocationAttribute, newcred.AttributeTypes[i].RevocationAttribute)
+		}
+
+		// Translations are sorted, so marshaling is deterministic
+		bts2, err := xml.Marshal(cred)
+		require.NoError(t, err)
+		require.Equal(t, bts, bts2)
+	}
+
+	// Inconsistent descriptions are rejected
+	issuer := *conf.Issuers[NewIssuerIdentifier("irma-demo.RU")]
+	issuer.Name = TranslatedString{"en": "RU", "nl": "RU"} // demo issuers need Demo prefix
+	require.Error(t, conf.ValidateIssuer(&issuer))
+	cred := *conf.CredentialTypes[NewCredentialTypeIdentifier("irma-demo.RU.studentCard")]
+	cred.AttributeTypes = nil
+	require.Error(t, conf.ValidateCredentialType(conf.Issuers[NewIssuerIdentifier("irma-demo.RU")], &cred))
+}
+
+func TestDiffConfigurations(t *testing.T) {
+	conf := parseConfiguration(t)
+	updated, err := NewConfiguration(filepath.Join("testdata", "irma_configuration_updated"), ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, updated.ParseFolder())
+
+	diff, err := DiffConfigurations(conf, conf)
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+
+	diff, err = DiffConfigurations(conf, updated)
+	require.NoError(t, err)
+	require.Empty(t, diff.Breaking())
+	require.Contains(t, diff.Changes, &SchemeChange{
+		Type:       SchemeChangeAttributeAdded,
+		Identifier: "irma-demo.RU.studentCard.newAttribute",
+	})
+	require.Contains(t, diff.Changes, &SchemeChange{
+		Type:       SchemeChangeAttributeOptional,
+		Identifier: "irma-demo.RU.studentCard.level",
+		Old:        "false",
+		New:        "true",
+	})
+
+	// In the other direction the changes invalidate existing credentials
+	diff, err = DiffConfigurations(updated, conf)
+	require.NoError(t, err)
+	require.Len(t, diff.Breaking(), len(diff.Changes))
+	require.Contains(t, diff.Changes, &SchemeChange{
+		Type:       SchemeChangeAttributeRemoved,
+		Identifier: "irma-demo.RU.studentCard.newAttribute",
+		Breaking:   true,
+		Reason:     "existing credentials contain this attribute",
+	})
+}
+
+func TestLintScheme(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	path := filepath.Join(storage, "irma-demo")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "irma-demo"), path))
+
+	diagnostics, err := LintScheme(path, LintOptions{})
+	require.NoError(t, err)
+	for _, d := range diagnostics {
+		// The public keys of the testdata have expired, but they are otherwise fine
+		require.Equal(t, LintRuleKeyExpiry, d.Rule, d.String())
+	}
+
+	// Introduce some problems
+	credpath := filepath.Join(path, "RU", "Issues", "studentCard", "description.xml")
+	bts, err := ioutil.ReadFile(credpath)
+	require.NoError(t, err)
+	bts = bytes.Replace(bts, []byte(`<Attribute id="studentID">`), []byte(`<Attribute id="studentID" displayIndex="0" displayHint="foo">`), 1)
+	bts = bytes.Replace(bts, []byte("<nl>Studentenkaart</nl>"), nil, 1)
+	require.NoError(t, ioutil.WriteFile(credpath, bts, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(path, "RU", "unused.png"), nil, 0644))
+
+	diagnostics, err = LintScheme(path, LintOptions{})
+	require.NoError(t, err)
+	problems := map[LintRule]*LintDiagnostic{}
+	for _, d := range diagnostics {
+		problems[d.Rule] = d
+	}
+	require.Equal(t, "RU/Issues/studentCard/description.xml", problems[LintRuleIndex].File)
+	require.Equal(t, "RU/unused.png", problems[LintRuleUnindexedFile].File)
+	require.Equal(t, "RU/unused.png", problems[LintRuleUnusedLogo].File)
+	require.Equal(t, 6, problems[LintRuleMissingTranslation].Line) // <ShortName>
+	require.Equal(t, 40, problems[LintRuleDisplayIndex].Line)
+	require.Equal(t, 40, problems[LintRuleDisplayHint].Line)
+}
+
 func TestInstallScheme(t *testing.T) {
 	test.StartSchemeManagerHttpServer()
 	defer test.StopSchemeManagerHttpServer()
@@ -716,6 +898,461 @@ func TestRevocationMemoryStore(t *testing.T) {
 	retrieve(t, pk, db, 4, 6)
 }
 
+func TestRevocationBoltStore(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	conf := parseConfiguration(t)
+	rs := conf.Revocation
+	require.NoError(t, rs.Load(false, "bolt", filepath.Join(storage, "revocation.db"), RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	defer func() { require.NoError(t, rs.Close()) }()
+
+	sk, err := rs.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	exists, err := rs.Exists(revocationTestCred, revocationPkCounter)
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, rs.EnableRevocation(revocationTestCred, sk))
+	exists, err = rs.Exists(revocationTestCred, revocationPkCounter)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Error(t, rs.EnableRevocation(revocationTestCred, sk))
+
+	// issue and revoke a few credentials
+	for i := 0; i < 3; i++ {
+		addTestIssuanceRecord(t, rs, fmt.Sprintf("key%d", i))
+	}
+	_, err = rs.IssuanceRecords(revocationTestCred, "nonexisting", time.Time{})
+	require.Equal(t, ErrUnknownRevocationKey, err)
+	require.NoError(t, rs.Revoke(revocationTestCred, "key0", time.Time{}))
+	require.NoError(t, rs.Revoke(revocationTestCred, "key2", time.Time{}))
+	require.Error(t, rs.Revoke(revocationTestCred, "key0", time.Time{}))
+	records, err := rs.IssuanceRecords(revocationTestCred, "key1", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	// check the resulting revocation state
+	updates, err := rs.UpdateLatest(revocationTestCred, 2, nil)
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	require.Len(t, updates[revocationPkCounter].Events, 2)
+	require.Equal(t, uint64(2), updates[revocationPkCounter].Events[1].Index)
+	sacc, err := rs.Accumulator(revocationTestCred, revocationPkCounter)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), sacc.Accumulator.Index)
+	eventrecords, err := rs.db.Events(revocationTestCred, revocationPkCounter, 0, RevocationParameters.UpdateMinCount)
+	require.NoError(t, err)
+	require.Len(t, eventrecords, 3)
+	_, err = rs.Events(revocationTestCred, revocationPkCounter, 0, RevocationParameters.UpdateMinCount)
+	require.Error(t, err) // interval not yet complete
+
+	// expired issuance records are deleted
+	require.NoError(t, rs.db.DeleteExpiredIssuanceRecords(time.Now().Add(2*time.Hour).UnixNano()))
+	_, err = rs.IssuanceRecords(revocationTestCred, "key1", time.Time{})
+	require.Equal(t, ErrUnknownRevocationKey, err)
+}
+
+func TestRevocationDBMigrations(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	path := filepath.Join(storage, "revocation.db")
+
+	status, err := RevocationDBSchemaStatus("bolt", path)
+	require.NoError(t, err)
+	require.Equal(t, 0, status.Version)
+	require.Len(t, status.Pending, len(revocationMigrations))
+
+	// refuse to use an outdated database if migrations are disabled
+	_, err = newRevStorage(false, "bolt", path, false)
+	require.Error(t, err)
+
+	applied, err := MigrateRevocationDB("bolt", path)
+	require.NoError(t, err)
+	require.Len(t, applied, len(revocationMigrations))
+	applied, err = MigrateRevocationDB("bolt", path)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	status, err = RevocationDBSchemaStatus("bolt", path)
+	require.NoError(t, err)
+	require.Equal(t, latestRevocationSchemaVersion(), status.Version)
+	require.Empty(t, status.Pending)
+	require.Len(t, status.Applied, len(revocationMigrations))
+
+	db, err := newRevStorage(false, "bolt", path, false)
+	require.NoError(t, err)
+
+	// refuse to use a database whose schema is newer than supported
+	future := latestRevocationSchemaVersion() + 1
+	require.NoError(t, db.Migrate(
+		revocationMigration{version: future},
+		&RevocationMigrationRecord{Version: future, AppliedAt: time.Now().Unix()},
+	))
+	require.NoError(t, db.Close())
+	_, err = newRevStorage(false, "bolt", path, true)
+	require.Error(t, err)
+	_, err = MigrateRevocationDB("bolt", path)
+	require.Error(t, err)
+}
+
+func TestRevocationStandby(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	authority := parseConfiguration(t).Revocation
+	authorityPath := filepath.Join(storage, "authority.db")
+	require.NoError(t, authority.Load(false, "bolt", authorityPath, RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	standby := parseConfiguration(t).Revocation
+	require.NoError(t, standby.Load(false, "bolt", filepath.Join(storage, "standby.db"), RevocationSettings{
+		revocationTestCred: {Standby: true, RevocationServerURL: "http://localhost:1"}, // unreachable
+	}))
+	defer func() { require.NoError(t, standby.Close()) }()
+
+	sk, err := authority.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	require.NoError(t, authority.EnableRevocation(revocationTestCred, sk))
+	for i := 0; i < 5; i++ {
+		addTestIssuanceRecord(t, authority, fmt.Sprintf("key%d", i))
+	}
+	latest := func(count uint64) *revocation.Update {
+		updates, err := authority.UpdateLatest(revocationTestCred, count, &revocationPkCounter)
+		require.NoError(t, err)
+		return updates[revocationPkCounter]
+	}
+
+	// the standby accepts the full event chain, and then updates extending it
+	require.NoError(t, authority.Revoke(revocationTestCred, "key0", time.Time{}))
+	require.NoError(t, standby.AddUpdate(revocationTestCred, latest(100)))
+	require.NoError(t, authority.Revoke(revocationTestCred, "key1", time.Time{}))
+	require.NoError(t, standby.AddUpdate(revocationTestCred, latest(1)))
+	require.NoError(t, standby.AddUpdate(revocationTestCred, latest(2))) // overlapping events are fine
+
+	// the standby refuses updates not connecting to its events, which it cannot fetch as the authority is down
+	require.NoError(t, authority.Revoke(revocationTestCred, "key2", time.Time{}))
+	require.NoError(t, authority.Revoke(revocationTestCred, "key3", time.Time{}))
+	require.Equal(t, errStandbyMissingEvents, standby.addStandbyUpdate(revocationTestCred, latest(1)))
+	require.Error(t, standby.AddUpdate(revocationTestCred, latest(1)))
+	require.NoError(t, standby.AddUpdate(revocationTestCred, latest(2)))
+	sacc, err := standby.Accumulator(revocationTestCred, revocationPkCounter)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), sacc.Accumulator.Index)
+
+	// replicate issuance records
+	resp, err := authority.Replicate(revocationTestCred, 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint{revocationPkCounter}, resp.Counters)
+	require.Len(t, resp.IssuanceRecords, 5)
+	for _, r := range resp.IssuanceRecords {
+		require.NoError(t, standby.db.SaveIssuanceRecord(r))
+	}
+
+	// fence the authority, after which it no longer revokes, also not after restarting
+	require.NoError(t, authority.Fence(revocationTestCred, 1))
+	require.Error(t, authority.Fence(revocationTestCred, 1))
+	require.Error(t, authority.Revoke(revocationTestCred, "key4", time.Time{}))
+	require.NoError(t, authority.Close())
+	restarted := parseConfiguration(t).Revocation
+	require.Error(t, restarted.Load(false, "bolt", authorityPath, RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	require.NoError(t, restarted.Close())
+
+	// promote the standby, which then continues the event chain
+	require.Error(t, standby.Promote(revocationTestCred, false))
+	require.NoError(t, standby.Promote(revocationTestCred, true))
+	require.Error(t, standby.Revoke(revocationTestCred, "key0", time.Time{})) // already revoked
+	require.NoError(t, standby.Revoke(revocationTestCred, "key4", time.Time{}))
+	updates, err := standby.UpdateLatest(revocationTestCred, 6, &revocationPkCounter)
+	require.NoError(t, err)
+	require.Len(t, updates[revocationPkCounter].Events, 6)
+	require.Equal(t, uint64(5), updates[revocationPkCounter].SignedAccumulator.Accumulator.Index)
+	rec, err := standby.db.ReplicationRecord(revocationTestCred)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), rec.Epoch)
+}
+
+func TestRevocationQuery(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	rs := parseConfiguration(t).Revocation
+	require.NoError(t, rs.Load(false, "bolt", filepath.Join(storage, "revocation.db"), RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	defer func() { require.NoError(t, rs.Close()) }()
+
+	sk, err := rs.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	require.NoError(t, rs.EnableRevocation(revocationTestCred, sk))
+	addTestIssuanceRecord(t, rs, "key0")
+	addTestIssuanceRecord(t, rs, "key0")
+	addTestIssuanceRecord(t, rs, "key1")
+	require.NoError(t, rs.Revoke(revocationTestCred, "key1", time.Time{}))
+
+	_, err = rs.IssuanceRecordStatus(revocationTestCred, "unknown")
+	require.Equal(t, ErrUnknownRevocationKey, err)
+
+	// unrevoked issuance records
+	statuses, err := rs.IssuanceRecordStatus(revocationTestCred, "key0")
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	for _, s := range statuses {
+		require.False(t, s.Revoked)
+		require.Nil(t, s.EventIndex)
+		require.Equal(t, revocationPkCounter, s.PKCounter)
+	}
+
+	// revoked issuance records are included, along with the event that revoked them
+	statuses, err = rs.IssuanceRecordStatus(revocationTestCred, "key1")
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.True(t, statuses[0].Revoked)
+	require.NotZero(t, statuses[0].RevokedAt)
+	require.NotNil(t, statuses[0].EventIndex)
+	require.Equal(t, uint64(1), *statuses[0].EventIndex)
+
+	// the event log contains all events from the initial one, and survives JSON roundtrips
+	counter := revocationPkCounter
+	res, err := rs.Query(&RevocationQueryRequest{
+		LDContext:      LDContextRevocationQueryRequest,
+		CredentialType: revocationTestCred,
+		Counter:        &counter,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.EventLog.Events, 2)
+	require.Equal(t, uint64(0), res.EventLog.Events[0].Index)
+	require.Equal(t, 0, res.EventLog.Events[1].E.Cmp(statuses[0].Attr))
+	bts, err := json.Marshal(res)
+	require.NoError(t, err)
+	parsed := &RevocationQueryResponse{}
+	require.NoError(t, json.Unmarshal(bts, parsed))
+	pk, err := rs.Keys.PublicKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	_, err = parsed.EventLog.Verify(pk)
+	require.NoError(t, err)
+
+	// a query must specify either a key or a key counter
+	_, err = rs.Query(&RevocationQueryRequest{LDContext: LDContextRevocationQueryRequest, CredentialType: revocationTestCred})
+	require.Error(t, err)
+}
+
+func TestRevocationAudit(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	path := filepath.Join(storage, "revocation.db")
+
+	conf := parseConfiguration(t)
+	rs := conf.Revocation
+	require.NoError(t, rs.Load(false, "bolt", path, RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	sk, err := rs.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	require.NoError(t, rs.EnableRevocation(revocationTestCred, sk))
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("key%d", i)
+		addTestIssuanceRecord(t, rs, key)
+		require.NoError(t, rs.Revoke(revocationTestCred, key, time.Time{}))
+	}
+	require.NoError(t, rs.Close())
+
+	audit, err := AuditRevocationDB(conf, revocationTestCred, "bolt", path)
+	require.NoError(t, err)
+	require.Len(t, audit.Keys, 1)
+	require.True(t, audit.OK(), "%v", audit.Keys[0].Problems)
+	require.Equal(t, revocationPkCounter, audit.Keys[0].Counter)
+	require.Equal(t, uint64(3), audit.Keys[0].Index)
+	require.Equal(t, 4, audit.Keys[0].Events)
+
+	// tamper with an event: both its own index and the parent hash of the next event are reported
+	db, err := newBoltStorage(path, false)
+	require.NoError(t, err)
+	records, err := db.Events(revocationTestCred, revocationPkCounter, 0, 4)
+	require.NoError(t, err)
+	e, err := rand.Prime(rand.Reader, 100)
+	require.NoError(t, err)
+	records[1].E = (*RevocationAttribute)(big.Convert(e))
+	require.NoError(t, db.update(func(tx *bbolt.Tx) error {
+		return boltPut(tx.Bucket(boltEventsBucket), boltKey(revocationTestCred.String(), uint64(revocationPkCounter), uint64(1)), records[1])
+	}))
+	// the database cannot be audited while another process, like the IRMA server, uses it
+	_, err = AuditRevocationDB(conf, revocationTestCred, "bolt", path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "in use")
+	require.NoError(t, db.Close())
+
+	audit, err = AuditRevocationDB(conf, revocationTestCred, "bolt", path)
+	require.NoError(t, err)
+	require.False(t, audit.OK())
+	require.Equal(t, []string{"event 2 has wrong parent hash"}, audit.Keys[0].Problems)
+}
+
+func TestRevocationBundles(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	defer func(size uint64) { RevocationParameters.BundleSize = size }(RevocationParameters.BundleSize)
+	RevocationParameters.BundleSize = 3
+
+	conf := parseConfiguration(t)
+	conf.options.RevocationBundleDir = filepath.Join(storage, "bundles")
+	rs := conf.Revocation
+	require.NoError(t, rs.Load(false, "bolt", filepath.Join(storage, "revocation.db"), RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	defer func() { require.NoError(t, rs.Close()) }()
+	sk, err := rs.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	require.NoError(t, rs.EnableRevocation(revocationTestCred, sk))
+
+	revoke := func(count int) {
+		for i := 0; i < count; i++ {
+			key := fmt.Sprintf("key%d", time.Now().UnixNano())
+			addTestIssuanceRecord(t, rs, key)
+			require.NoError(t, rs.Revoke(revocationTestCred, key, time.Time{}))
+		}
+	}
+
+	// not enough events yet for a bundle
+	revoke(1)
+	require.NoError(t, rs.PublishBundles())
+	_, err = rs.ReadBundleFile(revocationTestCred, revocationPkCounter, "index")
+	require.True(t, os.IsNotExist(err))
+
+	// events 0-3 go into the first bundle, 4-6 into the second; 7 is not yet bundled
+	revoke(2)
+	require.NoError(t, rs.PublishBundles())
+	revoke(3)
+	require.NoError(t, rs.PublishBundles())
+	revoke(1)
+	require.NoError(t, rs.PublishBundles())
+	bts, err := rs.ReadBundleFile(revocationTestCred, revocationPkCounter, "index")
+	require.NoError(t, err)
+	index := &RevocationBundleIndex{}
+	require.NoError(t, UnmarshalBinary(bts, index))
+	require.Equal(t, []RevocationBundleRange{{From: 0, To: 3}, {From: 4, To: 6}}, index.Bundles)
+
+	_, err = rs.ReadBundleFile(revocationTestCred, revocationPkCounter, "../index")
+	require.Error(t, err)
+
+	// serve the bundles statically, as a CDN would, and fetch them
+	ts := httptest.NewServer(http.FileServer(http.Dir(conf.options.RevocationBundleDir)))
+	defer ts.Close()
+	client := RevocationClient{Conf: conf, Settings: RevocationSettings{
+		revocationTestCred: {RevocationServerURL: ts.URL},
+	}}
+	events, next := client.fetchBundledEvents(revocationTestCred, revocationPkCounter, 1, 7)
+	require.Equal(t, uint64(7), next)
+	require.Len(t, events, 6)
+	require.Equal(t, uint64(1), events[0].Index)
+	require.Equal(t, uint64(6), events[5].Index)
+
+	// bundles are skipped when we already have most of their events
+	events, next = client.fetchBundledEvents(revocationTestCred, revocationPkCounter, 3, 7)
+	require.Equal(t, uint64(3), next)
+	require.Empty(t, events)
+}
+
+func TestRevocationRotate(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	rs := parseConfiguration(t).Revocation
+	require.NoError(t, rs.Load(false, "bolt", filepath.Join(storage, "revocation.db"), RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	defer func() { require.NoError(t, rs.Close()) }()
+
+	_, err := rs.KeyStates(revocationTestCred)
+	require.Equal(t, ErrRevocationStateNotFound, err)
+
+	// enabling revocation for the latest key is idempotent
+	counter, err := rs.RotateRevocation(revocationTestCred)
+	require.NoError(t, err)
+	require.Equal(t, revocationPkCounter, counter)
+	counter, err = rs.RotateRevocation(revocationTestCred)
+	require.NoError(t, err)
+	require.Equal(t, revocationPkCounter, counter)
+
+	addTestIssuanceRecord(t, rs, "key0")
+	addTestIssuanceRecord(t, rs, "key0")
+	addTestIssuanceRecord(t, rs, "key1")
+	counts, err := rs.RevokeKey(revocationTestCred, "key0")
+	require.NoError(t, err)
+	require.Equal(t, map[uint]int{revocationPkCounter: 2}, counts)
+	_, err = rs.RevokeKey(revocationTestCred, "key0")
+	require.Equal(t, ErrUnknownRevocationKey, err)
+
+	states, err := rs.KeyStates(revocationTestCred)
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	require.Equal(t, revocationPkCounter, states[0].Counter)
+	require.Equal(t, uint64(2), states[0].Index)
+	require.True(t, states[0].Latest)
+	require.NotZero(t, states[0].Time)
+}
+
+func TestRevocationStatus(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	// a revocation authority knows its accumulators and never goes stale
+	authority := parseConfiguration(t).Revocation
+	require.NoError(t, authority.Load(false, "bolt", filepath.Join(storage, "revocation.db"), RevocationSettings{
+		revocationTestCred: {Authority: true},
+	}))
+	defer func() { require.NoError(t, authority.Close()) }()
+	sk, err := authority.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	require.NoError(t, authority.EnableRevocation(revocationTestCred, sk))
+	statuses := authority.Status()
+	require.Len(t, statuses, 1)
+	require.Equal(t, "authority", statuses[0].Mode)
+	require.NotNil(t, statuses[0].Updated)
+	require.False(t, statuses[0].Stale)
+	require.Len(t, statuses[0].Keys, 1)
+	require.Equal(t, revocationPkCounter, statuses[0].Keys[0].Counter)
+
+	// a requestor that cannot reach the revocation server records the failure, and a tolerance
+	// violation when it is asked for nonrevocation proofs
+	requestor := parseConfiguration(t).Revocation
+	require.NoError(t, requestor.Load(false, "", "", RevocationSettings{
+		revocationTestCred: {RevocationServerURL: "http://localhost:1"},
+	}))
+	defer func() { require.NoError(t, requestor.Close()) }()
+	request := &BaseRequest{Revocation: NonRevocationParameters{revocationTestCred: {}}}
+	require.Error(t, requestor.SetRevocationUpdates(request))
+	statuses = requestor.Status()
+	require.Len(t, statuses, 1)
+	require.Equal(t, "requestor", statuses[0].Mode)
+	require.Nil(t, statuses[0].Updated)
+	require.Empty(t, statuses[0].Keys)
+	require.Equal(t, uint64(1), statuses[0].SyncFailures)
+	require.NotEmpty(t, statuses[0].LastSyncError)
+	require.Equal(t, uint64(1), statuses[0].ToleranceViolations)
+
+	// once its nonrevocation guarantees are older than the tolerance they are reported as stale
+	settings := requestor.settings.Get(revocationTestCred)
+	settings.updated = time.Now().Add(-time.Duration(settings.Tolerance+1) * time.Second)
+	require.True(t, requestor.Status()[0].Stale)
+}
+
+func addTestIssuanceRecord(t *testing.T, rs *RevocationStorage, key string) {
+	e, err := rand.Prime(rand.Reader, 100)
+	require.NoError(t, err)
+	require.NoError(t, rs.AddIssuanceRecord(&IssuanceRecord{
+		Key:        key,
+		CredType:   revocationTestCred,
+		Issued:     time.Now().UnixNano(),
+		PKCounter:  &revocationPkCounter,
+		Attr:       (*RevocationAttribute)(big.Convert(e)),
+		ValidUntil: time.Now().Add(time.Hour).UnixNano(),
+	}))
+}
+
 func revokeMultiple(t *testing.T, sk *revocation.PrivateKey, update *revocation.Update) *revocation.Update {
 	acc := update.SignedAccumulator.Accumulator
 	event := update.Events[len(update.Events)-1]
@@ -798,3 +1435,98 @@ func TestPrivateKeyRings(t *testing.T) {
 	_, err = mergedring.Latest(ru)
 	require.NoError(t, err)
 }
+
+func TestPrivateKeyRingSigner(t *testing.T) {
+	mo := NewIssuerIdentifier("irma-demo.MijnOverheid")
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	socket := filepath.Join(storage, "signer.sock")
+
+	// Run a signer having the private keys in the scheme
+	signer := NewSignerServer(parseConfiguration(t), parseConfiguration(t).PrivateKeys)
+	signer.SocketMode = 0660
+	signer.SocketGroup = strconv.Itoa(os.Getgid())
+	stopped := make(chan error)
+	go func() { stopped <- signer.ListenAndServe(socket) }()
+	defer func() {
+		require.NoError(t, signer.Stop())
+		require.NoError(t, <-stopped)
+	}()
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(socket)
+		return err == nil && info.Mode().Perm() == 0660
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Parse a configuration without private keys, using the signer instead
+	conf, err := NewConfiguration("testdata/irma_configuration", ConfigurationOptions{IgnorePrivateKeys: true})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	ring, err := NewPrivateKeyRingSigner(socket, conf)
+	require.NoError(t, err)
+	require.NoError(t, conf.AddPrivateKeyRing(ring))
+
+	sk, err := conf.PrivateKeys.Latest(mo)
+	require.NoError(t, err)
+	require.Equal(t, uint(2), sk.Counter)
+	require.Nil(t, sk.P) // the private key itself stays within the signer
+
+	// Issue a credential using the signer
+	pk, err := conf.PublicKey(mo, 2)
+	require.NoError(t, err)
+	secret, err := gabi.GenerateSecretAttribute()
+	require.NoError(t, err)
+	nonce1, err := gabi.GenerateNonce()
+	require.NoError(t, err)
+	nonce2, err := gabi.GenerateNonce()
+	require.NoError(t, err)
+	builder := gabi.NewCredentialBuilder(pk, big.NewInt(1), secret, nonce2, nil)
+	commitment := builder.CommitToSecretAndProve(nonce1)
+	attrs := []*big.Int{big.NewInt(42), big.NewInt(1337)}
+
+	sig, err := conf.IssueSignature(mo, 2, commitment.U, attrs, nil, nonce2, nil)
+	require.NoError(t, err)
+	_, err = builder.ConstructCredential(sig, attrs)
+	require.NoError(t, err)
+
+	_, err = conf.IssueSignature(mo, 7, commitment.U, attrs, nil, nonce2, nil)
+	require.Error(t, err)
+}
+
+func TestExpiringPrivateKeys(t *testing.T) {
+	conf := parseConfiguration(t)
+	ring, err := NewPrivateKeyRingFolder(filepath.Join(test.FindTestdataFolder(t), "privatekeys"), conf)
+	require.NoError(t, err)
+
+	// test.test-3 expires at 1881423265, irma-demo.MijnOverheid-1 and irma-demo.RU-2 at 1893456000
+	expiring, err := conf.ExpiringPrivateKeys(ring, 0)
+	require.NoError(t, err)
+	require.Empty(t, expiring)
+
+	expiring, err = conf.ExpiringPrivateKeys(ring, time.Until(time.Unix(1881423265, 0))+time.Hour)
+	require.NoError(t, err)
+	require.Len(t, expiring, 1)
+	require.Equal(t, NewIssuerIdentifier("test.test"), expiring[0].Issuer)
+	require.Equal(t, uint(3), expiring[0].Counter)
+	require.False(t, expiring[0].Expired)
+
+	expiring, err = conf.ExpiringPrivateKeys(ring, time.Until(time.Unix(1893456000, 0))+time.Hour)
+	require.NoError(t, err)
+	require.Len(t, expiring, 3)
+	require.Equal(t, NewIssuerIdentifier("irma-demo.MijnOverheid"), expiring[0].Issuer)
+	require.Equal(t, NewIssuerIdentifier("irma-demo.RU"), expiring[1].Issuer)
+
+	// The latest private key of irma-demo.MijnOverheid in the scheme has already expired
+	schemering, err := newPrivateKeyRingScheme(conf)
+	require.NoError(t, err)
+	expiring, err = conf.ExpiringPrivateKeys(schemering, 0)
+	require.NoError(t, err)
+	var found bool
+	for _, key := range expiring {
+		if key.Issuer == NewIssuerIdentifier("irma-demo.MijnOverheid") {
+			found = true
+			require.Equal(t, uint(2), key.Counter)
+			require.True(t, key.Expired)
+		}
+	}
+	require.True(t, found)
+}
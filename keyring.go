@@ -7,7 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi"
@@ -247,6 +249,16 @@ func (p *privateKeyRingMerge) Get(id IssuerIdentifier, counter uint) (*gabi.Priv
 	return nil, ErrMissingPrivateKey
 }
 
+// ringOf returns the first ring containing the specified private key, if any.
+func (p *privateKeyRingMerge) ringOf(id IssuerIdentifier, counter uint) PrivateKeyRing {
+	for _, ring := range p.rings {
+		if sk, err := ring.Get(id, counter); err == nil && sk != nil {
+			return ring
+		}
+	}
+	return nil
+}
+
 func (p *privateKeyRingMerge) Latest(id IssuerIdentifier) (*gabi.PrivateKey, error) {
 	var sk *gabi.PrivateKey
 	for _, ring := range p.rings {
@@ -273,6 +285,57 @@ func (p *privateKeyRingMerge) Iterate(id IssuerIdentifier, f func(sk *gabi.Priva
 	return nil
 }
 
+// KeyExpiry describes an issuer private key in a PrivateKeyRing whose public key expires soon,
+// or has already expired.
+type KeyExpiry struct {
+	Issuer     IssuerIdentifier `json:"issuer"`
+	Counter    uint             `json:"counter"`
+	ExpiryDate Timestamp        `json:"expiryDate"`
+	Expired    bool             `json:"expired"`
+}
+
+// ExpiringPrivateKeys returns, for each issuer of which the specified ring contains private keys,
+// the latest of those private keys if its public key expires within the specified window from now.
+// Older keys are not considered, as only the latest key is used for issuing.
+// Deprecated issuers are skipped. The result is sorted by issuer.
+func (conf *Configuration) ExpiringPrivateKeys(ring PrivateKeyRing, window time.Duration) ([]*KeyExpiry, error) {
+	var expiring []*KeyExpiry
+	now := time.Now()
+	for id, issuer := range conf.Issuers {
+		if !issuer.DeprecatedSince.IsZero() && !issuer.DeprecatedSince.After(Timestamp(now)) {
+			continue
+		}
+		sk, err := ring.Latest(id)
+		if goerrors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pk, err := conf.PublicKey(id, sk.Counter)
+		if err != nil {
+			return nil, err
+		}
+		if pk == nil {
+			return nil, errors.Errorf("Private key %d of issuer %s has no corresponding public key", sk.Counter, id.String())
+		}
+		expiry := time.Unix(pk.ExpiryDate, 0)
+		if expiry.After(now.Add(window)) {
+			continue
+		}
+		expiring = append(expiring, &KeyExpiry{
+			Issuer:     id,
+			Counter:    sk.Counter,
+			ExpiryDate: Timestamp(expiry),
+			Expired:    !expiry.After(now),
+		})
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].Issuer.String() < expiring[j].Issuer.String()
+	})
+	return expiring, nil
+}
+
 func validatePrivateKey(issuerid IssuerIdentifier, sk *gabi.PrivateKey, conf *Configuration) error {
 	if _, ok := conf.Issuers[issuerid]; !ok {
 		return errors.Errorf("Private key %d of issuer %s belongs to an unknown issuer", sk.Counter, issuerid.String())
@@ -294,6 +357,9 @@ func validatePrivateKey(issuerid IssuerIdentifier, sk *gabi.PrivateKey, conf *Co
 }
 
 func validatePrivateKeyRing(ring PrivateKeyRing, conf *Configuration) error {
+	if _, ok := ring.(IssuanceSigner); ok {
+		return nil // its private keys are not available to us; the signer validates them itself
+	}
 	for issuerid := range conf.Issuers {
 		err := ring.Iterate(issuerid, func(sk *gabi.PrivateKey) error {
 			return validatePrivateKey(issuerid, sk, conf)
@@ -0,0 +1,396 @@
+package irma
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// This file contains a PrivateKeyRing that keeps the issuer private keys out of this process,
+// delegating the computation of issuance signatures to an external signer process (for example,
+// a hardened daemon or one backed by an HSM) with which it communicates over a Unix socket.
+// The signer process is implemented by SignerServer, as used by the "irma signer" command.
+//
+// The protocol consists of newline-delimited JSON messages: the client sends a signerRequest,
+// to which the signer responds with a signerResponse. A connection may be used for multiple
+// requests.
+
+type (
+	// IssuanceSigner computes issuance signatures over blinded commitments without revealing
+	// the issuer private key. PrivateKeyRings that do not hold the private keys themselves
+	// implement this interface.
+	IssuanceSigner interface {
+		// IssueSignature computes a signature over the commitment U and the attributes using the
+		// specified private key, along with a proof of correctness of the signature over nonce2.
+		IssueSignature(id IssuerIdentifier, counter uint, U *big.Int, attributes []*big.Int, nonce2 *big.Int, blind []int) (*gabi.IssueSignatureMessage, error)
+	}
+
+	// PrivateKeyRingSigner is a PrivateKeyRing whose private keys are held by an external signer
+	// process listening on a Unix socket. The private keys it returns contain only the counter
+	// and expiry date; issuance signatures are computed by the signer using IssueSignature.
+	// Revocation is not supported for its keys.
+	PrivateKeyRingSigner struct {
+		socket string
+		keys   map[IssuerIdentifier]map[uint]*gabi.PrivateKey
+	}
+
+	// SignerServer serves the issuance signature requests of PrivateKeyRingSigner instances,
+	// using the private keys from a PrivateKeyRing.
+	SignerServer struct {
+		// SocketMode is the file mode of the socket created by ListenAndServe(); if zero, 0600 is
+		// used, so that only the current user can connect. To allow an IRMA server running as
+		// another user to connect, set SocketGroup to a group of which that user is a member,
+		// and SocketMode to 0660.
+		SocketMode os.FileMode
+		// SocketGroup is the name or ID of the group owning the socket created by ListenAndServe();
+		// if empty, the group is not changed.
+		SocketGroup string
+
+		conf     *Configuration
+		ring     PrivateKeyRing
+		listener net.Listener
+		stopped  bool
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+	}
+
+	signerRequest struct {
+		Type       signerRequestType `json:"type"`
+		Issuer     IssuerIdentifier  `json:"issuer,omitempty"`
+		Counter    uint              `json:"counter,omitempty"`
+		U          *big.Int          `json:"u,omitempty"`
+		Attributes []*big.Int        `json:"attributes,omitempty"`
+		Nonce2     *big.Int          `json:"nonce2,omitempty"`
+		Blind      []int             `json:"blind,omitempty"`
+	}
+
+	signerResponse struct {
+		Error     string                      `json:"error,omitempty"`
+		Keys      []*signerKey                `json:"keys,omitempty"`
+		Signature *gabi.IssueSignatureMessage `json:"signature,omitempty"`
+	}
+
+	signerKey struct {
+		Issuer     IssuerIdentifier `json:"issuer"`
+		Counter    uint             `json:"counter"`
+		ExpiryDate int64            `json:"expiryDate"`
+	}
+
+	signerRequestType string
+)
+
+const (
+	signerRequestKeys = signerRequestType("keys")
+	signerRequestSign = signerRequestType("sign")
+
+	signerTimeout = 30 * time.Second
+)
+
+// NewPrivateKeyRingSigner returns a PrivateKeyRingSigner for the signer listening at the specified
+// Unix socket, after retrieving the list of keys held by the signer.
+func NewPrivateKeyRingSigner(socket string, conf *Configuration) (*PrivateKeyRingSigner, error) {
+	ring := &PrivateKeyRingSigner{socket: socket, keys: map[IssuerIdentifier]map[uint]*gabi.PrivateKey{}}
+	res, err := ring.do(&signerRequest{Type: signerRequestKeys})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range res.Keys {
+		if _, ok := conf.Issuers[key.Issuer]; !ok {
+			return nil, errors.Errorf("Private key %d of issuer %s belongs to an unknown issuer", key.Counter, key.Issuer.String())
+		}
+		pk, err := conf.PublicKey(key.Issuer, key.Counter)
+		if err != nil {
+			return nil, err
+		}
+		if pk == nil {
+			return nil, errors.Errorf("Private key %d of issuer %s has no corresponding public key", key.Counter, key.Issuer.String())
+		}
+		if ring.keys[key.Issuer] == nil {
+			ring.keys[key.Issuer] = map[uint]*gabi.PrivateKey{}
+		}
+		ring.keys[key.Issuer][key.Counter] = &gabi.PrivateKey{Counter: key.Counter, ExpiryDate: key.ExpiryDate}
+	}
+	return ring, nil
+}
+
+func (p *PrivateKeyRingSigner) Get(id IssuerIdentifier, counter uint) (*gabi.PrivateKey, error) {
+	sk, ok := p.keys[id][counter]
+	if !ok {
+		return nil, ErrMissingPrivateKey
+	}
+	return sk, nil
+}
+
+func (p *PrivateKeyRingSigner) Latest(id IssuerIdentifier) (*gabi.PrivateKey, error) {
+	var sk *gabi.PrivateKey
+	for _, s := range p.keys[id] {
+		if sk == nil || s.Counter > sk.Counter {
+			sk = s
+		}
+	}
+	if sk == nil {
+		return nil, ErrMissingPrivateKey
+	}
+	return sk, nil
+}
+
+func (p *PrivateKeyRingSigner) Iterate(id IssuerIdentifier, f func(sk *gabi.PrivateKey) error) error {
+	for _, sk := range p.keys[id] {
+		if err := f(sk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PrivateKeyRingSigner) IssueSignature(
+	id IssuerIdentifier, counter uint, U *big.Int, attributes []*big.Int, nonce2 *big.Int, blind []int,
+) (*gabi.IssueSignatureMessage, error) {
+	if _, err := p.Get(id, counter); err != nil {
+		return nil, err
+	}
+	res, err := p.do(&signerRequest{
+		Type:       signerRequestSign,
+		Issuer:     id,
+		Counter:    counter,
+		U:          U,
+		Attributes: attributes,
+		Nonce2:     nonce2,
+		Blind:      blind,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Signature == nil {
+		return nil, errors.New("signer returned no signature")
+	}
+	return res.Signature, nil
+}
+
+func (p *PrivateKeyRingSigner) do(req *signerRequest) (*signerResponse, error) {
+	conn, err := net.DialTimeout("unix", p.socket, signerTimeout)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to connect to signer", 0)
+	}
+	defer func() { _ = conn.Close() }()
+	if err = conn.SetDeadline(time.Now().Add(signerTimeout)); err != nil {
+		return nil, err
+	}
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to send request to signer", 0)
+	}
+	res := &signerResponse{}
+	if err = json.NewDecoder(bufio.NewReader(conn)).Decode(res); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to read response of signer", 0)
+	}
+	if res.Error != "" {
+		return nil, errors.Errorf("signer returned error: %s", res.Error)
+	}
+	return res, nil
+}
+
+// IssueSignature computes an issuance signature over the commitment U and the attributes using
+// the specified private key. If the key ring containing the private key is an IssuanceSigner,
+// the signature is computed by it; otherwise the private key is used directly.
+func (conf *Configuration) IssueSignature(
+	id IssuerIdentifier, counter uint, U *big.Int, attributes []*big.Int, witness *revocation.Witness, nonce2 *big.Int, blind []int,
+) (*gabi.IssueSignatureMessage, error) {
+	ring := conf.PrivateKeys
+	if merge, ok := ring.(*privateKeyRingMerge); ok {
+		ring = merge.ringOf(id, counter)
+	}
+	if ring == nil {
+		return nil, ErrMissingPrivateKey
+	}
+
+	if signer, ok := ring.(IssuanceSigner); ok {
+		sig, err := signer.IssueSignature(id, counter, U, attributes, nonce2, blind)
+		if err != nil {
+			return nil, err
+		}
+		sig.NonRevocationWitness = witness
+		return sig, nil
+	}
+
+	sk, err := ring.Get(id, counter)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := conf.PublicKey(id, counter)
+	if err != nil {
+		return nil, err
+	}
+	if pk == nil {
+		return nil, ErrMissingPublicKey
+	}
+	return gabi.NewIssuer(sk, pk, big.NewInt(1)).IssueSignature(U, attributes, witness, nonce2, blind)
+}
+
+// NewSignerServer returns a SignerServer computing issuance signatures using the private keys
+// in the specified ring.
+func NewSignerServer(conf *Configuration, ring PrivateKeyRing) *SignerServer {
+	return &SignerServer{conf: conf, ring: ring}
+}
+
+// ListenAndServe listens on the specified Unix socket, which must not yet exist, and serves
+// incoming requests until Stop() is called. The mode and group of the socket are set according
+// to SocketMode and SocketGroup.
+func (s *SignerServer) ListenAndServe(socket string) error {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err = s.setSocketPermissions(socket); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	return s.Serve(listener)
+}
+
+func (s *SignerServer) setSocketPermissions(socket string) error {
+	if s.SocketGroup != "" {
+		group, err := user.LookupGroup(s.SocketGroup)
+		if err != nil {
+			if group, err = user.LookupGroupId(s.SocketGroup); err != nil {
+				return errors.WrapPrefix(err, "unknown socket group", 0)
+			}
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			return errors.WrapPrefix(err, "unsupported socket group", 0)
+		}
+		if err = os.Chown(socket, -1, gid); err != nil {
+			return err
+		}
+	}
+	mode := s.SocketMode
+	if mode == 0 {
+		mode = 0600
+	}
+	return os.Chmod(socket, mode)
+}
+
+// Serve serves incoming requests on the listener until Stop() is called.
+func (s *SignerServer) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			stopped := s.stopped
+			s.mutex.Unlock()
+			if stopped {
+				s.wg.Wait()
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// Stop stops the server, waiting for the requests being handled to finish.
+func (s *SignerServer) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil || s.stopped {
+		return nil
+	}
+	s.stopped = true
+	return s.listener.Close()
+}
+
+func (s *SignerServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		if err := conn.SetDeadline(time.Now().Add(signerTimeout)); err != nil {
+			return
+		}
+		req := &signerRequest{}
+		if err := decoder.Decode(req); err != nil {
+			if err != io.EOF {
+				Logger.WithField("error", err.Error()).Warn("Failed to read signer request")
+			}
+			return
+		}
+		res, err := s.respond(req)
+		if err != nil {
+			Logger.WithField("error", err.Error()).Warn("Failed to handle signer request")
+			res = &signerResponse{Error: err.Error()}
+		}
+		if err = encoder.Encode(res); err != nil {
+			Logger.WithField("error", err.Error()).Warn("Failed to write signer response")
+			return
+		}
+	}
+}
+
+func (s *SignerServer) respond(req *signerRequest) (*signerResponse, error) {
+	switch req.Type {
+	case signerRequestKeys:
+		return s.listKeys()
+	case signerRequestSign:
+		if req.U == nil || req.Nonce2 == nil || len(req.Attributes) == 0 {
+			return nil, errors.New("incomplete signing request")
+		}
+		sk, err := s.ring.Get(req.Issuer, req.Counter)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := s.conf.PublicKey(req.Issuer, req.Counter)
+		if err != nil {
+			return nil, err
+		}
+		if pk == nil {
+			return nil, ErrMissingPublicKey
+		}
+		Logger.WithFields(map[string]interface{}{"issuer": req.Issuer.String(), "counter": req.Counter}).
+			Info("Computing issuance signature")
+		sig, err := gabi.NewIssuer(sk, pk, big.NewInt(1)).
+			IssueSignature(req.U, req.Attributes, nil, req.Nonce2, req.Blind)
+		if err != nil {
+			return nil, err
+		}
+		return &signerResponse{Signature: sig}, nil
+	default:
+		return nil, errors.Errorf("unknown request type %s", req.Type)
+	}
+}
+
+func (s *SignerServer) listKeys() (*signerResponse, error) {
+	res := &signerResponse{Keys: []*signerKey{}}
+	for id := range s.conf.Issuers {
+		seen := map[uint]bool{}
+		err := s.ring.Iterate(id, func(sk *gabi.PrivateKey) error {
+			if !seen[sk.Counter] {
+				seen[sk.Counter] = true
+				res.Keys = append(res.Keys, &signerKey{Issuer: id, Counter: sk.Counter, ExpiryDate: sk.ExpiryDate})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
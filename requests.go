@@ -22,6 +22,8 @@ const (
 	LDContextSignatureRequest  = "https://irma.app/ld/request/signature/v2"
 	LDContextIssuanceRequest   = "https://irma.app/ld/request/issuance/v2"
 	LDContextRevocationRequest = "https://irma.app/ld/request/revocation/v1"
+
+	LDContextRevocationQueryRequest = "https://irma.app/ld/request/revocationquery/v1"
 )
 
 // BaseRequest contains information used by all IRMA session types, such the context and nonce,
@@ -174,6 +176,11 @@ type RevocationJwt struct {
 	Request *RevocationRequest `json:"revrequest"`
 }
 
+type RevocationQueryJwt struct {
+	ServerJwt
+	Request *RevocationQueryRequest `json:"revqueryrequest"`
+}
+
 // A RequestorJwt contains an IRMA session object.
 type RequestorJwt interface {
 	Action() Action
@@ -204,6 +211,16 @@ type RevocationRequest struct {
 	Issued         int64                    `json:"issued,omitempty"`
 }
 
+// RevocationQueryRequest asks the revocation authority of a credential type either for the
+// issuance records of a revocation key and their revocation state (if Key is set), or for the
+// complete event log of a key of the issuer (if Counter is set).
+type RevocationQueryRequest struct {
+	LDContext      string                   `json:"@context,omitempty"`
+	CredentialType CredentialTypeIdentifier `json:"type"`
+	Key            string                   `json:"revocationKey,omitempty"`
+	Counter        *uint                    `json:"counter,omitempty"`
+}
+
 type NonRevocationRequest struct {
 	Tolerance uint64                      `json:"tolerance,omitempty"`
 	Updates   map[uint]*revocation.Update `json:"updates,omitempty"`
@@ -250,6 +267,16 @@ func (r *RevocationRequest) Validate() error {
 	return nil
 }
 
+func (r *RevocationQueryRequest) Validate() error {
+	if r.LDContext != LDContextRevocationQueryRequest {
+		return errors.New("not a revocation query request")
+	}
+	if (r.Key == "") == (r.Counter == nil) {
+		return errors.New("revocation query request must specify either revocationKey or counter")
+	}
+	return nil
+}
+
 var (
 	bigZero = big.NewInt(0)
 	bigOne  = big.NewInt(1)
@@ -812,7 +839,11 @@ func (t Timestamp) IsZero() bool {
 	return time.Time(t).IsZero()
 }
 
+// MarshalXML implements xml.Marshaler. Zero timestamps are omitted.
 func (t *Timestamp) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if t.IsZero() {
+		return nil
+	}
 	return e.EncodeElement(t.String(), start)
 }
 
@@ -1040,6 +1071,17 @@ func (claims *RevocationJwt) Sign(method jwt.SigningMethod, key interface{}) (st
 	return jwt.NewWithClaims(method, claims).SignedString(key)
 }
 
+func (claims *RevocationQueryJwt) Valid() error {
+	if time.Time(claims.IssuedAt).After(time.Now()) {
+		return errors.New("Signature jwt not yet valid")
+	}
+	return nil
+}
+
+func (claims *RevocationQueryJwt) Sign(method jwt.SigningMethod, key interface{}) (string, error) {
+	return jwt.NewWithClaims(method, claims).SignedString(key)
+}
+
 func (claims *ServiceProviderJwt) Action() Action { return ActionDisclosing }
 
 func (claims *SignatureRequestorJwt) Action() Action { return ActionSigning }
@@ -28,14 +28,14 @@ import (
 )
 
 type (
-	// RevocationStorage stores and retrieves revocation-related data from and to a SQL database,
-	// and offers a revocation API for all other irmago code, including a Revoke() method that
+	// RevocationStorage stores and retrieves revocation-related data from and to a SQL or embedded
+	// bbolt database, and offers a revocation API for all other irmago code, including a Revoke() method that
 	// revokes an earlier issued credential.
 	RevocationStorage struct {
 		conf     *Configuration
-		sqldb    sqlRevStorage
+		db       revStorage
 		memdb    *memRevStorage
-		sqlMode  bool
+		dbMode   bool
 		settings RevocationSettings
 
 		Keys   RevocationKeys
@@ -45,6 +45,8 @@ type (
 
 		close  chan struct{}
 		events chan *sseclient.Event
+
+		statusMutex sync.Mutex
 	}
 
 	// RevocationClient offers an HTTP client to the revocation server endpoints.
@@ -64,14 +66,17 @@ type (
 	RevocationSetting struct {
 		Server              bool   `json:"server,omitempty" mapstructure:"server"`
 		Authority           bool   `json:"authority,omitempty" mapstructure:"authority"`
+		Standby             bool   `json:"standby,omitempty" mapstructure:"standby"`
 		RevocationServerURL string `json:"revocation_server_url,omitempty" mapstructure:"revocation_server_url"`
 		Tolerance           uint64 `json:"tolerance,omitempty" mapstructure:"tolerance"` // in seconds, min 30
 		SSE                 bool   `json:"sse,omitempty" mapstructure:"sse"`
 
 		// set to now whenever a new update is received, or when the RA indicates
 		// there are no new updates. Thus it specifies up to what time our nonrevocation
-		// guarantees lasts.
+		// guarantees lasts. Protected by RevocationStorage.statusMutex.
 		updated time.Time
+
+		counters revocationCounters
 	}
 
 	RevocationSettings map[CredentialTypeIdentifier]*RevocationSetting
@@ -110,6 +115,20 @@ type (
 		ValidUntil int64
 		RevokedAt  int64 `json:",omitempty"` // 0 if not currently revoked
 	}
+
+	// ReplicationRecord contains the state of a revocation authority or standby of a credential
+	// type with respect to replication and failover.
+	ReplicationRecord struct {
+		CredType CredentialTypeIdentifier `gorm:"primary_key"`
+		// Epoch is incremented whenever a standby is promoted to revocation authority.
+		Epoch uint64
+		// Fenced is set when a standby has been promoted to authority in our place,
+		// after which we must no longer act as authority.
+		Fenced bool
+		// IssuanceSynced is the time (in nanoseconds) up to which a standby has replicated
+		// the issuance records of its authority.
+		IssuanceSynced int64
+	}
 )
 
 var (
@@ -158,6 +177,22 @@ var RevocationParameters = struct {
 	// Cache-control: max-age HTTP return header (in seconds)
 	EventsCacheMaxAge uint64
 
+	// StandbySyncInterval is the time period in seconds with which revocation standbys
+	// synchronize with their revocation authority.
+	StandbySyncInterval uint64
+
+	// ReplicationRequestMaxAge is the maximum age in seconds of replication requests from
+	// revocation standbys accepted by revocation authorities.
+	ReplicationRequestMaxAge uint64
+
+	// BundleSize is the minimum amount of events in a revocation update bundle. Clients that miss
+	// at least this many events prefer update bundles over fetching the events in intervals.
+	BundleSize uint64
+
+	// BundlePublishInterval is the time period in minutes with which revocation servers check
+	// if a new update bundle should be published.
+	BundlePublishInterval uint64
+
 	UpdateMinCount      uint64
 	UpdateMaxCount      uint64
 	UpdateMinCountPower int
@@ -173,6 +208,10 @@ var RevocationParameters = struct {
 	UpdateMinCountPower:           4,
 	UpdateMaxCountPower:           9,
 	EventsCacheMaxAge:             60 * 60,
+	StandbySyncInterval:           10,
+	ReplicationRequestMaxAge:      5 * 60,
+	BundleSize:                    1024,
+	BundlePublishInterval:         60,
 }
 
 func init() {
@@ -199,7 +238,7 @@ func (rs *RevocationStorage) EnableRevocation(id CredentialTypeIdentifier, sk *r
 		return err
 	}
 
-	if err = rs.addUpdate(rs.sqldb, id, update, true); err != nil {
+	if err = rs.addUpdate(rs.db, id, update, true); err != nil {
 		return err
 	}
 	return nil
@@ -207,8 +246,8 @@ func (rs *RevocationStorage) EnableRevocation(id CredentialTypeIdentifier, sk *r
 
 // Exists returns whether or not an accumulator exists in the database for the given credential type.
 func (rs *RevocationStorage) Exists(id CredentialTypeIdentifier, counter uint) (bool, error) {
-	// only requires sql implementation
-	return rs.sqldb.Exists((*AccumulatorRecord)(nil), map[string]interface{}{"cred_type": id, "pk_counter": counter})
+	// only requires database implementation
+	return rs.db.AccumulatorExists(id, counter)
 }
 
 // Revocation update message methods
@@ -218,14 +257,11 @@ func (rs *RevocationStorage) Events(id CredentialTypeIdentifier, pkcounter uint,
 		return nil, errors.New("illegal update interval")
 	}
 
-	// Only requires SQL implementation
+	// Only requires database implementation
 	var events []*revocation.Event
-	if err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-		var records []*EventRecord
-		if err := tx.Find(&records,
-			"cred_type = ? and pk_counter = ? and eventindex >= ? and eventindex < ?",
-			id, pkcounter, from, to,
-		); err != nil {
+	if err := rs.db.Transaction(func(tx revStorage) error {
+		records, err := tx.Events(id, pkcounter, from, to)
+		if err != nil {
 			return err
 		}
 		if len(records) == 0 {
@@ -246,21 +282,23 @@ func (rs *RevocationStorage) Events(id CredentialTypeIdentifier, pkcounter uint,
 
 func (rs *RevocationStorage) UpdateLatest(id CredentialTypeIdentifier, count uint64, counter *uint) (map[uint]*revocation.Update, error) {
 	var updates map[uint]*revocation.Update
-	if rs.sqlMode {
-		if err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+	if rs.dbMode {
+		if err := rs.db.Transaction(func(tx revStorage) error {
 			var (
 				records []*AccumulatorRecord
 				events  []*EventRecord
 			)
-			where := map[string]interface{}{"cred_type": id}
-			if counter != nil {
-				where["pk_counter"] = *counter
+			record, err := tx.LatestAccumulator(id, counter)
+			if err == ErrRevocationStateNotFound {
+				updates = map[uint]*revocation.Update{}
+				return nil
 			}
-			if err := tx.Last(&records, where); err != nil {
+			if err != nil {
 				return err
 			}
+			records = append(records, record)
 			if count > 0 {
-				if err := tx.Latest(&events, count, where); err != nil {
+				if events, err = tx.LatestEvents(id, counter, count); err != nil {
 					return err
 				}
 			}
@@ -309,15 +347,22 @@ func (*RevocationStorage) newUpdates(records []*AccumulatorRecord, events []*Eve
 }
 
 func (rs *RevocationStorage) AddUpdate(id CredentialTypeIdentifier, record *revocation.Update) error {
-	if rs.sqlMode {
-		return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+	if rs.settings.Get(id).IsStandby() {
+		err := rs.addStandbyUpdate(id, record)
+		if err == errStandbyMissingEvents {
+			return rs.followAuthority(id, record.SignedAccumulator.PKCounter)
+		}
+		return err
+	}
+	if rs.dbMode {
+		return rs.db.Transaction(func(tx revStorage) error {
 			return rs.addUpdate(tx, id, record, false)
 		})
 	}
-	return rs.addUpdate(rs.sqldb, id, record, false)
+	return rs.addUpdate(rs.db, id, record, false)
 }
 
-func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdentifier, update *revocation.Update, create bool) error {
+func (rs *RevocationStorage) addUpdate(tx revStorage, id CredentialTypeIdentifier, update *revocation.Update, create bool) error {
 	// Unmarshal and verify the record against the appropriate public key
 	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), update.SignedAccumulator.PKCounter)
 	if err != nil {
@@ -328,16 +373,12 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 	}
 
 	// Save record
-	if rs.sqlMode {
-		save := tx.Save
-		if create {
-			save = tx.Insert
-		}
-		if err = save(new(AccumulatorRecord).Convert(id, update.SignedAccumulator)); err != nil {
+	if rs.dbMode {
+		if err = tx.SaveAccumulator(new(AccumulatorRecord).Convert(id, update.SignedAccumulator), create); err != nil {
 			return err
 		}
 		for _, event := range update.Events {
-			if err = tx.Insert(new(EventRecord).Convert(id, update.SignedAccumulator.PKCounter, event)); err != nil {
+			if err = tx.InsertEvent(new(EventRecord).Convert(id, update.SignedAccumulator.PKCounter, event)); err != nil {
 				return err
 			}
 		}
@@ -345,8 +386,7 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 		rs.memdb.Insert(id, update)
 	}
 
-	s := rs.settings.Get(id)
-	s.updated = time.Now()
+	rs.setUpdated(id)
 	// POST record to listeners, if any, asynchroniously
 	rs.PostUpdate(id, update)
 
@@ -356,16 +396,15 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 // Issuance records
 
 func (rs *RevocationStorage) AddIssuanceRecord(r *IssuanceRecord) error {
-	return rs.sqldb.Insert(r)
+	return rs.db.InsertIssuanceRecord(r)
 }
 
 func (rs *RevocationStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued time.Time) ([]*IssuanceRecord, error) {
-	where := map[string]interface{}{"cred_type": id, "revocationkey": key, "revoked_at": 0}
+	var nanos int64
 	if !issued.IsZero() {
-		where["Issued"] = issued.UnixNano()
+		nanos = issued.UnixNano()
 	}
-	var r []*IssuanceRecord
-	err := rs.sqldb.Find(&r, where)
+	r, err := rs.db.IssuanceRecords(id, key, nanos)
 	if err != nil {
 		return nil, err
 	}
@@ -382,78 +421,90 @@ func (rs *RevocationStorage) IssuanceRecords(id CredentialTypeIdentifier, key st
 // and updating the revocation database on disk.
 // If issued is not specified, i.e. passed the zero value, all credentials specified by key are revoked.
 func (rs *RevocationStorage) Revoke(id CredentialTypeIdentifier, key string, issued time.Time) error {
-	if !rs.settings.Get(id).Authority {
+	if !rs.settings.Get(id).IsAuthority() {
 		return errors.Errorf("cannot revoke %s", id)
 	}
-	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-		return rs.revoke(tx, id, key, issued)
+	return rs.db.Transaction(func(tx revStorage) error {
+		if err := rs.checkFenced(tx, id); err != nil {
+			return err
+		}
+		_, err := rs.revoke(tx, id, key, issued)
+		return err
 	})
 }
 
-func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifier, key string, issued time.Time) error {
+// revoke revokes the unrevoked credentials specified by key and issued, across all key counters,
+// and returns the amount of revoked credentials per key counter.
+func (rs *RevocationStorage) revoke(tx revStorage, id CredentialTypeIdentifier, key string, issued time.Time) (map[uint]int, error) {
 	var err error
 	issrecords, err := rs.IssuanceRecords(id, key, issued)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// get all relevant accumulators and events from the database
+	// get all relevant accumulators, events and private keys
 	accs, events, err := rs.revokeReadRecords(tx, id, issrecords)
+	if err != nil {
+		return nil, err
+	}
+	sks := map[uint]*revocation.PrivateKey{}
+	for counter := range accs {
+		if sks[counter], err = rs.Keys.PrivateKey(id.IssuerIdentifier(), counter); err != nil {
+			return nil, err
+		}
+	}
 
 	// For each issuance record, perform revocation, adding an Event and advancing the accumulator
+	counts := map[uint]int{}
 	for _, issrecord := range issrecords {
-		e := events[*issrecord.PKCounter]
-		newacc, event, err := rs.revokeCredential(tx, issrecord, accs[*issrecord.PKCounter], e[len(e)-1])
-		accs[*issrecord.PKCounter] = newacc
+		counter := *issrecord.PKCounter
+		e := events[counter]
+		newacc, event, err := rs.revokeCredential(tx, issrecord, sks[counter], accs[counter], e[len(e)-1])
 		if err != nil {
-			return err
+			return nil, err
 		}
-		events[*issrecord.PKCounter] = append(e, event)
+		accs[counter] = newacc
+		events[counter] = append(e, event)
+		counts[counter]++
 	}
 
 	// Gather accumulators and update events per key counter into revocation updates,
 	// and add them to the database
 	for counter := range accs {
-		sk, err := rs.Keys.PrivateKey(id.IssuerIdentifier(), counter)
-		if err != nil {
-			return err
-		}
 		// exclude parent event from the events
-		update, err := revocation.NewUpdate(sk, accs[counter], events[counter][1:])
+		update, err := revocation.NewUpdate(sks[counter], accs[counter], events[counter][1:])
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err = rs.addUpdate(tx, id, update, false); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return counts, nil
 }
 
 func (rs *RevocationStorage) revokeReadRecords(
-	tx sqlRevStorage,
+	tx revStorage,
 	id CredentialTypeIdentifier,
 	issrecords []*IssuanceRecord,
 ) (map[uint]*revocation.Accumulator, map[uint][]*revocation.Event, error) {
 	// gather all keys used in the issuance requests
 	var keycounters []uint
+	seen := map[uint]bool{}
 	for _, issrecord := range issrecords {
-		keycounters = append(keycounters, *issrecord.PKCounter)
+		if !seen[*issrecord.PKCounter] {
+			seen[*issrecord.PKCounter] = true
+			keycounters = append(keycounters, *issrecord.PKCounter)
+		}
 	}
 
 	// get all relevant accumulators from the database
-	var records []AccumulatorRecord
-	if err := tx.Find(&records, "cred_type = ? and pk_counter in (?)", id, keycounters); err != nil {
+	records, err := tx.Accumulators([]CredentialTypeIdentifier{id}, keycounters)
+	if err != nil {
 		return nil, nil, err
 	}
-	var eventrecords []EventRecord
-	err := tx.Find(&eventrecords, "eventindex = (?)", tx.gorm.
-		Table("event_records e2").
-		Select("max(e2.eventindex)").
-		Where("e2.cred_type = event_records.cred_type and e2.pk_counter = event_records.pk_counter").
-		QueryExpr(),
-	)
+	eventrecords, err := tx.LastEvents(id, keycounters)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -478,17 +529,14 @@ func (rs *RevocationStorage) revokeReadRecords(
 }
 
 func (rs *RevocationStorage) revokeCredential(
-	tx sqlRevStorage,
+	tx revStorage,
 	issrecord *IssuanceRecord,
+	sk *revocation.PrivateKey,
 	acc *revocation.Accumulator,
 	parent *revocation.Event,
 ) (*revocation.Accumulator, *revocation.Event, error) {
 	issrecord.RevokedAt = time.Now().UnixNano()
-	if err := tx.Save(&issrecord); err != nil {
-		return nil, nil, err
-	}
-	sk, err := rs.Keys.PrivateKey(issrecord.CredType.IssuerIdentifier(), *issrecord.PKCounter)
-	if err != nil {
+	if err := tx.SaveIssuanceRecord(issrecord); err != nil {
 		return nil, nil, err
 	}
 	newacc, event, err := acc.Remove(sk, (*big.Int)(issrecord.Attr), parent)
@@ -503,18 +551,18 @@ func (rs *RevocationStorage) revokeCredential(
 func (rs *RevocationStorage) Accumulator(id CredentialTypeIdentifier, pkcounter uint) (
 	*revocation.SignedAccumulator, error,
 ) {
-	return rs.accumulator(rs.sqldb, id, pkcounter)
+	return rs.accumulator(rs.db, id, pkcounter)
 }
 
 // accumulator retrieves, verifies and deserializes the accumulator of the given type and key.
-func (rs *RevocationStorage) accumulator(tx sqlRevStorage, id CredentialTypeIdentifier, pkcounter uint) (
+func (rs *RevocationStorage) accumulator(tx revStorage, id CredentialTypeIdentifier, pkcounter uint) (
 	*revocation.SignedAccumulator, error,
 ) {
 	var err error
 	var sacc *revocation.SignedAccumulator
-	if rs.sqlMode {
-		record := &AccumulatorRecord{}
-		if err = tx.Last(record, map[string]interface{}{"cred_type": id, "pk_counter": pkcounter}); err != nil {
+	if rs.dbMode {
+		record, err := tx.Accumulator(id, pkcounter)
+		if err != nil {
 			return nil, err
 		}
 		sacc = record.SignedAccumulator()
@@ -537,20 +585,22 @@ func (rs *RevocationStorage) accumulator(tx sqlRevStorage, id CredentialTypeIden
 }
 
 func (rs *RevocationStorage) updateAccumulatorTimes() error {
-	if !rs.sqlMode {
+	if !rs.dbMode {
 		return nil
 	}
 	var types []CredentialTypeIdentifier
-	for id, settings := range rs.settings {
-		if settings.Authority {
+	for id, settings := range rs.settings.Snapshot() {
+		if settings.IsAuthority() {
 			types = append(types, id)
 		}
 	}
-	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-		var err error
-		var records []AccumulatorRecord
+	if len(types) == 0 {
+		return nil
+	}
+	return rs.db.Transaction(func(tx revStorage) error {
 		Logger.Tracef("updating accumulator times")
-		if err = tx.Find(&records, "cred_type in (?)", types); err != nil {
+		records, err := tx.Accumulators(types, nil)
+		if err != nil {
 			return err
 		}
 		for _, r := range records {
@@ -572,12 +622,11 @@ func (rs *RevocationStorage) updateAccumulatorTimes() error {
 				return err
 			}
 			r.Data = signedMessage(sacc.Data)
-			if err = tx.Save(r); err != nil {
+			if err = tx.SaveAccumulator(r, false); err != nil {
 				return err
 			}
 
-			s := rs.settings.Get(r.CredType)
-			s.updated = time.Now()
+			rs.setUpdated(r.CredType)
 			// POST record to listeners, if any, asynchroniously
 			rs.PostUpdate(r.CredType, &revocation.Update{SignedAccumulator: sacc})
 		}
@@ -592,8 +641,10 @@ func (rs *RevocationStorage) SyncDB(id CredentialTypeIdentifier) error {
 	if ct == nil {
 		return ErrorUnknownCredentialType
 	}
-	if settings, ok := rs.settings[id]; ok && settings.Authority {
+	if settings := rs.settings.Lookup(id); settings.IsAuthority() {
 		return nil
+	} else if settings.IsStandby() {
+		return rs.syncStandby(id)
 	}
 
 	Logger.WithField("credtype", id).Tracef("fetching revocation updates")
@@ -607,13 +658,14 @@ func (rs *RevocationStorage) SyncDB(id CredentialTypeIdentifier) error {
 		}
 	}
 	// bump updated even if no new records were added
-	rs.settings.Get(id).updated = time.Now()
+	rs.setUpdated(id)
 	return nil
 }
 
 func (rs *RevocationStorage) SyncIfOld(id CredentialTypeIdentifier, maxage uint64) error {
-	if rs.settings.Get(id).updated.Before(time.Now().Add(time.Duration(-maxage) * time.Second)) {
+	if rs.updatedAt(id).Before(time.Now().Add(time.Duration(-maxage) * time.Second)) {
 		if err := rs.SyncDB(id); err != nil {
+			rs.recordSyncFailure(id, err)
 			return err
 		}
 	}
@@ -633,19 +685,20 @@ func (rs *RevocationStorage) SaveIssuanceRecord(id CredentialTypeIdentifier, rec
 
 	// Just store it if we are the revocation server for this credential type
 	settings := rs.settings.Get(id)
-	if settings.Authority {
+	if settings.IsAuthority() {
 		return rs.AddIssuanceRecord(rec)
 	}
 
 	// We have to send it, sign it first
-	if settings.RevocationServerURL == "" {
+	url := settings.ServerURL()
+	if url == "" {
 		return errors.New("cannot send issuance record: no server_url configured")
 	}
 	rsk, err := sk.RevocationKey()
 	if err != nil {
 		return err
 	}
-	return rs.client.PostIssuanceRecord(id, rsk, rec, settings.RevocationServerURL)
+	return rs.client.PostIssuanceRecord(id, rsk, rec, url)
 }
 
 // Misscelaneous methods
@@ -669,6 +722,7 @@ func (rs *RevocationStorage) handleSSEUpdates() {
 				logger.Warn("failed to unmarshal pushed update: ", err)
 			} else {
 				logger.Trace("received SSE update event")
+				rs.recordSSEEvent(id)
 				if err = rs.AddUpdate(id, &update); err != nil {
 					logger.Warn("failed to add pushed update: ", err)
 				}
@@ -694,16 +748,17 @@ func (rs *RevocationStorage) listenUpdates(id CredentialTypeIdentifier, url stri
 			return
 		}
 	}()
+	rs.recordSSEState(id, true, nil)
 	err := sseclient.Notify(ctx, url, true, rs.events)
+	rs.recordSSEState(id, false, err)
 	if err != nil {
 		logger.Warn("SSE connection closed: ", err)
 	}
 }
 
 func updateURL(id CredentialTypeIdentifier, conf *Configuration, rs RevocationSettings) ([]string, error) {
-	settings := rs[id]
-	if settings != nil && settings.RevocationServerURL != "" {
-		return []string{settings.RevocationServerURL}, nil
+	if url := rs.Lookup(id).ServerURL(); url != "" {
+		return []string{url}, nil
 	} else {
 		credtype := conf.CredentialTypes[id]
 		if credtype == nil {
@@ -717,10 +772,23 @@ func updateURL(id CredentialTypeIdentifier, conf *Configuration, rs RevocationSe
 }
 
 func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings RevocationSettings) error {
+	if settings == nil {
+		settings = RevocationSettings{}
+	}
 	settings.fixCase(rs.conf)
 	settings.fixSlash()
+	rs.settings = settings
 	var t *CredentialTypeIdentifier
 	for id, s := range settings {
+		if s.Standby {
+			if s.Authority {
+				return errors.Errorf("revocation standby mode for %s cannot be combined with authority mode", id.String())
+			}
+			if s.RevocationServerURL == "" {
+				return errors.Errorf("revocation standby mode for %s requires URL of authority to be configured", id.String())
+			}
+			s.Server = true
+		}
 		if !s.Authority {
 			if s.Server && s.RevocationServerURL == "" {
 				return errors.Errorf("revocation server mode for %s requires URL to be configured", id.String())
@@ -749,7 +817,7 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 		}
 	}
 	if t != nil && connstr == "" {
-		return errors.Errorf("revocation mode for %s requires SQL database but no connection string given", *t)
+		return errors.Errorf("revocation mode for %s requires a database but no connection string given", *t)
 	}
 
 	rs.conf.Scheduler.Every(RevocationParameters.AccumulatorUpdateInterval).Seconds().Do(func() {
@@ -759,11 +827,26 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 		}
 	})
 
+	rs.conf.Scheduler.Every(RevocationParameters.AccumulatorUpdateInterval).Seconds().Do(rs.checkStaleness)
+
+	rs.conf.Scheduler.Every(RevocationParameters.StandbySyncInterval).Seconds().Do(func() {
+		for id, s := range rs.settings.Snapshot() {
+			if !s.IsStandby() {
+				continue
+			}
+			if err := rs.syncStandby(id); err != nil {
+				err = errors.WrapPrefix(err, fmt.Sprintf("failed to synchronize %s with revocation authority", id), 0)
+				raven.CaptureError(err, nil)
+				Logger.Warn(err)
+			}
+		}
+	})
+
 	rs.conf.Scheduler.Every(RevocationParameters.DeleteIssuanceRecordsInterval).Minutes().Do(func() {
-		if !rs.sqlMode {
+		if !rs.dbMode {
 			return
 		}
-		if err := rs.sqldb.Delete(IssuanceRecord{}, "valid_until < ?", time.Now().UnixNano()); err != nil {
+		if err := rs.db.DeleteExpiredIssuanceRecords(time.Now().UnixNano()); err != nil {
 			err = errors.WrapPrefix(err, "failed to delete expired issuance records", 0)
 			raven.CaptureError(err, nil)
 		}
@@ -772,20 +855,15 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	if connstr == "" {
 		Logger.Trace("Using memory revocation database")
 		rs.memdb = newMemStorage()
-		rs.sqlMode = false
+		rs.dbMode = false
 	} else {
-		Logger.Trace("Connecting to revocation SQL database")
-		db, err := newSqlStorage(debug, dbtype, connstr)
+		Logger.WithField("type", dbtype).Trace("Connecting to revocation database")
+		db, err := newRevStorage(debug, dbtype, connstr, !rs.conf.options.RevocationDBNoMigrate)
 		if err != nil {
 			return err
 		}
-		rs.sqldb = db
-		rs.sqlMode = true
-	}
-	if settings != nil {
-		rs.settings = settings
-	} else {
-		rs.settings = RevocationSettings{}
+		rs.db = db
+		rs.dbMode = true
 	}
 	for id, settings := range rs.settings {
 		if settings.Tolerance != 0 && settings.Tolerance < 30 {
@@ -795,6 +873,15 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	}
 	rs.client = RevocationClient{Conf: rs.conf, Settings: rs.settings}
 	rs.Keys = RevocationKeys{Conf: rs.conf}
+	rs.scheduleBundlePublishing()
+	for id, s := range rs.settings {
+		if !s.Authority {
+			continue
+		}
+		if err := rs.checkFenced(rs.db, id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -802,7 +889,10 @@ func (rs *RevocationStorage) Close() error {
 	if rs.close != nil {
 		close(rs.close)
 	}
-	return rs.sqldb.Close()
+	if rs.db == nil {
+		return nil
+	}
+	return rs.db.Close()
 }
 
 // SetRevocationUpdates retrieves the latest revocation records from the database, and attaches
@@ -827,12 +917,17 @@ func (rs *RevocationStorage) SetRevocationUpdates(b *BaseRequest) error {
 			tolerance = params.Tolerance
 		}
 		if err = rs.SyncIfOld(credid, tolerance/2); err != nil {
-			updated := settings.updated
+			updated := rs.updatedAt(credid)
 			if !updated.IsZero() {
 				Logger.Warnf("failed to fetch revocation updates for %s, nonrevocation is guaranteed only until %s ago:",
 					credid, time.Now().Sub(updated).String())
 				Logger.Warn(err)
+				if time.Now().Sub(updated) > time.Duration(tolerance)*time.Second {
+					Logger.Warnf("nonrevocation guarantee for %s exceeds tolerance of %d seconds", credid, tolerance)
+					rs.recordToleranceViolation(credid)
+				}
 			} else {
+				rs.recordToleranceViolation(credid)
 				Logger.Errorf("revocation is disabled for %s: failed to fetch revocation updates and none are known locally", credid)
 				Logger.Warn(err)
 				// We can offer no nonrevocation guarantees at all while the requestor explicitly
@@ -849,7 +944,7 @@ func (rs *RevocationStorage) SetRevocationUpdates(b *BaseRequest) error {
 }
 
 func (rs *RevocationStorage) PostUpdate(id CredentialTypeIdentifier, update *revocation.Update) {
-	if rs.ServerSentEvents == nil || !rs.settings.Get(id).Authority {
+	if rs.ServerSentEvents == nil || !rs.settings.Get(id).IsAuthority() {
 		return
 	}
 	Logger.WithField("credtype", id).Tracef("sending SSE update event")
@@ -891,6 +986,16 @@ func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkco
 		return update, err
 	}
 
+	// If we are far behind, first try to fetch the missing events from the update bundles,
+	// which are cheaper to fetch and to serve than many event intervals
+	var bundled []*revocation.Event
+	if to-from+1 >= RevocationParameters.BundleSize {
+		bundled, from = client.fetchBundledEvents(id, pkcounter, from, to)
+		if from > to {
+			return update, update.Prepend(revocation.NewEventList(bundled...))
+		}
+	}
+
 	// Fetch events not included in the response above
 	indices := binaryPartition(from, to)
 	eventsChan := make(chan *revocation.EventList)
@@ -932,6 +1037,9 @@ func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkco
 	if err != nil {
 		return nil, err
 	}
+	if len(bundled) > 0 {
+		el = revocation.NewEventList(append(bundled, el.Events...)...)
+	}
 	return update, update.Prepend(el)
 }
 
@@ -1113,7 +1221,16 @@ func (i *RevocationAttribute) UnmarshalCBOR(data []byte) error {
 	return cbor.Unmarshal(data, (*big.Int)(i))
 }
 
+// revocationSettingsMutex protects RevocationSettings maps, into which Get() inserts settings
+// for credential types for which nonrevocation proofs are requested, possibly concurrently;
+// and the Authority, Standby and RevocationServerURL fields of RevocationSetting, which change
+// when a revocation standby is promoted to authority. Once the settings are in use, these fields
+// must be read using IsAuthority(), IsStandby() and ServerURL().
+var revocationSettingsMutex sync.Mutex
+
 func (rs RevocationSettings) Get(id CredentialTypeIdentifier) *RevocationSetting {
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
 	if rs[id] == nil {
 		rs[id] = &RevocationSetting{}
 	}
@@ -1124,6 +1241,66 @@ func (rs RevocationSettings) Get(id CredentialTypeIdentifier) *RevocationSetting
 	return s
 }
 
+// Lookup returns the settings of the specified credential type, or nil if there are none.
+func (rs RevocationSettings) Lookup(id CredentialTypeIdentifier) *RevocationSetting {
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
+	return rs[id]
+}
+
+// Snapshot returns a copy of the map, which can be ranged over while Get() inserts into rs.
+func (rs RevocationSettings) Snapshot() RevocationSettings {
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
+	snapshot := make(RevocationSettings, len(rs))
+	for id, s := range rs {
+		snapshot[id] = s
+	}
+	return snapshot
+}
+
+// IsAuthority returns whether we are the revocation authority of the credential type.
+// It returns false if s is nil.
+func (s *RevocationSetting) IsAuthority() bool {
+	if s == nil {
+		return false
+	}
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
+	return s.Authority
+}
+
+// IsStandby returns whether we are a revocation standby of the credential type.
+// It returns false if s is nil.
+func (s *RevocationSetting) IsStandby() bool {
+	if s == nil {
+		return false
+	}
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
+	return s.Standby
+}
+
+// ServerURL returns the URL of the revocation server of the credential type, if configured.
+// It returns "" if s is nil.
+func (s *RevocationSetting) ServerURL() string {
+	if s == nil {
+		return ""
+	}
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
+	return s.RevocationServerURL
+}
+
+// setMode sets whether we are revocation authority or standby, and the revocation server URL.
+func (s *RevocationSetting) setMode(authority, standby bool, url string) {
+	revocationSettingsMutex.Lock()
+	defer revocationSettingsMutex.Unlock()
+	s.Authority = authority
+	s.Standby = standby
+	s.RevocationServerURL = url
+}
+
 func (rs RevocationSettings) fixCase(conf *Configuration) {
 	for id := range conf.CredentialTypes {
 		idlc := NewCredentialTypeIdentifier(strings.ToLower(id.String()))
@@ -0,0 +1,252 @@
+package irma
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// Auditing the revocation state of credential types.
+//
+// An audit retrieves the signed accumulator and all events of each revocation key of a credential
+// type, either from a revocation server or directly from a revocation database, and checks that the
+// events form a hash chain with consecutive indices starting at 0 that ends in the accumulator, and
+// that the accumulator is validly signed by the issuer. Instead of stopping at the first problem, all
+// problems found are reported, so that audits can be run periodically to monitor revocation servers.
+
+type (
+	// RevocationAudit contains the results of auditing the revocation state of a credential type.
+	RevocationAudit struct {
+		CredentialType CredentialTypeIdentifier `json:"type"`
+		Keys           []*RevocationKeyAudit    `json:"keys"`
+	}
+
+	// RevocationKeyAudit contains the results of auditing the accumulator and events of a revocation key.
+	RevocationKeyAudit struct {
+		Counter  uint     `json:"counter"`
+		Index    uint64   `json:"index"` // index of the accumulator
+		Time     int64    `json:"time"`  // Unix time of the accumulator
+		Events   int      `json:"events"`
+		Problems []string `json:"problems,omitempty"`
+	}
+
+	// revocationAuditSource retrieves the revocation state to be audited.
+	revocationAuditSource interface {
+		// accumulator returns the signed accumulator of the key, or nil if it has none.
+		accumulator(id CredentialTypeIdentifier, counter uint) (*revocation.SignedAccumulator, error)
+		// events returns all events of the key, in order.
+		events(id CredentialTypeIdentifier, counter uint) ([]*revocation.Event, error)
+	}
+
+	revocationServerAuditSource struct {
+		client RevocationClient
+		// updates caches the updates fetched along with the accumulators, containing the latest events
+		updates map[uint]*revocation.Update
+	}
+
+	revocationDBAuditSource struct {
+		db revStorage
+	}
+)
+
+// OK returns whether no problems were found during the audit.
+func (audit *RevocationAudit) OK() bool {
+	for _, k := range audit.Keys {
+		if len(k.Problems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// AuditRevocationServer audits the revocation state of the credential type served by the revocation
+// server at the specified URL, or if url is empty, by the revocation servers from the scheme.
+func AuditRevocationServer(conf *Configuration, id CredentialTypeIdentifier, url string) (*RevocationAudit, error) {
+	settings := RevocationSettings{}
+	if url != "" {
+		settings[id] = &RevocationSetting{RevocationServerURL: url}
+	}
+	return auditRevocation(conf, id, &revocationServerAuditSource{
+		client:  RevocationClient{Conf: conf, Settings: settings},
+		updates: map[uint]*revocation.Update{},
+	})
+}
+
+// AuditRevocationDB audits the revocation state of the credential type in the specified revocation database.
+func AuditRevocationDB(conf *Configuration, id CredentialTypeIdentifier, dbtype, connstr string) (*RevocationAudit, error) {
+	db, err := openRevStorage(false, dbtype, connstr, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	return auditRevocation(conf, id, revocationDBAuditSource{db: db})
+}
+
+func auditRevocation(conf *Configuration, id CredentialTypeIdentifier, source revocationAuditSource) (*RevocationAudit, error) {
+	credtype := conf.CredentialTypes[id]
+	if credtype == nil {
+		return nil, ErrorUnknownCredentialType
+	}
+	if !credtype.RevocationSupported() {
+		return nil, errors.New("credential type does not support revocation")
+	}
+	counters, err := conf.PublicKeyIndices(id.IssuerIdentifier())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i] < counters[j] })
+
+	audit := &RevocationAudit{CredentialType: id}
+	keys := RevocationKeys{Conf: conf}
+	for _, counter := range counters {
+		pk, err := keys.PublicKey(id.IssuerIdentifier(), counter)
+		if err != nil {
+			continue // key does not support revocation
+		}
+		sacc, err := source.accumulator(id, counter)
+		if err != nil {
+			audit.Keys = append(audit.Keys, &RevocationKeyAudit{
+				Counter:  counter,
+				Problems: []string{fmt.Sprintf("failed to retrieve accumulator: %s", err)},
+			})
+			continue
+		}
+		if sacc == nil {
+			continue // revocation not enabled for this key
+		}
+		audit.Keys = append(audit.Keys, auditRevocationKey(id, counter, pk, sacc, source))
+	}
+	return audit, nil
+}
+
+func auditRevocationKey(
+	id CredentialTypeIdentifier, counter uint, pk *revocation.PublicKey,
+	sacc *revocation.SignedAccumulator, source revocationAuditSource,
+) *RevocationKeyAudit {
+	audit := &RevocationKeyAudit{Counter: counter}
+	problem := func(format string, args ...interface{}) {
+		audit.Problems = append(audit.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if sacc.PKCounter != counter {
+		problem("accumulator is of key %d", sacc.PKCounter)
+	}
+	acc, err := sacc.UnmarshalVerify(pk)
+	if err != nil {
+		problem("accumulator has invalid signature: %s", err)
+		return audit
+	}
+	audit.Index, audit.Time = acc.Index, acc.Time
+
+	events, err := source.events(id, counter)
+	if err != nil {
+		problem("failed to retrieve events: %s", err)
+		return audit
+	}
+	audit.Events = len(events)
+	if len(events) == 0 {
+		problem("no events")
+		return audit
+	}
+
+	for i, event := range events {
+		if event.Index != uint64(i) {
+			problem("event %d has index %d", i, event.Index)
+		}
+		if i > 0 && !eventHashEquals(events[i-1], event.ParentHash) {
+			problem("event %d has wrong parent hash", event.Index)
+		}
+	}
+	last := events[len(events)-1]
+	if last.Index != acc.Index {
+		problem("last event has index %d but accumulator has index %d", last.Index, acc.Index)
+	}
+	if !eventHashEquals(last, acc.EventHash) {
+		problem("accumulator does not contain hash of last event")
+	}
+	return audit
+}
+
+// eventHashEquals returns whether the hash of the event equals the specified hash.
+func eventHashEquals(event *revocation.Event, hash revocation.Hash) bool {
+	// Computing event hashes is not exposed by gabi, but verifying a single event against
+	// an accumulator amounts to checking its hash
+	return revocation.NewEventList(event).Verify(&revocation.Accumulator{EventHash: hash}) == nil
+}
+
+func (s *revocationServerAuditSource) accumulator(id CredentialTypeIdentifier, counter uint) (*revocation.SignedAccumulator, error) {
+	update, err := s.client.FetchUpdateLatest(id, counter, RevocationParameters.UpdateMinCount)
+	if err != nil {
+		return nil, err
+	}
+	if update == nil {
+		return nil, nil
+	}
+	s.updates[counter] = update
+	return update.SignedAccumulator, nil
+}
+
+func (s *revocationServerAuditSource) events(id CredentialTypeIdentifier, counter uint) ([]*revocation.Event, error) {
+	update := s.updates[counter]
+	if len(update.Events) == 0 {
+		return nil, nil
+	}
+
+	// Fetch the events preceding those included in the update in intervals, like FetchUpdateFrom()
+	// but without verifying them, so that we can report all problems
+	var events []*revocation.Event
+	if first := update.Events[0].Index; first > 0 {
+		urls, err := updateURL(id, s.client.Conf, s.client.Settings)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range binaryPartition(0, first-1) {
+			el := &revocation.EventList{}
+			path := fmt.Sprintf("/revocation/%s/events/%d/%d/%d", id, counter, i[0], i[1])
+			if err = s.client.getMultiple(urls, path, el); err != nil {
+				return nil, err
+			}
+			events = append(events, el.Events...)
+		}
+	}
+
+	// The last interval may overlap with the events from the update; skip those that we already have
+	have := map[uint64]*revocation.Event{}
+	for _, e := range events {
+		have[e.Index] = e
+	}
+	for _, e := range update.Events {
+		if h := have[e.Index]; h != nil && h.E.Cmp(e.E) == 0 && h.ParentHash.Equal(e.ParentHash) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s revocationDBAuditSource) accumulator(id CredentialTypeIdentifier, counter uint) (*revocation.SignedAccumulator, error) {
+	record, err := s.db.Accumulator(id, counter)
+	if err == ErrRevocationStateNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.SignedAccumulator(), nil
+}
+
+func (s revocationDBAuditSource) events(id CredentialTypeIdentifier, counter uint) ([]*revocation.Event, error) {
+	// Retrieve all events, including any beyond the accumulator which would be inconsistent
+	records, err := s.db.Events(id, counter, 0, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*revocation.Event, 0, len(records))
+	for _, r := range records {
+		events = append(events, r.Event())
+	}
+	return events, nil
+}
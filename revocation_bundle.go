@@ -0,0 +1,262 @@
+package irma
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/getsentry/raven-go"
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// Revocation update bundles.
+//
+// Clients that have not updated their witness for a long time would have to fetch many intervals
+// of events from the revocation server. Instead, revocation servers can periodically publish update
+// bundles: revocation updates consisting of the signed accumulator at the time of publishing, and
+// all events since the previous bundle. Each bundle verifies by itself against the issuer public
+// key, and published bundles never change, so that they can be served as static files, e.g. from a
+// CDN. For each key, an index lists the event range of each published bundle.
+//
+// Bundles are written to the directory specified by ConfigurationOptions.RevocationBundleDir,
+// using the same layout as the URLs at which the IRMA server serves them:
+//   revocation/<credtype>/bundle/<counter>/index
+//   revocation/<credtype>/bundle/<counter>/<index of first event of the bundle>
+
+type (
+	// RevocationBundleIndex lists the update bundles published for a revocation key, in order.
+	RevocationBundleIndex struct {
+		Bundles []RevocationBundleRange `json:"bundles"`
+	}
+
+	// RevocationBundleRange specifies the indices of the first and last events of an update bundle.
+	RevocationBundleRange struct {
+		From uint64 `json:"from"`
+		To   uint64 `json:"to"`
+	}
+)
+
+const revocationBundleIndexName = "index"
+
+// revocationBundlePath returns the path of the update bundle or bundle index file with the specified name,
+// relative to the bundle directory.
+func revocationBundlePath(id CredentialTypeIdentifier, counter uint, name string) string {
+	return filepath.Join("revocation", id.String(), "bundle", strconv.FormatUint(uint64(counter), 10), name)
+}
+
+// ReadBundleFile returns the contents of the specified published update bundle or bundle index.
+func (rs *RevocationStorage) ReadBundleFile(id CredentialTypeIdentifier, counter uint, name string) ([]byte, error) {
+	dir := rs.conf.options.RevocationBundleDir
+	if dir == "" {
+		return nil, errors.New("revocation update bundles not enabled")
+	}
+	if name != revocationBundleIndexName {
+		if _, err := strconv.ParseUint(name, 10, 64); err != nil {
+			return nil, errors.Errorf("invalid bundle name %s", name)
+		}
+	}
+	return ioutil.ReadFile(filepath.Join(dir, revocationBundlePath(id, counter, name)))
+}
+
+// PublishBundles publishes a new update bundle for each key of the credential types for which we
+// are a revocation server, if at least RevocationParameters.BundleSize events have occurred since
+// the previous bundle.
+func (rs *RevocationStorage) PublishBundles() error {
+	if !rs.dbMode || rs.conf.options.RevocationBundleDir == "" {
+		return nil
+	}
+	var types []CredentialTypeIdentifier
+	for id, settings := range rs.settings.Snapshot() {
+		if settings.Server {
+			types = append(types, id)
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	records, err := rs.db.Accumulators(types, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err = rs.publishBundle(r.CredType, *r.PKCounter); err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("failed to publish update bundle of %s-%d", r.CredType, *r.PKCounter), 0)
+		}
+	}
+	return nil
+}
+
+func (rs *RevocationStorage) publishBundle(id CredentialTypeIdentifier, counter uint) error {
+	dir := rs.conf.options.RevocationBundleDir
+	index := &RevocationBundleIndex{}
+	bts, err := rs.ReadBundleFile(id, counter, revocationBundleIndexName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = UnmarshalBinary(bts, index); err != nil {
+			return err
+		}
+	}
+	var from uint64
+	if len(index.Bundles) > 0 {
+		from = index.Bundles[len(index.Bundles)-1].To + 1
+	}
+
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
+	if err != nil {
+		return err
+	}
+	update := &revocation.Update{}
+	var acc *revocation.Accumulator
+	err = rs.db.Transaction(func(tx revStorage) error {
+		record, err := tx.Accumulator(id, counter)
+		if err != nil {
+			return err
+		}
+		update.SignedAccumulator = record.SignedAccumulator()
+		if acc, err = update.SignedAccumulator.UnmarshalVerify(pk); err != nil {
+			return err
+		}
+		if acc.Index+1 < from+RevocationParameters.BundleSize {
+			return nil
+		}
+		records, err := tx.Events(id, counter, from, acc.Index+1)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			update.Events = append(update.Events, r.Event())
+		}
+		return nil
+	})
+	if err != nil || len(update.Events) == 0 {
+		return err
+	}
+	if _, err = update.Verify(pk); err != nil {
+		return errors.WrapPrefix(err, "update bundle does not verify", 0)
+	}
+
+	// Write the bundle before adding it to the index, so that the index only refers to existing bundles
+	if bts, err = MarshalBinary(update); err != nil {
+		return err
+	}
+	if err = writeBundleFile(filepath.Join(dir, revocationBundlePath(id, counter, strconv.FormatUint(from, 10))), bts); err != nil {
+		return err
+	}
+	index.Bundles = append(index.Bundles, RevocationBundleRange{From: from, To: acc.Index})
+	if bts, err = MarshalBinary(index); err != nil {
+		return err
+	}
+	if err = writeBundleFile(filepath.Join(dir, revocationBundlePath(id, counter, revocationBundleIndexName)), bts); err != nil {
+		return err
+	}
+	Logger.WithFields(map[string]interface{}{"credtype": id, "counter": counter, "from": from, "to": acc.Index}).
+		Info("Published revocation update bundle")
+	return nil
+}
+
+// writeBundleFile atomically writes the file, so that it is never served partially written.
+// Unlike common.SaveFile it makes the file world-readable, so that it can be served by other web servers.
+func writeBundleFile(path string, bts []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bts, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (rs *RevocationStorage) scheduleBundlePublishing() {
+	if rs.conf.options.RevocationBundleDir == "" {
+		return
+	}
+	rs.conf.Scheduler.Every(RevocationParameters.BundlePublishInterval).Minutes().Do(func() {
+		if err := rs.PublishBundles(); err != nil {
+			raven.CaptureError(err, nil)
+			Logger.Warn(err)
+		}
+	})
+}
+
+// FetchBundleIndex fetches the index of the update bundles of the specified key.
+func (client RevocationClient) FetchBundleIndex(id CredentialTypeIdentifier, pkcounter uint) (*RevocationBundleIndex, error) {
+	urls, err := updateURL(id, client.Conf, client.Settings)
+	if err != nil {
+		return nil, err
+	}
+	index := &RevocationBundleIndex{}
+	return index, client.getMultiple(
+		urls,
+		fmt.Sprintf("/revocation/%s/bundle/%d/%s", id, pkcounter, revocationBundleIndexName),
+		index,
+	)
+}
+
+// FetchBundle fetches the update bundle of the specified key starting at the specified event index,
+// and verifies it.
+func (client RevocationClient) FetchBundle(id CredentialTypeIdentifier, pkcounter uint, from uint64) (*revocation.Update, error) {
+	urls, err := updateURL(id, client.Conf, client.Settings)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := RevocationKeys{client.Conf}.PublicKey(id.IssuerIdentifier(), pkcounter)
+	if err != nil {
+		return nil, err
+	}
+	update := &revocation.Update{}
+	if err = client.getMultiple(urls, fmt.Sprintf("/revocation/%s/bundle/%d/%d", id, pkcounter, from), update); err != nil {
+		return nil, err
+	}
+	if _, err = update.Verify(pk); err != nil {
+		return nil, err
+	}
+	if len(update.Events) == 0 || update.Events[0].Index != from {
+		return nil, errors.New("update bundle does not start at the requested event")
+	}
+	return update, nil
+}
+
+// fetchBundledEvents fetches the events in [from, to] from the update bundles of the specified key,
+// as far as they are covered by bundles. It returns the events and the index of the first event
+// that still has to be fetched. Failing to fetch bundles is not fatal, as the remaining events can
+// still be fetched from the revocation server.
+func (client RevocationClient) fetchBundledEvents(id CredentialTypeIdentifier, pkcounter uint, from, to uint64) ([]*revocation.Event, uint64) {
+	index, err := client.FetchBundleIndex(id, pkcounter)
+	if err != nil {
+		Logger.WithField("error", err).Debug("failed to fetch revocation update bundle index")
+		return nil, from
+	}
+	var events []*revocation.Event
+	next := from
+	for _, b := range index.Bundles {
+		if b.To < next {
+			continue
+		}
+		// Skip bundles that are not contiguous with what we have, or that would mostly consist of
+		// events that we already have
+		if b.From > next || next-b.From > b.To-next {
+			break
+		}
+		bundle, err := client.FetchBundle(id, pkcounter, b.From)
+		if err != nil {
+			Logger.WithField("error", err).Debug("failed to fetch revocation update bundle")
+			break
+		}
+		for _, e := range bundle.Events {
+			if e.Index >= next && e.Index <= to {
+				events = append(events, e)
+				next = e.Index + 1
+			}
+		}
+		if next > to {
+			break
+		}
+	}
+	return events, next
+}
@@ -11,6 +11,65 @@ import (
 )
 
 type (
+	// revStorage is a persistent database for the revocation records of revocation authorities
+	// and servers (EventRecord, AccumulatorRecord and IssuanceRecord). It is implemented by
+	// sqlRevStorage and boltRevStorage. Methods that look up a single record return
+	// ErrRevocationStateNotFound if it does not exist.
+	revStorage interface {
+		Close() error
+		// Transaction executes f within a transaction, which is rolled back if f returns an error.
+		Transaction(f func(tx revStorage) error) error
+
+		AccumulatorExists(id CredentialTypeIdentifier, counter uint) (bool, error)
+		// Accumulator returns the accumulator record of the specified credential type and key.
+		Accumulator(id CredentialTypeIdentifier, counter uint) (*AccumulatorRecord, error)
+		// LatestAccumulator returns the accumulator record of the specified key, or if counter is nil,
+		// that of the highest key counter.
+		LatestAccumulator(id CredentialTypeIdentifier, counter *uint) (*AccumulatorRecord, error)
+		// Accumulators returns the accumulator records of the specified credential types, restricted
+		// to the specified key counters if any are given.
+		Accumulators(ids []CredentialTypeIdentifier, counters []uint) ([]*AccumulatorRecord, error)
+		// SaveAccumulator inserts (if create is true) or updates the accumulator record.
+		SaveAccumulator(record *AccumulatorRecord, create bool) error
+
+		InsertEvent(record *EventRecord) error
+		// Events returns the event records of the specified key with index within [from, to), in order.
+		Events(id CredentialTypeIdentifier, counter uint, from, to uint64) ([]*EventRecord, error)
+		// LatestEvents returns the count latest event records of the specified key, or of any key
+		// if counter is nil, in reverse order.
+		LatestEvents(id CredentialTypeIdentifier, counter *uint, count uint64) ([]*EventRecord, error)
+		// LastEvents returns the last event record of each of the specified keys.
+		LastEvents(id CredentialTypeIdentifier, counters []uint) ([]*EventRecord, error)
+		// EventByAttribute returns the event record of the specified key that removed the specified
+		// revocation attribute from the accumulator.
+		EventByAttribute(id CredentialTypeIdentifier, counter uint, e *RevocationAttribute) (*EventRecord, error)
+
+		InsertIssuanceRecord(record *IssuanceRecord) error
+		SaveIssuanceRecord(record *IssuanceRecord) error
+		// IssuanceRecords returns the unrevoked issuance records having the specified revocation key,
+		// restricted to those issued at the specified time (in nanoseconds) if nonzero.
+		IssuanceRecords(id CredentialTypeIdentifier, key string, issued int64) ([]*IssuanceRecord, error)
+		// DeleteExpiredIssuanceRecords deletes the issuance records of credentials that expired
+		// before the specified time (in nanoseconds).
+		DeleteExpiredIssuanceRecords(before int64) error
+		// IssuanceRecordsSince returns all issuance records, including revoked ones, that were issued
+		// or revoked at or after the specified time (in nanoseconds).
+		IssuanceRecordsSince(id CredentialTypeIdentifier, since int64) ([]*IssuanceRecord, error)
+		// AllIssuanceRecords returns all issuance records having the specified revocation key,
+		// including revoked ones.
+		AllIssuanceRecords(id CredentialTypeIdentifier, key string) ([]*IssuanceRecord, error)
+
+		// ReplicationRecord returns the replication record of the credential type, or an empty
+		// record if none exists.
+		ReplicationRecord(id CredentialTypeIdentifier) (*ReplicationRecord, error)
+		SaveReplicationRecord(record *ReplicationRecord) error
+
+		// Migrations returns the schema migrations that have been applied to the database, in order.
+		Migrations() ([]*RevocationMigrationRecord, error)
+		// Migrate applies the schema migration to the database and stores the migration record.
+		Migrate(m revocationMigration, record *RevocationMigrationRecord) error
+	}
+
 	// sqlRevStorage is a wrapper around gorm, storing any record type in a SQL database,
 	// for use by revocation servers.
 	sqlRevStorage struct {
@@ -29,13 +88,35 @@ type (
 	}
 )
 
-func newSqlStorage(debug bool, dbtype, connstr string) (sqlRevStorage, error) {
+// newRevStorage opens the revocation database of the specified type, and checks that its schema
+// is up to date, applying pending schema migrations if migrate is true.
+func newRevStorage(debug bool, dbtype, connstr string, migrate bool) (revStorage, error) {
+	db, err := openRevStorage(debug, dbtype, connstr, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRevStorageVersion(db, migrate); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openRevStorage opens the revocation database of the specified type without checking its schema.
+// If readOnly is true, bolt databases are opened read-only so that they can be opened by multiple
+// processes at the same time.
+func openRevStorage(debug bool, dbtype, connstr string, readOnly bool) (revStorage, error) {
 	switch dbtype {
 	case "postgres", "mysql":
+		return newSqlStorage(debug, dbtype, connstr)
+	case "bolt":
+		return newBoltStorage(connstr, readOnly)
 	default:
-		return sqlRevStorage{}, errors.New("unsupported database type")
+		return nil, errors.New("unsupported database type")
 	}
+}
 
+func newSqlStorage(debug bool, dbtype, connstr string) (sqlRevStorage, error) {
 	g, err := gorm.Open(dbtype, connstr)
 	if err != nil {
 		return sqlRevStorage{}, err
@@ -45,16 +126,6 @@ func newSqlStorage(debug bool, dbtype, connstr string) (sqlRevStorage, error) {
 		g.LogMode(true)
 		g.SetLogger(gorm.Logger{LogWriter: log.New(Logger.WriterLevel(logrus.TraceLevel), "db: ", 0)})
 	}
-	if g.AutoMigrate((*EventRecord)(nil)); g.Error != nil {
-		return sqlRevStorage{}, g.Error
-	}
-	if g.AutoMigrate((*AccumulatorRecord)(nil)); g.Error != nil {
-		return sqlRevStorage{}, g.Error
-	}
-	if g.AutoMigrate((*IssuanceRecord)(nil)); g.Error != nil {
-		return sqlRevStorage{}, g.Error
-	}
-
 	return sqlRevStorage{gorm: g}, nil
 }
 
@@ -66,7 +137,7 @@ func (s sqlRevStorage) Close() error {
 	return s.gorm.Close()
 }
 
-func (s sqlRevStorage) Transaction(f func(tx sqlRevStorage) error) (err error) {
+func (s sqlRevStorage) Transaction(f func(tx revStorage) error) (err error) {
 	tx := sqlRevStorage{gorm: s.gorm.Begin()}
 	defer func() {
 		if e := recover(); e != nil {
@@ -84,23 +155,161 @@ func (s sqlRevStorage) Transaction(f func(tx sqlRevStorage) error) (err error) {
 	return
 }
 
-func (s sqlRevStorage) Insert(o interface{}) error {
+func (s sqlRevStorage) AccumulatorExists(id CredentialTypeIdentifier, counter uint) (bool, error) {
+	return s.exists((*AccumulatorRecord)(nil), map[string]interface{}{"cred_type": id, "pk_counter": counter})
+}
+
+func (s sqlRevStorage) Accumulator(id CredentialTypeIdentifier, counter uint) (*AccumulatorRecord, error) {
+	return s.LatestAccumulator(id, &counter)
+}
+
+func (s sqlRevStorage) LatestAccumulator(id CredentialTypeIdentifier, counter *uint) (*AccumulatorRecord, error) {
+	where := map[string]interface{}{"cred_type": id}
+	if counter != nil {
+		where["pk_counter"] = *counter
+	}
+	record := &AccumulatorRecord{}
+	if err := s.last(record, where); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s sqlRevStorage) Accumulators(ids []CredentialTypeIdentifier, counters []uint) ([]*AccumulatorRecord, error) {
+	var records []*AccumulatorRecord
+	var err error
+	if len(counters) == 0 {
+		err = s.find(&records, "cred_type in (?)", ids)
+	} else {
+		err = s.find(&records, "cred_type in (?) and pk_counter in (?)", ids, counters)
+	}
+	return records, err
+}
+
+func (s sqlRevStorage) SaveAccumulator(record *AccumulatorRecord, create bool) error {
+	if create {
+		return s.insert(record)
+	}
+	return s.save(record)
+}
+
+func (s sqlRevStorage) InsertEvent(record *EventRecord) error {
+	return s.insert(record)
+}
+
+func (s sqlRevStorage) Events(id CredentialTypeIdentifier, counter uint, from, to uint64) ([]*EventRecord, error) {
+	var records []*EventRecord
+	err := s.find(&records,
+		"cred_type = ? and pk_counter = ? and eventindex >= ? and eventindex < ?",
+		id, counter, from, to,
+	)
+	return records, err
+}
+
+func (s sqlRevStorage) LatestEvents(id CredentialTypeIdentifier, counter *uint, count uint64) ([]*EventRecord, error) {
+	where := map[string]interface{}{"cred_type": id}
+	if counter != nil {
+		where["pk_counter"] = *counter
+	}
+	var records []*EventRecord
+	err := s.gorm.
+		Where(where).
+		Limit(count).
+		Set("gorm:order_by_primary_key", "DESC").
+		Find(&records).Error
+	return records, err
+}
+
+func (s sqlRevStorage) LastEvents(id CredentialTypeIdentifier, counters []uint) ([]*EventRecord, error) {
+	var records []*EventRecord
+	err := s.find(&records, "cred_type = ? and pk_counter in (?) and eventindex = (?)", id, counters, s.gorm.
+		Table("event_records e2").
+		Select("max(e2.eventindex)").
+		Where("e2.cred_type = event_records.cred_type and e2.pk_counter = event_records.pk_counter").
+		QueryExpr(),
+	)
+	return records, err
+}
+
+func (s sqlRevStorage) EventByAttribute(id CredentialTypeIdentifier, counter uint, e *RevocationAttribute) (*EventRecord, error) {
+	record := &EventRecord{}
+	if err := s.last(record, "cred_type = ? and pk_counter = ? and e = ?", id, counter, e); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s sqlRevStorage) InsertIssuanceRecord(record *IssuanceRecord) error {
+	return s.insert(record)
+}
+
+func (s sqlRevStorage) SaveIssuanceRecord(record *IssuanceRecord) error {
+	return s.save(record)
+}
+
+func (s sqlRevStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued int64) ([]*IssuanceRecord, error) {
+	where := map[string]interface{}{"cred_type": id, "revocationkey": key, "revoked_at": 0}
+	if issued != 0 {
+		where["Issued"] = issued
+	}
+	var records []*IssuanceRecord
+	err := s.find(&records, where)
+	return records, err
+}
+
+func (s sqlRevStorage) DeleteExpiredIssuanceRecords(before int64) error {
+	return s.gorm.Delete(IssuanceRecord{}, "valid_until < ?", before).Error
+}
+
+func (s sqlRevStorage) IssuanceRecordsSince(id CredentialTypeIdentifier, since int64) ([]*IssuanceRecord, error) {
+	var records []*IssuanceRecord
+	err := s.find(&records, "cred_type = ? and (issued >= ? or revoked_at >= ?)", id, since, since)
+	return records, err
+}
+
+func (s sqlRevStorage) AllIssuanceRecords(id CredentialTypeIdentifier, key string) ([]*IssuanceRecord, error) {
+	var records []*IssuanceRecord
+	err := s.find(&records, map[string]interface{}{"cred_type": id, "revocationkey": key})
+	return records, err
+}
+
+func (s sqlRevStorage) ReplicationRecord(id CredentialTypeIdentifier) (*ReplicationRecord, error) {
+	record := &ReplicationRecord{}
+	err := s.last(record, map[string]interface{}{"cred_type": id})
+	if err == ErrRevocationStateNotFound {
+		return &ReplicationRecord{CredType: id}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s sqlRevStorage) SaveReplicationRecord(record *ReplicationRecord) error {
+	return s.save(record)
+}
+
+func (s sqlRevStorage) insert(o interface{}) error {
 	return s.gorm.Create(o).Error
 }
 
-func (s sqlRevStorage) Save(o interface{}) error {
+func (s sqlRevStorage) save(o interface{}) error {
 	return s.gorm.Save(o).Error
 }
 
-func (s sqlRevStorage) Last(dest interface{}, query interface{}, args ...interface{}) error {
+func (s sqlRevStorage) last(dest interface{}, query interface{}, args ...interface{}) error {
 	db := s.gorm
 	if query != nil {
 		db = db.Where(query, args...)
 	}
-	return db.Last(dest).Error
+	err := db.Last(dest).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrRevocationStateNotFound
+	}
+	return err
 }
 
-func (s sqlRevStorage) Exists(id interface{}, query interface{}, args ...interface{}) (bool, error) {
+func (s sqlRevStorage) exists(id interface{}, query interface{}, args ...interface{}) (bool, error) {
 	var c int
 	db := s.gorm.Model(id)
 	if query != nil {
@@ -110,25 +319,13 @@ func (s sqlRevStorage) Exists(id interface{}, query interface{}, args ...interfa
 	return c > 0, db.Error
 }
 
-func (s sqlRevStorage) Delete(id interface{}, query interface{}, args ...interface{}) error {
-	return s.gorm.Delete(id, query, args).Error
-}
-
-func (s sqlRevStorage) Find(dest interface{}, query interface{}, args ...interface{}) error {
+func (s sqlRevStorage) find(dest interface{}, query interface{}, args ...interface{}) error {
 	return s.gorm.
 		Where(query, args...).
 		Set("gorm:order_by_primary_key", "ASC").
 		Find(dest).Error
 }
 
-func (s sqlRevStorage) Latest(dest interface{}, count uint64, query interface{}, args ...interface{}) error {
-	return s.gorm.
-		Where(query, args...).
-		Limit(count).
-		Set("gorm:order_by_primary_key", "DESC").
-		Find(dest).Error
-}
-
 func newMemStorage() *memRevStorage {
 	return &memRevStorage{
 		records: make(map[CredentialTypeIdentifier]*memUpdateRecord),
@@ -0,0 +1,463 @@
+package irma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor"
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"go.etcd.io/bbolt"
+)
+
+// boltRevStorage stores revocation records in an embedded bbolt database, for use by revocation
+// servers and authorities that do not want to run a separate SQL database server.
+// Records are CBOR-encoded and stored in one bucket per record type, under keys that start with
+// the credential type such that records can be retrieved in order using a prefix scan:
+//   - accumulators: credtype|counter
+//   - events:       credtype|counter|index
+//   - issuance:     credtype|revocationkey|issued
+//   - replication:  credtype
+//
+// where | is a zero byte and numbers are encoded as 8 byte big endian integers.
+type boltRevStorage struct {
+	db *bbolt.DB
+	tx *bbolt.Tx // nil if not within a transaction
+}
+
+// Bucketnames
+var (
+	boltAccumulatorsBucket = []byte("accumulators")
+	boltEventsBucket       = []byte("events")
+	boltIssuanceBucket     = []byte("issuance")
+	boltMigrationsBucket   = []byte("migrations")
+	boltReplicationBucket  = []byte("replication")
+)
+
+// newBoltStorage opens the bolt database at the specified path, creating it if it does not exist.
+// A bolt database can be opened by only one process at a time, unless all of them open it
+// read-only; so it cannot be opened while an IRMA server using it is running.
+func newBoltStorage(path string, readOnly bool) (boltRevStorage, error) {
+	if path == "" {
+		return boltRevStorage{}, errors.New("no path to bolt database given")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		readOnly = false // bolt can only initialize the database file when opening it read-write
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 1 * time.Second, ReadOnly: readOnly})
+	if err == bbolt.ErrTimeout {
+		return boltRevStorage{}, errors.Errorf("bolt database %s is in use by another process, e.g. a running IRMA server", path)
+	}
+	if err != nil {
+		return boltRevStorage{}, err
+	}
+	return boltRevStorage{db: db}, nil
+}
+
+func (s boltRevStorage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	Logger.Debug("closing revocation bolt database")
+	return s.db.Close()
+}
+
+func (s boltRevStorage) Transaction(f func(tx revStorage) error) error {
+	if s.tx != nil {
+		return f(s)
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return f(boltRevStorage{db: s.db, tx: tx})
+	})
+}
+
+func (s boltRevStorage) view(f func(tx *bbolt.Tx) error) error {
+	if s.tx != nil {
+		return f(s.tx)
+	}
+	return s.db.View(f)
+}
+
+func (s boltRevStorage) update(f func(tx *bbolt.Tx) error) error {
+	if s.tx != nil {
+		return f(s.tx)
+	}
+	return s.db.Update(f)
+}
+
+func (s boltRevStorage) AccumulatorExists(id CredentialTypeIdentifier, counter uint) (bool, error) {
+	_, err := s.Accumulator(id, counter)
+	if err == ErrRevocationStateNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s boltRevStorage) Accumulator(id CredentialTypeIdentifier, counter uint) (*AccumulatorRecord, error) {
+	record := &AccumulatorRecord{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		bts := tx.Bucket(boltAccumulatorsBucket).Get(boltKey(id.String(), uint64(counter)))
+		if bts == nil {
+			return ErrRevocationStateNotFound
+		}
+		return cbor.Unmarshal(bts, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s boltRevStorage) LatestAccumulator(id CredentialTypeIdentifier, counter *uint) (*AccumulatorRecord, error) {
+	if counter != nil {
+		return s.Accumulator(id, *counter)
+	}
+	var record *AccumulatorRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		return boltScan(tx.Bucket(boltAccumulatorsBucket), boltKey(id.String()), true, func(_, v []byte) (bool, error) {
+			record = &AccumulatorRecord{}
+			return false, cbor.Unmarshal(v, record)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrRevocationStateNotFound
+	}
+	return record, nil
+}
+
+func (s boltRevStorage) Accumulators(ids []CredentialTypeIdentifier, counters []uint) ([]*AccumulatorRecord, error) {
+	include := map[uint]bool{}
+	for _, counter := range counters {
+		include[counter] = true
+	}
+	var records []*AccumulatorRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		for _, id := range ids {
+			err := boltScan(tx.Bucket(boltAccumulatorsBucket), boltKey(id.String()), false, func(_, v []byte) (bool, error) {
+				record := &AccumulatorRecord{}
+				if err := cbor.Unmarshal(v, record); err != nil {
+					return false, err
+				}
+				if len(counters) == 0 || include[*record.PKCounter] {
+					records = append(records, record)
+				}
+				return true, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (s boltRevStorage) SaveAccumulator(record *AccumulatorRecord, create bool) error {
+	key := boltKey(record.CredType.String(), uint64(*record.PKCounter))
+	return s.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltAccumulatorsBucket)
+		if create && b.Get(key) != nil {
+			return errors.Errorf("accumulator record %s-%d already exists", record.CredType, *record.PKCounter)
+		}
+		return boltPut(b, key, record)
+	})
+}
+
+func (s boltRevStorage) InsertEvent(record *EventRecord) error {
+	key := boltKey(record.CredType.String(), uint64(*record.PKCounter), *record.Index)
+	return s.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltEventsBucket)
+		if b.Get(key) != nil {
+			return errors.Errorf("event record %s-%d-%d already exists", record.CredType, *record.PKCounter, *record.Index)
+		}
+		return boltPut(b, key, record)
+	})
+}
+
+func (s boltRevStorage) Events(id CredentialTypeIdentifier, counter uint, from, to uint64) ([]*EventRecord, error) {
+	var records []*EventRecord
+	prefix := boltKey(id.String(), uint64(counter))
+	err := s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(boltEventsBucket).Cursor()
+		for k, v := c.Seek(boltKey(id.String(), uint64(counter), from)); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			record := &EventRecord{}
+			if err := cbor.Unmarshal(v, record); err != nil {
+				return err
+			}
+			if *record.Index >= to {
+				break
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (s boltRevStorage) LatestEvents(id CredentialTypeIdentifier, counter *uint, count uint64) ([]*EventRecord, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	prefix := boltKey(id.String())
+	if counter != nil {
+		prefix = boltKey(id.String(), uint64(*counter))
+	}
+	var records []*EventRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		return boltScan(tx.Bucket(boltEventsBucket), prefix, true, func(_, v []byte) (bool, error) {
+			record := &EventRecord{}
+			if err := cbor.Unmarshal(v, record); err != nil {
+				return false, err
+			}
+			records = append(records, record)
+			// If no key is specified, we need the events of all keys to select the latest ones below
+			return counter == nil || uint64(len(records)) < count, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if counter == nil {
+		// Order the events of all keys by index like the SQL implementation
+		sort.Slice(records, func(i, j int) bool {
+			if *records[i].Index != *records[j].Index {
+				return *records[i].Index > *records[j].Index
+			}
+			return *records[i].PKCounter > *records[j].PKCounter
+		})
+		if uint64(len(records)) > count {
+			records = records[:count]
+		}
+	}
+	return records, nil
+}
+
+func (s boltRevStorage) LastEvents(id CredentialTypeIdentifier, counters []uint) ([]*EventRecord, error) {
+	var records []*EventRecord
+	seen := map[uint]bool{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		for _, counter := range counters {
+			// Like the SQL implementation, return each event once even if its key is specified more than once
+			if seen[counter] {
+				continue
+			}
+			seen[counter] = true
+			err := boltScan(tx.Bucket(boltEventsBucket), boltKey(id.String(), uint64(counter)), true, func(_, v []byte) (bool, error) {
+				record := &EventRecord{}
+				if err := cbor.Unmarshal(v, record); err != nil {
+					return false, err
+				}
+				records = append(records, record)
+				return false, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (s boltRevStorage) EventByAttribute(id CredentialTypeIdentifier, counter uint, e *RevocationAttribute) (*EventRecord, error) {
+	var record *EventRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		return boltScan(tx.Bucket(boltEventsBucket), boltKey(id.String(), uint64(counter)), false, func(_, v []byte) (bool, error) {
+			r := &EventRecord{}
+			if err := cbor.Unmarshal(v, r); err != nil {
+				return false, err
+			}
+			if (*big.Int)(r.E).Cmp((*big.Int)(e)) == 0 {
+				record = r
+				return false, nil
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrRevocationStateNotFound
+	}
+	return record, nil
+}
+
+func (s boltRevStorage) InsertIssuanceRecord(record *IssuanceRecord) error {
+	key := boltKey(record.CredType.String(), record.Key, uint64(record.Issued))
+	return s.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltIssuanceBucket)
+		if b.Get(key) != nil {
+			return errors.Errorf("issuance record %s-%s-%d already exists", record.CredType, record.Key, record.Issued)
+		}
+		return boltPut(b, key, record)
+	})
+}
+
+func (s boltRevStorage) SaveIssuanceRecord(record *IssuanceRecord) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return boltPut(tx.Bucket(boltIssuanceBucket), boltKey(record.CredType.String(), record.Key, uint64(record.Issued)), record)
+	})
+}
+
+func (s boltRevStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued int64) ([]*IssuanceRecord, error) {
+	prefix := boltKey(id.String(), key)
+	if issued != 0 {
+		prefix = boltKey(id.String(), key, uint64(issued))
+	}
+	var records []*IssuanceRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		return boltScan(tx.Bucket(boltIssuanceBucket), prefix, false, func(_, v []byte) (bool, error) {
+			record := &IssuanceRecord{}
+			if err := cbor.Unmarshal(v, record); err != nil {
+				return false, err
+			}
+			if record.RevokedAt == 0 {
+				records = append(records, record)
+			}
+			return true, nil
+		})
+	})
+	return records, err
+}
+
+func (s boltRevStorage) DeleteExpiredIssuanceRecords(before int64) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltIssuanceBucket)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			record := &IssuanceRecord{}
+			if err := cbor.Unmarshal(v, record); err != nil {
+				return err
+			}
+			if record.ValidUntil < before {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Deleting keys while iterating using ForEach is not allowed, so we do it afterwards
+		for _, k := range expired {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltRevStorage) IssuanceRecordsSince(id CredentialTypeIdentifier, since int64) ([]*IssuanceRecord, error) {
+	var records []*IssuanceRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		return boltScan(tx.Bucket(boltIssuanceBucket), boltKey(id.String()), false, func(_, v []byte) (bool, error) {
+			record := &IssuanceRecord{}
+			if err := cbor.Unmarshal(v, record); err != nil {
+				return false, err
+			}
+			if record.Issued >= since || record.RevokedAt >= since {
+				records = append(records, record)
+			}
+			return true, nil
+		})
+	})
+	return records, err
+}
+
+func (s boltRevStorage) AllIssuanceRecords(id CredentialTypeIdentifier, key string) ([]*IssuanceRecord, error) {
+	var records []*IssuanceRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		return boltScan(tx.Bucket(boltIssuanceBucket), boltKey(id.String(), key), false, func(_, v []byte) (bool, error) {
+			record := &IssuanceRecord{}
+			records = append(records, record)
+			return true, cbor.Unmarshal(v, record)
+		})
+	})
+	return records, err
+}
+
+func (s boltRevStorage) ReplicationRecord(id CredentialTypeIdentifier) (*ReplicationRecord, error) {
+	record := &ReplicationRecord{CredType: id}
+	err := s.view(func(tx *bbolt.Tx) error {
+		bts := tx.Bucket(boltReplicationBucket).Get(boltKey(id.String()))
+		if bts == nil {
+			return nil
+		}
+		return cbor.Unmarshal(bts, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s boltRevStorage) SaveReplicationRecord(record *ReplicationRecord) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return boltPut(tx.Bucket(boltReplicationBucket), boltKey(record.CredType.String()), record)
+	})
+}
+
+// boltKey constructs a key out of the specified strings and integers,
+// each of which is followed by a zero byte or encoded as 8 byte big endian integer, respectively.
+func boltKey(parts ...interface{}) []byte {
+	var key []byte
+	for _, part := range parts {
+		switch p := part.(type) {
+		case string:
+			key = append(append(key, p...), 0)
+		case uint64:
+			var bts [8]byte
+			binary.BigEndian.PutUint64(bts[:], p)
+			key = append(key, bts[:]...)
+		default:
+			panic("unsupported bolt key part")
+		}
+	}
+	return key
+}
+
+// boltScan calls f on each key-value pair in the bucket whose key starts with the prefix,
+// in order of the keys (or reversed order if reverse is true), until f returns false or an error.
+func boltScan(b *bbolt.Bucket, prefix []byte, reverse bool, f func(k, v []byte) (bool, error)) error {
+	c := b.Cursor()
+	var k, v []byte
+	if !reverse {
+		k, v = c.Seek(prefix)
+	} else {
+		// Position the cursor at the last key having the prefix, by seeking to the first key
+		// after all keys having the prefix
+		end := append(append([]byte{}, prefix...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		if k, _ = c.Seek(end); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	}
+	for k != nil && bytes.HasPrefix(k, prefix) {
+		cont, err := f(k, v)
+		if err != nil || !cont {
+			return err
+		}
+		if reverse {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Next()
+		}
+	}
+	return nil
+}
+
+func boltPut(b *bbolt.Bucket, key []byte, record interface{}) error {
+	bts, err := cbor.Marshal(record, cbor.EncOptions{})
+	if err != nil {
+		return err
+	}
+	return b.Put(key, bts)
+}
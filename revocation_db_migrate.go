@@ -0,0 +1,242 @@
+package irma
+
+import (
+	"time"
+
+	"github.com/fxamacker/cbor"
+	"github.com/go-errors/errors"
+	"github.com/jinzhu/gorm"
+	"go.etcd.io/bbolt"
+)
+
+type (
+	// RevocationMigrationRecord records a schema migration of the revocation database.
+	RevocationMigrationRecord struct {
+		Version     int `gorm:"primary_key;auto_increment:false"`
+		Description string
+		AppliedAt   int64 // 0 if not yet applied
+	}
+
+	// RevocationSchemaStatus describes the schema version of a revocation database.
+	RevocationSchemaStatus struct {
+		Version       int // 0 if no migrations have been applied yet
+		LatestVersion int // latest version supported by this version of irmago
+		Applied       []*RevocationMigrationRecord
+		Pending       []*RevocationMigrationRecord
+	}
+
+	// revocationMigration is a versioned change to the schema of the revocation database,
+	// implemented for each of the database backends. A nil implementation means that the
+	// migration does not apply to that backend. As some SQL databases (such as MySQL) implicitly
+	// commit schema changes, a failed SQL migration may have been partially applied; so the sql
+	// implementation must succeed when it is run again on such a partially migrated database.
+	revocationMigration struct {
+		version     int
+		description string
+		sql         func(g *gorm.DB) error
+		bolt        func(tx *bbolt.Tx) error
+	}
+)
+
+// revocationMigrations contains all schema migrations of the revocation database, in order of
+// increasing version. Once released, migrations must never be modified: add a new one instead.
+var revocationMigrations = []revocationMigration{
+	{
+		version:     1,
+		description: "create accumulator, event and issuance record tables",
+		sql: func(g *gorm.DB) error {
+			// Databases created by earlier versions of irmago using gorm's AutoMigrate already
+			// contain these tables; this is a no-op for those.
+			return g.AutoMigrate(
+				(*EventRecord)(nil),
+				(*AccumulatorRecord)(nil),
+				(*IssuanceRecord)(nil),
+			).Error
+		},
+		bolt: func(tx *bbolt.Tx) error {
+			for _, bucket := range [][]byte{boltAccumulatorsBucket, boltEventsBucket, boltIssuanceBucket} {
+				if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "add index on expiry of issuance records",
+		sql: func(g *gorm.DB) error {
+			table := g.NewScope((*IssuanceRecord)(nil)).TableName()
+			if g.Dialect().HasIndex(table, "idx_issuance_records_valid_until") {
+				return nil
+			}
+			return g.Model((*IssuanceRecord)(nil)).
+				AddIndex("idx_issuance_records_valid_until", "valid_until").Error
+		},
+	},
+	{
+		version:     3,
+		description: "create replication record table",
+		sql: func(g *gorm.DB) error {
+			return g.AutoMigrate((*ReplicationRecord)(nil)).Error
+		},
+		bolt: func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltReplicationBucket)
+			return err
+		},
+	},
+}
+
+func latestRevocationSchemaVersion() int {
+	return revocationMigrations[len(revocationMigrations)-1].version
+}
+
+// RevocationDBSchemaStatus reports the schema version of the specified revocation database,
+// and the migrations that have been and are yet to be applied to it.
+func RevocationDBSchemaStatus(dbtype, connstr string) (*RevocationSchemaStatus, error) {
+	db, err := openRevStorage(false, dbtype, connstr, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	return revStorageStatus(db)
+}
+
+// MigrateRevocationDB applies all pending schema migrations to the specified revocation database,
+// returning the migrations that were applied.
+func MigrateRevocationDB(dbtype, connstr string) ([]*RevocationMigrationRecord, error) {
+	db, err := openRevStorage(false, dbtype, connstr, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	return migrateRevStorage(db)
+}
+
+func revStorageStatus(db revStorage) (*RevocationSchemaStatus, error) {
+	applied, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	status := &RevocationSchemaStatus{
+		LatestVersion: latestRevocationSchemaVersion(),
+		Applied:       applied,
+	}
+	if len(applied) > 0 {
+		status.Version = applied[len(applied)-1].Version
+	}
+	for _, m := range revocationMigrations {
+		if m.version > status.Version {
+			status.Pending = append(status.Pending, &RevocationMigrationRecord{
+				Version:     m.version,
+				Description: m.description,
+			})
+		}
+	}
+	return status, nil
+}
+
+func migrateRevStorage(db revStorage) ([]*RevocationMigrationRecord, error) {
+	status, err := revStorageStatus(db)
+	if err != nil {
+		return nil, err
+	}
+	if status.Version > status.LatestVersion {
+		return nil, errors.Errorf("revocation database schema version %d is newer than latest supported version %d",
+			status.Version, status.LatestVersion)
+	}
+	var applied []*RevocationMigrationRecord
+	for _, m := range revocationMigrations {
+		if m.version <= status.Version {
+			continue
+		}
+		Logger.Infof("Migrating revocation database to schema version %d: %s", m.version, m.description)
+		record := &RevocationMigrationRecord{
+			Version:     m.version,
+			Description: m.description,
+			AppliedAt:   time.Now().Unix(),
+		}
+		if err = db.Migrate(m, record); err != nil {
+			return applied, errors.WrapPrefix(err, "failed to migrate revocation database", 0)
+		}
+		applied = append(applied, record)
+	}
+	return applied, nil
+}
+
+// checkRevStorageVersion ensures that the schema of the revocation database is up to date,
+// applying pending migrations if migrate is true. It refuses databases whose schema is newer
+// than supported by this version of irmago.
+func checkRevStorageVersion(db revStorage, migrate bool) error {
+	status, err := revStorageStatus(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Version > status.LatestVersion:
+		return errors.Errorf("revocation database schema version %d is newer than latest supported version %d, upgrade irmago",
+			status.Version, status.LatestVersion)
+	case status.Version == status.LatestVersion:
+		return nil
+	case !migrate:
+		return errors.Errorf("revocation database schema version %d is outdated (latest: %d), run \"irma issuer revocation db migrate\"",
+			status.Version, status.LatestVersion)
+	}
+	_, err = migrateRevStorage(db)
+	return err
+}
+
+func (s sqlRevStorage) Migrations() ([]*RevocationMigrationRecord, error) {
+	if !s.gorm.HasTable((*RevocationMigrationRecord)(nil)) {
+		return nil, nil
+	}
+	var records []*RevocationMigrationRecord
+	err := s.find(&records, "version > ?", 0)
+	return records, err
+}
+
+// Migrate applies the migration and then records it. This is not done in a transaction, as some
+// databases implicitly commit schema changes; instead, migrations are safe to run again if
+// recording them fails (see revocationMigration).
+func (s sqlRevStorage) Migrate(m revocationMigration, record *RevocationMigrationRecord) error {
+	if m.sql != nil {
+		if err := m.sql(s.gorm); err != nil {
+			return err
+		}
+	}
+	if err := s.gorm.AutoMigrate((*RevocationMigrationRecord)(nil)).Error; err != nil {
+		return err
+	}
+	return s.gorm.Create(record).Error
+}
+
+func (s boltRevStorage) Migrations() ([]*RevocationMigrationRecord, error) {
+	var records []*RevocationMigrationRecord
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltMigrationsBucket)
+		if b == nil {
+			return nil
+		}
+		return boltScan(b, nil, false, func(_, v []byte) (bool, error) {
+			record := &RevocationMigrationRecord{}
+			records = append(records, record)
+			return true, cbor.Unmarshal(v, record)
+		})
+	})
+	return records, err
+}
+
+func (s boltRevStorage) Migrate(m revocationMigration, record *RevocationMigrationRecord) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		if m.bolt != nil {
+			if err := m.bolt(tx); err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucketIfNotExists(boltMigrationsBucket)
+		if err != nil {
+			return err
+		}
+		return boltPut(b, boltKey(uint64(record.Version)), record)
+	})
+}
@@ -0,0 +1,136 @@
+package irma
+
+import (
+	"math"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+type (
+	// IssuanceRecordStatus describes an issuance record and its revocation state.
+	IssuanceRecordStatus struct {
+		Key        string                   `json:"revocationKey"`
+		CredType   CredentialTypeIdentifier `json:"type"`
+		Issued     int64                    `json:"issued"` // in nanoseconds
+		PKCounter  uint                     `json:"counter"`
+		ValidUntil int64                    `json:"validUntil"`
+		Attr       *big.Int                 `json:"revocationAttribute"`
+
+		Revoked   bool  `json:"revoked"`
+		RevokedAt int64 `json:"revokedAt,omitempty"` // in nanoseconds
+		// EventIndex is the index of the event that removed the credential from the accumulator,
+		// if it has been revoked.
+		EventIndex *uint64 `json:"eventIndex,omitempty"`
+	}
+
+	// RevocationQueryResponse is the response to a RevocationQueryRequest.
+	RevocationQueryResponse struct {
+		Records []*IssuanceRecordStatus `json:"records,omitempty"`
+		// EventLog contains the current accumulator and all events of the requested key.
+		EventLog *revocation.Update `json:"eventLog,omitempty"`
+	}
+)
+
+// Query answers the revocation query request using the revocation database.
+func (rs *RevocationStorage) Query(request *RevocationQueryRequest) (*RevocationQueryResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	if request.Key != "" {
+		records, err := rs.IssuanceRecordStatus(request.CredentialType, request.Key)
+		if err != nil {
+			return nil, err
+		}
+		return &RevocationQueryResponse{Records: records}, nil
+	}
+	update, err := rs.EventLog(request.CredentialType, *request.Counter)
+	if err != nil {
+		return nil, err
+	}
+	return &RevocationQueryResponse{EventLog: update}, nil
+}
+
+// IssuanceRecordStatus returns all issuance records of the specified revocation key, including
+// revoked ones, along with their revocation state. Only the revocation authority or standby of
+// the credential type has issuance records.
+func (rs *RevocationStorage) IssuanceRecordStatus(id CredentialTypeIdentifier, key string) ([]*IssuanceRecordStatus, error) {
+	if settings := rs.settings.Get(id); !settings.IsAuthority() && !settings.IsStandby() {
+		return nil, errors.Errorf("not revocation authority for %s", id)
+	}
+	var statuses []*IssuanceRecordStatus
+	err := rs.db.Transaction(func(tx revStorage) error {
+		records, err := tx.AllIssuanceRecords(id, key)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return ErrUnknownRevocationKey
+		}
+		for _, r := range records {
+			status := &IssuanceRecordStatus{
+				Key:        r.Key,
+				CredType:   r.CredType,
+				Issued:     r.Issued,
+				PKCounter:  *r.PKCounter,
+				ValidUntil: r.ValidUntil,
+				Attr:       (*big.Int)(r.Attr),
+				Revoked:    r.RevokedAt != 0,
+				RevokedAt:  r.RevokedAt,
+			}
+			if status.Revoked {
+				event, err := tx.EventByAttribute(id, *r.PKCounter, r.Attr)
+				if err != nil && err != ErrRevocationStateNotFound {
+					return err
+				}
+				// A standby may not yet have the event if it was revoked just now
+				if event != nil {
+					status.EventIndex = event.Index
+				}
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// EventLog returns the current accumulator of the specified key along with all of its events,
+// starting at the initial event, as a verified revocation update.
+func (rs *RevocationStorage) EventLog(id CredentialTypeIdentifier, counter uint) (*revocation.Update, error) {
+	if !rs.dbMode {
+		return nil, errors.Errorf("no revocation database for %s", id)
+	}
+	update := &revocation.Update{}
+	err := rs.db.Transaction(func(tx revStorage) error {
+		record, err := tx.Accumulator(id, counter)
+		if err != nil {
+			return err
+		}
+		update.SignedAccumulator = record.SignedAccumulator()
+		records, err := tx.Events(id, counter, 0, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			update.Events = append(update.Events, r.Event())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = update.Verify(pk); err != nil {
+		return nil, errors.WrapPrefix(err, "event log does not verify", 0)
+	}
+	return update, nil
+}
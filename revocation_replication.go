@@ -0,0 +1,326 @@
+package irma
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
+)
+
+// Replication and failover of revocation authorities.
+//
+// A revocation standby (RevocationSetting.Standby) follows the revocation authority of a credential
+// type at its RevocationServerURL. It stores the complete event chain of each key of the credential
+// type, accepting only events that extend the chain it already has, and it periodically replicates
+// the issuance records of the authority. The standby serves revocation updates just like a revocation
+// server. When the authority fails it can be promoted to authority using Promote(), after which it
+// fences the old authority: that refuses to sign accumulators from then on, also after a restart.
+// The issuers of the credential type must then be reconfigured to send their issuance records to the
+// new authority.
+
+type (
+	// ReplicationRequest is sent by a revocation standby to its revocation authority, signed with
+	// the revocation private key of the issuer, to replicate the issuance records of the authority
+	// or to fence the authority when the standby is promoted.
+	ReplicationRequest struct {
+		CredentialType CredentialTypeIdentifier `json:"credential_type"`
+		Time           int64                    `json:"time"`            // Unix time at which the request was made
+		Since          int64                    `json:"since,omitempty"` // return issuance records issued or revoked since (in nanoseconds)
+		Epoch          uint64                   `json:"epoch,omitempty"` // when fencing, the epoch of the promoted standby
+	}
+
+	// ReplicationResponse is the response of a revocation authority to a ReplicationRequest.
+	ReplicationResponse struct {
+		Epoch           uint64            `json:"epoch"`
+		Time            int64             `json:"time"` // time (in nanoseconds) as of which the issuance records were retrieved
+		Counters        []uint            `json:"counters"`
+		IssuanceRecords []*IssuanceRecord `json:"issuance_records,omitempty"`
+	}
+)
+
+var errStandbyMissingEvents = errors.New("update does not connect to known events")
+
+// Replicate returns the issuance records issued or revoked since the specified time (in nanoseconds),
+// and the key counters having an accumulator, for a revocation standby.
+func (rs *RevocationStorage) Replicate(id CredentialTypeIdentifier, since int64) (*ReplicationResponse, error) {
+	if !rs.settings.Get(id).IsAuthority() {
+		return nil, errors.Errorf("not revocation authority for %s", id)
+	}
+	resp := &ReplicationResponse{Time: time.Now().UnixNano()}
+	err := rs.db.Transaction(func(tx revStorage) error {
+		rec, err := tx.ReplicationRecord(id)
+		if err != nil {
+			return err
+		}
+		resp.Epoch = rec.Epoch
+		accs, err := tx.Accumulators([]CredentialTypeIdentifier{id}, nil)
+		if err != nil {
+			return err
+		}
+		for _, acc := range accs {
+			resp.Counters = append(resp.Counters, *acc.PKCounter)
+		}
+		resp.IssuanceRecords, err = tx.IssuanceRecordsSince(id, since)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Fence stops us from acting as revocation authority of the credential type, because a standby
+// has been promoted to authority at the specified epoch. This is persisted in the database, so
+// that we also refuse to act as authority after a restart.
+func (rs *RevocationStorage) Fence(id CredentialTypeIdentifier, epoch uint64) error {
+	settings := rs.settings.Get(id)
+	if !settings.IsAuthority() {
+		return errors.Errorf("not revocation authority for %s", id)
+	}
+	err := rs.db.Transaction(func(tx revStorage) error {
+		rec, err := tx.ReplicationRecord(id)
+		if err != nil {
+			return err
+		}
+		if epoch <= rec.Epoch {
+			return errors.Errorf("cannot fence revocation authority at epoch %d by standby of epoch %d", rec.Epoch, epoch)
+		}
+		rec.Epoch = epoch
+		rec.Fenced = true
+		return tx.SaveReplicationRecord(rec)
+	})
+	if err != nil {
+		return err
+	}
+	settings.setMode(false, false, settings.ServerURL())
+	Logger.WithField("credtype", id).Warnf("Revocation standby promoted to authority at epoch %d, no longer acting as authority", epoch)
+	return nil
+}
+
+// Promote promotes us from revocation standby to revocation authority of the credential type.
+// It first synchronizes with the current authority, and then fences it so that it no longer acts
+// as authority. If force is true, it proceeds even if the current authority cannot be reached,
+// in which case the caller must ensure that it no longer runs.
+func (rs *RevocationStorage) Promote(id CredentialTypeIdentifier, force bool) error {
+	settings := rs.settings.Get(id)
+	if !settings.IsStandby() {
+		return errors.Errorf("not revocation standby for %s", id)
+	}
+	sk, err := rs.Keys.PrivateKeyLatest(id.IssuerIdentifier())
+	if err != nil {
+		return err
+	}
+
+	if err = rs.syncStandby(id); err != nil {
+		if !force {
+			return errors.WrapPrefix(err, "failed to synchronize with revocation authority", 0)
+		}
+		Logger.Warn("failed to synchronize with revocation authority, promoting anyway: ", err)
+	}
+	rec, err := rs.db.ReplicationRecord(id)
+	if err != nil {
+		return err
+	}
+	epoch := rec.Epoch + 1
+	_, err = rs.client.PostReplicationRequest(settings.ServerURL(), "fence", sk, &ReplicationRequest{
+		CredentialType: id,
+		Time:           time.Now().Unix(),
+		Epoch:          epoch,
+	})
+	if err != nil {
+		if !force {
+			return errors.WrapPrefix(err, "failed to fence revocation authority", 0)
+		}
+		Logger.Warn("failed to fence revocation authority, promoting anyway: ", err)
+	}
+
+	rec.Epoch = epoch
+	rec.Fenced = false
+	if err = rs.db.SaveReplicationRecord(rec); err != nil {
+		return err
+	}
+	settings.setMode(true, false, "")
+	Logger.WithField("credtype", id).Infof("Promoted to revocation authority at epoch %d", epoch)
+	return nil
+}
+
+// checkFenced returns an error if we have been fenced as revocation authority of the credential type.
+func (rs *RevocationStorage) checkFenced(tx revStorage, id CredentialTypeIdentifier) error {
+	rec, err := tx.ReplicationRecord(id)
+	if err != nil {
+		return err
+	}
+	if rec.Fenced {
+		return errors.Errorf("revocation authority for %s has been fenced by a standby promoted at epoch %d", id, rec.Epoch)
+	}
+	return nil
+}
+
+// syncStandby brings the database of a revocation standby up to date with its revocation authority:
+// it fetches the events of each key that it does not yet have, and the issuance records issued or
+// revoked since the previous synchronization.
+func (rs *RevocationStorage) syncStandby(id CredentialTypeIdentifier) error {
+	settings := rs.settings.Get(id)
+	sk, err := rs.Keys.PrivateKeyLatest(id.IssuerIdentifier())
+	if err != nil {
+		return err
+	}
+	rec, err := rs.db.ReplicationRecord(id)
+	if err != nil {
+		return err
+	}
+
+	Logger.WithField("credtype", id).Tracef("synchronizing with revocation authority")
+	resp, err := rs.client.PostReplicationRequest(settings.ServerURL(), "replicate", sk, &ReplicationRequest{
+		CredentialType: id,
+		Time:           time.Now().Unix(),
+		Since:          rec.IssuanceSynced,
+	})
+	if err != nil {
+		return err
+	}
+	for _, counter := range resp.Counters {
+		if err = rs.followAuthority(id, counter); err != nil {
+			return err
+		}
+	}
+
+	err = rs.db.Transaction(func(tx revStorage) error {
+		for _, r := range resp.IssuanceRecords {
+			if r.CredType != id {
+				return errors.New("issuance record of wrong credential type")
+			}
+			if err := tx.SaveIssuanceRecord(r); err != nil {
+				return err
+			}
+		}
+		rec.Epoch = resp.Epoch
+		// Issuance records may be stored by the authority some time after their issuance time,
+		// so we request the records of the last minute again at the next synchronization
+		rec.IssuanceSynced = resp.Time - int64(time.Minute)
+		return tx.SaveReplicationRecord(rec)
+	})
+	if err != nil {
+		return err
+	}
+	rs.setUpdated(id)
+	return nil
+}
+
+// followAuthority fetches and stores the events of the specified key that we do not yet have.
+func (rs *RevocationStorage) followAuthority(id CredentialTypeIdentifier, counter uint) error {
+	records, err := rs.db.LastEvents(id, []uint{counter})
+	if err != nil {
+		return err
+	}
+	var from uint64
+	if len(records) > 0 {
+		from = *records[0].Index + 1
+	}
+	update, err := rs.client.FetchUpdateFrom(id, counter, from)
+	if err != nil {
+		return err
+	}
+	return rs.addStandbyUpdate(id, update)
+}
+
+// addStandbyUpdate verifies the update from the revocation authority, and stores it if its events
+// extend the event chain that we already have. It returns errStandbyMissingEvents if there are
+// events missing between those that we have and those in the update.
+func (rs *RevocationStorage) addStandbyUpdate(id CredentialTypeIdentifier, update *revocation.Update) error {
+	counter := update.SignedAccumulator.PKCounter
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
+	if err != nil {
+		return err
+	}
+	acc, err := update.Verify(pk)
+	if err != nil {
+		return err
+	}
+
+	err = rs.db.Transaction(func(tx revStorage) error {
+		records, err := tx.LastEvents(id, []uint{counter})
+		if err != nil {
+			return err
+		}
+		var known *revocation.Event
+		if len(records) > 0 {
+			known = records[0].Event()
+			if acc.Index < known.Index {
+				return nil // older than what we have
+			}
+		}
+
+		// Select the events that we don't have yet, and check that they connect to the ones we do have
+		var events []*revocation.Event
+		for _, e := range update.Events {
+			if known == nil || e.Index > known.Index {
+				events = append(events, e)
+			}
+		}
+		var next uint64
+		if known != nil {
+			next = known.Index + 1
+		}
+		if (len(events) == 0 && acc.Index+1 != next) || (len(events) > 0 && events[0].Index != next) {
+			return errStandbyMissingEvents
+		}
+		chain := events
+		if known != nil {
+			chain = append([]*revocation.Event{known}, events...)
+		}
+		if err = revocation.NewEventList(chain...).Verify(acc); err != nil {
+			return errors.WrapPrefix(err, "update does not extend event chain", 0)
+		}
+
+		// Don't replace our accumulator by an older one
+		if known != nil && len(events) == 0 {
+			record, err := tx.Accumulator(id, counter)
+			if err != nil && err != ErrRevocationStateNotFound {
+				return err
+			}
+			if record != nil {
+				ours, err := record.SignedAccumulator().UnmarshalVerify(pk)
+				if err != nil {
+					return err
+				}
+				if ours.Time >= acc.Time {
+					return nil
+				}
+			}
+		}
+
+		if err = tx.SaveAccumulator(new(AccumulatorRecord).Convert(id, update.SignedAccumulator), false); err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err = tx.InsertEvent(new(EventRecord).Convert(id, counter, event)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.setUpdated(id)
+	return nil
+}
+
+// PostReplicationRequest signs and sends the replication request to the revocation authority at the
+// specified URL, using the specified action ("replicate" or "fence").
+func (client RevocationClient) PostReplicationRequest(
+	url, action string, sk *revocation.PrivateKey, req *ReplicationRequest,
+) (*ReplicationResponse, error) {
+	message, err := signed.MarshalSign(sk.ECDSA, req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ReplicationResponse{}
+	return resp, client.transport(false).Post(
+		fmt.Sprintf("%s/revocation/%s/%s/%d", url, req.CredentialType, action, sk.Counter), resp, []byte(message),
+	)
+}
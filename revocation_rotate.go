@@ -0,0 +1,115 @@
+package irma
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// Revocation state is kept per issuer public key: each key counter has its own accumulator and
+// event chain. When an issuer rotates to a new key, credentials issued with older keys keep using
+// the accumulators of those keys, so that revocation has to be enabled for the new key while
+// revoking credentials may involve the accumulators of all keys.
+
+// RevocationKeyState describes the accumulator of one issuer key counter of a credential type.
+type RevocationKeyState struct {
+	Counter uint `json:"counter"`
+	// Index is the index of the latest event, i.e., the amount of revocations using this key.
+	Index uint64 `json:"index"`
+	// Time is the time at which the accumulator was last signed (Unix timestamp).
+	Time int64 `json:"time"`
+	// ExpiryDate is the expiry date of the issuer public key (Unix timestamp), if known.
+	ExpiryDate int64 `json:"expiryDate,omitempty"`
+	// Latest is true for the key counter used for issuing new credentials, i.e., the highest
+	// key counter having an accumulator.
+	Latest bool `json:"latest"`
+}
+
+// KeyStates returns the state of the accumulator of each key counter of the specified credential
+// type for which revocation is enabled, ordered by key counter.
+func (rs *RevocationStorage) KeyStates(id CredentialTypeIdentifier) ([]*RevocationKeyState, error) {
+	var saccs []*revocation.SignedAccumulator
+	if rs.dbMode {
+		records, err := rs.db.Accumulators([]CredentialTypeIdentifier{id}, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			saccs = append(saccs, r.SignedAccumulator())
+		}
+	} else {
+		for _, u := range rs.memdb.Latest(id, 0) {
+			saccs = append(saccs, u.SignedAccumulator)
+		}
+	}
+
+	var states []*RevocationKeyState
+	for _, sacc := range saccs {
+		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), sacc.PKCounter)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := sacc.UnmarshalVerify(pk)
+		if err != nil {
+			return nil, err
+		}
+		state := &RevocationKeyState{Counter: sacc.PKCounter, Index: acc.Index, Time: acc.Time}
+		if gabipk, err := rs.conf.PublicKey(id.IssuerIdentifier(), sacc.PKCounter); err == nil && gabipk != nil {
+			state.ExpiryDate = gabipk.ExpiryDate
+		}
+		states = append(states, state)
+	}
+	if len(states) == 0 {
+		return nil, ErrRevocationStateNotFound
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Counter < states[j].Counter })
+	states[len(states)-1].Latest = true
+	return states, nil
+}
+
+// RotateRevocation enables revocation for the latest issuer private key of the credential type,
+// if it is not already enabled, and returns the counter of that key. The accumulators of older
+// keys are kept, so that credentials issued with those keys can still be revoked.
+func (rs *RevocationStorage) RotateRevocation(id CredentialTypeIdentifier) (uint, error) {
+	if !rs.settings.Get(id).IsAuthority() {
+		return 0, errors.Errorf("not revocation authority for %s", id)
+	}
+	sk, err := rs.Keys.PrivateKeyLatest(id.IssuerIdentifier())
+	if err != nil {
+		return 0, err
+	}
+	exists, err := rs.Exists(id, sk.Counter)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return sk.Counter, nil
+	}
+	if err = rs.EnableRevocation(id, sk); err != nil {
+		return 0, err
+	}
+	Logger.WithFields(map[string]interface{}{"credtype": id, "counter": sk.Counter}).
+		Info("Enabled revocation for new issuer key")
+	return sk.Counter, nil
+}
+
+// RevokeKey revokes all unrevoked credentials having the specified revocation key, regardless of
+// the issuer key with which they were issued, in a single transaction. It returns the amount of
+// revoked credentials per issuer key counter.
+func (rs *RevocationStorage) RevokeKey(id CredentialTypeIdentifier, key string) (map[uint]int, error) {
+	if !rs.settings.Get(id).IsAuthority() {
+		return nil, errors.Errorf("cannot revoke %s", id)
+	}
+	var counts map[uint]int
+	err := rs.db.Transaction(func(tx revStorage) error {
+		if err := rs.checkFenced(tx, id); err != nil {
+			return err
+		}
+		var err error
+		counts, err = rs.revoke(tx, id, key, time.Time{})
+		return err
+	})
+	return counts, err
+}
@@ -0,0 +1,202 @@
+package irma
+
+import (
+	"sort"
+	"time"
+)
+
+type (
+	// RevocationStatus describes the operational state of revocation for a credential type:
+	// up to when nonrevocation is guaranteed, the accumulator of each issuer key, and the
+	// health of the mechanisms that keep the revocation state up to date.
+	RevocationStatus struct {
+		CredentialType CredentialTypeIdentifier `json:"credentialType"`
+		// Mode is the revocation mode of this server for the credential type: authority, standby,
+		// server, or requestor (if it only fetches revocation state from the revocation server).
+		Mode string `json:"mode"`
+		// Updated is the time up to which nonrevocation is guaranteed, i.e. when the revocation
+		// state was last updated; absent if it has never been updated.
+		Updated *Timestamp `json:"updated,omitempty"`
+		// Tolerance in seconds: the maximum age of Updated when a nonrevocation proof is requested.
+		Tolerance uint64 `json:"tolerance"`
+		// Stale is true if Updated is older than Tolerance.
+		Stale bool                  `json:"stale"`
+		Keys  []*RevocationKeyState `json:"keys,omitempty"`
+		// SSE contains the state of the server-sent events connection with the revocation server,
+		// if enabled.
+		SSE *RevocationSSEStatus `json:"sse,omitempty"`
+		// SyncFailures is the amount of failed attempts to fetch revocation updates from the
+		// revocation server.
+		SyncFailures      uint64     `json:"syncFailures"`
+		LastSyncError     string     `json:"lastSyncError,omitempty"`
+		LastSyncErrorTime *Timestamp `json:"lastSyncErrorTime,omitempty"`
+		// ToleranceViolations is the amount of sessions in which nonrevocation could not be
+		// guaranteed within the tolerance, because updating the revocation state failed.
+		ToleranceViolations uint64 `json:"toleranceViolations"`
+	}
+
+	// RevocationSSEStatus describes the server-sent events connection with the revocation server.
+	RevocationSSEStatus struct {
+		Connected bool       `json:"connected"`
+		LastEvent *Timestamp `json:"lastEvent,omitempty"`
+		Error     string     `json:"error,omitempty"`
+	}
+
+	// revocationCounters keeps track of the operational state of revocation for a credential type.
+	// Its fields are protected by RevocationStorage.statusMutex.
+	revocationCounters struct {
+		stale               bool
+		sseConnected        bool
+		sseLastEvent        time.Time
+		sseError            string
+		syncFailures        uint64
+		syncError           string
+		syncErrorTime       time.Time
+		toleranceViolations uint64
+	}
+)
+
+// Status returns the revocation status of each credential type for which revocation is
+// configured or for which nonrevocation proofs have been requested, ordered by credential type.
+func (rs *RevocationStorage) Status() []*RevocationStatus {
+	var ids []CredentialTypeIdentifier
+	for id := range rs.settings.Snapshot() {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	var statuses []*RevocationStatus
+	for _, id := range ids {
+		statuses = append(statuses, rs.status(id))
+	}
+	return statuses
+}
+
+func (rs *RevocationStorage) status(id CredentialTypeIdentifier) *RevocationStatus {
+	settings := rs.settings.Get(id)
+	status := &RevocationStatus{
+		CredentialType: id,
+		Mode:           settings.mode(),
+		Tolerance:      settings.Tolerance,
+	}
+	keys, err := rs.KeyStates(id)
+	if err == nil {
+		status.Keys = keys
+	} else if err != ErrRevocationStateNotFound {
+		Logger.WithField("credtype", id).Warn("failed to read revocation key states: ", err)
+	}
+
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	if !settings.updated.IsZero() {
+		status.Updated = timestampPointer(settings.updated)
+		status.Stale = settings.stale()
+	}
+	c := &settings.counters
+	if settings.SSE {
+		status.SSE = &RevocationSSEStatus{Connected: c.sseConnected, Error: c.sseError}
+		if !c.sseLastEvent.IsZero() {
+			status.SSE.LastEvent = timestampPointer(c.sseLastEvent)
+		}
+	}
+	status.SyncFailures = c.syncFailures
+	status.LastSyncError = c.syncError
+	if !c.syncErrorTime.IsZero() {
+		status.LastSyncErrorTime = timestampPointer(c.syncErrorTime)
+	}
+	status.ToleranceViolations = c.toleranceViolations
+	return status
+}
+
+// checkStaleness logs a warning when the nonrevocation guarantees of a credential type that we
+// keep up to date in the background become older than its tolerance, and when they recover.
+func (rs *RevocationStorage) checkStaleness() {
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	for id, settings := range rs.settings.Snapshot() {
+		if settings.IsAuthority() || (!settings.Server && !settings.SSE) || settings.updated.IsZero() {
+			continue
+		}
+		stale := settings.stale()
+		if stale && !settings.counters.stale {
+			Logger.WithField("credtype", id).Warnf(
+				"nonrevocation is guaranteed only until %s ago, exceeding tolerance of %d seconds",
+				time.Now().Sub(settings.updated).Round(time.Second), settings.Tolerance)
+		} else if !stale && settings.counters.stale {
+			Logger.WithField("credtype", id).Info("nonrevocation guarantees are within tolerance again")
+		}
+		settings.counters.stale = stale
+	}
+}
+
+// setUpdated records that our nonrevocation guarantees for the credential type last until now.
+func (rs *RevocationStorage) setUpdated(id CredentialTypeIdentifier) {
+	settings := rs.settings.Get(id)
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	settings.updated = time.Now()
+}
+
+// updatedAt returns up to when our nonrevocation guarantees for the credential type last.
+func (rs *RevocationStorage) updatedAt(id CredentialTypeIdentifier) time.Time {
+	settings := rs.settings.Get(id)
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	return settings.updated
+}
+
+func (rs *RevocationStorage) recordSyncFailure(id CredentialTypeIdentifier, err error) {
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	c := &rs.settings.Get(id).counters
+	c.syncFailures++
+	c.syncError = err.Error()
+	c.syncErrorTime = time.Now()
+}
+
+func (rs *RevocationStorage) recordToleranceViolation(id CredentialTypeIdentifier) {
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	rs.settings.Get(id).counters.toleranceViolations++
+}
+
+func (rs *RevocationStorage) recordSSEState(id CredentialTypeIdentifier, connected bool, err error) {
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	c := &rs.settings.Get(id).counters
+	c.sseConnected = connected
+	if err != nil {
+		c.sseError = err.Error()
+	} else if connected {
+		c.sseError = ""
+	}
+}
+
+func (rs *RevocationStorage) recordSSEEvent(id CredentialTypeIdentifier) {
+	rs.statusMutex.Lock()
+	defer rs.statusMutex.Unlock()
+	rs.settings.Get(id).counters.sseLastEvent = time.Now()
+}
+
+func (s *RevocationSetting) mode() string {
+	switch {
+	case s.IsAuthority():
+		return "authority"
+	case s.IsStandby():
+		return "standby"
+	case s.Server:
+		return "server"
+	default:
+		return "requestor"
+	}
+}
+
+// stale returns whether our nonrevocation guarantees are older than the tolerance.
+func (s *RevocationSetting) stale() bool {
+	return time.Now().Sub(s.updated) > time.Duration(s.Tolerance)*time.Second
+}
+
+func timestampPointer(t time.Time) *Timestamp {
+	ts := Timestamp(t)
+	return &ts
+}
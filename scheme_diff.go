@@ -0,0 +1,266 @@
+package irma
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This file contains functions for computing the differences between two versions
+// of the issuer schemes in a Configuration, e.g. before and after a scheme update.
+
+type (
+	// SchemeDiff contains the differences between two Configurations.
+	SchemeDiff struct {
+		Changes []*SchemeChange `json:"changes"`
+	}
+
+	// SchemeChange describes a single difference between two Configurations.
+	SchemeChange struct {
+		Type       SchemeChangeType `json:"type"`
+		Identifier string           `json:"id"`
+		Old        string           `json:"old,omitempty"`
+		New        string           `json:"new,omitempty"`
+		// Breaking indicates that the change invalidates existing credentials
+		Breaking bool   `json:"breaking"`
+		Reason   string `json:"reason,omitempty"`
+	}
+
+	SchemeChangeType string
+)
+
+const (
+	SchemeChangeSchemeAdded              = SchemeChangeType("scheme-added")
+	SchemeChangeSchemeRemoved            = SchemeChangeType("scheme-removed")
+	SchemeChangeIssuerAdded              = SchemeChangeType("issuer-added")
+	SchemeChangeIssuerRemoved            = SchemeChangeType("issuer-removed")
+	SchemeChangeIssuerDeprecated         = SchemeChangeType("issuer-deprecated")
+	SchemeChangeCredentialTypeAdded      = SchemeChangeType("credentialtype-added")
+	SchemeChangeCredentialTypeRemoved    = SchemeChangeType("credentialtype-removed")
+	SchemeChangeCredentialTypeDeprecated = SchemeChangeType("credentialtype-deprecated")
+	SchemeChangeRevocationEnabled        = SchemeChangeType("revocation-enabled")
+	SchemeChangeRevocationDisabled       = SchemeChangeType("revocation-disabled")
+	SchemeChangeAttributeAdded           = SchemeChangeType("attribute-added")
+	SchemeChangeAttributeRemoved         = SchemeChangeType("attribute-removed")
+	SchemeChangeAttributeMoved           = SchemeChangeType("attribute-moved")
+	SchemeChangeAttributeOptional        = SchemeChangeType("attribute-optional")
+	SchemeChangeAttributeRandomBlind     = SchemeChangeType("attribute-randomblind")
+	SchemeChangePublicKeyAdded           = SchemeChangeType("publickey-added")
+	SchemeChangePublicKeyRemoved         = SchemeChangeType("publickey-removed")
+)
+
+// DiffConfigurations computes the changes of the issuer schemes in the new Configuration with respect to
+// the ones in the old Configuration. The changes are sorted by identifier.
+func DiffConfigurations(oldconf, newconf *Configuration) (*SchemeDiff, error) {
+	diff := &SchemeDiff{}
+
+	for id := range oldconf.SchemeManagers {
+		if _, ok := newconf.SchemeManagers[id]; !ok {
+			diff.add(SchemeChangeSchemeRemoved, id.String(), true, "existing credentials can no longer be used")
+		}
+	}
+	for id := range newconf.SchemeManagers {
+		if _, ok := oldconf.SchemeManagers[id]; !ok {
+			diff.add(SchemeChangeSchemeAdded, id.String(), false, "")
+		}
+	}
+
+	for id, oldissuer := range oldconf.Issuers {
+		newissuer, ok := newconf.Issuers[id]
+		if !ok {
+			if _, schemeExists := newconf.SchemeManagers[id.SchemeManagerIdentifier()]; schemeExists {
+				diff.add(SchemeChangeIssuerRemoved, id.String(), true, "existing credentials can no longer be used")
+			}
+			continue
+		}
+		if oldissuer.DeprecatedSince.IsZero() && !newissuer.DeprecatedSince.IsZero() {
+			diff.add(SchemeChangeIssuerDeprecated, id.String(), false, "").New = formatDeprecation(newissuer.DeprecatedSince)
+		}
+		if err := diff.comparePublicKeys(id, oldconf, newconf); err != nil {
+			return nil, err
+		}
+	}
+	for id := range newconf.Issuers {
+		if _, ok := oldconf.Issuers[id]; !ok {
+			diff.add(SchemeChangeIssuerAdded, id.String(), false, "")
+			if err := diff.comparePublicKeys(id, oldconf, newconf); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for id, oldcred := range oldconf.CredentialTypes {
+		newcred, ok := newconf.CredentialTypes[id]
+		if !ok {
+			if _, issuerExists := newconf.Issuers[id.IssuerIdentifier()]; issuerExists {
+				diff.add(SchemeChangeCredentialTypeRemoved, id.String(), true, "existing credentials can no longer be used")
+			}
+			continue
+		}
+		diff.compareCredentialTypes(oldcred, newcred)
+	}
+	for id := range newconf.CredentialTypes {
+		if _, ok := oldconf.CredentialTypes[id]; !ok {
+			diff.add(SchemeChangeCredentialTypeAdded, id.String(), false, "")
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Identifier != diff.Changes[j].Identifier {
+			return diff.Changes[i].Identifier < diff.Changes[j].Identifier
+		}
+		return diff.Changes[i].Type < diff.Changes[j].Type
+	})
+	return diff, nil
+}
+
+// Breaking returns the changes that invalidate existing credentials.
+func (diff *SchemeDiff) Breaking() []*SchemeChange {
+	var changes []*SchemeChange
+	for _, change := range diff.Changes {
+		if change.Breaking {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// Empty returns true if there are no changes.
+func (diff *SchemeDiff) Empty() bool {
+	return len(diff.Changes) == 0
+}
+
+// String returns a human-readable summary of the changes, one per line.
+func (diff *SchemeDiff) String() string {
+	var b strings.Builder
+	for _, change := range diff.Changes {
+		b.WriteString(change.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (change *SchemeChange) String() string {
+	s := fmt.Sprintf("%s %s", change.Type, change.Identifier)
+	if change.Old != "" || change.New != "" {
+		s += fmt.Sprintf(" (%s -> %s)", change.Old, change.New)
+	}
+	if change.Breaking {
+		s += " [BREAKING: " + change.Reason + "]"
+	} else if change.Reason != "" {
+		s += " [" + change.Reason + "]"
+	}
+	return s
+}
+
+func (diff *SchemeDiff) add(typ SchemeChangeType, id string, breaking bool, reason string) *SchemeChange {
+	change := &SchemeChange{Type: typ, Identifier: id, Breaking: breaking, Reason: reason}
+	diff.Changes = append(diff.Changes, change)
+	return change
+}
+
+func (diff *SchemeDiff) comparePublicKeys(id IssuerIdentifier, oldconf, newconf *Configuration) error {
+	var oldkeys, newkeys []uint
+	var err error
+	if _, ok := oldconf.Issuers[id]; ok {
+		if oldkeys, err = oldconf.PublicKeyIndices(id); err != nil {
+			return err
+		}
+	}
+	if newkeys, err = newconf.PublicKeyIndices(id); err != nil {
+		return err
+	}
+
+	present := map[uint]bool{}
+	for _, counter := range newkeys {
+		present[counter] = true
+	}
+	for _, counter := range oldkeys {
+		if !present[counter] {
+			diff.add(SchemeChangePublicKeyRemoved, fmt.Sprintf("%s-%d", id, counter), true,
+				"existing credentials signed with this key can no longer be verified")
+		}
+		delete(present, counter)
+	}
+	for _, counter := range newkeys {
+		if present[counter] {
+			diff.add(SchemeChangePublicKeyAdded, fmt.Sprintf("%s-%d", id, counter), false, "")
+		}
+	}
+	return nil
+}
+
+func (diff *SchemeDiff) compareCredentialTypes(oldcred, newcred *CredentialType) {
+	credid := oldcred.Identifier().String()
+	if oldcred.DeprecatedSince.IsZero() && !newcred.DeprecatedSince.IsZero() {
+		diff.add(SchemeChangeCredentialTypeDeprecated, credid, false, "").New = formatDeprecation(newcred.DeprecatedSince)
+	}
+	if !oldcred.RevocationSupported() && newcred.RevocationSupported() {
+		diff.add(SchemeChangeRevocationEnabled, credid, true,
+			"existing credentials lack the revocation attribute")
+	}
+	if oldcred.RevocationSupported() && !newcred.RevocationSupported() {
+		diff.add(SchemeChangeRevocationDisabled, credid, true,
+			"existing credentials contain a revocation attribute")
+	}
+
+	oldattrs, newattrs := attributeIndices(oldcred), attributeIndices(newcred)
+	for i, oldattr := range oldcred.AttributeTypes {
+		if oldattr.RevocationAttribute {
+			continue
+		}
+		id := credid + "." + oldattr.ID
+		j, ok := newattrs[oldattr.ID]
+		if !ok {
+			diff.add(SchemeChangeAttributeRemoved, id, true, "existing credentials contain this attribute")
+			continue
+		}
+		newattr := newcred.AttributeTypes[j]
+		if i != j {
+			c := diff.add(SchemeChangeAttributeMoved, id, true, "attribute order of existing credentials differs")
+			c.Old, c.New = fmt.Sprint(i), fmt.Sprint(j)
+		}
+		if oldattr.IsOptional() != newattr.IsOptional() {
+			// Existing credentials might lack an attribute that has become required
+			c := diff.add(SchemeChangeAttributeOptional, id, !newattr.IsOptional(), "")
+			c.Old, c.New = fmt.Sprint(oldattr.IsOptional()), fmt.Sprint(newattr.IsOptional())
+			if c.Breaking {
+				c.Reason = "existing credentials might lack this attribute"
+			}
+		}
+		if oldattr.RandomBlind != newattr.RandomBlind {
+			c := diff.add(SchemeChangeAttributeRandomBlind, id, true, "existing credentials were issued differently")
+			c.Old, c.New = fmt.Sprint(oldattr.RandomBlind), fmt.Sprint(newattr.RandomBlind)
+		}
+	}
+	for j, newattr := range newcred.AttributeTypes {
+		if newattr.RevocationAttribute {
+			continue
+		}
+		if _, ok := oldattrs[newattr.ID]; ok {
+			continue
+		}
+		c := diff.add(SchemeChangeAttributeAdded, credid+"."+newattr.ID, false, "")
+		switch {
+		case !newattr.IsOptional() && !newattr.RandomBlind:
+			c.Breaking, c.Reason = true, "existing credentials lack this required attribute"
+		case j < len(oldcred.AttributeTypes):
+			c.Breaking, c.Reason = true, "attribute order of existing credentials differs"
+		}
+	}
+}
+
+func attributeIndices(cred *CredentialType) map[string]int {
+	indices := make(map[string]int, len(cred.AttributeTypes))
+	for i, attr := range cred.AttributeTypes {
+		if !attr.RevocationAttribute {
+			indices[attr.ID] = i
+		}
+	}
+	return indices
+}
+
+func formatDeprecation(t Timestamp) string {
+	return time.Time(t).UTC().Format(time.RFC3339)
+}
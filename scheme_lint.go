@@ -0,0 +1,441 @@
+package irma
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// This file contains a linter for issuer schemes, which unlike parsing the scheme into a
+// Configuration does not stop at the first problem, but collects all problems it finds
+// along with their position.
+
+type (
+	// LintOptions configures LintScheme.
+	LintOptions struct {
+		// Languages in which all texts must be translated (default: en, nl)
+		Languages []string
+		// Public keys expiring within this period are reported (default: 31 days)
+		ExpiryWindow time.Duration
+	}
+
+	// LintDiagnostic is a problem in a scheme found by LintScheme.
+	LintDiagnostic struct {
+		Rule     LintRule     `json:"rule"`
+		Severity LintSeverity `json:"severity"`
+		Message  string       `json:"message"`
+		// File is the slash-separated path of the file relative to the scheme directory
+		File string `json:"file"`
+		Line int    `json:"line,omitempty"`
+	}
+
+	LintRule     string
+	LintSeverity string
+
+	schemeLinter struct {
+		path        string
+		opts        LintOptions
+		conf        *Configuration
+		scheme      *SchemeManager
+		index       SchemeManagerIndex
+		diagnostics []*LintDiagnostic
+		logos       map[string]struct{}
+	}
+
+	// xmlLines maps paths of elements within an XML document to their line number. Paths are of the
+	// form "IssueSpecification/Attributes/Attribute[2]/Name", where the [i] suffix denotes the i-th
+	// occurence of an element within its parent (omitted for the first).
+	xmlLines map[string]int
+)
+
+const (
+	LintSeverityError   = LintSeverity("error")
+	LintSeverityWarning = LintSeverity("warning")
+
+	LintRuleSignature          = LintRule("signature")
+	LintRuleIndex              = LintRule("index")
+	LintRuleUnindexedFile      = LintRule("unindexed-file")
+	LintRuleParse              = LintRule("parse")
+	LintRuleValidation         = LintRule("validation")
+	LintRuleMissingTranslation = LintRule("missing-translation")
+	LintRuleUnusedLogo         = LintRule("unused-logo")
+	LintRuleDisplayIndex       = LintRule("display-index")
+	LintRuleDisplayHint        = LintRule("display-hint")
+	LintRuleDeprecation        = LintRule("deprecation")
+	LintRuleKeyExpiry          = LintRule("key-expiry")
+)
+
+// LintRules contains a short description of each lint rule.
+var LintRules = map[LintRule]string{
+	LintRuleSignature:          "The scheme index must be validly signed",
+	LintRuleIndex:              "Files in the index must exist and match their hash",
+	LintRuleUnindexedFile:      "Files in the scheme should be included in the index",
+	LintRuleParse:              "Descriptions must be valid XML",
+	LintRuleValidation:         "Issuers and credential types must pass validation",
+	LintRuleMissingTranslation: "Texts should be translated in all languages",
+	LintRuleUnusedLogo:         "Logos should belong to an issuer or credential type",
+	LintRuleDisplayIndex:       "Attribute display indices should be unique and within range",
+	LintRuleDisplayHint:        "Attribute display hints should be recognized",
+	LintRuleDeprecation:        "Credential types of deprecated issuers should be deprecated",
+	LintRuleKeyExpiry:          "Issuers should have a public key that does not expire soon",
+}
+
+// AttributeDisplayHints contains the recognized values of AttributeType.DisplayHint.
+var AttributeDisplayHints = []string{"portraitPhoto"}
+
+// LintScheme checks the issuer scheme at the specified path for problems, returning all
+// problems found sorted by file and line. An error is returned only if linting could not be
+// performed at all.
+func LintScheme(path string, opts LintOptions) ([]*LintDiagnostic, error) {
+	if len(opts.Languages) == 0 {
+		opts.Languages = validLangs
+	}
+	if opts.ExpiryWindow == 0 {
+		opts.ExpiryWindow = 31 * 24 * time.Hour
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := NewConfiguration(filepath.Dir(path), ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	l := &schemeLinter{path: path, opts: opts, conf: conf, logos: map[string]struct{}{}}
+
+	if err = l.lintScheme(); err != nil {
+		return nil, err
+	}
+	if err = l.lintIssuers(); err != nil {
+		return nil, err
+	}
+	if err = l.lintIndex(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return l.diagnostics, nil
+}
+
+// String returns the diagnostic in the format file:line: severity: message (rule).
+func (d *LintDiagnostic) String() string {
+	pos := d.File
+	if d.Line > 0 {
+		pos += ":" + strconv.Itoa(d.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", pos, d.Severity, d.Message, d.Rule)
+}
+
+func (l *schemeLinter) report(rule LintRule, severity LintSeverity, file string, line int, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, &LintDiagnostic{
+		Rule:     rule,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		File:     filepath.ToSlash(file),
+		Line:     line,
+	})
+}
+
+// readXML reads and unmarshals the XML file at the specified path relative to the scheme,
+// reporting any problem. It returns the line numbers of the elements in the file, or nil
+// if the file could not be read or parsed.
+func (l *schemeLinter) readXML(file string, dest interface{}) (xmlLines, error) {
+	bts, err := ioutil.ReadFile(filepath.Join(l.path, file))
+	if err != nil {
+		return nil, err
+	}
+	lines, err := parseXMLLines(bts)
+	if err == nil {
+		err = xml.Unmarshal(bts, dest)
+	}
+	if err != nil {
+		line := 0
+		if serr, ok := err.(*xml.SyntaxError); ok {
+			line = serr.Line
+		}
+		l.report(LintRuleParse, LintSeverityError, file, line, "failed to parse: %s", err)
+		return nil, nil
+	}
+	return lines, nil
+}
+
+func (l *schemeLinter) lintScheme() error {
+	if err := l.conf.verifySignature(l.path); err != nil {
+		l.report(LintRuleSignature, LintSeverityError, "index.sig", 0, "%s", err)
+	}
+
+	l.index = SchemeManagerIndex(make(map[string]SchemeFileHash))
+	bts, err := ioutil.ReadFile(filepath.Join(l.path, "index"))
+	if err != nil {
+		l.report(LintRuleIndex, LintSeverityError, "index", 0, "failed to read index: %s", err)
+	} else if err = l.index.FromString(string(bts)); err != nil {
+		l.report(LintRuleIndex, LintSeverityError, "index", 0, "failed to parse index: %s", err)
+	}
+
+	l.scheme = &SchemeManager{storagepath: l.path}
+	lines, err := l.readXML("description.xml", l.scheme)
+	if err != nil {
+		return err
+	}
+	if lines == nil {
+		return errors.New("scheme description could not be parsed")
+	}
+	if l.scheme.XMLVersion < 7 {
+		l.report(LintRuleValidation, LintSeverityError, "description.xml", 1, "unsupported scheme description version")
+	}
+	l.lintTranslations("description.xml", lines, "SchemeManager", "", l.scheme)
+	return nil
+}
+
+func (l *schemeLinter) lintIndex() error {
+	if len(l.index) == 0 {
+		return nil
+	}
+	if l.index.Scheme() != l.scheme.ID {
+		l.report(LintRuleIndex, LintSeverityError, "index", 0, "index belongs to scheme %s", l.index.Scheme())
+		return nil
+	}
+
+	for file, hash := range l.index {
+		rel := file[len(l.scheme.ID)+1:]
+		bts, err := ioutil.ReadFile(filepath.Join(l.path, filepath.FromSlash(rel)))
+		if os.IsNotExist(err) {
+			// Issuer private keys and logos need not be present (see also verifyFiles())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		computed := sha256.Sum256(bts)
+		if !bytes.Equal(computed[:], hash) {
+			l.report(LintRuleIndex, LintSeverityError, rel, 0, "hash of file does not match index")
+		}
+	}
+
+	err := walkUnsignedFiles(l.path, l.index, func(schemepath string, info os.FileInfo) {
+		rel := schemepath[len(l.scheme.ID)+1:]
+		if info.IsDir() {
+			l.report(LintRuleUnindexedFile, LintSeverityWarning, rel, 0, "directory contains no files in the index")
+		} else {
+			l.report(LintRuleUnindexedFile, LintSeverityWarning, rel, 0, "file is not in the index")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Report all logos that don't belong to an issuer or credential type
+	return common.WalkDir(l.path, func(path string, info os.FileInfo) error {
+		if info.IsDir() || filepath.Ext(path) != ".png" {
+			return nil
+		}
+		rel, err := filepath.Rel(l.path, path)
+		if err != nil {
+			return err
+		}
+		if _, used := l.logos[filepath.ToSlash(rel)]; !used {
+			l.report(LintRuleUnusedLogo, LintSeverityWarning, rel, 0, "logo is not used by any issuer or credential type")
+		}
+		return nil
+	})
+}
+
+func (l *schemeLinter) lintIssuers() error {
+	return common.IterateSubfolders(l.path, func(dir string, _ os.FileInfo) error {
+		file := filepath.Join(filepath.Base(dir), "description.xml")
+		exists, err := common.PathExists(filepath.Join(l.path, file))
+		if err != nil || !exists {
+			return err
+		}
+		issuer := &Issuer{}
+		lines, err := l.readXML(file, issuer)
+		if err != nil || lines == nil {
+			return err
+		}
+		l.logos[filepath.ToSlash(filepath.Join(filepath.Base(dir), "logo.png"))] = struct{}{}
+
+		if issuer.XMLVersion < 4 {
+			l.report(LintRuleValidation, LintSeverityError, file, 1, "unsupported issuer description version")
+		} else if err = l.conf.validateIssuer(l.scheme, issuer, dir); err != nil {
+			l.report(LintRuleValidation, LintSeverityError, file, 1, "%s", err)
+		}
+		l.lintTranslations(file, lines, "Issuer", "", issuer)
+		if err = l.lintPublicKeys(issuer, dir); err != nil {
+			return err
+		}
+		return l.lintCredentialTypes(issuer, dir)
+	})
+}
+
+func (l *schemeLinter) lintPublicKeys(issuer *Issuer, dir string) error {
+	deprecated := !issuer.DeprecatedSince.IsZero() && !issuer.DeprecatedSince.After(Timestamp(time.Now()))
+	if deprecated {
+		return nil
+	}
+	counters, err := matchKeyPattern(filepath.Join(dir, "PublicKeys", "*"))
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(issuer.ID, "description.xml")
+	if len(counters) == 0 {
+		l.report(LintRuleKeyExpiry, LintSeverityWarning, file, 1, "issuer has no public keys")
+		return nil
+	}
+
+	latest := filepath.Join(issuer.ID, "PublicKeys", fmt.Sprintf("%d.xml", counters[len(counters)-1]))
+	pk, err := gabi.NewPublicKeyFromFile(filepath.Join(l.path, latest))
+	if err != nil {
+		l.report(LintRuleParse, LintSeverityError, latest, 0, "failed to parse public key: %s", err)
+		return nil
+	}
+	expiry := time.Unix(pk.ExpiryDate, 0)
+	switch {
+	case expiry.Before(time.Now()):
+		l.report(LintRuleKeyExpiry, LintSeverityError, latest, 0,
+			"latest public key of non-deprecated issuer expired at %s", expiry.Format(time.RFC3339))
+	case expiry.Before(time.Now().Add(l.opts.ExpiryWindow)):
+		l.report(LintRuleKeyExpiry, LintSeverityWarning, latest, 0,
+			"latest public key expires soon, at %s", expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (l *schemeLinter) lintCredentialTypes(issuer *Issuer, dir string) error {
+	return common.IterateSubfolders(filepath.Join(dir, "Issues"), func(dir string, _ os.FileInfo) error {
+		file := filepath.Join(issuer.ID, "Issues", filepath.Base(dir), "description.xml")
+		exists, err := common.PathExists(filepath.Join(l.path, file))
+		if err != nil || !exists {
+			return err
+		}
+		cred := &CredentialType{}
+		lines, err := l.readXML(file, cred)
+		if err != nil || lines == nil {
+			return err
+		}
+		l.logos[filepath.ToSlash(filepath.Join(filepath.Dir(file), "logo.png"))] = struct{}{}
+
+		// validateCredentialType() adds warnings to the Configuration which we report more
+		// precisely below, so we only use its error
+		if err = l.conf.validateCredentialType(l.scheme, issuer, cred, dir); err != nil {
+			l.report(LintRuleValidation, LintSeverityError, file, 1, "%s", err)
+		}
+		l.lintTranslations(file, lines, "IssueSpecification", "", cred)
+
+		if !issuer.DeprecatedSince.IsZero() && cred.DeprecatedSince.IsZero() {
+			l.report(LintRuleDeprecation, LintSeverityWarning, file, lines["IssueSpecification"],
+				"credential type of deprecated issuer %s is not deprecated", issuer.ID)
+		}
+
+		l.lintAttributes(file, lines, cred)
+		return nil
+	})
+}
+
+func (l *schemeLinter) lintAttributes(file string, lines xmlLines, cred *CredentialType) {
+	count := len(cred.AttributeTypes)
+	indices := map[int]string{}
+	for i, attr := range cred.AttributeTypes {
+		path := "IssueSpecification/Attributes/Attribute"
+		if i > 0 {
+			path += fmt.Sprintf("[%d]", i)
+		}
+		line := lines[path]
+		if attr.RevocationAttribute {
+			continue
+		}
+		l.lintTranslations(file, lines, path, "attribute "+attr.ID+" ", attr)
+
+		index := i
+		if attr.DisplayIndex != nil {
+			index = *attr.DisplayIndex
+		}
+		if index < 0 || index >= count {
+			l.report(LintRuleDisplayIndex, LintSeverityWarning, file, line,
+				"attribute %s has displayIndex %d out of range", attr.ID, index)
+		} else if other, ok := indices[index]; ok {
+			l.report(LintRuleDisplayIndex, LintSeverityWarning, file, line,
+				"attribute %s has the same displayIndex (%d) as attribute %s", attr.ID, index, other)
+		}
+		indices[index] = attr.ID
+
+		if attr.DisplayHint != "" && !stringSliceContains(AttributeDisplayHints, attr.DisplayHint) {
+			l.report(LintRuleDisplayHint, LintSeverityWarning, file, line,
+				"attribute %s has unrecognized displayHint %s", attr.ID, attr.DisplayHint)
+		}
+	}
+}
+
+// lintTranslations reports the missing translations of the TranslatedString members of o,
+// which is described by the element at the specified path in the XML file. The prefix is
+// prepended to the messages.
+func (l *schemeLinter) lintTranslations(file string, lines xmlLines, path, prefix string, o interface{}) {
+	for _, missing := range missingTranslations(o, l.opts.Languages) {
+		line, ok := lines[path+"/"+missing.field]
+		if !ok {
+			line = lines[path]
+		}
+		l.report(LintRuleMissingTranslation, LintSeverityWarning, file, line,
+			"%s<%s> misses %s translation", prefix, missing.field, missing.lang)
+	}
+}
+
+func parseXMLLines(bts []byte) (xmlLines, error) {
+	var (
+		lines   = xmlLines{}
+		decoder = xml.NewDecoder(bytes.NewReader(bts))
+		stack   []string
+		counts  = []map[string]int{{}}
+	)
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if i := counts[len(counts)-1][name]; i > 0 {
+				name += fmt.Sprintf("[%d]", i)
+			}
+			counts[len(counts)-1][t.Name.Local]++
+			stack = append(stack, name)
+			counts = append(counts, map[string]int{})
+			lines[strings.Join(stack, "/")] = bytes.Count(bts[:offset], []byte{'\n'}) + 1
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			counts = counts[:len(counts)-1]
+		}
+	}
+}
+
+func stringSliceContains(slice []string, s string) bool {
+	for _, x := range slice {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -705,6 +705,18 @@ func (conf *Configuration) parseIndex(dir string) (SchemeManagerIndex, error, Sc
 }
 
 func (conf *Configuration) checkUnsignedFiles(dir string, index SchemeManagerIndex) error {
+	return walkUnsignedFiles(dir, index, func(schemepath string, info os.FileInfo) {
+		if info.IsDir() {
+			conf.Warnings = append(conf.Warnings, "Ignored dir: "+schemepath)
+		} else {
+			conf.Warnings = append(conf.Warnings, "Ignored file: "+schemepath)
+		}
+	})
+}
+
+// walkUnsignedFiles calls the handler for each file and directory in the scheme at dir
+// that is not present in the index (and that is not expected to be).
+func walkUnsignedFiles(dir string, index SchemeManagerIndex, handler func(schemepath string, info os.FileInfo)) error {
 	return common.WalkDir(dir, func(path string, info os.FileInfo) error {
 		relpath, err := filepath.Rel(dir, path)
 		if err != nil {
@@ -719,11 +731,11 @@ func (conf *Configuration) checkUnsignedFiles(dir string, index SchemeManagerInd
 
 		if info.IsDir() {
 			if !dirInScheme(index, schemepath) {
-				conf.Warnings = append(conf.Warnings, "Ignored dir: "+schemepath)
+				handler(schemepath, info)
 			}
 		} else {
 			if _, ok := index[schemepath]; !ok {
-				conf.Warnings = append(conf.Warnings, "Ignored file: "+schemepath)
+				handler(schemepath, info)
 			}
 		}
 
@@ -1190,6 +1202,9 @@ func (scheme *RequestorScheme) setPath(path string) { scheme.storagepath = path
 
 func (scheme *RequestorScheme) parseContents(conf *Configuration) error {
 	for _, requestor := range scheme.requestors {
+		if err := requestor.validate(); err != nil {
+			return err
+		}
 		for _, hostname := range requestor.Hostnames {
 			if _, ok := conf.Requestors[hostname]; ok {
 				return errors.Errorf("Double occurence of hostname %s", hostname)
@@ -1200,6 +1215,57 @@ func (scheme *RequestorScheme) parseContents(conf *Configuration) error {
 	return nil
 }
 
+// validate checks the purpose and allowed attributes of the requestor. Whether the allowed
+// attributes exist is checked by Configuration.ValidateRequestors, as they may be defined in
+// schemes that are parsed after the requestor scheme.
+func (requestor *RequestorInfo) validate() error {
+	if requestor.Purpose != nil {
+		if len(*requestor.Purpose) == 0 {
+			return errors.Errorf("Requestor %s has empty purpose", requestor.Name)
+		}
+		for lang, purpose := range *requestor.Purpose {
+			if purpose == "" {
+				return errors.Errorf("Requestor %s has empty %s purpose", requestor.Name, lang)
+			}
+		}
+	}
+	for _, id := range requestor.AllowedAttributes {
+		parts := strings.Split(id.String(), ".")
+		if len(parts) != 3 && len(parts) != 4 {
+			return errors.Errorf("Requestor %s has malformed allowed attribute %s", requestor.Name, id)
+		}
+		for _, part := range parts {
+			if part == "" {
+				return errors.Errorf("Requestor %s has malformed allowed attribute %s", requestor.Name, id)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateRequestors checks that the attribute and credential types that requestors are allowed
+// to ask for exist. Those of schemes that are not present are reported in conf.Warnings.
+func (conf *Configuration) ValidateRequestors() error {
+	for _, scheme := range conf.RequestorSchemes {
+		for _, requestor := range scheme.requestors {
+			for _, id := range requestor.AllowedAttributes {
+				credid := id.CredentialTypeIdentifier()
+				if _, ok := conf.SchemeManagers[credid.IssuerIdentifier().SchemeManagerIdentifier()]; !ok {
+					conf.Warnings = append(conf.Warnings, fmt.Sprintf(
+						"Requestor %s of requestor scheme %s is allowed attribute %s of unknown scheme", requestor.Name, scheme.ID, id))
+					continue
+				}
+				_, attrExists := conf.AttributeTypes[id]
+				if _, credExists := conf.CredentialTypes[credid]; !credExists || !id.IsCredential() && !attrExists {
+					return errors.Errorf("Requestor %s of requestor scheme %s is allowed nonexisting attribute %s",
+						requestor.Name, scheme.ID, id)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (scheme *RequestorScheme) validate(conf *Configuration) (error, SchemeManagerStatus) {
 	// Verify all files in index, reading the RequestorChunks
 	var (
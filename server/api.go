@@ -48,6 +48,16 @@ type SessionResult struct {
 // once an IRMA session has completed.
 type SessionHandler func(*SessionResult)
 
+// KeyExpiryStatus contains the result of the most recent check for issuer private keys
+// whose public key expires soon.
+type KeyExpiryStatus struct {
+	Checked    irma.Timestamp    `json:"checked"`
+	WindowDays int               `json:"windowDays"`
+	Expiring   int               `json:"expiring"`
+	Expired    int               `json:"expired"`
+	Keys       []*irma.KeyExpiry `json:"keys"`
+}
+
 // Status is the status of an IRMA session.
 type Status string
 
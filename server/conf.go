@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-errors/errors"
@@ -32,6 +33,11 @@ type Configuration struct {
 	SchemesUpdateInterval int `json:"schemes_update" mapstructure:"schemes_update"`
 	// Path to issuer private keys to parse
 	IssuerPrivateKeysPath string `json:"privkeys" mapstructure:"privkeys"`
+	// Path to the Unix socket of an external signer holding issuer private keys (see "irma signer")
+	IssuerPrivateKeysSigner string `json:"privkeys_signer" mapstructure:"privkeys_signer"`
+	// Warn about issuer private keys whose public key expires within this many days
+	// (default value 0 means 31; a negative value disables the check)
+	KeyExpiryWarning int `json:"key_expiry_warning" mapstructure:"key_expiry_warning"`
 	// URL at which the IRMA app can reach this server during sessions
 	URL string `json:"url" mapstructure:"url"`
 	// Required to be set to true if URL does not begin with https:// in production mode.
@@ -69,8 +75,15 @@ type Configuration struct {
 
 	// Connection string for revocation database
 	RevocationDBConnStr string `json:"revocation_db_str" mapstructure:"revocation_db_str"`
-	// Database type for revocation database, supported: postgres, mysql
+	// Database type for revocation database, supported: postgres, mysql, bolt.
+	// For bolt, the connection string is the path to the database file.
 	RevocationDBType string `json:"revocation_db_type" mapstructure:"revocation_db_type"`
+	// Refuse to start if the revocation database has pending schema migrations, instead of applying them.
+	// Migrations can then be applied manually using "irma issuer revocation db migrate".
+	RevocationDBNoMigrate bool `json:"revocation_db_no_migrate" mapstructure:"revocation_db_no_migrate"`
+	// Directory to which revocation update bundles are periodically published (leave empty to disable).
+	// Its contents may also be served by other web servers or a CDN.
+	RevocationBundleDir string `json:"revocation_bundle_dir" mapstructure:"revocation_bundle_dir"`
 	// Credentials types for which revocation database should be hosted
 	RevocationSettings irma.RevocationSettings `json:"revocation_settings" mapstructure:"revocation_settings"`
 
@@ -123,6 +136,42 @@ func (conf *Configuration) HavePrivateKeys() bool {
 	return false
 }
 
+// CheckKeyExpiry logs a warning for each issuer private key (i.e., the latest of each issuer)
+// whose public key expires within KeyExpiryWarning days, and returns these keys.
+// Returns nil if the check is disabled.
+func (conf *Configuration) CheckKeyExpiry() (*KeyExpiryStatus, error) {
+	if conf.KeyExpiryWarning < 0 {
+		return nil, nil
+	}
+	days := conf.KeyExpiryWarning
+	if days == 0 {
+		days = 31
+	}
+	keys, err := conf.IrmaConfiguration.ExpiringPrivateKeys(
+		conf.IrmaConfiguration.PrivateKeys, time.Duration(days)*24*time.Hour,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &KeyExpiryStatus{Checked: irma.Timestamp(time.Now()), WindowDays: days, Keys: keys}
+	for _, key := range keys {
+		entry := conf.Logger.WithFields(logrus.Fields{
+			"issuer":  key.Issuer.String(),
+			"counter": key.Counter,
+			"expiry":  time.Time(key.ExpiryDate).String(),
+		})
+		if key.Expired {
+			status.Expired++
+			entry.Error("Public key of latest issuer private key has expired; use \"irma issuer rotate\" to generate a new one")
+		} else {
+			status.Expiring++
+			entry.Warn("Public key of latest issuer private key expires soon; use \"irma issuer rotate\" to generate a new one")
+		}
+	}
+	return status, nil
+}
+
 // helpers
 
 func (conf *Configuration) verifyStaticSessions() error {
@@ -168,10 +217,12 @@ func (conf *Configuration) verifyIrmaConf() error {
 		}
 		conf.Logger.WithField("schemes_path", conf.SchemesPath).Info("Determined schemes path")
 		conf.IrmaConfiguration, err = irma.NewConfiguration(conf.SchemesPath, irma.ConfigurationOptions{
-			Assets:              conf.SchemesAssetsPath,
-			RevocationDBType:    conf.RevocationDBType,
-			RevocationDBConnStr: conf.RevocationDBConnStr,
-			RevocationSettings:  conf.RevocationSettings,
+			Assets:                conf.SchemesAssetsPath,
+			RevocationDBType:      conf.RevocationDBType,
+			RevocationDBConnStr:   conf.RevocationDBConnStr,
+			RevocationDBNoMigrate: conf.RevocationDBNoMigrate,
+			RevocationBundleDir:   conf.RevocationBundleDir,
+			RevocationSettings:    conf.RevocationSettings,
 		})
 		if err != nil {
 			return err
@@ -198,14 +249,25 @@ func (conf *Configuration) verifyIrmaConf() error {
 }
 
 func (conf *Configuration) verifyPrivateKeys() error {
-	if conf.IssuerPrivateKeysPath == "" {
-		return nil
+	if conf.IssuerPrivateKeysPath != "" {
+		ring, err := irma.NewPrivateKeyRingFolder(conf.IssuerPrivateKeysPath, conf.IrmaConfiguration)
+		if err != nil {
+			return err
+		}
+		if err = conf.IrmaConfiguration.AddPrivateKeyRing(ring); err != nil {
+			return err
+		}
 	}
-	ring, err := irma.NewPrivateKeyRingFolder(conf.IssuerPrivateKeysPath, conf.IrmaConfiguration)
-	if err != nil {
-		return err
+	if conf.IssuerPrivateKeysSigner != "" {
+		ring, err := irma.NewPrivateKeyRingSigner(conf.IssuerPrivateKeysSigner, conf.IrmaConfiguration)
+		if err != nil {
+			return err
+		}
+		if err = conf.IrmaConfiguration.AddPrivateKeyRing(ring); err != nil {
+			return err
+		}
 	}
-	return conf.IrmaConfiguration.AddPrivateKeyRing(ring)
+	return nil
 }
 
 func (conf *Configuration) prepareRevocation(credid irma.CredentialTypeIdentifier) error {
@@ -264,6 +326,12 @@ func (conf *Configuration) verifyRevocation() error {
 			if err := conf.prepareRevocation(credid); err != nil {
 				return err
 			}
+		} else if settings.Standby {
+			conf.Logger.Info("revocation standby mode enabled for " + credid.String())
+			// the standby signs its requests to the authority with the revocation private key
+			if _, err := rev.Keys.PrivateKeyLatest(credid.IssuerIdentifier()); err != nil {
+				return errors.WrapPrefix(err, "revocation standby mode enabled for "+credid.String()+" but no private key installed", 0)
+			}
 		} else if settings.Server {
 			conf.Logger.Info("revocation server mode enabled for " + credid.String())
 		}
@@ -6,6 +6,7 @@ package irmaserver
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/alexandrevicenzi/go-sse"
@@ -25,6 +26,8 @@ type Server struct {
 	stopScheduler    chan bool
 	handlers         map[string]server.SessionHandler
 	serverSentEvents *sse.Server
+	keyExpiry        *server.KeyExpiryStatus
+	keyExpiryMutex   sync.Mutex
 }
 
 // Default server instance
@@ -63,9 +66,9 @@ func New(conf *server.Configuration) (*Server, error) {
 	})
 
 	s.scheduler.Every(irma.RevocationParameters.RequestorUpdateInterval).Seconds().Do(func() {
-		for credid, settings := range s.conf.RevocationSettings {
-			if settings.Authority {
-				continue
+		for credid, settings := range s.conf.RevocationSettings.Snapshot() {
+			if settings.IsAuthority() || settings.IsStandby() {
+				continue // standbys are synchronized by irma.RevocationStorage itself
 			}
 			if err := s.conf.IrmaConfiguration.Revocation.SyncIfOld(credid, settings.Tolerance/2); err != nil {
 				s.conf.Logger.Errorf("failed to update revocation database for %s", credid.String())
@@ -74,6 +77,11 @@ func New(conf *server.Configuration) (*Server, error) {
 		}
 	})
 
+	if conf.KeyExpiryWarning >= 0 {
+		s.checkKeyExpiry()
+		s.scheduler.Every(1).Hours().Do(s.checkKeyExpiry)
+	}
+
 	s.stopScheduler = s.scheduler.Start()
 
 	return s, nil
@@ -130,12 +138,47 @@ func (s *Server) HandlerFunc() http.HandlerFunc {
 		r.Get("/updateevents", s.handleRevocationUpdateEvents)
 		r.Get("/update/{count:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Get("/update/{count:\\d+}/{counter:\\d+}", s.handleRevocationGetUpdateLatest)
+		r.Get("/bundle/{counter:\\d+}/{name}", s.handleRevocationGetBundle)
 		r.Post("/issuancerecord/{counter:\\d+}", s.handleRevocationPostIssuanceRecord)
+		r.Post("/replicate/{counter:\\d+}", s.handleRevocationPostReplicate)
+		r.Post("/fence/{counter:\\d+}", s.handleRevocationPostFence)
 	})
 
 	return s.router.ServeHTTP
 }
 
+// KeyExpiry returns the result of the most recent check for issuer private keys whose
+// public key expires soon, or nil if the check is disabled.
+func KeyExpiry() *server.KeyExpiryStatus {
+	return s.KeyExpiry()
+}
+func (s *Server) KeyExpiry() *server.KeyExpiryStatus {
+	s.keyExpiryMutex.Lock()
+	defer s.keyExpiryMutex.Unlock()
+	return s.keyExpiry
+}
+
+// RevocationStatus returns the revocation status of each credential type for which revocation
+// is configured or for which nonrevocation proofs have been requested.
+func RevocationStatus() []*irma.RevocationStatus {
+	return s.RevocationStatus()
+}
+func (s *Server) RevocationStatus() []*irma.RevocationStatus {
+	return s.conf.IrmaConfiguration.Revocation.Status()
+}
+
+func (s *Server) checkKeyExpiry() {
+	status, err := s.conf.CheckKeyExpiry()
+	if err != nil {
+		s.conf.Logger.Error("Failed to check issuer key expiry")
+		_ = server.LogError(err)
+		return
+	}
+	s.keyExpiryMutex.Lock()
+	defer s.keyExpiryMutex.Unlock()
+	s.keyExpiry = status
+}
+
 // Stop the server.
 func Stop() {
 	s.Stop()
@@ -247,6 +290,16 @@ func (s *Server) Revoke(credid irma.CredentialTypeIdentifier, key string, issued
 	return s.conf.IrmaConfiguration.Revocation.Revoke(credid, key, issued)
 }
 
+// QueryRevocation returns the issuance records of a revocation key and their revocation state,
+// or the event log of an issuer key, as specified by the request. (Can only be used if this server
+// is the revocation server for the specified credential type.)
+func QueryRevocation(request *irma.RevocationQueryRequest) (*irma.RevocationQueryResponse, error) {
+	return s.QueryRevocation(request)
+}
+func (s *Server) QueryRevocation(request *irma.RevocationQueryRequest) (*irma.RevocationQueryResponse, error) {
+	return s.conf.IrmaConfiguration.Revocation.Query(request)
+}
+
 // SubscribeServerSentEvents subscribes the HTTP client to server sent events on status updates
 // of the specified IRMA session.
 func SubscribeServerSentEvents(w http.ResponseWriter, r *http.Request, token string, requestor bool) error {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -195,9 +196,7 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 	var sigs []*gabi.IssueSignatureMessage
 	for i, cred := range request.Credentials {
 		id := cred.CredentialTypeID.IssuerIdentifier()
-		pk, _ := session.conf.IrmaConfiguration.PublicKey(id, cred.KeyCounter)
-		sk, _ := session.conf.IrmaConfiguration.PrivateKeys.Latest(id)
-		issuer := gabi.NewIssuer(sk, pk, one)
+		sk, _ := session.conf.IrmaConfiguration.PrivateKeys.Get(id, cred.KeyCounter)
 		proof, ok := commitments.Proofs[i+discloseCount].(*gabi.ProofU)
 		if !ok {
 			return nil, session.fail(server.ErrorMalformedInput, "Received invalid issuance commitment")
@@ -207,7 +206,9 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 			return nil, session.fail(server.ErrorIssuanceFailed, err.Error())
 		}
 		rb := session.conf.IrmaConfiguration.CredentialTypes[cred.CredentialTypeID].RandomBlindAttributeIndices()
-		sig, err := issuer.IssueSignature(proof.U, attrs, witness, commitments.Nonce2, rb)
+		sig, err := session.conf.IrmaConfiguration.IssueSignature(
+			id, cred.KeyCounter, proof.U, attrs, witness, commitments.Nonce2, rb,
+		)
 		if err != nil {
 			return nil, session.fail(server.ErrorIssuanceFailed, err.Error())
 		}
@@ -323,7 +324,7 @@ func (s *Server) handleRevocationGetEvents(w http.ResponseWriter, r *http.Reques
 	min, _ := strconv.ParseUint(chi.URLParam(r, "min"), 10, 64)
 	max, _ := strconv.ParseUint(chi.URLParam(r, "max"), 10, 64)
 
-	if settings := s.conf.RevocationSettings[cred]; settings == nil || !settings.Server {
+	if settings := s.conf.RevocationSettings.Lookup(cred); settings == nil || !settings.Server {
 		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
 		return
 	}
@@ -363,7 +364,7 @@ func (s *Server) handleRevocationGetUpdateLatest(w http.ResponseWriter, r *http.
 		counter = &k
 	}
 
-	if settings := s.conf.RevocationSettings[cred]; settings == nil || !settings.Server {
+	if settings := s.conf.RevocationSettings.Lookup(cred); settings == nil || !settings.Server {
 		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
 		return
 	}
@@ -387,12 +388,38 @@ func (s *Server) handleRevocationGetUpdateLatest(w http.ResponseWriter, r *http.
 	}
 }
 
+// GET revocation/bundle/{credtype}/{counter}/{name}
+func (s *Server) handleRevocationGetBundle(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+	counter, _ := strconv.ParseUint(chi.URLParam(r, "counter"), 10, 32)
+
+	if settings := s.conf.RevocationSettings.Lookup(cred); settings == nil || !settings.Server {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
+		return
+	}
+	bts, err := s.conf.IrmaConfiguration.Revocation.ReadBundleFile(cred, uint(counter), chi.URLParam(r, "name"))
+	if os.IsNotExist(err) {
+		server.WriteBinaryResponse(w, nil, &irma.RemoteError{Status: 404, ErrorName: string(server.ErrorInvalidRequest.Type)})
+		return
+	}
+	if err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		return
+	}
+	// Published bundles never change, but the index does when the next bundle is published
+	if chi.URLParam(r, "name") != "index" {
+		w.Header().Set("Cache-Control", "max-age=31536000, immutable")
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(bts)
+}
+
 // POST revocation/issuancerecord/{credtype}/{counter}
 func (s *Server) handleRevocationPostIssuanceRecord(w http.ResponseWriter, r *http.Request) {
 	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
 	counter, _ := strconv.ParseUint(chi.URLParam(r, "counter"), 10, 32)
 
-	if settings := s.conf.RevocationSettings[cred]; settings == nil || !settings.Authority {
+	if !s.conf.RevocationSettings.Lookup(cred).IsAuthority() {
 		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
 		return
 	}
@@ -425,3 +452,63 @@ func (s *Server) handleRevocationPostIssuanceRecord(w http.ResponseWriter, r *ht
 	w.WriteHeader(200)
 	return
 }
+
+// POST revocation/{credtype}/replicate/{counter}
+func (s *Server) handleRevocationPostReplicate(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+	req, rerr := s.replicationRequest(r, cred)
+	if rerr != nil {
+		server.WriteBinaryResponse(w, nil, rerr)
+		return
+	}
+	resp, err := s.conf.IrmaConfiguration.Revocation.Replicate(cred, req.Since)
+	if err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		return
+	}
+	server.WriteBinaryResponse(w, resp, nil)
+}
+
+// POST revocation/{credtype}/fence/{counter}
+func (s *Server) handleRevocationPostFence(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+	req, rerr := s.replicationRequest(r, cred)
+	if rerr != nil {
+		server.WriteBinaryResponse(w, nil, rerr)
+		return
+	}
+	if err := s.conf.IrmaConfiguration.Revocation.Fence(cred, req.Epoch); err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		return
+	}
+	server.WriteBinaryResponse(w, &irma.ReplicationResponse{Epoch: req.Epoch}, nil)
+}
+
+// replicationRequest verifies and unmarshals the replication request of a revocation standby,
+// which must be signed by the counter-th revocation private key of the issuer and be recent.
+func (s *Server) replicationRequest(r *http.Request, cred irma.CredentialTypeIdentifier) (*irma.ReplicationRequest, *irma.RemoteError) {
+	counter, _ := strconv.ParseUint(chi.URLParam(r, "counter"), 10, 32)
+	if !s.conf.RevocationSettings.Lookup(cred).IsAuthority() {
+		return nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server")
+	}
+	pk, err := s.conf.IrmaConfiguration.Revocation.Keys.PublicKey(cred.IssuerIdentifier(), uint(counter))
+	if err != nil {
+		return nil, server.RemoteError(server.ErrorRevocation, err.Error())
+	}
+	message, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	var req irma.ReplicationRequest
+	if err := signed.UnmarshalVerify(pk.ECDSA, message, &req); err != nil {
+		return nil, server.RemoteError(server.ErrorUnauthorized, err.Error())
+	}
+	if req.CredentialType != cred {
+		return nil, server.RemoteError(server.ErrorInvalidRequest, "replication request for wrong credential type")
+	}
+	maxAge := time.Duration(irma.RevocationParameters.ReplicationRequestMaxAge) * time.Second
+	if age := time.Since(time.Unix(req.Time, 0)); age > maxAge || age < -maxAge {
+		return nil, server.RemoteError(server.ErrorUnauthorized, "replication request expired")
+	}
+	return &req, nil
+}
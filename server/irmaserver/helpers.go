@@ -207,8 +207,12 @@ func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
 		cred.KeyCounter = privatekey.Counter
 
 		if s.conf.IrmaConfiguration.CredentialTypes[cred.CredentialTypeID].RevocationSupported() {
-			settings := s.conf.RevocationSettings[cred.CredentialTypeID]
-			if settings == nil || (settings.RevocationServerURL == "" && !settings.Server) {
+			if !privatekey.RevocationSupported() {
+				return errors.Errorf("revocation enabled for %s but private key %s-%d does not support revocation",
+					cred.CredentialTypeID, iss.String(), privatekey.Counter)
+			}
+			settings := s.conf.RevocationSettings.Lookup(cred.CredentialTypeID)
+			if settings == nil || (settings.ServerURL() == "" && !settings.Server) {
 				return errors.Errorf("revocation enabled for %s but no revocation server configured", cred.CredentialTypeID)
 			}
 			if cred.RevocationKey == "" {
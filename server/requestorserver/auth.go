@@ -34,6 +34,24 @@ type Authenticator interface {
 	) (applies bool, request *irma.RevocationRequest, requestor string, err *irma.RemoteError)
 }
 
+// RevocationQueryAuthenticator is implemented by Authenticators that can authenticate revocation
+// status queries. Authenticators not implementing it never apply to such requests.
+type RevocationQueryAuthenticator interface {
+	AuthenticateRevocationQuery(
+		headers http.Header, body []byte,
+	) (applies bool, request *irma.RevocationQueryRequest, requestor string, err *irma.RemoteError)
+}
+
+// AdminAuthenticator is implemented by Authenticators that can authenticate requests to the
+// /admin endpoints. Authenticators not implementing it never apply to such requests.
+type AdminAuthenticator interface {
+	// AuthenticateAdmin checks, given the HTTP header of a GET request to one of the /admin
+	// endpoints, if the requestor is known. Token authenticated requestors put their token in the
+	// Authorization header; JWT authenticated requestors use "Authorization: Bearer <jwt>", where
+	// the JWT has "admin" as subject.
+	AuthenticateAdmin(headers http.Header) (applies bool, requestor string, err *irma.RemoteError)
+}
+
 type AuthenticationMethod string
 
 // Currently supported requestor authentication methods
@@ -57,6 +75,19 @@ type PresharedKeyAuthenticator struct {
 }
 type NilAuthenticator struct{}
 
+// The builtin authenticators also authenticate revocation status queries and requests to the
+// /admin endpoints
+var (
+	_ RevocationQueryAuthenticator = NilAuthenticator{}
+	_ RevocationQueryAuthenticator = (*HmacAuthenticator)(nil)
+	_ RevocationQueryAuthenticator = (*PublicKeyAuthenticator)(nil)
+	_ RevocationQueryAuthenticator = (*PresharedKeyAuthenticator)(nil)
+	_ AdminAuthenticator           = NilAuthenticator{}
+	_ AdminAuthenticator           = (*HmacAuthenticator)(nil)
+	_ AdminAuthenticator           = (*PublicKeyAuthenticator)(nil)
+	_ AdminAuthenticator           = (*PresharedKeyAuthenticator)(nil)
+)
+
 var authenticators map[AuthenticationMethod]Authenticator
 
 func (NilAuthenticator) AuthenticateSession(
@@ -83,6 +114,24 @@ func (NilAuthenticator) AuthenticateRevocation(headers http.Header, body []byte)
 	return true, r, "", nil
 }
 
+func (NilAuthenticator) AuthenticateRevocationQuery(headers http.Header, body []byte) (bool, *irma.RevocationQueryRequest, string, *irma.RemoteError) {
+	if headers.Get("Authorization") != "" || !strings.HasPrefix(headers.Get("Content-Type"), "application/json") {
+		return false, nil, "", nil
+	}
+	r := &irma.RevocationQueryRequest{}
+	if err := irma.UnmarshalValidate(body, r); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, r, "", nil
+}
+
+func (NilAuthenticator) AuthenticateAdmin(headers http.Header) (bool, string, *irma.RemoteError) {
+	if headers.Get("Authorization") != "" {
+		return false, "", nil
+	}
+	return true, "", nil
+}
+
 func (NilAuthenticator) Initialize(name string, requestor Requestor) error {
 	return nil
 }
@@ -97,6 +146,14 @@ func (hauth *HmacAuthenticator) AuthenticateRevocation(headers http.Header, body
 	return jwtAutheticateRevocation(headers, body, jwt.SigningMethodHS256.Name, hauth.hmackeys, hauth.maxRequestAge)
 }
 
+func (hauth *HmacAuthenticator) AuthenticateRevocationQuery(headers http.Header, body []byte) (bool, *irma.RevocationQueryRequest, string, *irma.RemoteError) {
+	return jwtAuthenticateRevocationQuery(headers, body, jwt.SigningMethodHS256.Name, hauth.hmackeys, hauth.maxRequestAge)
+}
+
+func (hauth *HmacAuthenticator) AuthenticateAdmin(headers http.Header) (bool, string, *irma.RemoteError) {
+	return jwtAuthenticateAdmin(headers, jwt.SigningMethodHS256.Name, hauth.hmackeys, hauth.maxRequestAge)
+}
+
 func (hauth *HmacAuthenticator) Initialize(name string, requestor Requestor) error {
 	bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
 	if err != nil {
@@ -124,6 +181,14 @@ func (pkauth *PublicKeyAuthenticator) AuthenticateRevocation(headers http.Header
 	return jwtAutheticateRevocation(headers, body, jwt.SigningMethodRS256.Name, pkauth.publickeys, pkauth.maxRequestAge)
 }
 
+func (pkauth *PublicKeyAuthenticator) AuthenticateRevocationQuery(headers http.Header, body []byte) (bool, *irma.RevocationQueryRequest, string, *irma.RemoteError) {
+	return jwtAuthenticateRevocationQuery(headers, body, jwt.SigningMethodRS256.Name, pkauth.publickeys, pkauth.maxRequestAge)
+}
+
+func (pkauth *PublicKeyAuthenticator) AuthenticateAdmin(headers http.Header) (bool, string, *irma.RemoteError) {
+	return jwtAuthenticateAdmin(headers, jwt.SigningMethodRS256.Name, pkauth.publickeys, pkauth.maxRequestAge)
+}
+
 func (pkauth *PublicKeyAuthenticator) Initialize(name string, requestor Requestor) error {
 	bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
 	if err != nil {
@@ -173,6 +238,34 @@ func (pskauth *PresharedKeyAuthenticator) AuthenticateRevocation(headers http.He
 	return true, r, requestor, nil
 }
 
+func (pskauth *PresharedKeyAuthenticator) AuthenticateRevocationQuery(headers http.Header, body []byte) (bool, *irma.RevocationQueryRequest, string, *irma.RemoteError) {
+	auth := headers.Get("Authorization")
+	if auth == "" || !strings.HasPrefix(headers.Get("Content-Type"), "application/json") {
+		return false, nil, "", nil
+	}
+	requestor, ok := pskauth.presharedkeys[auth]
+	if !ok {
+		return true, nil, "", server.RemoteError(server.ErrorUnauthorized, "")
+	}
+	r := &irma.RevocationQueryRequest{}
+	if err := irma.UnmarshalValidate(body, r); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, r, requestor, nil
+}
+
+func (pskauth *PresharedKeyAuthenticator) AuthenticateAdmin(headers http.Header) (bool, string, *irma.RemoteError) {
+	auth := headers.Get("Authorization")
+	if auth == "" || strings.HasPrefix(auth, "Bearer ") {
+		return false, "", nil
+	}
+	requestor, ok := pskauth.presharedkeys[auth]
+	if !ok {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "")
+	}
+	return true, requestor, nil
+}
+
 func (pskauth *PresharedKeyAuthenticator) Initialize(name string, requestor Requestor) error {
 	bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
 	if err != nil {
@@ -187,16 +280,27 @@ func (pskauth *PresharedKeyAuthenticator) Initialize(name string, requestor Requ
 // Given an (unauthenticated) jwt, return the key against which it should be verified using the "kid" header
 func jwtKeyExtractor(publickeys map[string]interface{}) func(token *jwt.Token) (interface{}, error) {
 	return func(token *jwt.Token) (interface{}, error) {
+		var issuer *string
+		switch claims := token.Claims.(type) {
+		case *jwt.StandardClaims:
+			issuer = &claims.Issuer
+		case *irma.RevocationJwt:
+			issuer = &claims.ServerName
+		case *irma.RevocationQueryJwt:
+			issuer = &claims.ServerName
+		default:
+			return nil, errors.New("unsupported jwt claims")
+		}
 		var ok bool
 		kid, ok := token.Header["kid"]
 		if !ok {
-			kid = token.Claims.(*jwt.StandardClaims).Issuer
+			kid = *issuer
 		}
 		requestor, ok := kid.(string)
 		if !ok {
 			return nil, errors.New("requestor name was not a string")
 		}
-		token.Claims.(*jwt.StandardClaims).Issuer = requestor
+		*issuer = requestor
 		if pk, ok := publickeys[requestor]; ok {
 			return pk, nil
 		}
@@ -253,6 +357,55 @@ func jwtAutheticateRevocation(
 	return true, s.Request, s.ServerName, nil
 }
 
+func jwtAuthenticateRevocationQuery(
+	headers http.Header, body []byte, signatureAlg string, keys map[string]interface{}, maxRequestAge int,
+) (bool, *irma.RevocationQueryRequest, string, *irma.RemoteError) {
+	if !jwtApplies(headers, body, signatureAlg) {
+		return false, nil, "", nil
+	}
+	s := &irma.RevocationQueryJwt{}
+	if _, err := jwt.ParseWithClaims(string(body), s, jwtKeyExtractor(keys)); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	if time.Unix(time.Time(s.IssuedAt).Unix(), 0).Add(time.Duration(maxRequestAge) * time.Second).Before(time.Now()) {
+		return true, nil, "", server.RemoteError(server.ErrorUnauthorized, "jwt too old")
+	}
+	if s.Request == nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, "jwt does not contain revocation query request")
+	}
+	if err := s.Request.Validate(); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, s.Request, s.ServerName, nil
+}
+
+func jwtAuthenticateAdmin(
+	headers http.Header, signatureAlg string, keys map[string]interface{}, maxRequestAge int,
+) (bool, string, *irma.RemoteError) {
+	auth := headers.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false, "", nil
+	}
+	requestorJwt := strings.TrimPrefix(auth, "Bearer ")
+	if alg, err := jwtSignatureAlg(requestorJwt); err != nil || alg != signatureAlg {
+		return false, "", nil
+	}
+	claims := &jwt.StandardClaims{}
+	if _, err := jwt.ParseWithClaims(requestorJwt, claims, jwtKeyExtractor(keys)); err != nil {
+		return true, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	if claims.Subject != "admin" {
+		return true, "", server.RemoteError(server.ErrorInvalidRequest, "jwt subject must be admin")
+	}
+	if time.Unix(claims.IssuedAt, 0).Add(time.Duration(maxRequestAge) * time.Second).Before(time.Now()) {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "jwt too old")
+	}
+	if !claims.VerifyIssuedAt(time.Now().Unix(), true) {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "jwt not yet valid")
+	}
+	return true, claims.Issuer, nil
+}
+
 func jwtApplies(headers http.Header, body []byte, signatureAlg string) bool {
 	// Read JWT and check its type
 	if headers.Get("Authorization") != "" || !strings.HasPrefix(headers.Get("Content-Type"), "text/plain") {
@@ -2,6 +2,7 @@ package requestorserver
 
 import (
 	"encoding/json"
+	"net/http"
 	"testing"
 	"time"
 
@@ -170,3 +171,121 @@ func TestHmacAuthenticator_Authenticate(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestHmacAuthenticator_AuthenticateRevocationQuery(t *testing.T) {
+	key := []byte("953BCAB6F25F3622619A9A16BE895")
+	authenticator := HmacAuthenticator{
+		hmackeys: map[string]interface{}{
+			"my_requestor": key,
+		},
+		maxRequestAge: 500,
+	}
+	requestHeaders := map[string][]string{
+		"Content-Type": {"text/plain"},
+	}
+	sign := func(name string, request *irma.RevocationQueryRequest) []byte {
+		j := irma.RevocationQueryJwt{
+			ServerJwt: irma.ServerJwt{ServerName: name, IssuedAt: irma.Timestamp(time.Now())},
+			Request:   request,
+		}
+		jwtdata, err := j.Sign(jwt.SigningMethodHS256, key)
+		require.NoError(t, err)
+		return []byte(jwtdata)
+	}
+	request := &irma.RevocationQueryRequest{
+		LDContext:      irma.LDContextRevocationQueryRequest,
+		CredentialType: irma.NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"),
+		Key:            "12345",
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		applies, parsedRequest, requestor, err := authenticator.AuthenticateRevocationQuery(requestHeaders, sign("my_requestor", request))
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, request, parsedRequest)
+		require.Equal(t, "my_requestor", requestor)
+	})
+
+	server.Logger.SetLevel(logrus.ErrorLevel)
+	t.Run("unknown requestor", func(t *testing.T) {
+		applies, _, _, err := authenticator.AuthenticateRevocationQuery(requestHeaders, sign("another_requestor", request))
+		require.True(t, applies)
+		require.NotNil(t, err)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		applies, _, _, err := authenticator.AuthenticateRevocationQuery(requestHeaders, sign("my_requestor", &irma.RevocationQueryRequest{
+			LDContext:      irma.LDContextRevocationQueryRequest,
+			CredentialType: request.CredentialType,
+		}))
+		require.True(t, applies)
+		require.NotNil(t, err)
+		require.Equal(t, string(server.ErrorInvalidRequest.Type), err.ErrorName)
+	})
+}
+
+func TestAuthenticateAdmin(t *testing.T) {
+	key := []byte("953BCAB6F25F3622619A9A16BE895")
+	hauth := &HmacAuthenticator{
+		hmackeys:      map[string]interface{}{"my_requestor": key},
+		maxRequestAge: 500,
+	}
+	pskauth := &PresharedKeyAuthenticator{presharedkeys: map[string]string{"token": "my_requestor"}}
+	sign := func(subject string, iat time.Time) string {
+		claims := &jwt.StandardClaims{Issuer: "my_requestor", Subject: subject, IssuedAt: iat.Unix()}
+		jwtdata, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		require.NoError(t, err)
+		return jwtdata
+	}
+	header := func(auth string) http.Header {
+		return map[string][]string{"Authorization": {auth}}
+	}
+
+	t.Run("jwt", func(t *testing.T) {
+		applies, requestor, err := hauth.AuthenticateAdmin(header("Bearer " + sign("admin", time.Now())))
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "my_requestor", requestor)
+
+		applies, _, _ = pskauth.AuthenticateAdmin(header("Bearer " + sign("admin", time.Now())))
+		require.False(t, applies)
+	})
+
+	server.Logger.SetLevel(logrus.ErrorLevel)
+	t.Run("jwt wrong subject", func(t *testing.T) {
+		applies, _, err := hauth.AuthenticateAdmin(header("Bearer " + sign("verification_request", time.Now())))
+		require.True(t, applies)
+		require.Error(t, err)
+	})
+
+	t.Run("jwt too old", func(t *testing.T) {
+		applies, _, err := hauth.AuthenticateAdmin(header("Bearer " + sign("admin", time.Unix(0, 0))))
+		require.True(t, applies)
+		require.Error(t, err)
+		require.Equal(t, string(server.ErrorUnauthorized.Type), err.ErrorName)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		applies, requestor, err := pskauth.AuthenticateAdmin(header("token"))
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "my_requestor", requestor)
+
+		applies, _, err = pskauth.AuthenticateAdmin(header("invalid"))
+		require.True(t, applies)
+		require.Error(t, err)
+
+		applies, _, _ = hauth.AuthenticateAdmin(header("token"))
+		require.False(t, applies)
+	})
+
+	t.Run("no authorization header", func(t *testing.T) {
+		applies, _, _ := pskauth.AuthenticateAdmin(http.Header{})
+		require.False(t, applies)
+		applies, _, _ = hauth.AuthenticateAdmin(http.Header{})
+		require.False(t, applies)
+		applies, _, err := NilAuthenticator{}.AuthenticateAdmin(http.Header{})
+		require.Nil(t, err)
+		require.True(t, applies)
+	})
+}
@@ -63,6 +63,7 @@ type Permissions struct {
 	Signing    []string `json:"sign_perms" mapstructure:"sign_perms"`
 	Issuing    []string `json:"issue_perms" mapstructure:"issue_perms"`
 	Revoking   []string `json:"revoke_perms" mapstructure:"revoke_perms"`
+	Admin      bool     `json:"admin_perms" mapstructure:"admin_perms"`
 }
 
 // Requestor contains all configuration (disclosure or verification permissions and authentication)
@@ -151,6 +152,11 @@ func (conf *Configuration) CanRevoke(requestor string, cred irma.CredentialTypeI
 	return false, cred.String()
 }
 
+// CanAdminister returns whether or not the specified requestor may access the /admin endpoints.
+func (conf *Configuration) CanAdminister(requestor string) bool {
+	return conf.Requestors[requestor].Admin || conf.Admin
+}
+
 func (conf *Configuration) initialize() error {
 	if conf.DisableRequestorAuthentication {
 		authenticators = map[AuthenticationMethod]Authenticator{AuthenticationMethodNone: NilAuthenticator{}}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -214,6 +215,14 @@ func (s *Server) Handler() http.Handler {
 		})
 
 		r.Get("/publickey", s.handlePublicKey)
+
+		// Admin routes, only accessible to requestors having admin permission
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.adminMiddleware)
+			r.Get("/keyexpiry", s.handleKeyExpiry)
+			r.Get("/revocation", s.handleRevocationStatus)
+			r.Get("/metrics", s.handleMetrics)
+		})
 	})
 
 	router.Group(func(r chi.Router) {
@@ -224,6 +233,7 @@ func (s *Server) Handler() http.Handler {
 			r.Use(server.LogMiddleware("revocation", log))
 		}
 		r.Post("/revocation", s.handleRevocation)
+		r.Post("/revocation/query", s.handleRevocationQuery)
 	})
 
 	return router
@@ -301,6 +311,55 @@ func (s *Server) handleRevocation(w http.ResponseWriter, r *http.Request) {
 	s.revoke(w, requestor, revreq)
 }
 
+func (s *Server) handleRevocationQuery(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.conf.Logger.Error("Could not read revocation query request HTTP POST body")
+		_ = server.LogError(err)
+		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	var (
+		queryreq  *irma.RevocationQueryRequest
+		requestor string
+		rerr      *irma.RemoteError
+		applies   bool
+	)
+	for _, authenticator := range authenticators {
+		queryAuthenticator, ok := authenticator.(RevocationQueryAuthenticator)
+		if !ok {
+			continue
+		}
+		applies, queryreq, requestor, rerr = queryAuthenticator.AuthenticateRevocationQuery(r.Header, body)
+		if applies || rerr != nil {
+			break
+		}
+	}
+	if ok := s.checkAuth(w, r, rerr, applies, body); !ok {
+		return
+	}
+
+	// Revocation state is only disclosed to requestors that are allowed to revoke the credential type
+	allowed, reason := s.conf.CanRevoke(requestor, queryreq.CredentialType)
+	if !allowed {
+		s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor, "message": reason}).
+			Warn("Requestor not authorized to query revocation state; full request: ", server.ToJson(queryreq))
+		server.WriteError(w, server.ErrorUnauthorized, reason)
+		return
+	}
+	res, err := s.irmaserv.QueryRevocation(queryreq)
+	if err == irma.ErrUnknownRevocationKey {
+		server.WriteError(w, server.ErrorUnknownRevocationKey, queryreq.Key)
+		return
+	}
+	if err != nil {
+		server.WriteError(w, server.ErrorRevocation, err.Error())
+		return
+	}
+	server.WriteJson(w, res)
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	res := s.irmaserv.GetSessionResult(chi.URLParam(r, "token"))
 	if res == nil {
@@ -454,6 +513,91 @@ func (s *Server) handlePublicKey(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(pubBytes)
 }
 
+func (s *Server) handleKeyExpiry(w http.ResponseWriter, r *http.Request) {
+	status := s.irmaserv.KeyExpiry()
+	if status == nil {
+		server.WriteError(w, server.ErrorUnsupported, "key expiry check disabled")
+		return
+	}
+	server.WriteJson(w, status)
+}
+
+func (s *Server) handleRevocationStatus(w http.ResponseWriter, r *http.Request) {
+	server.WriteJson(w, s.irmaserv.RevocationStatus())
+}
+
+// handleMetrics writes the issuer key expiry and revocation status in the Prometheus text exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(metrics(s.irmaserv.KeyExpiry(), s.irmaserv.RevocationStatus())))
+}
+
+// metrics returns the key expiry status (if the key expiry check is enabled) and the revocation
+// status in the Prometheus text format.
+func metrics(expiry *server.KeyExpiryStatus, revocation []*irma.RevocationStatus) string {
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	boolean := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	if expiry != nil {
+		metric("irma_issuer_key_expiry_checked_timestamp_seconds", "gauge", "Time of the most recent issuer key expiry check.")
+		fmt.Fprintf(&b, "irma_issuer_key_expiry_checked_timestamp_seconds %d\n", time.Time(expiry.Checked).Unix())
+		metric("irma_issuer_keys_expiring", "gauge", "Amount of issuer keys whose public key expires within the warning window.")
+		fmt.Fprintf(&b, "irma_issuer_keys_expiring %d\n", expiry.Expiring)
+		metric("irma_issuer_keys_expired", "gauge", "Amount of issuer keys whose public key has expired.")
+		fmt.Fprintf(&b, "irma_issuer_keys_expired %d\n", expiry.Expired)
+		metric("irma_issuer_key_expiry_timestamp_seconds", "gauge", "Expiry date of the public key of issuer keys that expire within the warning window.")
+		for _, key := range expiry.Keys {
+			fmt.Fprintf(&b, "irma_issuer_key_expiry_timestamp_seconds{issuer=%q,counter=\"%d\"} %d\n",
+				key.Issuer.String(), key.Counter, time.Time(key.ExpiryDate).Unix())
+		}
+	}
+
+	if len(revocation) == 0 {
+		return b.String()
+	}
+	metric("irma_revocation_updated_timestamp_seconds", "gauge", "Time up to which nonrevocation is guaranteed.")
+	for _, status := range revocation {
+		if status.Updated != nil {
+			fmt.Fprintf(&b, "irma_revocation_updated_timestamp_seconds{credtype=%q,mode=%q} %d\n",
+				status.CredentialType.String(), status.Mode, time.Time(*status.Updated).Unix())
+		}
+	}
+	metric("irma_revocation_stale", "gauge", "Whether nonrevocation is guaranteed only until longer ago than the tolerance.")
+	for _, status := range revocation {
+		fmt.Fprintf(&b, "irma_revocation_stale{credtype=%q} %d\n", status.CredentialType.String(), boolean(status.Stale))
+	}
+	metric("irma_revocation_sync_failures_total", "counter", "Amount of failed attempts to fetch revocation updates from the revocation server.")
+	for _, status := range revocation {
+		fmt.Fprintf(&b, "irma_revocation_sync_failures_total{credtype=%q} %d\n", status.CredentialType.String(), status.SyncFailures)
+	}
+	metric("irma_revocation_tolerance_violations_total", "counter", "Amount of sessions in which nonrevocation could not be guaranteed within the tolerance.")
+	for _, status := range revocation {
+		fmt.Fprintf(&b, "irma_revocation_tolerance_violations_total{credtype=%q} %d\n", status.CredentialType.String(), status.ToleranceViolations)
+	}
+	metric("irma_revocation_sse_connected", "gauge", "Whether the server-sent events connection with the revocation server is up.")
+	for _, status := range revocation {
+		if status.SSE != nil {
+			fmt.Fprintf(&b, "irma_revocation_sse_connected{credtype=%q} %d\n", status.CredentialType.String(), boolean(status.SSE.Connected))
+		}
+	}
+	metric("irma_revocation_sse_last_event_timestamp_seconds", "gauge", "Time of the most recent server-sent event from the revocation server.")
+	for _, status := range revocation {
+		if status.SSE != nil && status.SSE.LastEvent != nil {
+			fmt.Fprintf(&b, "irma_revocation_sse_last_event_timestamp_seconds{credtype=%q} %d\n",
+				status.CredentialType.String(), time.Time(*status.SSE.LastEvent).Unix())
+		}
+	}
+	return b.String()
+}
+
 func (s *Server) doResultCallback(result *server.SessionResult) {
 	url := s.irmaserv.GetRequest(result.Token).Base().CallbackURL
 	if url == "" {
@@ -532,6 +676,45 @@ func (s *Server) revoke(w http.ResponseWriter, requestor string, request *irma.R
 	server.WriteString(w, "OK")
 }
 
+// adminMiddleware only passes on requests of requestors that are allowed to access the /admin
+// endpoints, see AdminAuthenticator and Configuration.CanAdminister().
+func (s *Server) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			requestor string
+			rerr      *irma.RemoteError
+			applies   bool
+		)
+		for _, authenticator := range authenticators {
+			adminAuthenticator, ok := authenticator.(AdminAuthenticator)
+			if !ok {
+				continue
+			}
+			applies, requestor, rerr = adminAuthenticator.AuthenticateAdmin(r.Header)
+			if applies || rerr != nil {
+				break
+			}
+		}
+		if rerr != nil {
+			_ = server.LogError(rerr)
+			server.WriteResponse(w, nil, rerr)
+			return
+		}
+		if !applies {
+			s.conf.Logger.Warnf("Admin request uses unknown authentication method, HTTP headers: %s", server.ToJson(r.Header))
+			server.WriteError(w, server.ErrorInvalidRequest, "Request could not be authenticated")
+			return
+		}
+		if !s.conf.CanAdminister(requestor) {
+			s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor, "path": r.URL.Path}).
+				Warn("Requestor not authorized to access admin endpoint")
+			server.WriteError(w, server.ErrorUnauthorized, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) checkAuth(w http.ResponseWriter, r *http.Request, rerr *irma.RemoteError, applies bool, body []byte) bool {
 	if rerr != nil {
 		_ = server.LogError(rerr)
@@ -0,0 +1,31 @@
+package requestorserver
+
+import (
+	"testing"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	require.Empty(t, metrics(nil, nil))
+
+	updated := irma.Timestamp(time.Unix(1600000000, 0))
+	text := metrics(nil, []*irma.RevocationStatus{{
+		CredentialType:      irma.NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"),
+		Mode:                "server",
+		Updated:             &updated,
+		Stale:               true,
+		SyncFailures:        3,
+		ToleranceViolations: 2,
+		SSE:                 &irma.RevocationSSEStatus{Connected: true},
+	}})
+	require.Contains(t, text, `irma_revocation_updated_timestamp_seconds{credtype="irma-demo.MijnOverheid.root",mode="server"} 1600000000`)
+	require.Contains(t, text, `irma_revocation_stale{credtype="irma-demo.MijnOverheid.root"} 1`)
+	require.Contains(t, text, `irma_revocation_sync_failures_total{credtype="irma-demo.MijnOverheid.root"} 3`)
+	require.Contains(t, text, `irma_revocation_tolerance_violations_total{credtype="irma-demo.MijnOverheid.root"} 2`)
+	require.Contains(t, text, `irma_revocation_sse_connected{credtype="irma-demo.MijnOverheid.root"} 1`)
+	require.NotContains(t, text, "irma_issuer_keys_expired")
+	require.NotContains(t, text, "irma_revocation_sse_last_event_timestamp_seconds{")
+}
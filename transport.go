@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -164,6 +165,32 @@ func (transport *HTTPTransport) log(prefix string, message interface{}, binary b
 	}
 }
 
+// WithLastError returns a copy of the transport whose errors, when all attempts of a request
+// fail, wrap the error of the last attempt so that callers can inspect its cause.
+func (transport *HTTPTransport) WithLastError() *HTTPTransport {
+	t := *transport
+	t.headers = transport.headers.Clone()
+	t.client = &retryablehttp.Client{
+		HTTPClient:   transport.client.HTTPClient,
+		Logger:       transport.client.Logger,
+		RetryWaitMin: transport.client.RetryWaitMin,
+		RetryWaitMax: transport.client.RetryWaitMax,
+		RetryMax:     transport.client.RetryMax,
+		CheckRetry:   transport.client.CheckRetry,
+		Backoff:      transport.client.Backoff,
+		ErrorHandler: func(resp *http.Response, err error, numTries int) (*http.Response, error) {
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			if err == nil {
+				return nil, fmt.Errorf("giving up after %d attempts", numTries)
+			}
+			return nil, fmt.Errorf("giving up after %d attempts: %w", numTries, err)
+		},
+	}
+	return &t
+}
+
 // SetHeader sets a header to be sent in requests.
 func (transport *HTTPTransport) SetHeader(name, val string) {
 	transport.headers.Set(name, val)